@@ -0,0 +1,110 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/schema"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+)
+
+func dataOpennebulaMarketPlaceApp() *schema.Resource {
+	return &schema.Resource{
+		Read: dataOpennebulaMarketPlaceAppRead,
+
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Name of the Marketplace App",
+			},
+			"market_id": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     -1,
+				Description: "ID of the Marketplace of the App. If not set, the App is searched in all the Marketplaces",
+			},
+			"type": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Type of the Marketplace App: IMAGE, VMTEMPLATE, SERVICE_TEMPLATE",
+			},
+			"description": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Description of the Marketplace App",
+			},
+			"version": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Version of the Marketplace App",
+			},
+			"size": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Size of the Marketplace App in MB",
+			},
+			"format": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Format of the Marketplace App image",
+			},
+			"state": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "State of the Marketplace App",
+			},
+			"app_template": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Template used to import the Marketplace App",
+			},
+		},
+	}
+}
+
+func dataOpennebulaMarketPlaceAppRead(d *schema.ResourceData, meta interface{}) error {
+	controller := meta.(*goca.Controller)
+
+	name := d.Get("name").(string)
+	marketid := d.Get("market_id").(int)
+
+	apps, err := controller.MarketPlaceApps().Info()
+	if err != nil {
+		return err
+	}
+
+	found := -1
+	for i, app := range apps.MarketPlaceApps {
+		if app.Name != name || (marketid >= 0 && app.MarketPlaceID != marketid) {
+			continue
+		}
+		if found >= 0 {
+			return fmt.Errorf("Several Marketplace Apps named %s found, set market_id", name)
+		}
+		found = i
+	}
+	if found < 0 {
+		return fmt.Errorf("Could not find Marketplace App with name %s", name)
+	}
+
+	app := &apps.MarketPlaceApps[found]
+
+	apptpl, err := decodeMarketPlaceAppTemplate(app)
+	if err != nil {
+		return err
+	}
+
+	d.SetId(fmt.Sprintf("%v", app.ID))
+	d.Set("market_id", app.MarketPlaceID)
+	if app.Type >= 0 && app.Type < len(marketapptypes) {
+		d.Set("type", marketapptypes[app.Type])
+	}
+	d.Set("description", app.Description)
+	d.Set("version", app.Version)
+	d.Set("size", app.Size)
+	d.Set("format", app.Format)
+	d.Set("state", marketAppState(app))
+	d.Set("app_template", apptpl)
+
+	return nil
+}
@@ -0,0 +1,24 @@
+package opennebula
+
+import (
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func dataOpennebulaZone() *schema.Resource {
+	return &schema.Resource{
+		Read: resourceOpennebulaZoneRead,
+
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Name of the Zone",
+			},
+			"endpoint": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "XML-RPC endpoint of the Zone",
+			},
+		},
+	}
+}
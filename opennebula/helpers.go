@@ -1,9 +1,15 @@
 package opennebula
 
 import (
+	"encoding/xml"
 	"fmt"
-	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/shared"
+	"github.com/hashicorp/terraform/helper/schema"
 	"strings"
+	"time"
+
+	"github.com/OpenNebula/addon-terraform/opennebula/onetemplate"
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/shared"
 )
 
 func inArray(val string, array []string) (index int) {
@@ -16,11 +22,6 @@ func inArray(val string, array []string) (index int) {
 	return -1
 }
 
-// appendTemplate add attribute and value to an existing string
-func appendTemplate(template, attribute, value string) string {
-	return fmt.Sprintf("%s\n%s = \"%s\"", template, attribute, value)
-}
-
 func ArrayToString(list []interface{}, delim string) string {
 	return strings.Trim(strings.Join(strings.Fields(fmt.Sprint(list)), delim), "[]")
 }
@@ -60,3 +61,97 @@ func LockLevelToString(lock int) string {
 	}
 	return ""
 }
+
+// validateDuration checks that the value can be parsed by time.ParseDuration
+func validateDuration(v interface{}, k string) (ws []string, errors []error) {
+	value := v.(string)
+
+	if _, err := time.ParseDuration(value); err != nil {
+		errors = append(errors, fmt.Errorf("%q must be a valid duration: %s", k, err))
+	}
+
+	return
+}
+
+// getObjectTemplate returns the TEMPLATE section of an object, read from the
+// XML-RPC response of its info method as goca only decodes the attributes it
+// knows
+func getObjectTemplate(controller *goca.Controller, method string, args ...interface{}) (*onetemplate.Template, error) {
+	response, err := controller.Client.Call(method, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &struct {
+		Template struct {
+			Content string `xml:",innerxml"`
+		} `xml:"TEMPLATE"`
+	}{}
+	if err = xml.Unmarshal([]byte(response.Body()), doc); err != nil {
+		return nil, fmt.Errorf("Unable to decode the response of %s: %s", method, err)
+	}
+
+	return onetemplate.ParseXML("<TEMPLATE>" + doc.Template.Content + "</TEMPLATE>")
+}
+
+// templateDiffSuppress ignores the differences between two raw templates
+// holding the same attributes, whatever their order, blanks, quoting or form
+// (XML or OpenNebula syntax)
+func templateDiffSuppress(k, old, new string, d *schema.ResourceData) bool {
+	oldtpl, err := onetemplate.Decode(old)
+	if err != nil {
+		return false
+	}
+	newtpl, err := onetemplate.Decode(new)
+	if err != nil {
+		return false
+	}
+
+	return oldtpl.Equal(newtpl)
+}
+
+// managedTemplate returns the attributes of the template read from OpenNebula
+// whose names are part of the configured template. OpenNebula adds its own
+// attributes, like default values or monitoring information, which are not
+// managed by the configuration.
+func managedTemplate(tpl *onetemplate.Template, configured string) string {
+	conftpl, err := onetemplate.Decode(configured)
+	if err != nil {
+		return tpl.String()
+	}
+
+	names := make(map[string]bool)
+	for _, e := range conftpl.Elements {
+		names[strings.ToUpper(e.Name())] = true
+	}
+
+	managed := &onetemplate.Template{}
+	for _, e := range tpl.Elements {
+		if names[strings.ToUpper(e.Name())] {
+			managed.Elements = append(managed.Elements, e)
+		}
+	}
+
+	return managed.String()
+}
+
+// replaceManagedTemplate returns the template replacing the current one of an
+// object: the attributes of the previous configured template are removed and
+// the configured ones are set, the attributes added by OpenNebula are kept.
+// Merging would never remove the attributes dropped from the configuration.
+func replaceManagedTemplate(current *onetemplate.Template, previous string, configured *onetemplate.Template) *onetemplate.Template {
+	tpl := &onetemplate.Template{}
+	tpl.Elements = append(tpl.Elements, current.Elements...)
+
+	if prevtpl, err := onetemplate.Decode(previous); err == nil {
+		for _, e := range prevtpl.Elements {
+			tpl.Del(e.Name())
+		}
+	}
+	for _, e := range configured.Elements {
+		tpl.Del(e.Name())
+	}
+	tpl.Append(configured)
+
+	return tpl
+}
@@ -0,0 +1,2872 @@
+package opennebula
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// registerKinds declares the pools of the stand-in and their specific calls
+func (s *onedStandIn) registerKinds() {
+	s.register(&onedKind{method: "vm", pool: "vmpool", element: "VM", object: "virtual machine", owned: true,
+		render: (*onedStandIn).renderVM})
+	delete(s.methods, "one.vm.delete")
+	s.methods["one.vm.allocate"] = s.vmAllocate
+	s.methods["one.vm.action"] = s.vmAction
+	s.methods["one.vm.resize"] = s.vmResize
+	s.methods["one.vm.attach"] = s.vmAttachDisk
+	s.methods["one.vm.detach"] = s.vmDetachDisk
+	s.methods["one.vm.attachnic"] = s.vmAttachNIC
+	s.methods["one.vm.detachnic"] = s.vmDetachNIC
+	s.methods["one.vmpool.infoextended"] = s.poolInfo
+
+	s.register(&onedKind{method: "template", pool: "templatepool", element: "VMTEMPLATE", object: "virtual machine template", owned: true, unique: "owner",
+		render: (*onedStandIn).renderTemplate})
+	s.methods["one.template.allocate"] = s.templateAllocate
+	s.methods["one.template.clone"] = s.templateClone
+	s.methods["one.template.instantiate"] = s.templateInstantiate
+
+	s.register(&onedKind{method: "image", pool: "imagepool", element: "IMAGE", object: "image", owned: true, unique: "owner",
+		render: (*onedStandIn).renderImage, remove: (*onedStandIn).removeImage})
+	s.methods["one.image.allocate"] = s.imageAllocate
+	s.methods["one.image.clone"] = s.imageClone
+	s.methods["one.image.persistent"] = s.imagePersistent
+	s.methods["one.image.chtype"] = s.imageChtype
+	s.methods["one.image.enable"] = s.imageEnable
+
+	s.register(&onedKind{method: "vn", pool: "vnpool", element: "VNET", object: "virtual network", owned: true, unique: "owner",
+		render: (*onedStandIn).renderVNet, remove: (*onedStandIn).removeVNet})
+	s.methods["one.vn.allocate"] = s.vnetAllocate
+	s.methods["one.vn.add_ar"] = s.vnetAddAR
+	s.methods["one.vn.rm_ar"] = s.vnetRmAR
+	s.methods["one.vn.update_ar"] = s.vnetUpdateAR
+	s.methods["one.vn.reserve"] = s.vnetReserve
+	s.methods["one.vn.hold"] = s.vnetHold
+	s.methods["one.vn.release"] = s.vnetRelease
+
+	s.register(&onedKind{method: "secgroup", pool: "secgrouppool", element: "SECURITY_GROUP", object: "security group", owned: true, unique: "owner",
+		render: (*onedStandIn).renderSecurityGroup, check: (*onedStandIn).checkSecurityGroup, remove: (*onedStandIn).removeSecurityGroup})
+	s.methods["one.secgroup.allocate"] = s.securityGroupAllocate
+	s.methods["one.secgroup.commit"] = s.securityGroupCommit
+
+	s.register(&onedKind{method: "group", pool: "grouppool", element: "GROUP", object: "group", unique: "global",
+		render: (*onedStandIn).renderGroup, remove: (*onedStandIn).removeGroup})
+	s.methods["one.group.allocate"] = s.groupAllocate
+	s.methods["one.group.quota"] = s.quota
+	s.methods["one.group.addadmin"] = s.groupAddAdmin
+	s.methods["one.group.deladmin"] = s.groupDelAdmin
+
+	s.register(&onedKind{method: "user", pool: "userpool", element: "USER", object: "user", unique: "global",
+		render: (*onedStandIn).renderUser, remove: (*onedStandIn).removeUser})
+	s.methods["one.user.allocate"] = s.userAllocate
+	s.methods["one.user.quota"] = s.quota
+	s.methods["one.user.passwd"] = s.userPasswd
+	s.methods["one.user.chauth"] = s.userChauth
+	s.methods["one.user.chgrp"] = s.userChgrp
+	s.methods["one.user.addgroup"] = s.userAddGroup
+	s.methods["one.user.delgroup"] = s.userDelGroup
+
+	s.register(&onedKind{method: "vdc", pool: "vdcpool", element: "VDC", object: "VDC", unique: "global",
+		render: (*onedStandIn).renderVDC})
+	s.methods["one.vdc.allocate"] = s.vdcAllocate
+	s.methods["one.vdc.addgroup"] = s.vdcAddGroup
+	s.methods["one.vdc.delgroup"] = s.vdcDelGroup
+	for _, res := range []string{"cluster", "host", "datastore", "vnet"} {
+		s.methods["one.vdc.add"+res] = s.vdcAddResource
+		s.methods["one.vdc.del"+res] = s.vdcDelResource
+	}
+
+	s.register(&onedKind{method: "cluster", pool: "clusterpool", element: "CLUSTER", object: "cluster", unique: "global",
+		render: (*onedStandIn).renderCluster, remove: (*onedStandIn).removeCluster})
+	s.methods["one.cluster.allocate"] = s.clusterAllocate
+	for _, res := range []string{"host", "datastore", "vnet"} {
+		s.methods["one.cluster.add"+res] = s.clusterAddResource
+		s.methods["one.cluster.del"+res] = s.clusterDelResource
+	}
+
+	s.register(&onedKind{method: "host", pool: "hostpool", element: "HOST", object: "host", unique: "global",
+		render: (*onedStandIn).renderHost, remove: (*onedStandIn).removeHost})
+	s.methods["one.host.allocate"] = s.hostAllocate
+	s.methods["one.host.status"] = s.hostStatus
+
+	s.register(&onedKind{method: "datastore", pool: "datastorepool", element: "DATASTORE", object: "datastore", owned: true, unique: "global",
+		render: (*onedStandIn).renderDatastore, remove: (*onedStandIn).removeDatastore})
+	s.methods["one.datastore.allocate"] = s.datastoreAllocate
+	s.methods["one.datastore.enable"] = s.datastoreEnable
+
+	s.register(&onedKind{method: "vrouter", pool: "vrouterpool", element: "VROUTER", object: "virtual router", owned: true, unique: "owner",
+		render: (*onedStandIn).renderVRouter, remove: (*onedStandIn).removeVRouter})
+	s.methods["one.vrouter.allocate"] = s.vrouterAllocate
+	s.methods["one.vrouter.instantiate"] = s.vrouterInstantiate
+	s.methods["one.vrouter.attachnic"] = s.vrouterAttachNIC
+	s.methods["one.vrouter.detachnic"] = s.vrouterDetachNIC
+
+	s.register(&onedKind{method: "marketapp", pool: "marketapppool", element: "MARKETPLACEAPP", object: "marketplaceapp", owned: true, unique: "owner",
+		render: (*onedStandIn).renderMarketPlaceApp, check: (*onedStandIn).checkMarketPlaceApp})
+	s.methods["one.marketapp.allocate"] = s.marketPlaceAppAllocate
+	s.methods["one.marketapp.enable"] = s.marketPlaceAppEnable
+
+	s.register(&onedKind{method: "zone", pool: "zonepool", element: "ZONE", object: "zone", unique: "global",
+		render: (*onedStandIn).renderZone, remove: (*onedStandIn).removeZone})
+	s.methods["one.zone.allocate"] = s.zoneAllocate
+
+	s.register(&onedKind{method: "vmgroup", pool: "vmgrouppool", element: "VM_GROUP", object: "vm group", owned: true, unique: "owner",
+		render: (*onedStandIn).renderVMGroup, check: (*onedStandIn).checkVMGroup, remove: (*onedStandIn).removeVMGroup})
+	s.methods["one.vmgroup.allocate"] = s.vmGroupAllocate
+
+	s.methods["one.acl.addrule"] = s.aclAddRule
+	s.methods["one.acl.delrule"] = s.aclDelRule
+	s.methods["one.acl.info"] = s.aclInfo
+
+	s.methods["one.system.version"] = func(call *onedCall) (interface{}, error) {
+		return "5.8.0", nil
+	}
+}
+
+// Virtual machines
+
+var onedVMStates = map[int]string{0: "INIT", 1: "PENDING", 2: "HOLD", 3: "ACTIVE", 4: "STOPPED", 5: "SUSPENDED",
+	6: "DONE", 8: "POWEROFF", 9: "UNDEPLOYED"}
+
+var onedLCMStates = map[int]string{0: "LCM_INIT", 1: "PROLOG", 2: "BOOT", 3: "RUNNING", 5: "SAVE_STOP", 6: "SAVE_SUSPEND",
+	9: "PROLOG_RESUME", 10: "EPILOG_STOP", 11: "EPILOG", 12: "SHUTDOWN", 17: "HOTPLUG", 18: "SHUTDOWN_POWEROFF",
+	20: "BOOT_POWEROFF", 21: "BOOT_SUSPENDED", 22: "BOOT_STOPPED", 25: "HOTPLUG_NIC", 30: "SHUTDOWN_UNDEPLOY",
+	31: "EPILOG_UNDEPLOY", 32: "PROLOG_UNDEPLOY", 33: "BOOT_UNDEPLOY"}
+
+// onedVMAttributes are kept in the VM template, the others go to the user template
+var onedVMAttributes = map[string]bool{"CPU": true, "VCPU": true, "MEMORY": true, "DISK": true, "NIC": true,
+	"NIC_ALIAS": true, "CONTEXT": true, "GRAPHICS": true, "OS": true, "FEATURES": true, "INPUT": true, "RAW": true,
+	"VMGROUP": true, "TEMPLATE_ID": true, "PCI": true, "TOPOLOGY": true, "CPU_COST": true, "MEMORY_COST": true,
+	"DISK_COST": true, "VROUTER_ID": true, "SECURITY_GROUP_RULE": true}
+
+func onedVMStateName(vm *onedObject) string {
+	if vm.state == 3 {
+		return onedLCMStates[vm.lcmState]
+	}
+
+	return onedVMStates[vm.state]
+}
+
+func (s *onedStandIn) vmAllocate(call *onedCall) (interface{}, error) {
+	tpl, err := parseOnedTemplate(call.str(0))
+	if err != nil {
+		return nil, onedErrorf(onedInternalError, "Parse error: %s", err)
+	}
+
+	vm, err := s.createVM(call, tpl, call.bool(1))
+	if err != nil {
+		return nil, err
+	}
+
+	return vm.id, nil
+}
+
+// createVM allocates a VM, acquires its images, leases and VM group role and
+// queues its deployment unless it is on hold
+func (s *onedStandIn) createVM(call *onedCall, tpl onedTemplate, hold bool) (*onedObject, error) {
+	tpl = tpl.clone()
+
+	if tpl.getInt("MEMORY", 0) <= 0 {
+		return nil, onedErrorf(onedAllocateError, "MEMORY attribute must be a positive integer value.")
+	}
+	if cpu, err := strconv.ParseFloat(tpl.get("CPU"), 64); err != nil || cpu <= 0 {
+		return nil, onedErrorf(onedAllocateError, "CPU attribute must be a positive float or integer value.")
+	}
+
+	vm := s.newObject("vm", tpl.get("NAME"), call.user.id, call.user.gid)
+	if vm.name == "" {
+		vm.name = fmt.Sprintf("one-%d", vm.id)
+	}
+
+	disks, nics := 0, 0
+	for _, attr := range tpl.without("NAME") {
+		if !onedVMAttributes[attr.name] {
+			vm.user = append(vm.user, attr)
+			continue
+		}
+
+		var err error
+		switch {
+		case attr.name == "DISK" && attr.vector != nil:
+			err = s.acquireDisk(vm, attr, disks)
+			disks++
+		case attr.name == "NIC" && attr.vector != nil:
+			err = s.acquireNIC(vm, attr, nics)
+			nics++
+		case attr.name == "VMGROUP" && attr.vector != nil:
+			err = s.joinVMGroup(vm, attr)
+		}
+		vm.template = append(vm.template, attr)
+
+		if err != nil {
+			s.releaseVM(vm)
+			s.remove(vm)
+			return nil, err
+		}
+	}
+
+	for _, context := range vm.template.vectors("CONTEXT") {
+		context.vector = context.vector.set("DISK_ID", disks).set("TARGET", s.nextTarget(vm, "hd"))
+	}
+	for _, graphics := range vm.template.vectors("GRAPHICS") {
+		graphics.vector = graphics.vector.set("PORT", 5900+vm.id)
+	}
+	vm.template = vm.template.set("VMID", vm.id)
+	s.securityGroupRules(vm)
+
+	if hold {
+		vm.state = 2
+	} else {
+		s.deployVM(vm)
+	}
+
+	return vm, nil
+}
+
+// deployVM queues the deployment of a pending VM on the first monitored host
+func (s *onedStandIn) deployVM(vm *onedObject) {
+	vm.state, vm.lcmState = 1, 0
+
+	var deploy func()
+	deploy = func() {
+		for _, id := range s.ids("host") {
+			if host := s.pools["host"][id]; host.state == 2 {
+				vm.fields["HID"] = strconv.Itoa(host.id)
+				vm.fields["DEPLOY_ID"] = fmt.Sprintf("one-%d", vm.id)
+				vm.state, vm.lcmState = 3, 2
+				vm.steps = append(vm.steps, s.vmState(vm, 3, 3))
+				return
+			}
+		}
+		vm.steps = append(vm.steps, deploy)
+	}
+	vm.steps = []func(){deploy}
+}
+
+// vmState returns a step setting the state of the VM, resources are released
+// when it is done
+func (s *onedStandIn) vmState(vm *onedObject, state, lcmState int) func() {
+	return func() {
+		vm.state, vm.lcmState = state, lcmState
+		if state == 6 {
+			s.releaseVM(vm)
+			vm.fields["ETIME"] = strconv.FormatInt(time.Now().Unix(), 10)
+		}
+	}
+}
+
+// moveVM sets the VM in the first state and queues the next ones
+func (s *onedStandIn) moveVM(vm *onedObject, states ...[2]int) {
+	vm.steps = nil
+	for i, state := range states {
+		if i == 0 {
+			s.vmState(vm, state[0], state[1])()
+			continue
+		}
+		vm.steps = append(vm.steps, s.vmState(vm, state[0], state[1]))
+	}
+}
+
+// nextTarget returns the first target with the prefix not used by the VM disks
+func (s *onedStandIn) nextTarget(vm *onedObject, prefix string) string {
+	used := make(map[string]bool)
+	for _, disk := range vm.template.vectors("DISK") {
+		used[disk.vector.get("TARGET")] = true
+	}
+	for _, context := range vm.template.vectors("CONTEXT") {
+		used[context.vector.get("TARGET")] = true
+	}
+
+	for c := 'a'; c <= 'z'; c++ {
+		target := prefix + string(c)
+		if !used[target] {
+			return target
+		}
+	}
+
+	return prefix
+}
+
+func (s *onedStandIn) acquireDisk(vm *onedObject, disk *onedAttr, id int) error {
+	d := disk.vector.set("DISK_ID", id)
+	disk.vector = d
+	prefix := d.get("DEV_PREFIX")
+
+	image := (*onedObject)(nil)
+	if d.has("IMAGE_ID") {
+		image = s.pools["image"][d.getInt("IMAGE_ID", -1)]
+		if image == nil {
+			return onedErrorf(onedAllocateError, "Error getting image [%s].", d.get("IMAGE_ID"))
+		}
+	} else if name := d.get("IMAGE"); name != "" {
+		for _, o := range s.pools["image"] {
+			if o.name == name && (o.uid == vm.uid || s.nameOf("user", o.uid) == d.get("IMAGE_UNAME")) {
+				image = o
+			}
+		}
+		if image == nil {
+			return onedErrorf(onedAllocateError, "Error getting image %s.", name)
+		}
+	}
+
+	if image != nil {
+		persistent := image.fields["PERSISTENT"] == "1"
+		switch {
+		case image.state != 1 && image.state != 2:
+			return onedErrorf(onedAllocateError, "Cannot acquire image %d, it is not ready.", image.id)
+		case persistent && len(image.ids["VMS"]) > 0:
+			return onedErrorf(onedAllocateError, "Cannot acquire image %d, it is persistent and already in use.", image.id)
+		}
+
+		image.ids["VMS"] = append(image.ids["VMS"], vm.id)
+		image.state = 2
+		if persistent {
+			image.state = 8
+		}
+
+		d = d.set("IMAGE", image.name).set("IMAGE_ID", image.id).set("IMAGE_UNAME", s.nameOf("user", image.uid))
+		d = d.set("DATASTORE", image.fields["DATASTORE"]).set("DATASTORE_ID", image.fields["DATASTORE_ID"])
+		d = d.set("SOURCE", image.fields["SOURCE"]).set("TM_MAD", "ssh").set("DISK_TYPE", "FILE")
+		if !d.has("SIZE") {
+			d = d.set("SIZE", image.fields["SIZE"])
+		}
+		if persistent {
+			d = d.set("PERSISTENT", "YES").set("CLONE", "NO")
+		} else {
+			d = d.set("CLONE", "YES")
+		}
+		if image.fields["TYPE"] == "1" {
+			d = d.set("TYPE", "CDROM").set("READONLY", "YES")
+		} else {
+			d = d.set("TYPE", "FILE").set("READONLY", "NO")
+		}
+		if driver := image.template.get("DRIVER"); driver != "" && !d.has("DRIVER") {
+			d = d.set("DRIVER", driver)
+		}
+		if prefix == "" {
+			prefix = image.template.get("DEV_PREFIX")
+		}
+	} else if !d.has("SIZE") {
+		return onedErrorf(onedAllocateError, "DISK %d: volatile disks need a SIZE.", id)
+	}
+
+	if prefix == "" {
+		prefix = "hd"
+	}
+	disk.vector = d
+	if !d.has("TARGET") {
+		disk.vector = d.set("TARGET", s.nextTarget(vm, prefix))
+	}
+
+	return nil
+}
+
+func (s *onedStandIn) releaseDisk(vm *onedObject, disk *onedAttr) {
+	if !disk.vector.has("IMAGE_ID") {
+		return
+	}
+
+	image, ok := s.pools["image"][disk.vector.getInt("IMAGE_ID", -1)]
+	if !ok || !onedContains(image.ids["VMS"], vm.id) {
+		return
+	}
+
+	image.ids["VMS"] = onedWithout(image.ids["VMS"], vm.id)
+	if len(image.ids["VMS"]) == 0 && (image.state == 2 || image.state == 8) {
+		image.state = 1
+	}
+}
+
+func (s *onedStandIn) acquireNIC(vm *onedObject, nic *onedAttr, id int) error {
+	n := nic.vector.set("NIC_ID", id)
+	nic.vector = n
+
+	vnet := (*onedObject)(nil)
+	if n.has("NETWORK_ID") {
+		vnet = s.pools["vn"][n.getInt("NETWORK_ID", -1)]
+		if vnet == nil {
+			return onedErrorf(onedAllocateError, "Error getting virtual network [%s].", n.get("NETWORK_ID"))
+		}
+	} else if name := n.get("NETWORK"); name != "" {
+		for _, o := range s.pools["vn"] {
+			if o.name == name && (o.uid == vm.uid || s.nameOf("user", o.uid) == n.get("NETWORK_UNAME")) {
+				vnet = o
+			}
+		}
+		if vnet == nil {
+			return onedErrorf(onedAllocateError, "Error getting virtual network %s.", name)
+		}
+	} else {
+		return onedErrorf(onedAllocateError, "NIC %d: no NETWORK or NETWORK_ID.", id)
+	}
+
+	lease, err := s.lease(vnet, n.get("IP"), "VM", vm.id)
+	if err != nil {
+		return err
+	}
+
+	n = n.set("NETWORK", vnet.name).set("NETWORK_ID", vnet.id).set("NETWORK_UNAME", s.nameOf("user", vnet.uid))
+	n = n.set("BRIDGE", vnet.fields["BRIDGE"]).set("VN_MAD", vnet.fields["VN_MAD"])
+	n = n.set("AR_ID", lease.vector.get("AR_ID")).set("MAC", lease.vector.get("MAC"))
+	if ip := lease.vector.get("IP"); ip != "" {
+		n = n.set("IP", ip)
+	}
+	n = n.set("TARGET", fmt.Sprintf("one-%d-%d", vm.id, id))
+	// Like oned, the security groups of the network are added to the ones of the NIC
+	secgroups := make([]int, 0)
+	for _, list := range []string{n.get("SECURITY_GROUPS"), vnet.template.get("SECURITY_GROUPS")} {
+		for _, sg := range strings.Split(list, ",") {
+			if sgid, err := strconv.Atoi(strings.TrimSpace(sg)); err == nil && !onedContains(secgroups, sgid) {
+				secgroups = append(secgroups, sgid)
+			}
+		}
+	}
+	if len(secgroups) > 0 {
+		sort.Ints(secgroups)
+		ids := make([]string, len(secgroups))
+		for i, sgid := range secgroups {
+			ids[i] = strconv.Itoa(sgid)
+		}
+		n = n.set("SECURITY_GROUPS", strings.Join(ids, ","))
+	}
+	nic.vector = n
+
+	return nil
+}
+
+func (s *onedStandIn) releaseNIC(vm *onedObject, nic *onedAttr) {
+	vnet, ok := s.pools["vn"][nic.vector.getInt("NETWORK_ID", -1)]
+	if !ok {
+		return
+	}
+
+	vnet.vectors["LEASES"] = onedAttrsWithout(vnet.vectors["LEASES"], func(lease *onedAttr) bool {
+		return lease.vector.get("VM") == strconv.Itoa(vm.id) && lease.vector.get("MAC") == nic.vector.get("MAC")
+	})
+}
+
+// releaseVM releases the images, the leases and the VM group role of the VM
+func (s *onedStandIn) releaseVM(vm *onedObject) {
+	for _, disk := range vm.template.vectors("DISK") {
+		s.releaseDisk(vm, disk)
+	}
+	for _, nic := range vm.template.vectors("NIC") {
+		s.releaseNIC(vm, nic)
+	}
+	for _, vmgroup := range vm.template.vectors("VMGROUP") {
+		s.leaveVMGroup(vm, vmgroup)
+	}
+}
+
+// securityGroupRules copies the rules of the security groups of the NICs to the VM
+func (s *onedStandIn) securityGroupRules(vm *onedObject) {
+	vm.template = vm.template.without("SECURITY_GROUP_RULE")
+
+	added := make(map[int]bool)
+	for _, nic := range vm.template.vectors("NIC") {
+		for _, sgid := range strings.Split(nic.vector.get("SECURITY_GROUPS"), ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(sgid))
+			sg, ok := s.pools["secgroup"][id]
+			if err != nil || !ok || added[id] {
+				continue
+			}
+			added[id] = true
+
+			for _, rule := range sg.template.vectors("RULE") {
+				r := rule.vector.clone().set("SECURITY_GROUP_ID", sg.id).set("SECURITY_GROUP_NAME", sg.name)
+				vm.template = append(vm.template, &onedAttr{name: "SECURITY_GROUP_RULE", vector: r})
+			}
+		}
+	}
+}
+
+func (s *onedStandIn) vmAction(call *onedCall) (interface{}, error) {
+	action := call.str(0)
+	vm, err := s.object(call, "vm", call.int(1), onedRightManage)
+	if err != nil {
+		return nil, err
+	}
+
+	running := vm.state == 3 && vm.lcmState == 3
+	available := false
+	switch action {
+	case "terminate", "terminate-hard":
+		available = vm.state != 6
+		switch {
+		case !available:
+		case vm.state == 1 || vm.state == 2:
+			s.moveVM(vm, [2]int{6, 0})
+		case running:
+			s.moveVM(vm, [2]int{3, 12}, [2]int{6, 0})
+		default:
+			s.moveVM(vm, [2]int{3, 11}, [2]int{6, 0})
+		}
+	case "poweroff", "poweroff-hard":
+		if available = running; available {
+			s.moveVM(vm, [2]int{3, 18}, [2]int{8, 0})
+		}
+	case "suspend":
+		if available = running; available {
+			s.moveVM(vm, [2]int{3, 6}, [2]int{5, 0})
+		}
+	case "stop":
+		if available = running; available {
+			s.moveVM(vm, [2]int{3, 5}, [2]int{3, 10}, [2]int{4, 0})
+		}
+	case "undeploy", "undeploy-hard":
+		if available = running || vm.state == 8; available {
+			s.moveVM(vm, [2]int{3, 30}, [2]int{3, 31}, [2]int{9, 0})
+		}
+	case "resume":
+		available = true
+		switch vm.state {
+		case 8:
+			s.moveVM(vm, [2]int{3, 20}, [2]int{3, 3})
+		case 5:
+			s.moveVM(vm, [2]int{3, 21}, [2]int{3, 3})
+		case 4:
+			s.moveVM(vm, [2]int{1, 0}, [2]int{3, 9}, [2]int{3, 22}, [2]int{3, 3})
+		case 9:
+			s.moveVM(vm, [2]int{1, 0}, [2]int{3, 32}, [2]int{3, 33}, [2]int{3, 3})
+		default:
+			available = false
+		}
+	case "reboot", "reboot-hard":
+		available = running
+	case "hold":
+		if available = vm.state == 1; available {
+			s.moveVM(vm, [2]int{2, 0})
+		}
+	case "release":
+		if available = vm.state == 2; available {
+			s.deployVM(vm)
+		}
+	}
+
+	if !available {
+		return nil, onedErrorf(onedActionError, "Error performing action \"%s\": This action is not available for state %s",
+			action, onedVMStateName(vm))
+	}
+
+	return vm.id, nil
+}
+
+// vmResize changes the capacity of the VM, which can not be running
+func (s *onedStandIn) vmResize(call *onedCall) (interface{}, error) {
+	vm, err := s.object(call, "vm", call.int(0), onedRightManage)
+	if err != nil {
+		return nil, err
+	}
+
+	if vm.state != 1 && vm.state != 2 && vm.state != 8 && vm.state != 9 {
+		return nil, onedErrorf(onedActionError, "Resize action is not available for state %s", onedVMStateName(vm))
+	}
+
+	tpl, err := parseOnedTemplate(call.str(1))
+	if err != nil {
+		return nil, onedErrorf(onedInternalError, "Parse error: %s", err)
+	}
+	for _, name := range []string{"CPU", "VCPU", "MEMORY"} {
+		if value := tpl.get(name); value != "" {
+			vm.template = vm.template.set(name, value)
+		}
+	}
+
+	return vm.id, nil
+}
+
+// hotplug checks the VM accepts a hotplug operation, and goes through the LCM
+// state of the operation if the VM is running
+func (s *onedStandIn) hotplug(call *onedCall, lcmState int) (*onedObject, error) {
+	vm, err := s.object(call, "vm", call.int(0), onedRightManage)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case vm.state == 3 && vm.lcmState == 3:
+		s.moveVM(vm, [2]int{3, lcmState}, [2]int{3, 3})
+	case vm.state != 8:
+		return nil, onedErrorf(onedActionError, "Could not perform the operation: This action is not available for state %s",
+			onedVMStateName(vm))
+	}
+
+	return vm, nil
+}
+
+// nextID returns the next free ID of the vectors, by their ID attribute
+func onedNextID(vectors []*onedAttr, attribute string) int {
+	next := 0
+	for _, v := range vectors {
+		if id := v.vector.getInt(attribute, -1); id >= next {
+			next = id + 1
+		}
+	}
+
+	return next
+}
+
+func (s *onedStandIn) vmAttachDisk(call *onedCall) (interface{}, error) {
+	tpl, err := parseOnedTemplate(call.str(1))
+	if err != nil || len(tpl.vectors("DISK")) != 1 {
+		return nil, onedErrorf(onedInternalError, "Wrong DISK template.")
+	}
+
+	vm, err := s.hotplug(call, 17)
+	if err != nil {
+		return nil, err
+	}
+
+	disk := tpl.vectors("DISK")[0]
+	id := onedNextID(append(vm.template.vectors("DISK"), vm.template.vectors("CONTEXT")...), "DISK_ID")
+	if err := s.acquireDisk(vm, disk, id); err != nil {
+		return nil, onedErrorf(onedActionError, "%s", err)
+	}
+	vm.template = append(vm.template, disk)
+
+	return vm.id, nil
+}
+
+func (s *onedStandIn) vmDetachDisk(call *onedCall) (interface{}, error) {
+	vm, err := s.hotplug(call, 17)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, disk := range vm.template.vectors("DISK") {
+		if disk.vector.getInt("DISK_ID", -1) == call.int(1) {
+			s.releaseDisk(vm, disk)
+			vm.template = vm.template.withoutAttr(disk)
+			return vm.id, nil
+		}
+	}
+
+	return nil, onedErrorf(onedActionError, "VM %d does not have DISK %d.", vm.id, call.int(1))
+}
+
+func (s *onedStandIn) vmAttachNIC(call *onedCall) (interface{}, error) {
+	tpl, err := parseOnedTemplate(call.str(1))
+	if err != nil || len(tpl.vectors("NIC")) != 1 {
+		return nil, onedErrorf(onedInternalError, "Wrong NIC template.")
+	}
+
+	vm, err := s.hotplug(call, 25)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.attachNIC(vm, tpl.vectors("NIC")[0]); err != nil {
+		return nil, err
+	}
+
+	return vm.id, nil
+}
+
+func (s *onedStandIn) attachNIC(vm *onedObject, nic *onedAttr) error {
+	id := onedNextID(vm.template.vectors("NIC"), "NIC_ID")
+	if err := s.acquireNIC(vm, nic, id); err != nil {
+		return onedErrorf(onedActionError, "%s", err)
+	}
+	vm.template = append(vm.template, nic)
+	s.securityGroupRules(vm)
+
+	return nil
+}
+
+func (s *onedStandIn) vmDetachNIC(call *onedCall) (interface{}, error) {
+	vm, err := s.hotplug(call, 25)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.detachNIC(vm, call.int(1)); err != nil {
+		return nil, err
+	}
+
+	return vm.id, nil
+}
+
+func (s *onedStandIn) detachNIC(vm *onedObject, id int) error {
+	for _, nic := range vm.template.vectors("NIC") {
+		if nic.vector.getInt("NIC_ID", -1) == id {
+			s.releaseNIC(vm, nic)
+			vm.template = vm.template.withoutAttr(nic)
+			s.securityGroupRules(vm)
+			return nil
+		}
+	}
+
+	return onedErrorf(onedActionError, "VM %d does not have NIC %d.", vm.id, id)
+}
+
+func (s *onedStandIn) renderVM(vm *onedObject, b *bytes.Buffer) {
+	etime := vm.fields["ETIME"]
+	if etime == "" {
+		etime = "0"
+	}
+
+	onedField(b, "LAST_POLL", 0)
+	onedField(b, "STATE", vm.state)
+	onedField(b, "LCM_STATE", vm.lcmState)
+	onedField(b, "PREV_STATE", vm.state)
+	onedField(b, "PREV_LCM_STATE", vm.lcmState)
+	onedField(b, "RESCHED", 0)
+	onedField(b, "STIME", vm.regtime)
+	onedField(b, "ETIME", etime)
+	vm.writeFields(b, "DEPLOY_ID")
+	b.WriteString("<MONITORING></MONITORING>")
+	b.WriteString("<USER_TEMPLATE>")
+	vm.user.write(b)
+	b.WriteString("</USER_TEMPLATE>")
+
+	b.WriteString("<HISTORY_RECORDS>")
+	if hid, ok := vm.fields["HID"]; ok {
+		host := s.pools["host"][onedAtoi(hid)]
+		b.WriteString("<HISTORY>")
+		onedField(b, "OID", vm.id)
+		onedField(b, "SEQ", 0)
+		if host != nil {
+			onedField(b, "HOSTNAME", host.name)
+			onedField(b, "CID", host.fields["CLUSTER_ID"])
+			onedField(b, "VM_MAD", host.fields["VM_MAD"])
+		}
+		onedField(b, "HID", hid)
+		onedField(b, "STIME", vm.regtime)
+		onedField(b, "ETIME", etime)
+		onedField(b, "DS_ID", 0)
+		onedField(b, "TM_MAD", "ssh")
+		onedField(b, "ACTION", 0)
+		b.WriteString("</HISTORY>")
+	}
+	b.WriteString("</HISTORY_RECORDS>")
+}
+
+// VM templates
+
+func (s *onedStandIn) templateAllocate(call *onedCall) (interface{}, error) {
+	o, _, err := s.allocateTemplate(call)
+	if err != nil {
+		return nil, err
+	}
+
+	return o.id, nil
+}
+
+func (s *onedStandIn) templateClone(call *onedCall) (interface{}, error) {
+	source, err := s.object(call, "template", call.int(0), onedRightUse)
+	if err != nil {
+		return nil, err
+	}
+
+	o, err := s.allocate(call, call.str(1))
+	if err != nil {
+		return nil, err
+	}
+	o.template = source.template.clone()
+
+	return o.id, nil
+}
+
+// templateInstantiate creates a VM from the template merged with the extra
+// template of the call
+func (s *onedStandIn) templateInstantiate(call *onedCall) (interface{}, error) {
+	source, err := s.object(call, "template", call.int(0), onedRightUse)
+	if err != nil {
+		return nil, err
+	}
+
+	extra, err := parseOnedTemplate(call.str(3))
+	if err != nil {
+		return nil, onedErrorf(onedInternalError, "Parse error: %s", err)
+	}
+
+	name := call.str(1)
+	if name == "" {
+		name = fmt.Sprintf("%s-%d", source.name, s.nextID["vm"])
+	}
+	tpl := source.template.merge(extra).without("NAME").set("NAME", name).set("TEMPLATE_ID", source.id)
+
+	vm, err := s.createVM(call, tpl, call.bool(2))
+	if err != nil {
+		return nil, err
+	}
+
+	return vm.id, nil
+}
+
+func (s *onedStandIn) renderTemplate(o *onedObject, b *bytes.Buffer) {
+	onedField(b, "REGTIME", o.regtime)
+}
+
+// Images
+
+var onedImageTypes = []string{"OS", "CDROM", "DATABLOCK", "KERNEL", "RAMDISK", "CONTEXT"}
+
+// onedIndex returns the position of the value in the list, or -1
+func onedIndex(list []string, value string) int {
+	for i, v := range list {
+		if v == value {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// imageAllocate registers an image, from a path, a marketplace app or empty.
+// The image is LOCKED until its first read.
+func (s *onedStandIn) imageAllocate(call *onedCall) (interface{}, error) {
+	ds, err := s.object(call, "datastore", call.int(1), onedRightUse)
+	if err != nil {
+		return nil, err
+	}
+	if ds.template.get("TYPE") == "SYSTEM_DS" {
+		return nil, onedErrorf(onedAllocateError, "New images cannot be allocated in a system datastore.")
+	}
+
+	tpl, err := parseOnedTemplate(call.str(0))
+	if err != nil {
+		return nil, onedErrorf(onedInternalError, "Parse error: %s", err)
+	}
+
+	imagetype := strings.ToUpper(tpl.get("TYPE"))
+	if imagetype == "" {
+		imagetype = "OS"
+	}
+	if onedIndex(onedImageTypes, imagetype) < 0 {
+		return nil, onedErrorf(onedAllocateError, "Unknown IMAGE TYPE %s.", imagetype)
+	}
+
+	size := tpl.getInt("SIZE", -1)
+	path := tpl.get("PATH")
+	if appID := tpl.get("FROM_APP"); appID != "" {
+		app, ok := s.pools["marketapp"][onedAtoi(appID)]
+		if !ok {
+			return nil, onedErrorf(onedAllocateError, "Error getting marketplaceapp [%s].", appID)
+		}
+		if app.state != 1 {
+			return nil, onedErrorf(onedAllocateError, "Marketplace app %d is not ready.", app.id)
+		}
+		size = onedAtoi(app.fields["SIZE"])
+		path = fmt.Sprintf("marketplace://%d/%d", onedAtoi(app.fields["MARKETPLACE_ID"]), app.id)
+		tpl = tpl.set("FROM_APP_NAME", app.name)
+	}
+	if size < 0 {
+		if path == "" {
+			return nil, onedErrorf(onedAllocateError, "SIZE attribute must be a positive integer value.")
+		}
+		size = 256
+	}
+
+	o, err := s.allocate(call, tpl.get("NAME"))
+	if err != nil {
+		return nil, err
+	}
+
+	o.fields["TYPE"] = strconv.Itoa(onedIndex(onedImageTypes, imagetype))
+	o.fields["PERSISTENT"] = "0"
+	if strings.ToUpper(tpl.get("PERSISTENT")) == "YES" {
+		o.fields["PERSISTENT"] = "1"
+	}
+	o.fields["SIZE"] = strconv.Itoa(size)
+	o.fields["PATH"] = path
+	o.fields["SOURCE"] = fmt.Sprintf("/var/lib/one//datastores/%d/%x", ds.id, sha256.Sum256([]byte(o.name)))
+	o.fields["DATASTORE_ID"] = strconv.Itoa(ds.id)
+	o.fields["DATASTORE"] = ds.name
+	for _, name := range []string{"NAME", "TYPE", "PERSISTENT", "SIZE", "PATH", "SOURCE"} {
+		tpl = tpl.without(name)
+	}
+	o.template = tpl
+
+	s.lockImage(o)
+
+	return o.id, nil
+}
+
+// lockImage sets the image LOCKED while the datastore copies it
+func (s *onedStandIn) lockImage(o *onedObject) {
+	o.state = 4
+	o.steps = []func(){func() { o.state = 1 }}
+}
+
+func (s *onedStandIn) imageClone(call *onedCall) (interface{}, error) {
+	source, err := s.object(call, "image", call.int(0), onedRightUse)
+	if err != nil {
+		return nil, err
+	}
+	if source.state != 1 && source.state != 2 {
+		return nil, onedErrorf(onedActionError, "Cannot clone image in state %d.", source.state)
+	}
+
+	dsID := call.int(2)
+	if dsID < 0 {
+		dsID = onedAtoi(source.fields["DATASTORE_ID"])
+	}
+	ds, err := s.object(call, "datastore", dsID, onedRightUse)
+	if err != nil {
+		return nil, err
+	}
+
+	o, err := s.allocate(call, call.str(1))
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range source.fields {
+		o.fields[name] = value
+	}
+	o.fields["DATASTORE_ID"] = strconv.Itoa(ds.id)
+	o.fields["DATASTORE"] = ds.name
+	o.fields["SOURCE"] = fmt.Sprintf("/var/lib/one//datastores/%d/%x", ds.id, sha256.Sum256([]byte(o.name)))
+	o.template = source.template.clone()
+
+	s.lockImage(o)
+
+	return o.id, nil
+}
+
+func (s *onedStandIn) imagePersistent(call *onedCall) (interface{}, error) {
+	o, err := s.object(call, "image", call.int(0), onedRightManage)
+	if err != nil {
+		return nil, err
+	}
+	if len(o.ids["VMS"]) > 0 {
+		return nil, onedErrorf(onedActionError, "Cannot change persistent state of image %d, it is being used by %d VMs.",
+			o.id, len(o.ids["VMS"]))
+	}
+
+	o.fields["PERSISTENT"] = "0"
+	if call.bool(1) {
+		o.fields["PERSISTENT"] = "1"
+	}
+
+	return o.id, nil
+}
+
+func (s *onedStandIn) imageChtype(call *onedCall) (interface{}, error) {
+	o, err := s.object(call, "image", call.int(0), onedRightManage)
+	if err != nil {
+		return nil, err
+	}
+
+	imagetype := onedIndex(onedImageTypes, strings.ToUpper(call.str(1)))
+	if imagetype < 0 {
+		return nil, onedErrorf(onedActionError, "Unknown IMAGE TYPE %s.", call.str(1))
+	}
+	o.fields["TYPE"] = strconv.Itoa(imagetype)
+
+	return o.id, nil
+}
+
+func (s *onedStandIn) imageEnable(call *onedCall) (interface{}, error) {
+	o, err := s.object(call, "image", call.int(0), onedRightManage)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case call.bool(1) && (o.state == 1 || o.state == 3):
+		o.state = 1
+	case !call.bool(1) && (o.state == 1 || o.state == 3):
+		o.state = 3
+	default:
+		return nil, onedErrorf(onedActionError, "Image %d cannot be enabled or disabled in state %d.", o.id, o.state)
+	}
+
+	return o.id, nil
+}
+
+// removeImage goes through the DELETE state before removing the image
+func (s *onedStandIn) removeImage(call *onedCall, o *onedObject) error {
+	if len(o.ids["VMS"]) > 0 {
+		return onedErrorf(onedActionError, "Cannot delete image %d, it is being used by %d VMs.", o.id, len(o.ids["VMS"]))
+	}
+
+	o.state = 7
+	o.steps = []func(){func() { s.remove(o) }}
+
+	return nil
+}
+
+func (s *onedStandIn) renderImage(o *onedObject, b *bytes.Buffer) {
+	onedField(b, "REGTIME", o.regtime)
+	o.writeFields(b, "SOURCE", "PATH", "SIZE")
+	onedField(b, "STATE", o.state)
+	onedField(b, "RUNNING_VMS", len(o.ids["VMS"]))
+	onedField(b, "CLONING_OPS", 0)
+	onedField(b, "CLONING_ID", -1)
+	onedField(b, "TARGET_SNAPSHOT", -1)
+	o.writeFields(b, "DATASTORE_ID", "DATASTORE", "TYPE", "PERSISTENT")
+	onedField(b, "DISK_TYPE", 0)
+	onedIDs(b, "VMS", o.ids["VMS"])
+	onedIDs(b, "CLONES", nil)
+	onedIDs(b, "APP_CLONES", nil)
+	b.WriteString("<SNAPSHOTS><ALLOW_ORPHANS>NO</ALLOW_ORPHANS><CURRENT_BASE>-1</CURRENT_BASE><NEXT_SNAPSHOT>0</NEXT_SNAPSHOT></SNAPSHOTS>")
+}
+
+// Virtual networks
+
+var onedARTypes = []string{"IP4", "IP6", "IP6_STATIC", "IP4_6", "IP4_6_STATIC", "ETHER"}
+
+// onedIPAdd returns the IPv4 address following the given one by n
+func onedIPAdd(ip string, n int) string {
+	ip4 := net.ParseIP(ip).To4()
+	if ip4 == nil {
+		return ""
+	}
+
+	next := make(net.IP, 4)
+	binary.BigEndian.PutUint32(next, binary.BigEndian.Uint32(ip4)+uint32(n))
+	return next.String()
+}
+
+// onedMACAdd returns the MAC address following the given one by n
+func onedMACAdd(mac string, n int) string {
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) != 6 {
+		return ""
+	}
+
+	next := make(net.HardwareAddr, 6)
+	copy(next, hw)
+	binary.BigEndian.PutUint32(next[2:], binary.BigEndian.Uint32(hw[2:])+uint32(n))
+	return next.String()
+}
+
+// addAR validates an address range and adds it to the network, the first
+// MAC address is derived from the IP as oned does
+func (s *onedStandIn) addAR(vnet *onedObject, ar *onedAttr) error {
+	a := ar.vector.clone()
+
+	artype := strings.ToUpper(a.get("TYPE"))
+	switch {
+	case onedIndex(onedARTypes, artype) < 0:
+		return onedErrorf(onedActionError, "Unknown or missing TYPE for address range.")
+	case a.getInt("SIZE", 0) <= 0 && artype != "IP6_STATIC" && artype != "IP4_6_STATIC":
+		return onedErrorf(onedActionError, "Wrong SIZE for address range.")
+	case strings.HasPrefix(artype, "IP4") && net.ParseIP(a.get("IP")).To4() == nil:
+		return onedErrorf(onedActionError, "Wrong or missing IP for address range.")
+	}
+
+	id := onedNextID(vnet.vectors["AR_POOL"], "AR_ID")
+	a = a.set("AR_ID", id).set("TYPE", artype)
+	if !a.has("MAC") {
+		if ip := net.ParseIP(a.get("IP")).To4(); ip != nil {
+			a = a.set("MAC", fmt.Sprintf("02:00:%02x:%02x:%02x:%02x", ip[0], ip[1], ip[2], ip[3]))
+		} else {
+			a = a.set("MAC", fmt.Sprintf("02:00:00:%02x:%02x:00", vnet.id&0xff, id&0xff))
+		}
+	}
+	vnet.vectors["AR_POOL"] = append(vnet.vectors["AR_POOL"], &onedAttr{name: "AR", vector: a})
+
+	return nil
+}
+
+func (s *onedStandIn) leased(vnet *onedObject, mac string) bool {
+	for _, lease := range vnet.vectors["LEASES"] {
+		if lease.vector.get("MAC") == mac {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lease takes the given IP, or the first free address, for a VM, a
+// reservation network ("VNET") or a virtual router
+func (s *onedStandIn) lease(vnet *onedObject, ip string, owner string, ownerID int) (*onedAttr, error) {
+	for _, ar := range vnet.vectors["AR_POOL"] {
+		for i := 0; i < ar.vector.getInt("SIZE", 0); i++ {
+			leaseIP := onedIPAdd(ar.vector.get("IP"), i)
+			if ip != "" && leaseIP != ip {
+				continue
+			}
+
+			mac := onedMACAdd(ar.vector.get("MAC"), i)
+			if s.leased(vnet, mac) {
+				if ip != "" {
+					return nil, onedErrorf(onedActionError, "IP %s is already in use in virtual network %d.", ip, vnet.id)
+				}
+				continue
+			}
+
+			lease := &onedAttr{name: "LEASE", vector: onedTemplate{}}
+			if leaseIP != "" {
+				lease.vector = lease.vector.set("IP", leaseIP)
+			}
+			lease.vector = lease.vector.set("MAC", mac).set(owner, ownerID).set("AR_ID", ar.vector.get("AR_ID"))
+			vnet.vectors["LEASES"] = append(vnet.vectors["LEASES"], lease)
+
+			return lease, nil
+		}
+	}
+
+	return nil, onedErrorf(onedActionError, "Cannot get IP/MAC lease from virtual network %d.", vnet.id)
+}
+
+// onedAttrsWithout returns the attributes, like leases, not matching the filter
+func onedAttrsWithout(attrs onedTemplate, match func(attr *onedAttr) bool) onedTemplate {
+	kept := onedTemplate{}
+	for _, attr := range attrs {
+		if !match(attr) {
+			kept = append(kept, attr)
+		}
+	}
+
+	return kept
+}
+
+func (s *onedStandIn) vnetAllocate(call *onedCall) (interface{}, error) {
+	tpl, err := parseOnedTemplate(call.str(0))
+	if err != nil {
+		return nil, onedErrorf(onedInternalError, "Parse error: %s", err)
+	}
+	if tpl.get("VN_MAD") == "" {
+		return nil, onedErrorf(onedAllocateError, "No VN_MAD in template.")
+	}
+
+	clusterID := call.int(1)
+	if clusterID < 0 {
+		clusterID = 0
+	}
+	if _, err := s.object(call, "cluster", clusterID, onedRightUse); err != nil {
+		return nil, err
+	}
+
+	o, err := s.allocate(call, tpl.get("NAME"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range []string{"VN_MAD", "BRIDGE", "PHYDEV", "VLAN_ID", "OUTER_VLAN_ID", "BRIDGE_TYPE"} {
+		if value := tpl.get(name); value != "" {
+			o.fields[name] = value
+		}
+		tpl = tpl.without(name)
+	}
+	if o.fields["BRIDGE"] == "" {
+		o.fields["BRIDGE"] = fmt.Sprintf("onebr%d", o.id)
+	}
+
+	for _, ar := range tpl.vectors("AR") {
+		if err := s.addAR(o, ar); err != nil {
+			s.remove(o)
+			return nil, err
+		}
+	}
+
+	o.template = tpl.without("NAME").without("AR")
+	if !o.template.has("SECURITY_GROUPS") {
+		o.template = o.template.set("SECURITY_GROUPS", "0")
+	}
+	o.ids["CLUSTERS"] = []int{clusterID}
+
+	return o.id, nil
+}
+
+func (s *onedStandIn) vnetAddAR(call *onedCall) (interface{}, error) {
+	vnet, err := s.object(call, "vn", call.int(0), onedRightManage)
+	if err != nil {
+		return nil, err
+	}
+
+	tpl, err := parseOnedTemplate(call.str(1))
+	if err != nil {
+		return nil, onedErrorf(onedInternalError, "Parse error: %s", err)
+	}
+	for _, ar := range tpl.vectors("AR") {
+		if err := s.addAR(vnet, ar); err != nil {
+			return nil, err
+		}
+	}
+
+	return vnet.id, nil
+}
+
+// ar returns the address range of the network with the ID
+func (s *onedStandIn) ar(vnet *onedObject, id int) (*onedAttr, error) {
+	for _, ar := range vnet.vectors["AR_POOL"] {
+		if ar.vector.getInt("AR_ID", -1) == id {
+			return ar, nil
+		}
+	}
+
+	return nil, onedErrorf(onedActionError, "Address Range %d does not exist in virtual network %d.", id, vnet.id)
+}
+
+func (s *onedStandIn) vnetRmAR(call *onedCall) (interface{}, error) {
+	vnet, err := s.object(call, "vn", call.int(0), onedRightManage)
+	if err != nil {
+		return nil, err
+	}
+
+	ar, err := s.ar(vnet, call.int(1))
+	if err != nil {
+		return nil, err
+	}
+
+	arID := ar.vector.get("AR_ID")
+	for _, lease := range vnet.vectors["LEASES"] {
+		if lease.vector.get("AR_ID") == arID && lease.vector.get("VM") != "-1" {
+			return nil, onedErrorf(onedActionError, "Cannot remove Address Range %s, it has used leases.", arID)
+		}
+	}
+
+	vnet.vectors["LEASES"] = onedAttrsWithout(vnet.vectors["LEASES"], func(lease *onedAttr) bool {
+		return lease.vector.get("AR_ID") == arID
+	})
+	vnet.vectors["AR_POOL"] = vnet.vectors["AR_POOL"].withoutAttr(ar)
+
+	return vnet.id, nil
+}
+
+// vnetUpdateAR updates the attributes of an address range, its type and first
+// addresses can not be changed
+func (s *onedStandIn) vnetUpdateAR(call *onedCall) (interface{}, error) {
+	vnet, err := s.object(call, "vn", call.int(0), onedRightManage)
+	if err != nil {
+		return nil, err
+	}
+
+	tpl, err := parseOnedTemplate(call.str(1))
+	if err != nil {
+		return nil, onedErrorf(onedInternalError, "Parse error: %s", err)
+	}
+	for _, update := range tpl.vectors("AR") {
+		ar, err := s.ar(vnet, update.vector.getInt("AR_ID", -1))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range []string{"AR_ID", "TYPE", "IP", "MAC"} {
+			update.vector = update.vector.without(name)
+		}
+		ar.vector = ar.vector.merge(update.vector)
+	}
+
+	return vnet.id, nil
+}
+
+// vnetReserve moves free addresses of the network to a reservation, a new
+// network unless NETWORK_ID is given
+func (s *onedStandIn) vnetReserve(call *onedCall) (interface{}, error) {
+	parent, err := s.object(call, "vn", call.int(0), onedRightUse)
+	if err != nil {
+		return nil, err
+	}
+
+	tpl, err := parseOnedTemplate(call.str(1))
+	if err != nil {
+		return nil, onedErrorf(onedInternalError, "Parse error: %s", err)
+	}
+	size := tpl.getInt("SIZE", 0)
+	if size <= 0 {
+		return nil, onedErrorf(onedActionError, "Reservation SIZE must be a positive integer.")
+	}
+
+	// Look for enough contiguous free addresses
+	var ar *onedAttr
+	start := -1
+	for _, candidate := range parent.vectors["AR_POOL"] {
+		if tpl.has("AR_ID") && candidate.vector.getInt("AR_ID", -1) != tpl.getInt("AR_ID", -1) {
+			continue
+		}
+		free := 0
+		for i := 0; i < candidate.vector.getInt("SIZE", 0) && start < 0; i++ {
+			if s.leased(parent, onedMACAdd(candidate.vector.get("MAC"), i)) {
+				free = 0
+				continue
+			}
+			if free++; free == size {
+				ar, start = candidate, i-size+1
+			}
+		}
+	}
+	if ar == nil {
+		return nil, onedErrorf(onedActionError, "Not enough free addresses in an address range.")
+	}
+
+	var vnet *onedObject
+	if tpl.has("NETWORK_ID") {
+		vnet, err = s.object(call, "vn", tpl.getInt("NETWORK_ID", -1), onedRightManage)
+		if err != nil {
+			return nil, err
+		}
+		if vnet.fields["PARENT_NETWORK_ID"] != strconv.Itoa(parent.id) {
+			return nil, onedErrorf(onedActionError, "Virtual network %d is not a reservation of %d.", vnet.id, parent.id)
+		}
+	} else {
+		vnet, err = s.allocate(call, tpl.get("NAME"))
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range parent.fields {
+			vnet.fields[name] = value
+		}
+		vnet.fields["PARENT_NETWORK_ID"] = strconv.Itoa(parent.id)
+		vnet.ids["CLUSTERS"] = append([]int{}, parent.ids["CLUSTERS"]...)
+		vnet.template = parent.template.clone()
+	}
+
+	reservation := onedTemplate{}.set("TYPE", ar.vector.get("TYPE")).set("SIZE", size)
+	if ip := onedIPAdd(ar.vector.get("IP"), start); ip != "" {
+		reservation = reservation.set("IP", ip)
+	}
+	reservation = reservation.set("MAC", onedMACAdd(ar.vector.get("MAC"), start)).set("PARENT_NETWORK_AR_ID", ar.vector.get("AR_ID"))
+	if err := s.addAR(vnet, &onedAttr{name: "AR", vector: reservation}); err != nil {
+		return nil, err
+	}
+
+	for i := start; i < start+size; i++ {
+		lease := &onedAttr{name: "LEASE", vector: onedTemplate{}}
+		if ip := onedIPAdd(ar.vector.get("IP"), i); ip != "" {
+			lease.vector = lease.vector.set("IP", ip)
+		}
+		lease.vector = lease.vector.set("MAC", onedMACAdd(ar.vector.get("MAC"), i)).set("VNET", vnet.id).set("AR_ID", ar.vector.get("AR_ID"))
+		parent.vectors["LEASES"] = append(parent.vectors["LEASES"], lease)
+	}
+
+	return vnet.id, nil
+}
+
+// leaseAddress returns the address of the LEASES attribute of hold and release calls
+func onedLeaseAddress(call *onedCall) (string, error) {
+	tpl, err := parseOnedTemplate(call.str(1))
+	if err != nil {
+		return "", onedErrorf(onedInternalError, "Parse error: %s", err)
+	}
+
+	leases := tpl.vectors("LEASES")
+	if len(leases) != 1 || leases[0].vector.get("IP") == "" {
+		return "", onedErrorf(onedActionError, "Wrong LEASES template, an IP is needed.")
+	}
+
+	return leases[0].vector.get("IP"), nil
+}
+
+// vnetHold puts an address on hold, as a lease of VM -1
+func (s *onedStandIn) vnetHold(call *onedCall) (interface{}, error) {
+	vnet, err := s.object(call, "vn", call.int(0), onedRightManage)
+	if err != nil {
+		return nil, err
+	}
+
+	ip, err := onedLeaseAddress(call)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.lease(vnet, ip, "VM", -1); err != nil {
+		return nil, err
+	}
+
+	return vnet.id, nil
+}
+
+func (s *onedStandIn) vnetRelease(call *onedCall) (interface{}, error) {
+	vnet, err := s.object(call, "vn", call.int(0), onedRightManage)
+	if err != nil {
+		return nil, err
+	}
+
+	ip, err := onedLeaseAddress(call)
+	if err != nil {
+		return nil, err
+	}
+
+	held := func(lease *onedAttr) bool {
+		return lease.vector.get("IP") == ip && lease.vector.get("VM") == "-1"
+	}
+	leases := onedAttrsWithout(vnet.vectors["LEASES"], held)
+	if len(leases) == len(vnet.vectors["LEASES"]) {
+		return nil, onedErrorf(onedActionError, "IP %s is not on hold in virtual network %d.", ip, vnet.id)
+	}
+	vnet.vectors["LEASES"] = leases
+
+	return vnet.id, nil
+}
+
+// removeVNet refuses to remove networks with used leases or reservations, and
+// gives the addresses of reservations back to their parent
+func (s *onedStandIn) removeVNet(call *onedCall, vnet *onedObject) error {
+	for _, lease := range vnet.vectors["LEASES"] {
+		if lease.vector.has("VNET") {
+			return onedErrorf(onedActionError, "Can not remove a virtual network with reservations.")
+		}
+		if lease.vector.get("VM") != "-1" {
+			return onedErrorf(onedActionError, "Can not remove a virtual network with leases in use.")
+		}
+	}
+
+	if parent, ok := s.pools["vn"][onedAtoi(vnet.fields["PARENT_NETWORK_ID"])]; ok && vnet.fields["PARENT_NETWORK_ID"] != "" {
+		parent.vectors["LEASES"] = onedAttrsWithout(parent.vectors["LEASES"], func(lease *onedAttr) bool {
+			return lease.vector.get("VNET") == strconv.Itoa(vnet.id)
+		})
+	}
+	s.remove(vnet)
+
+	return nil
+}
+
+func (s *onedStandIn) renderVNet(o *onedObject, b *bytes.Buffer) {
+	onedIDs(b, "CLUSTERS", o.ids["CLUSTERS"])
+	o.writeFields(b, "BRIDGE", "BRIDGE_TYPE")
+	onedField(b, "PARENT_NETWORK_ID", o.fields["PARENT_NETWORK_ID"])
+	o.writeFields(b, "VN_MAD", "PHYDEV", "VLAN_ID", "OUTER_VLAN_ID")
+	onedField(b, "VLAN_ID_AUTOMATIC", 0)
+	onedField(b, "OUTER_VLAN_ID_AUTOMATIC", 0)
+	onedField(b, "USED_LEASES", len(o.vectors["LEASES"]))
+	onedIDs(b, "VROUTERS", s.filter("vrouter", func(vr *onedObject) bool {
+		for _, nic := range vr.template.vectors("NIC") {
+			if nic.vector.getInt("NETWORK_ID", -1) == o.id {
+				return true
+			}
+		}
+		return false
+	}))
+
+	b.WriteString("<AR_POOL>")
+	for _, ar := range o.vectors["AR_POOL"] {
+		size := ar.vector.getInt("SIZE", 0)
+		leases := onedAttrsWithout(o.vectors["LEASES"], func(lease *onedAttr) bool {
+			return lease.vector.get("AR_ID") != ar.vector.get("AR_ID")
+		})
+
+		b.WriteString("<AR>")
+		ar.vector.write(b)
+		if ip := onedIPAdd(ar.vector.get("IP"), size-1); ip != "" {
+			onedField(b, "IP_END", ip)
+		}
+		onedField(b, "MAC_END", onedMACAdd(ar.vector.get("MAC"), size-1))
+		onedField(b, "USED_LEASES", len(leases))
+		b.WriteString("<LEASES>")
+		for _, lease := range leases {
+			b.WriteString("<LEASE>")
+			lease.vector.without("AR_ID").write(b)
+			b.WriteString("</LEASE>")
+		}
+		b.WriteString("</LEASES>")
+		b.WriteString("</AR>")
+	}
+	b.WriteString("</AR_POOL>")
+}
+
+// filter returns the sorted IDs of the objects of a pool matching the function
+func (s *onedStandIn) filter(kind string, match func(o *onedObject) bool) []int {
+	ids := make([]int, 0)
+	for _, id := range s.ids(kind) {
+		if match(s.pools[kind][id]) {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+// Security groups
+
+var onedRuleProtocols = []string{"TCP", "UDP", "ICMP", "ICMPV6", "IPSEC", "ALL"}
+
+func (s *onedStandIn) securityGroupAllocate(call *onedCall) (interface{}, error) {
+	o, _, err := s.allocateTemplate(call)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkSecurityGroup(o); err != nil {
+		s.remove(o)
+		return nil, err
+	}
+
+	return o.id, nil
+}
+
+func (s *onedStandIn) checkSecurityGroup(o *onedObject) error {
+	for _, rule := range o.template.vectors("RULE") {
+		if onedIndex(onedRuleProtocols, strings.ToUpper(rule.vector.get("PROTOCOL"))) < 0 {
+			return onedErrorf(onedActionError, "Invalid PROTOCOL %q in RULE.", rule.vector.get("PROTOCOL"))
+		}
+		if ruletype := strings.ToUpper(rule.vector.get("RULE_TYPE")); ruletype != "INBOUND" && ruletype != "OUTBOUND" {
+			return onedErrorf(onedActionError, "Invalid RULE_TYPE %q in RULE.", rule.vector.get("RULE_TYPE"))
+		}
+	}
+
+	return nil
+}
+
+// securityGroupCommit has nothing to do, rules are applied when the VMs are read
+func (s *onedStandIn) securityGroupCommit(call *onedCall) (interface{}, error) {
+	o, err := s.object(call, "secgroup", call.int(0), onedRightManage)
+	if err != nil {
+		return nil, err
+	}
+
+	return o.id, nil
+}
+
+// securityGroupVMs returns the VMs with rules of the security group
+func (s *onedStandIn) securityGroupVMs(sg *onedObject) []int {
+	return s.filter("vm", func(vm *onedObject) bool {
+		for _, rule := range vm.template.vectors("SECURITY_GROUP_RULE") {
+			if vm.state != 6 && rule.vector.getInt("SECURITY_GROUP_ID", -1) == sg.id {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (s *onedStandIn) removeSecurityGroup(call *onedCall, o *onedObject) error {
+	if o.id == 0 {
+		return onedErrorf(onedActionError, "The default security group (ID 0) cannot be deleted.")
+	}
+	if len(s.securityGroupVMs(o)) > 0 {
+		return onedErrorf(onedActionError, "The security group has VMs using it.")
+	}
+	s.remove(o)
+
+	return nil
+}
+
+func (s *onedStandIn) renderSecurityGroup(o *onedObject, b *bytes.Buffer) {
+	onedIDs(b, "UPDATED_VMS", s.securityGroupVMs(o))
+	onedIDs(b, "OUTDATED_VMS", nil)
+	onedIDs(b, "UPDATING_VMS", nil)
+	onedIDs(b, "ERROR_VMS", nil)
+}
+
+// Quotas, of the users and the groups
+
+// onedQuotas describes the quota kinds: the element of the pool of quotas,
+// the element of a quota and its limits
+var onedQuotas = []struct {
+	pool, element string
+	limits        []string
+}{
+	{"DATASTORE_QUOTA", "DATASTORE", []string{"IMAGES", "SIZE"}},
+	{"NETWORK_QUOTA", "NETWORK", []string{"LEASES"}},
+	{"VM_QUOTA", "VM", []string{"CPU", "MEMORY", "RUNNING_CPU", "RUNNING_MEMORY", "RUNNING_VMS", "SYSTEM_DISK_SIZE", "VMS"}},
+	{"IMAGE_QUOTA", "IMAGE", []string{"RVMS"}},
+}
+
+// quota sets the limits of the quotas of the template, the quotas are
+// identified by their ID except the VM one
+func (s *onedStandIn) quota(call *onedCall) (interface{}, error) {
+	if !s.isAdmin(call.user) {
+		return nil, onedErrorf(onedAuthorizationError, "User [%d] : Not authorized to perform ADMIN %s [%d].",
+			call.user.id, call.kind.element, call.int(0))
+	}
+	o, err := s.object(call, call.kind.method, call.int(0), onedRightAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	tpl, err := parseOnedTemplate(call.str(1))
+	if err != nil {
+		return nil, onedErrorf(onedInternalError, "Parse error: %s", err)
+	}
+
+	for _, q := range onedQuotas {
+		for _, update := range tpl.vectors(q.element) {
+			id := update.vector.get("ID")
+			if q.element != "VM" && id == "" {
+				return nil, onedErrorf(onedActionError, "%s quota: no ID.", q.element)
+			}
+
+			var quota *onedAttr
+			for _, existing := range o.vectors[q.pool] {
+				if existing.vector.get("ID") == id {
+					quota = existing
+				}
+			}
+			if quota == nil {
+				quota = &onedAttr{name: q.element, vector: onedTemplate{}}
+				if id != "" {
+					quota.vector = quota.vector.set("ID", id)
+				}
+				for _, limit := range q.limits {
+					quota.vector = quota.vector.set(limit, "-1").set(limit+"_USED", "0")
+				}
+				o.vectors[q.pool] = append(o.vectors[q.pool], quota)
+			}
+
+			for _, limit := range q.limits {
+				if value := update.vector.get(limit); value != "" {
+					quota.vector = quota.vector.set(limit, value)
+				}
+			}
+		}
+	}
+
+	return o.id, nil
+}
+
+func (s *onedStandIn) renderQuotas(o *onedObject, b *bytes.Buffer) {
+	for _, q := range onedQuotas {
+		b.WriteString("<" + q.pool + ">")
+		o.vectors[q.pool].write(b)
+		b.WriteString("</" + q.pool + ">")
+	}
+}
+
+// Groups
+
+func (s *onedStandIn) groupAllocate(call *onedCall) (interface{}, error) {
+	o, err := s.allocate(call, call.str(0))
+	if err != nil {
+		return nil, err
+	}
+	o.ids["ADMINS"] = []int{}
+
+	return o.id, nil
+}
+
+// groupUsers returns the users with the group as primary or secondary group
+func (s *onedStandIn) groupUsers(group *onedObject) []int {
+	return s.filter("user", func(user *onedObject) bool {
+		return onedContains(user.ids["GROUPS"], group.id)
+	})
+}
+
+func (s *onedStandIn) groupAddAdmin(call *onedCall) (interface{}, error) {
+	group, err := s.object(call, "group", call.int(0), onedRightManage)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.object(call, "user", call.int(1), onedRightManage)
+	if err != nil {
+		return nil, err
+	}
+
+	if onedContains(group.ids["ADMINS"], user.id) {
+		return nil, onedErrorf(onedActionError, "User %d is already an administrator of group %d.", user.id, group.id)
+	}
+	group.ids["ADMINS"] = append(group.ids["ADMINS"], user.id)
+
+	return group.id, nil
+}
+
+func (s *onedStandIn) groupDelAdmin(call *onedCall) (interface{}, error) {
+	group, err := s.object(call, "group", call.int(0), onedRightManage)
+	if err != nil {
+		return nil, err
+	}
+
+	if !onedContains(group.ids["ADMINS"], call.int(1)) {
+		return nil, onedErrorf(onedActionError, "User %d is not an administrator of group %d.", call.int(1), group.id)
+	}
+	group.ids["ADMINS"] = onedWithout(group.ids["ADMINS"], call.int(1))
+
+	return group.id, nil
+}
+
+func (s *onedStandIn) removeGroup(call *onedCall, o *onedObject) error {
+	switch {
+	case o.id < 100:
+		return onedErrorf(onedActionError, "System Groups (ID < 100) cannot be deleted.")
+	case len(s.groupUsers(o)) > 0:
+		return onedErrorf(onedActionError, "Group %d is not empty.", o.id)
+	}
+
+	// The group is also removed from the VDCs
+	for _, vdc := range s.pools["vdc"] {
+		vdc.ids["GROUPS"] = onedWithout(vdc.ids["GROUPS"], o.id)
+	}
+	s.remove(o)
+
+	return nil
+}
+
+func (s *onedStandIn) renderGroup(o *onedObject, b *bytes.Buffer) {
+	onedIDs(b, "USERS", s.groupUsers(o))
+	onedIDs(b, "ADMINS", o.ids["ADMINS"])
+	s.renderQuotas(o, b)
+	b.WriteString("<DEFAULT_GROUP_QUOTAS><DATASTORE_QUOTA></DATASTORE_QUOTA><NETWORK_QUOTA></NETWORK_QUOTA>")
+	b.WriteString("<VM_QUOTA></VM_QUOTA><IMAGE_QUOTA></IMAGE_QUOTA></DEFAULT_GROUP_QUOTAS>")
+}
+
+// Users
+
+func (s *onedStandIn) userAllocate(call *onedCall) (interface{}, error) {
+	password, driver := call.str(1), call.str(2)
+	if driver == "" {
+		driver = "core"
+	}
+	if password == "" && driver == "core" {
+		return nil, onedErrorf(onedAllocateError, "Invalid password, it can not be empty.")
+	}
+
+	groups := call.ints(3)
+	if len(groups) == 0 {
+		groups = []int{1}
+	}
+	for _, gid := range groups {
+		if _, err := s.object(call, "group", gid, onedRightManage); err != nil {
+			return nil, err
+		}
+	}
+
+	o, err := s.allocate(call, call.str(0))
+	if err != nil {
+		return nil, err
+	}
+	o.uid, o.gid = o.id, groups[0]
+	o.fields["PASSWORD"] = password
+	o.fields["AUTH_DRIVER"] = driver
+	o.ids["GROUPS"] = groups
+	// Like oned, generate the password of the login tokens of the user
+	token := sha256.Sum256([]byte(fmt.Sprintf("%s:%d", o.name, time.Now().UnixNano())))
+	o.template = o.template.set("TOKEN_PASSWORD", fmt.Sprintf("%x", token[:20]))
+
+	return o.id, nil
+}
+
+func (s *onedStandIn) userPasswd(call *onedCall) (interface{}, error) {
+	o, err := s.object(call, "user", call.int(0), onedRightManage)
+	if err != nil {
+		return nil, err
+	}
+
+	if call.str(1) == "" {
+		return nil, onedErrorf(onedActionError, "Invalid password, it can not be empty.")
+	}
+	o.fields["PASSWORD"] = call.str(1)
+
+	return o.id, nil
+}
+
+func (s *onedStandIn) userChauth(call *onedCall) (interface{}, error) {
+	o, err := s.object(call, "user", call.int(0), onedRightAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	if call.str(1) == "" {
+		return nil, onedErrorf(onedActionError, "Auth driver can not be empty.")
+	}
+	o.fields["AUTH_DRIVER"] = call.str(1)
+	if call.str(2) != "" {
+		o.fields["PASSWORD"] = call.str(2)
+	}
+
+	return o.id, nil
+}
+
+// userChgrp sets the primary group of the user, the group is added to its
+// groups and the previous primary group is removed from them
+func (s *onedStandIn) userChgrp(call *onedCall) (interface{}, error) {
+	o, err := s.object(call, "user", call.int(0), onedRightAdmin)
+	if err != nil {
+		return nil, err
+	}
+	group, err := s.object(call, "group", call.int(1), onedRightManage)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := onedWithout(o.ids["GROUPS"], o.gid)
+	if !onedContains(groups, group.id) {
+		groups = append([]int{group.id}, groups...)
+	}
+	o.gid = group.id
+	o.ids["GROUPS"] = groups
+
+	return o.id, nil
+}
+
+func (s *onedStandIn) userAddGroup(call *onedCall) (interface{}, error) {
+	o, err := s.object(call, "user", call.int(0), onedRightAdmin)
+	if err != nil {
+		return nil, err
+	}
+	group, err := s.object(call, "group", call.int(1), onedRightManage)
+	if err != nil {
+		return nil, err
+	}
+
+	if onedContains(o.ids["GROUPS"], group.id) {
+		return nil, onedErrorf(onedActionError, "User %d is already in group %d.", o.id, group.id)
+	}
+	o.ids["GROUPS"] = append(o.ids["GROUPS"], group.id)
+
+	return o.id, nil
+}
+
+func (s *onedStandIn) userDelGroup(call *onedCall) (interface{}, error) {
+	o, err := s.object(call, "user", call.int(0), onedRightAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	gid := call.int(1)
+	switch {
+	case gid == o.gid:
+		return nil, onedErrorf(onedActionError, "Cannot remove the primary group %d of user %d.", gid, o.id)
+	case !onedContains(o.ids["GROUPS"], gid):
+		return nil, onedErrorf(onedActionError, "User %d is not in group %d.", o.id, gid)
+	}
+	o.ids["GROUPS"] = onedWithout(o.ids["GROUPS"], gid)
+
+	return o.id, nil
+}
+
+func (s *onedStandIn) removeUser(call *onedCall, o *onedObject) error {
+	if o.id < 2 {
+		return onedErrorf(onedActionError, "oneadmin and serveradmin cannot be deleted.")
+	}
+
+	for _, group := range s.pools["group"] {
+		group.ids["ADMINS"] = onedWithout(group.ids["ADMINS"], o.id)
+	}
+	s.remove(o)
+
+	return nil
+}
+
+// renderUser writes the core passwords hashed, as oned does
+func (s *onedStandIn) renderUser(o *onedObject, b *bytes.Buffer) {
+	password := o.fields["PASSWORD"]
+	if o.fields["AUTH_DRIVER"] == "core" {
+		password = fmt.Sprintf("%x", sha256.Sum256([]byte(password)))
+	}
+	enabled := 1
+	if o.fields["ENABLED"] == "0" {
+		enabled = 0
+	}
+
+	onedField(b, "GID", o.gid)
+	onedIDs(b, "GROUPS", o.ids["GROUPS"])
+	onedField(b, "GNAME", s.nameOf("group", o.gid))
+	onedField(b, "PASSWORD", password)
+	onedField(b, "AUTH_DRIVER", o.fields["AUTH_DRIVER"])
+	onedField(b, "ENABLED", enabled)
+	b.WriteString("<LOGIN_TOKEN></LOGIN_TOKEN>")
+	s.renderQuotas(o, b)
+	b.WriteString("<DEFAULT_USER_QUOTAS><DATASTORE_QUOTA></DATASTORE_QUOTA><NETWORK_QUOTA></NETWORK_QUOTA>")
+	b.WriteString("<VM_QUOTA></VM_QUOTA><IMAGE_QUOTA></IMAGE_QUOTA></DEFAULT_USER_QUOTAS>")
+}
+
+// VDCs
+
+// onedVDCResources maps the resources of the VDC calls to their element and pool
+var onedVDCResources = map[string][2]string{
+	"cluster":   {"CLUSTER", "cluster"},
+	"host":      {"HOST", "host"},
+	"datastore": {"DATASTORE", "datastore"},
+	"vnet":      {"VNET", "vn"},
+}
+
+// onedVDCResource returns a resource of a VDC, the zone and the ID of the resource
+func onedVDCResource(element string, zone, id int) *onedAttr {
+	return &onedAttr{name: element, vector: onedTemplate{}.set("ZONE_ID", zone).set(element+"_ID", id)}
+}
+
+// vdcAllocate creates a VDC from its XML description, its template is taken
+// from the TEMPLATE element if there is one
+func (s *onedStandIn) vdcAllocate(call *onedCall) (interface{}, error) {
+	o, tpl, err := s.allocateTemplate(call)
+	if err != nil {
+		return nil, err
+	}
+
+	o.template = onedTemplate{}
+	for _, attr := range tpl.without("NAME") {
+		switch attr.name {
+		case "ID", "GROUPS", "CLUSTERS", "HOSTS", "DATASTORES", "VNETS":
+		case "TEMPLATE":
+			o.template = append(o.template, attr.vector...)
+		default:
+			o.template = append(o.template, attr)
+		}
+	}
+	o.ids["GROUPS"] = []int{}
+
+	return o.id, nil
+}
+
+func (s *onedStandIn) vdcAddGroup(call *onedCall) (interface{}, error) {
+	vdc, err := s.object(call, "vdc", call.int(0), onedRightAdmin)
+	if err != nil {
+		return nil, err
+	}
+	group, err := s.object(call, "group", call.int(1), onedRightAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	if onedContains(vdc.ids["GROUPS"], group.id) {
+		return nil, onedErrorf(onedActionError, "Group %d is already in VDC %d.", group.id, vdc.id)
+	}
+	vdc.ids["GROUPS"] = append(vdc.ids["GROUPS"], group.id)
+
+	return vdc.id, nil
+}
+
+func (s *onedStandIn) vdcDelGroup(call *onedCall) (interface{}, error) {
+	vdc, err := s.object(call, "vdc", call.int(0), onedRightAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	if !onedContains(vdc.ids["GROUPS"], call.int(1)) {
+		return nil, onedErrorf(onedActionError, "Group %d is not in VDC %d.", call.int(1), vdc.id)
+	}
+	vdc.ids["GROUPS"] = onedWithout(vdc.ids["GROUPS"], call.int(1))
+
+	return vdc.id, nil
+}
+
+// vdcResource returns the resource of a VDC call, by the name of the method
+func (s *onedStandIn) vdcResource(call *onedCall, prefix string) (*onedObject, *onedAttr, string, error) {
+	vdc, err := s.object(call, "vdc", call.int(0), onedRightAdmin)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	resource := onedVDCResources[strings.TrimPrefix(strings.Split(call.method, ".")[2], prefix)]
+	element, pool := resource[0], resource[1]
+	zone, id := call.int(1), call.int(2)
+
+	// Resources of other zones can not be checked
+	if _, ok := s.pools["zone"][zone]; !ok {
+		return nil, nil, "", onedErrorf(onedNoExistsError, "Error getting zone [%d].", zone)
+	}
+	if _, ok := s.pools[pool][id]; zone == 0 && !ok && id != -10 {
+		return nil, nil, "", onedErrorf(onedNoExistsError, "Error getting %s [%d].", s.kinds[pool].object, id)
+	}
+
+	return vdc, onedVDCResource(element, zone, id), element + "S", nil
+}
+
+func (s *onedStandIn) vdcAddResource(call *onedCall) (interface{}, error) {
+	vdc, resource, list, err := s.vdcResource(call, "add")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range vdc.vectors[list] {
+		if sameVDCResource(r, resource) {
+			return nil, onedErrorf(onedActionError, "%s %s is already assigned to the VDC %d.",
+				resource.name, resource.vector.get(resource.name+"_ID"), vdc.id)
+		}
+	}
+	vdc.vectors[list] = append(vdc.vectors[list], resource)
+
+	return vdc.id, nil
+}
+
+func (s *onedStandIn) vdcDelResource(call *onedCall) (interface{}, error) {
+	vdc, resource, list, err := s.vdcResource(call, "del")
+	if err != nil {
+		return nil, err
+	}
+
+	resources := onedAttrsWithout(vdc.vectors[list], func(r *onedAttr) bool {
+		return sameVDCResource(r, resource)
+	})
+	if len(resources) == len(vdc.vectors[list]) {
+		return nil, onedErrorf(onedActionError, "%s %s is not assigned to the VDC %d.",
+			resource.name, resource.vector.get(resource.name+"_ID"), vdc.id)
+	}
+	vdc.vectors[list] = resources
+
+	return vdc.id, nil
+}
+
+// sameVDCResource tells if two VDC resources are the same
+func sameVDCResource(a, b *onedAttr) bool {
+	return a.name == b.name && a.vector.get("ZONE_ID") == b.vector.get("ZONE_ID") &&
+		a.vector.get(a.name+"_ID") == b.vector.get(b.name+"_ID")
+}
+
+func (s *onedStandIn) renderVDC(o *onedObject, b *bytes.Buffer) {
+	onedIDs(b, "GROUPS", o.ids["GROUPS"])
+	for _, list := range []string{"CLUSTERS", "HOSTS", "DATASTORES", "VNETS"} {
+		b.WriteString("<" + list + ">")
+		o.vectors[list].write(b)
+		b.WriteString("</" + list + ">")
+	}
+}
+
+// Clusters
+
+func (s *onedStandIn) clusterAllocate(call *onedCall) (interface{}, error) {
+	o, err := s.allocate(call, call.str(0))
+	if err != nil {
+		return nil, err
+	}
+	o.template = o.template.set("RESERVED_CPU", "").set("RESERVED_MEM", "")
+
+	return o.id, nil
+}
+
+// clusterMember returns the host, datastore or network of a cluster call
+func (s *onedStandIn) clusterMember(call *onedCall, prefix string) (*onedObject, *onedObject, error) {
+	cluster, err := s.object(call, "cluster", call.int(0), onedRightAdmin)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pool := strings.TrimPrefix(strings.Split(call.method, ".")[2], prefix)
+	if pool == "vnet" {
+		pool = "vn"
+	}
+	member, err := s.object(call, pool, call.int(1), onedRightAdmin)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cluster, member, nil
+}
+
+// clusterAddResource moves a host to the cluster, or adds a datastore or a
+// network to the clusters they belong to
+func (s *onedStandIn) clusterAddResource(call *onedCall) (interface{}, error) {
+	cluster, member, err := s.clusterMember(call, "add")
+	if err != nil {
+		return nil, err
+	}
+
+	if member.kind.method == "host" {
+		member.fields["CLUSTER_ID"] = strconv.Itoa(cluster.id)
+	} else if !onedContains(member.ids["CLUSTERS"], cluster.id) {
+		member.ids["CLUSTERS"] = append(member.ids["CLUSTERS"], cluster.id)
+	}
+
+	return cluster.id, nil
+}
+
+// clusterDelResource moves a host back to the default cluster, or removes a
+// datastore or a network from the cluster
+func (s *onedStandIn) clusterDelResource(call *onedCall) (interface{}, error) {
+	cluster, member, err := s.clusterMember(call, "del")
+	if err != nil {
+		return nil, err
+	}
+
+	if member.kind.method == "host" {
+		if member.fields["CLUSTER_ID"] == strconv.Itoa(cluster.id) {
+			member.fields["CLUSTER_ID"] = "0"
+		}
+	} else {
+		member.ids["CLUSTERS"] = onedWithout(member.ids["CLUSTERS"], cluster.id)
+	}
+
+	return cluster.id, nil
+}
+
+// clusterMembers returns the hosts, datastores or networks of the cluster
+func (s *onedStandIn) clusterMembers(cluster *onedObject, pool string) []int {
+	return s.filter(pool, func(o *onedObject) bool {
+		if pool == "host" {
+			return o.fields["CLUSTER_ID"] == strconv.Itoa(cluster.id)
+		}
+		return onedContains(o.ids["CLUSTERS"], cluster.id)
+	})
+}
+
+func (s *onedStandIn) removeCluster(call *onedCall, o *onedObject) error {
+	if o.id == 0 {
+		return onedErrorf(onedActionError, "The default cluster (ID 0) cannot be deleted.")
+	}
+	for _, pool := range []string{"host", "datastore", "vn"} {
+		if len(s.clusterMembers(o, pool)) > 0 {
+			return onedErrorf(onedActionError, "Cannot delete cluster. Cluster %d is not empty, it contains %ss.",
+				o.id, s.kinds[pool].object)
+		}
+	}
+	s.remove(o)
+
+	return nil
+}
+
+func (s *onedStandIn) renderCluster(o *onedObject, b *bytes.Buffer) {
+	onedIDs(b, "HOSTS", s.clusterMembers(o, "host"))
+	onedIDs(b, "DATASTORES", s.clusterMembers(o, "datastore"))
+	onedIDs(b, "VNETS", s.clusterMembers(o, "vn"))
+}
+
+// Hosts
+
+// hostAllocate creates a host, which is monitored on its first read
+func (s *onedStandIn) hostAllocate(call *onedCall) (interface{}, error) {
+	clusterID := call.int(3)
+	if clusterID < 0 {
+		clusterID = 0
+	}
+	if _, err := s.object(call, "cluster", clusterID, onedRightAdmin); err != nil {
+		return nil, err
+	}
+
+	o, err := s.allocate(call, call.str(0))
+	if err != nil {
+		return nil, err
+	}
+	o.fields["IM_MAD"] = call.str(1)
+	o.fields["VM_MAD"] = call.str(2)
+	o.fields["CLUSTER_ID"] = strconv.Itoa(clusterID)
+	// The first monitoring adds the host information to its template
+	o.steps = []func(){func() {
+		o.state = 2
+		o.template = o.template.set("HYPERVISOR", o.fields["VM_MAD"]).set("HOSTNAME", o.name)
+	}}
+
+	return o.id, nil
+}
+
+// hostStatus enables (0), disables (1) or sets offline (2) the host
+func (s *onedStandIn) hostStatus(call *onedCall) (interface{}, error) {
+	o, err := s.object(call, "host", call.int(0), onedRightAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	states := []int{2, 4, 8}
+	status := call.int(1)
+	if status < 0 || status >= len(states) {
+		return nil, onedErrorf(onedActionError, "Wrong status %d for host %d.", status, o.id)
+	}
+	o.steps = nil
+	o.state = states[status]
+
+	return o.id, nil
+}
+
+// hostVMs returns the VMs running on the host
+func (s *onedStandIn) hostVMs(host *onedObject) []int {
+	return s.filter("vm", func(vm *onedObject) bool {
+		return vm.state != 6 && vm.fields["HID"] == strconv.Itoa(host.id)
+	})
+}
+
+func (s *onedStandIn) removeHost(call *onedCall, o *onedObject) error {
+	if len(s.hostVMs(o)) > 0 {
+		return onedErrorf(onedActionError, "Can not remove a host with running VMs.")
+	}
+	s.remove(o)
+
+	return nil
+}
+
+func (s *onedStandIn) renderHost(o *onedObject, b *bytes.Buffer) {
+	vms := s.hostVMs(o)
+
+	onedField(b, "STATE", o.state)
+	onedField(b, "PREV_STATE", o.state)
+	o.writeFields(b, "IM_MAD", "VM_MAD", "CLUSTER_ID")
+	onedField(b, "CLUSTER", s.nameOf("cluster", onedAtoi(o.fields["CLUSTER_ID"])))
+	b.WriteString("<HOST_SHARE>")
+	onedField(b, "DISK_USAGE", 0)
+	onedField(b, "MEM_USAGE", 0)
+	onedField(b, "CPU_USAGE", 0)
+	onedField(b, "TOTAL_MEM", 16777216)
+	onedField(b, "TOTAL_CPU", 800)
+	onedField(b, "MAX_DISK", 102400)
+	onedField(b, "MAX_MEM", 16777216)
+	onedField(b, "MAX_CPU", 800)
+	onedField(b, "FREE_DISK", 102400)
+	onedField(b, "FREE_MEM", 16777216)
+	onedField(b, "FREE_CPU", 800)
+	onedField(b, "USED_DISK", 0)
+	onedField(b, "USED_MEM", 0)
+	onedField(b, "USED_CPU", 0)
+	onedField(b, "RUNNING_VMS", len(vms))
+	b.WriteString("<DATASTORES></DATASTORES><PCI_DEVICES></PCI_DEVICES>")
+	b.WriteString("</HOST_SHARE>")
+	onedIDs(b, "VMS", vms)
+}
+
+// Datastores
+
+var onedDatastoreTypes = []string{"IMAGE_DS", "SYSTEM_DS", "FILE_DS"}
+
+func (s *onedStandIn) datastoreAllocate(call *onedCall) (interface{}, error) {
+	tpl, err := parseOnedTemplate(call.str(0))
+	if err != nil {
+		return nil, onedErrorf(onedInternalError, "Parse error: %s", err)
+	}
+
+	dstype := strings.ToUpper(tpl.get("TYPE"))
+	if dstype == "" {
+		dstype = "IMAGE_DS"
+	}
+	switch {
+	case onedIndex(onedDatastoreTypes, dstype) < 0:
+		return nil, onedErrorf(onedAllocateError, "Unknown datastore TYPE %s.", dstype)
+	case dstype != "SYSTEM_DS" && tpl.get("DS_MAD") == "":
+		return nil, onedErrorf(onedAllocateError, "No DS_MAD in template.")
+	case tpl.get("TM_MAD") == "":
+		return nil, onedErrorf(onedAllocateError, "No TM_MAD in template.")
+	}
+
+	clusterID := call.int(1)
+	if clusterID < 0 {
+		clusterID = 0
+	}
+	if _, err := s.object(call, "cluster", clusterID, onedRightAdmin); err != nil {
+		return nil, err
+	}
+
+	o, err := s.allocate(call, tpl.get("NAME"))
+	if err != nil {
+		return nil, err
+	}
+	o.template = tpl.without("NAME").set("TYPE", dstype)
+	o.ids["CLUSTERS"] = []int{clusterID}
+
+	return o.id, nil
+}
+
+// datastoreEnable enables (state 0) or disables (state 1) the datastore
+func (s *onedStandIn) datastoreEnable(call *onedCall) (interface{}, error) {
+	o, err := s.object(call, "datastore", call.int(0), onedRightManage)
+	if err != nil {
+		return nil, err
+	}
+
+	o.state = 1
+	if call.bool(1) {
+		o.state = 0
+	}
+
+	return o.id, nil
+}
+
+// datastoreImages returns the images stored in the datastore
+func (s *onedStandIn) datastoreImages(ds *onedObject) []int {
+	return s.filter("image", func(image *onedObject) bool {
+		return image.fields["DATASTORE_ID"] == strconv.Itoa(ds.id)
+	})
+}
+
+func (s *onedStandIn) removeDatastore(call *onedCall, o *onedObject) error {
+	if len(s.datastoreImages(o)) > 0 {
+		return onedErrorf(onedActionError, "Datastore %d is not empty.", o.id)
+	}
+	s.remove(o)
+
+	return nil
+}
+
+func (s *onedStandIn) renderDatastore(o *onedObject, b *bytes.Buffer) {
+	used := 0
+	images := s.datastoreImages(o)
+	for _, id := range images {
+		used += onedAtoi(s.pools["image"][id].fields["SIZE"])
+	}
+
+	onedField(b, "DS_MAD", o.template.get("DS_MAD"))
+	onedField(b, "TM_MAD", o.template.get("TM_MAD"))
+	onedField(b, "BASE_PATH", fmt.Sprintf("/var/lib/one//datastores/%d", o.id))
+	onedField(b, "TYPE", onedIndex(onedDatastoreTypes, o.template.get("TYPE")))
+	onedField(b, "DISK_TYPE", 0)
+	onedField(b, "STATE", o.state)
+	onedIDs(b, "CLUSTERS", o.ids["CLUSTERS"])
+	onedField(b, "TOTAL_MB", 102400)
+	onedField(b, "FREE_MB", 102400-used)
+	onedField(b, "USED_MB", used)
+	onedIDs(b, "IMAGES", images)
+}
+
+// Virtual routers
+
+// vrouterAllocate creates a virtual router, its NICs with a floating IP get
+// a lease of the virtual router
+func (s *onedStandIn) vrouterAllocate(call *onedCall) (interface{}, error) {
+	o, _, err := s.allocateTemplate(call)
+	if err != nil {
+		return nil, err
+	}
+
+	nics := o.template.vectors("NIC")
+	o.template = o.template.without("NIC")
+	for _, nic := range nics {
+		if err := s.vrouterNIC(o, nic); err != nil {
+			s.releaseVRouter(o)
+			s.remove(o)
+			return nil, onedErrorf(onedAllocateError, "%s", err)
+		}
+	}
+	o.ids["VMS"] = []int{}
+
+	return o.id, nil
+}
+
+// vrouterNIC adds a NIC to the virtual router
+func (s *onedStandIn) vrouterNIC(vr *onedObject, nic *onedAttr) error {
+	n := nic.vector.set("NIC_ID", onedNextID(vr.template.vectors("NIC"), "NIC_ID"))
+
+	vnet, ok := s.pools["vn"][n.getInt("NETWORK_ID", -1)]
+	if !ok {
+		return onedErrorf(onedNoExistsError, "Error getting virtual network [%s].", n.get("NETWORK_ID"))
+	}
+	if strings.ToUpper(n.get("FLOATING_IP")) == "YES" {
+		lease, err := s.lease(vnet, n.get("IP"), "VROUTER", vr.id)
+		if err != nil {
+			return err
+		}
+		n = n.set("IP", lease.vector.get("IP")).set("MAC", lease.vector.get("MAC"))
+	}
+	n = n.set("NETWORK", vnet.name)
+	nic.vector = n
+	vr.template = append(vr.template, nic)
+
+	return nil
+}
+
+// vrouterVMNIC returns the NIC of a VM of the virtual router, the floating
+// IP is only held by the virtual router
+func vrouterVMNIC(nic *onedAttr) *onedAttr {
+	n := nic.vector.clone()
+	for _, name := range []string{"IP", "MAC", "FLOATING_IP", "FLOATING_ONLY", "NETWORK"} {
+		n = n.without(name)
+	}
+
+	return &onedAttr{name: "NIC", vector: n}
+}
+
+// vrouterInstantiate creates the VMs of the virtual router from a template,
+// %i in their name is replaced by the number of the VM
+func (s *onedStandIn) vrouterInstantiate(call *onedCall) (interface{}, error) {
+	vr, err := s.object(call, "vrouter", call.int(0), onedRightManage)
+	if err != nil {
+		return nil, err
+	}
+	source, err := s.object(call, "template", call.int(2), onedRightUse)
+	if err != nil {
+		return nil, err
+	}
+
+	extra, err := parseOnedTemplate(call.str(5))
+	if err != nil {
+		return nil, onedErrorf(onedInternalError, "Parse error: %s", err)
+	}
+
+	name := call.str(3)
+	if name == "" {
+		name = "vr-" + vr.name + "-%i"
+	}
+
+	for i := 0; i < call.int(1); i++ {
+		tpl := source.template.merge(extra).without("NAME").without("NIC")
+		tpl = tpl.set("NAME", strings.Replace(name, "%i", strconv.Itoa(i), -1))
+		tpl = tpl.set("TEMPLATE_ID", source.id).set("VROUTER_ID", vr.id)
+		for _, nic := range vr.template.vectors("NIC") {
+			tpl = append(tpl, vrouterVMNIC(nic))
+		}
+
+		vm, err := s.createVM(call, tpl, call.bool(4))
+		if err != nil {
+			return nil, err
+		}
+		vr.ids["VMS"] = append(vr.ids["VMS"], vm.id)
+	}
+
+	return vr.id, nil
+}
+
+// vrouterVMs returns the VMs of the virtual router which are not done
+func (s *onedStandIn) vrouterVMs(vr *onedObject) []*onedObject {
+	vms := make([]*onedObject, 0)
+	for _, id := range vr.ids["VMS"] {
+		if vm, ok := s.pools["vm"][id]; ok && vm.state != 6 {
+			vms = append(vms, vm)
+		}
+	}
+
+	return vms
+}
+
+// vrouterAttachNIC adds the NIC to the virtual router and hotplugs it in its VMs
+func (s *onedStandIn) vrouterAttachNIC(call *onedCall) (interface{}, error) {
+	vr, err := s.object(call, "vrouter", call.int(0), onedRightManage)
+	if err != nil {
+		return nil, err
+	}
+
+	tpl, err := parseOnedTemplate(call.str(1))
+	if err != nil || len(tpl.vectors("NIC")) != 1 {
+		return nil, onedErrorf(onedInternalError, "Wrong NIC template.")
+	}
+
+	nic := tpl.vectors("NIC")[0]
+	if err := s.vrouterNIC(vr, nic); err != nil {
+		return nil, onedErrorf(onedActionError, "%s", err)
+	}
+	for _, vm := range s.vrouterVMs(vr) {
+		if err := s.attachNIC(vm, vrouterVMNIC(nic)); err != nil {
+			return nil, err
+		}
+		if vm.state == 3 && vm.lcmState == 3 {
+			s.moveVM(vm, [2]int{3, 25}, [2]int{3, 3})
+		}
+	}
+
+	return vr.id, nil
+}
+
+func (s *onedStandIn) vrouterDetachNIC(call *onedCall) (interface{}, error) {
+	vr, err := s.object(call, "vrouter", call.int(0), onedRightManage)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, nic := range vr.template.vectors("NIC") {
+		if nic.vector.getInt("NIC_ID", -1) != call.int(1) {
+			continue
+		}
+
+		s.releaseVRouterNIC(vr, nic)
+		vr.template = vr.template.withoutAttr(nic)
+		for _, vm := range s.vrouterVMs(vr) {
+			s.detachNIC(vm, call.int(1))
+			if vm.state == 3 && vm.lcmState == 3 {
+				s.moveVM(vm, [2]int{3, 25}, [2]int{3, 3})
+			}
+		}
+		return vr.id, nil
+	}
+
+	return nil, onedErrorf(onedActionError, "Virtual router %d does not have NIC %d.", vr.id, call.int(1))
+}
+
+func (s *onedStandIn) releaseVRouterNIC(vr *onedObject, nic *onedAttr) {
+	vnet, ok := s.pools["vn"][nic.vector.getInt("NETWORK_ID", -1)]
+	if !ok {
+		return
+	}
+
+	vnet.vectors["LEASES"] = onedAttrsWithout(vnet.vectors["LEASES"], func(lease *onedAttr) bool {
+		return lease.vector.get("VROUTER") == strconv.Itoa(vr.id) && lease.vector.get("MAC") == nic.vector.get("MAC")
+	})
+}
+
+func (s *onedStandIn) releaseVRouter(vr *onedObject) {
+	for _, nic := range vr.template.vectors("NIC") {
+		s.releaseVRouterNIC(vr, nic)
+	}
+}
+
+// removeVRouter terminates the VMs of the virtual router
+func (s *onedStandIn) removeVRouter(call *onedCall, o *onedObject) error {
+	for _, vm := range s.vrouterVMs(o) {
+		if vm.state == 1 || vm.state == 2 {
+			s.moveVM(vm, [2]int{6, 0})
+		} else {
+			s.moveVM(vm, [2]int{3, 12}, [2]int{6, 0})
+		}
+	}
+	s.releaseVRouter(o)
+	s.remove(o)
+
+	return nil
+}
+
+func (s *onedStandIn) renderVRouter(o *onedObject, b *bytes.Buffer) {
+	onedIDs(b, "VMS", o.ids["VMS"])
+}
+
+// Marketplace apps
+
+var onedMarketPlaceAppTypes = []string{"UNKNOWN", "IMAGE", "VMTEMPLATE", "SERVICE_TEMPLATE"}
+
+// marketPlaceAppAllocate exports an image to a marketplace, the public
+// marketplace refuses it as oned does
+func (s *onedStandIn) marketPlaceAppAllocate(call *onedCall) (interface{}, error) {
+	marketID := call.int(1)
+	market, ok := s.markets[marketID]
+	if !ok {
+		return nil, onedErrorf(onedNoExistsError, "Error getting marketplace [%d].", marketID)
+	}
+	if marketID == 0 {
+		return nil, onedErrorf(onedAllocateError, "Create disabled for market: %s.", market)
+	}
+
+	tpl, err := parseOnedTemplate(call.str(0))
+	if err != nil {
+		return nil, onedErrorf(onedInternalError, "Parse error: %s", err)
+	}
+
+	apptype := strings.ToUpper(tpl.get("TYPE"))
+	if apptype == "" {
+		apptype = "IMAGE"
+	}
+	if onedIndex(onedMarketPlaceAppTypes, apptype) < 1 {
+		return nil, onedErrorf(onedAllocateError, "Unknown marketplace app TYPE %s.", apptype)
+	}
+	image, err := s.object(call, "image", tpl.getInt("ORIGIN_ID", -1), onedRightUse)
+	if err != nil {
+		return nil, err
+	}
+
+	o, err := s.allocate(call, tpl.get("NAME"))
+	if err != nil {
+		return nil, err
+	}
+	o.fields["MARKETPLACE_ID"] = strconv.Itoa(marketID)
+	o.fields["ORIGIN_ID"] = strconv.Itoa(image.id)
+	o.fields["TYPE"] = strconv.Itoa(onedIndex(onedMarketPlaceAppTypes, apptype))
+	o.fields["SIZE"] = image.fields["SIZE"]
+	o.fields["FORMAT"] = image.template.get("FORMAT")
+	o.fields["VERSION"] = tpl.get("VERSION")
+	o.fields["DESCRIPTION"] = tpl.get("DESCRIPTION")
+	appTemplate := &bytes.Buffer{}
+	for _, name := range []string{"DEV_PREFIX", "DRIVER", "TYPE"} {
+		if value := image.template.get(name); value != "" {
+			fmt.Fprintf(appTemplate, "%s=\"%s\"\n", name, value)
+		}
+	}
+	o.fields["APPTEMPLATE64"] = base64.StdEncoding.EncodeToString(appTemplate.Bytes())
+	for _, name := range []string{"NAME", "ORIGIN_ID", "TYPE", "VERSION", "DESCRIPTION"} {
+		tpl = tpl.without(name)
+	}
+	o.template = tpl
+
+	// The app is LOCKED while the image is exported
+	o.state = 2
+	o.steps = []func(){func() { o.state = 1 }}
+
+	return o.id, nil
+}
+
+// marketPlaceAppEnable enables (READY) or disables (DISABLED) the app
+func (s *onedStandIn) marketPlaceAppEnable(call *onedCall) (interface{}, error) {
+	o, err := s.object(call, "marketapp", call.int(0), onedRightManage)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.state != 1 && o.state != 4 {
+		return nil, onedErrorf(onedActionError, "Marketplace app %d cannot be enabled or disabled in state %d.", o.id, o.state)
+	}
+	o.state = 4
+	if call.bool(1) {
+		o.state = 1
+	}
+
+	return o.id, nil
+}
+
+// checkMarketPlaceApp moves the description and the version of the template
+// to the app, as oned does after an update
+func (s *onedStandIn) checkMarketPlaceApp(o *onedObject) error {
+	for _, name := range []string{"DESCRIPTION", "VERSION"} {
+		if value := o.template.get(name); value != "" {
+			o.fields[name] = value
+		}
+		o.template = o.template.without(name)
+	}
+
+	return nil
+}
+
+func (s *onedStandIn) renderMarketPlaceApp(o *onedObject, b *bytes.Buffer) {
+	onedField(b, "REGTIME", o.regtime)
+	onedField(b, "SOURCE", fmt.Sprintf("https://marketplace.opennebula.systems/appliance/%d", o.id))
+	onedField(b, "ORIGIN_ID", o.fields["ORIGIN_ID"])
+	onedField(b, "MD5", fmt.Sprintf("%x", sha256.Sum256([]byte(o.name)))[:32])
+	o.writeFields(b, "SIZE", "DESCRIPTION", "VERSION", "FORMAT", "APPTEMPLATE64", "MARKETPLACE_ID")
+	onedField(b, "MARKETPLACE", s.markets[onedAtoi(o.fields["MARKETPLACE_ID"])])
+	onedField(b, "STATE", o.state)
+	o.writeFields(b, "TYPE")
+}
+
+// Zones
+
+func (s *onedStandIn) zoneAllocate(call *onedCall) (interface{}, error) {
+	tpl, err := parseOnedTemplate(call.str(0))
+	if err != nil {
+		return nil, onedErrorf(onedInternalError, "Parse error: %s", err)
+	}
+	if tpl.get("ENDPOINT") == "" {
+		return nil, onedErrorf(onedAllocateError, "No ENDPOINT in template.")
+	}
+
+	o, _, err := s.allocateTemplate(call)
+	if err != nil {
+		return nil, err
+	}
+
+	return o.id, nil
+}
+
+func (s *onedStandIn) removeZone(call *onedCall, o *onedObject) error {
+	if o.id == 0 {
+		return onedErrorf(onedActionError, "The local zone (ID 0) cannot be deleted.")
+	}
+	s.remove(o)
+
+	return nil
+}
+
+func (s *onedStandIn) renderZone(o *onedObject, b *bytes.Buffer) {
+	b.WriteString("<SERVER_POOL></SERVER_POOL>")
+}
+
+// VM groups
+
+var onedVMGroupPolicies = []string{"NONE", "AFFINED", "ANTI_AFFINED"}
+
+// vmGroupAllocate creates a VM group, the roles are kept apart from the
+// template and can not be updated
+func (s *onedStandIn) vmGroupAllocate(call *onedCall) (interface{}, error) {
+	o, _, err := s.allocateTemplate(call)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := onedTemplate{}
+	for _, role := range o.template.vectors("ROLE") {
+		r := role.vector
+		name := r.get("NAME")
+		policy := strings.ToUpper(r.get("POLICY"))
+		if policy == "" {
+			policy = "NONE"
+		}
+
+		err = nil
+		switch {
+		case name == "":
+			err = onedErrorf(onedAllocateError, "Roles of a VM group need a NAME.")
+		case onedIndex(onedVMGroupPolicies, policy) < 0:
+			err = onedErrorf(onedAllocateError, "Wrong POLICY %s for role %s.", policy, name)
+		}
+		for _, other := range roles {
+			if other.vector.get("NAME") == name {
+				err = onedErrorf(onedAllocateError, "Role %s is defined twice.", name)
+			}
+		}
+		if err != nil {
+			s.remove(o)
+			return nil, err
+		}
+
+		v := onedTemplate{}.set("ID", len(roles)).set("NAME", name).set("POLICY", policy)
+		for _, hosts := range []string{"HOST_AFFINED", "HOST_ANTI_AFFINED"} {
+			if value := r.get(hosts); value != "" {
+				v = v.set(hosts, value)
+			}
+		}
+		roles = append(roles, &onedAttr{name: "ROLE", vector: v})
+	}
+	o.vectors["ROLES"] = roles
+
+	if err := s.checkVMGroup(o); err != nil {
+		s.remove(o)
+		return nil, err
+	}
+
+	return o.id, nil
+}
+
+// checkVMGroup removes the roles from the template, and checks the rules
+// between roles name existing roles
+func (s *onedStandIn) checkVMGroup(o *onedObject) error {
+	o.template = o.template.without("ROLE").without("NAME")
+
+	for _, rule := range []string{"AFFINED", "ANTI_AFFINED"} {
+		for _, attr := range o.template {
+			if attr.name != rule {
+				continue
+			}
+			for _, name := range strings.Split(attr.value, ",") {
+				if s.vmGroupRole(o, strings.TrimSpace(name)) == nil {
+					return onedErrorf(onedActionError, "Some roles used in %s are not defined.", rule)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *onedStandIn) vmGroupRole(vmg *onedObject, name string) *onedAttr {
+	for _, role := range vmg.vectors["ROLES"] {
+		if role.vector.get("NAME") == name {
+			return role
+		}
+	}
+
+	return nil
+}
+
+// vmGroupOf returns the VM group and the role a VM refers to
+func (s *onedStandIn) vmGroupOf(vmgroup *onedAttr) (*onedObject, *onedAttr, error) {
+	v := vmgroup.vector
+
+	var vmg *onedObject
+	if v.has("VMGROUP_ID") {
+		vmg = s.pools["vmgroup"][v.getInt("VMGROUP_ID", -1)]
+	} else {
+		for _, o := range s.pools["vmgroup"] {
+			if o.name == v.get("VMGROUP_NAME") {
+				vmg = o
+			}
+		}
+	}
+	if vmg == nil {
+		return nil, nil, onedErrorf(onedAllocateError, "Error getting vm group [%s].", v.get("VMGROUP_ID"))
+	}
+
+	role := s.vmGroupRole(vmg, v.get("ROLE"))
+	if role == nil {
+		return nil, nil, onedErrorf(onedAllocateError, "Role %s does not exist in VM group %d.", v.get("ROLE"), vmg.id)
+	}
+
+	return vmg, role, nil
+}
+
+// joinVMGroup adds the VM to its role in the VM group
+func (s *onedStandIn) joinVMGroup(vm *onedObject, vmgroup *onedAttr) error {
+	vmg, role, err := s.vmGroupOf(vmgroup)
+	if err != nil {
+		return err
+	}
+
+	vms := parseIntList(role.vector.get("VMS"))
+	role.vector = role.vector.set("VMS", joinOnedIDs(append(vms, vm.id)))
+	vmgroup.vector = vmgroup.vector.set("VMGROUP_ID", vmg.id)
+
+	return nil
+}
+
+func (s *onedStandIn) leaveVMGroup(vm *onedObject, vmgroup *onedAttr) {
+	_, role, err := s.vmGroupOf(vmgroup)
+	if err != nil {
+		return
+	}
+
+	vms := onedWithout(parseIntList(role.vector.get("VMS")), vm.id)
+	role.vector = role.vector.without("VMS")
+	if len(vms) > 0 {
+		role.vector = role.vector.set("VMS", joinOnedIDs(vms))
+	}
+}
+
+func joinOnedIDs(ids []int) string {
+	list := make([]string, 0, len(ids))
+	for _, id := range ids {
+		list = append(list, strconv.Itoa(id))
+	}
+
+	return strings.Join(list, ",")
+}
+
+func (s *onedStandIn) removeVMGroup(call *onedCall, o *onedObject) error {
+	for _, role := range o.vectors["ROLES"] {
+		if role.vector.get("VMS") != "" {
+			return onedErrorf(onedActionError, "Cannot delete VM group %d, it has VMs.", o.id)
+		}
+	}
+	s.remove(o)
+
+	return nil
+}
+
+func (s *onedStandIn) renderVMGroup(o *onedObject, b *bytes.Buffer) {
+	b.WriteString("<ROLES>")
+	o.vectors["ROLES"].write(b)
+	b.WriteString("</ROLES>")
+}
+
+// ACLs
+
+func (s *onedStandIn) addACL(user, resource, rights, zone string) *onedACL {
+	acl := &onedACL{id: s.nextACL, user: user, resource: resource, rights: rights, zone: zone}
+	s.acls[acl.id] = acl
+	s.nextACL++
+
+	return acl
+}
+
+// onedACLString returns the rule as displayed by oneacl
+func onedACLString(acl *onedACL) string {
+	user, _ := strconv.ParseUint(acl.user, 16, 64)
+	resource, _ := strconv.ParseUint(acl.resource, 16, 64)
+	rights, _ := strconv.ParseUint(acl.rights, 16, 64)
+	zone, _ := strconv.ParseUint(acl.zone, 16, 64)
+
+	selector := func(value uint64) string {
+		switch {
+		case value&aclSelectors["id"] != 0:
+			return fmt.Sprintf("#%d", uint32(value))
+		case value&aclSelectors["group"] != 0:
+			return fmt.Sprintf("@%d", uint32(value))
+		case value&aclSelectors["cluster"] != 0:
+			return fmt.Sprintf("%%%d", uint32(value))
+		}
+		return "*"
+	}
+
+	return fmt.Sprintf("%s %s/%s %s %s", selector(user), strings.Join(parseACLFlags(resource, aclResources), "+"),
+		selector(resource), strings.Join(parseACLFlags(rights, aclRights), "+"), selector(zone))
+}
+
+func (s *onedStandIn) aclAddRule(call *onedCall) (interface{}, error) {
+	if !s.isAdmin(call.user) {
+		return nil, onedErrorf(onedAuthorizationError, "User [%d] : Not authorized to perform MANAGE ACL.", call.user.id)
+	}
+
+	parts := make([]string, 4)
+	for i := range parts {
+		parts[i] = call.str(i)
+		if i == 3 && parts[i] == "" {
+			parts[i] = "100000000"
+		}
+		value, err := strconv.ParseUint(parts[i], 16, 64)
+		if err != nil {
+			return nil, onedErrorf(onedActionError, "Error parsing rule: %s is not an hexadecimal number.", parts[i])
+		}
+		parts[i] = strconv.FormatUint(value, 16)
+	}
+
+	for _, acl := range s.acls {
+		if acl.user == parts[0] && acl.resource == parts[1] && acl.rights == parts[2] && acl.zone == parts[3] {
+			return nil, onedErrorf(onedActionError, "Rule already exists.")
+		}
+	}
+
+	return s.addACL(parts[0], parts[1], parts[2], parts[3]).id, nil
+}
+
+func (s *onedStandIn) aclDelRule(call *onedCall) (interface{}, error) {
+	if !s.isAdmin(call.user) {
+		return nil, onedErrorf(onedAuthorizationError, "User [%d] : Not authorized to perform MANAGE ACL.", call.user.id)
+	}
+
+	if _, ok := s.acls[call.int(0)]; !ok {
+		return nil, onedErrorf(onedActionError, "Rule does not exist.")
+	}
+	delete(s.acls, call.int(0))
+
+	return call.int(0), nil
+}
+
+func (s *onedStandIn) aclInfo(call *onedCall) (interface{}, error) {
+	ids := make([]int, 0, len(s.acls))
+	for id := range s.acls {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	b := &bytes.Buffer{}
+	b.WriteString("<ACL_POOL>")
+	for _, id := range ids {
+		acl := s.acls[id]
+		b.WriteString("<ACL>")
+		onedField(b, "ID", acl.id)
+		onedField(b, "USER", acl.user)
+		onedField(b, "RESOURCE", acl.resource)
+		onedField(b, "RIGHTS", acl.rights)
+		onedField(b, "ZONE", acl.zone)
+		onedField(b, "STRING", onedACLString(acl))
+		b.WriteString("</ACL>")
+	}
+	b.WriteString("</ACL_POOL>")
+
+	return b.String(), nil
+}
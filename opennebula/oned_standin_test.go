@@ -0,0 +1,1292 @@
+package opennebula
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+)
+
+// Error codes returned by oned
+const (
+	onedAuthenticationError = 0x0100
+	onedAuthorizationError  = 0x0200
+	onedNoExistsError       = 0x0400
+	onedActionError         = 0x0800
+	onedXMLRPCAPIError      = 0x1000
+	onedInternalError       = 0x2000
+	onedAllocateError       = 0x4000
+	onedLockedError         = 0x8000
+)
+
+// Rights needed by the calls. Info calls need USE but are not refused by locks.
+const (
+	onedRightInfo = iota
+	onedRightUse
+	onedRightManage
+	onedRightAdmin
+)
+
+var onedRights = []string{"USE", "USE", "MANAGE", "ADMIN"}
+
+// onedStandIn is an in-memory oned serving the XML-RPC API used by the
+// provider. Objects go through their transient states one step each time
+// they are read, so the waiters see the same states than with a real oned.
+type onedStandIn struct {
+	*httptest.Server
+
+	mutex   sync.Mutex
+	methods map[string]onedMethod
+	kinds   map[string]*onedKind
+	pools   map[string]map[int]*onedObject
+	nextID  map[string]int
+	acls    map[int]*onedACL
+	nextACL int
+	markets map[int]string
+}
+
+type onedMethod func(call *onedCall) (interface{}, error)
+
+// onedCall holds the arguments of a call, the session is already resolved to
+// the calling user
+type onedCall struct {
+	method string
+	kind   *onedKind
+	user   *onedObject
+	args   []interface{}
+}
+
+type onedError struct {
+	code int
+	msg  string
+}
+
+func (e *onedError) Error() string {
+	return e.msg
+}
+
+func onedErrorf(code int, format string, a ...interface{}) *onedError {
+	return &onedError{code: code, msg: fmt.Sprintf(format, a...)}
+}
+
+// onedKind describes a pool of objects
+type onedKind struct {
+	// method is the prefix of the object calls, pool the prefix of the pool calls
+	method string
+	pool   string
+	// element is the XML element of the objects, it also names them in the errors
+	element string
+	object  string
+	// owned objects have an owner, a group and permissions
+	owned bool
+	// unique is the scope of the name: "owner", "global" or none
+	unique string
+	// render writes the elements specific to the kind
+	render func(s *onedStandIn, o *onedObject, b *bytes.Buffer)
+	// check validates the template of the object after an update
+	check func(s *onedStandIn, o *onedObject) error
+	// remove deletes the object, it may refuse it or defer it to a later step
+	remove func(s *onedStandIn, call *onedCall, o *onedObject) error
+}
+
+// onedObject is an object of any of the pools
+type onedObject struct {
+	kind     *onedKind
+	id       int
+	name     string
+	uid      int
+	gid      int
+	perms    [9]int
+	regtime  int64
+	lock     int
+	state    int
+	lcmState int
+	template onedTemplate
+	// user template of the VMs
+	user onedTemplate
+	// fields and ids are the top level elements specific to the kind
+	fields  map[string]string
+	ids     map[string][]int
+	vectors map[string]onedTemplate
+	// steps are applied one by one, each time the object is read
+	steps []func()
+}
+
+type onedACL struct {
+	id       int
+	user     string
+	resource string
+	rights   string
+	zone     string
+}
+
+// onedAttr is a template attribute, vector attributes have a non nil vector
+type onedAttr struct {
+	name   string
+	value  string
+	vector onedTemplate
+}
+
+type onedTemplate []*onedAttr
+
+func newOnedStandIn() *onedStandIn {
+	s := &onedStandIn{
+		methods: make(map[string]onedMethod),
+		kinds:   make(map[string]*onedKind),
+		pools:   make(map[string]map[int]*onedObject),
+		nextID:  make(map[string]int),
+		acls:    make(map[int]*onedACL),
+		// Apps can only be exported to the private marketplace
+		markets: map[int]string{0: "OpenNebula Public", 1: "private"},
+	}
+	s.registerKinds()
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	s.bootstrap()
+	return s
+}
+
+// Endpoint returns the XML-RPC endpoint of the stand-in
+func (s *onedStandIn) Endpoint() string {
+	return s.URL + "/RPC2"
+}
+
+// register adds a kind of object, along with the calls every kind supports
+func (s *onedStandIn) register(kind *onedKind) {
+	s.kinds[kind.method] = kind
+	s.pools[kind.method] = make(map[int]*onedObject)
+
+	s.methods["one."+kind.method+".info"] = s.info
+	s.methods["one."+kind.method+".update"] = s.update
+	s.methods["one."+kind.method+".rename"] = s.rename
+	s.methods["one."+kind.method+".delete"] = s.delete
+	s.methods["one."+kind.pool+".info"] = s.poolInfo
+	if kind.owned {
+		s.methods["one."+kind.method+".chmod"] = s.chmod
+		s.methods["one."+kind.method+".chown"] = s.chown
+		s.methods["one."+kind.method+".lock"] = s.lockObject
+		s.methods["one."+kind.method+".unlock"] = s.unlockObject
+	}
+}
+
+// bootstrap creates the objects of a fresh installation, with the dummy host
+// used by the acceptance tests
+func (s *onedStandIn) bootstrap() {
+	admins := s.newObject("group", "oneadmin", 0, 0)
+	s.newObject("group", "users", 0, 0)
+	admins.ids["ADMINS"] = []int{}
+
+	oneadmin := s.newObject("user", "oneadmin", 0, 0)
+	oneadmin.fields["PASSWORD"] = "opennebula"
+	oneadmin.fields["AUTH_DRIVER"] = "core"
+	oneadmin.ids["GROUPS"] = []int{0}
+	serveradmin := s.newObject("user", "serveradmin", 0, 0)
+	serveradmin.fields["PASSWORD"] = "serveradmin"
+	serveradmin.fields["AUTH_DRIVER"] = "server_cipher"
+	serveradmin.ids["GROUPS"] = []int{0}
+
+	zone := s.newObject("zone", "OpenNebula", 0, 0)
+	zone.template = onedTemplate{{name: "ENDPOINT", value: s.Endpoint()}}
+
+	s.newObject("cluster", "default", 0, 0)
+
+	for _, ds := range []struct{ name, dstype, dsmad, tmmad string }{
+		{"system", "SYSTEM_DS", "", "ssh"},
+		{"default", "IMAGE_DS", "fs", "ssh"},
+		{"files", "FILE_DS", "fs", "ssh"},
+	} {
+		o := s.newObject("datastore", ds.name, 0, 0)
+		o.perms = [9]int{1, 1, 0, 1, 0, 0, 0, 0, 0}
+		o.template = onedTemplate{
+			{name: "TYPE", value: ds.dstype},
+			{name: "DS_MAD", value: ds.dsmad},
+			{name: "TM_MAD", value: ds.tmmad},
+		}
+		o.ids["CLUSTERS"] = []int{0}
+	}
+
+	host := s.newObject("host", "dummy", 0, 0)
+	host.fields["IM_MAD"] = "dummy"
+	host.fields["VM_MAD"] = "dummy"
+	host.fields["CLUSTER_ID"] = "0"
+	host.state = 2
+
+	secgroup := s.newObject("secgroup", "default", 0, 0)
+	secgroup.perms = [9]int{1, 1, 0, 1, 0, 0, 0, 0, 0}
+	secgroup.template = onedTemplate{
+		{name: "DESCRIPTION", value: "The default security group is added to every network. Use it to add default filter rules for your networks. You may remove this security group from any network by updating its properties."},
+		{name: "RULE", vector: onedTemplate{{name: "PROTOCOL", value: "ALL"}, {name: "RULE_TYPE", value: "OUTBOUND"}}},
+		{name: "RULE", vector: onedTemplate{{name: "PROTOCOL", value: "ALL"}, {name: "RULE_TYPE", value: "INBOUND"}}},
+	}
+
+	vdc := s.newObject("vdc", "default", 0, 0)
+	vdc.ids["GROUPS"] = []int{1}
+	vdc.vectors["CLUSTERS"] = onedTemplate{onedVDCResource("CLUSTER", 0, -10)}
+
+	app := s.newObject("marketapp", "ttylinux - KVM", 0, 0)
+	app.perms = [9]int{1, 1, 0, 1, 0, 0, 1, 0, 0}
+	app.fields["MARKETPLACE_ID"] = "0"
+	app.fields["TYPE"] = "1"
+	app.fields["SIZE"] = "200"
+	app.fields["FORMAT"] = "raw"
+	app.fields["VERSION"] = "5.8.0-1.0"
+	app.fields["DESCRIPTION"] = "ttylinux image for KVM hypervisors."
+	app.fields["APPTEMPLATE64"] = "REVWX1BSRUZJWD0idmQiCkRSSVZFUj0icmF3IgpUWVBFPSJPUyIK"
+	app.state = 1
+
+	// Objects created by the users get IDs out of the reserved ranges
+	for _, kind := range []string{"group", "cluster", "datastore", "vdc"} {
+		s.nextID[kind] = 100
+	}
+
+	// Default rules: users may create their resources, see the zones and the marketplaces
+	s.addACL("200000001", "2542d400000000", "8", "100000000")
+	s.addACL("400000000", "800400000000", "1", "400000000")
+	s.addACL("400000000", "18000400000000", "1", "400000000")
+}
+
+func (s *onedStandIn) newObject(kind, name string, uid, gid int) *onedObject {
+	o := &onedObject{
+		kind:     s.kinds[kind],
+		id:       s.nextID[kind],
+		name:     name,
+		uid:      uid,
+		gid:      gid,
+		perms:    [9]int{1, 1, 0, 0, 0, 0, 0, 0, 0},
+		regtime:  time.Now().Unix(),
+		template: onedTemplate{},
+		user:     onedTemplate{},
+		fields:   make(map[string]string),
+		ids:      make(map[string][]int),
+		vectors:  make(map[string]onedTemplate),
+	}
+	s.nextID[kind]++
+	s.pools[kind][o.id] = o
+
+	return o
+}
+
+func (s *onedStandIn) serve(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	call := &onedXMLCall{}
+	if err := xml.Unmarshal(body, call); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	params := make([]interface{}, 0, len(call.Params))
+	for _, p := range call.Params {
+		params = append(params, p.decode())
+	}
+
+	s.mutex.Lock()
+	response := s.call(call.Method, params)
+	s.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write(response)
+}
+
+// call runs a method and returns the XML-RPC response
+func (s *onedStandIn) call(method string, params []interface{}) []byte {
+	handler, ok := s.methods[method]
+	if !ok {
+		return onedFault(-32601, fmt.Sprintf("Requested method '%s' not found", method))
+	}
+
+	session := ""
+	if len(params) > 0 {
+		session, _ = params[0].(string)
+	}
+	user := s.authenticate(session)
+	if user == nil {
+		return onedResponse(method, nil, onedErrorf(onedAuthenticationError, "User couldn't be authenticated, aborting call."))
+	}
+
+	call := &onedCall{method: method, user: user, args: params[1:]}
+	if parts := strings.Split(method, "."); len(parts) == 3 {
+		for _, kind := range s.kinds {
+			if kind.method == parts[1] || kind.pool == parts[1] {
+				call.kind = kind
+			}
+		}
+	}
+
+	result, err := handler(call)
+	return onedResponse(method, result, err)
+}
+
+// authenticate returns the user of the "username:password" session
+func (s *onedStandIn) authenticate(session string) *onedObject {
+	parts := strings.SplitN(session, ":", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+
+	for _, user := range s.pools["user"] {
+		if user.name == parts[0] && user.fields["PASSWORD"] == parts[1] && user.fields["ENABLED"] != "0" {
+			return user
+		}
+	}
+
+	return nil
+}
+
+func (s *onedStandIn) isAdmin(user *onedObject) bool {
+	return onedContains(user.ids["GROUPS"], 0)
+}
+
+// authorized checks the permissions of the object, oneadmin group members are
+// allowed everything
+func (s *onedStandIn) authorized(user, o *onedObject, right int) bool {
+	if s.isAdmin(user) {
+		return true
+	}
+
+	if !o.kind.owned {
+		return right <= onedRightUse || (o.kind.method == "user" && o.id == user.id && right <= onedRightManage)
+	}
+
+	bit := right - 1
+	if right == onedRightInfo {
+		bit = 0
+	}
+	switch {
+	case o.uid == user.id && o.perms[bit] == 1:
+		return true
+	case onedContains(user.ids["GROUPS"], o.gid) && o.perms[3+bit] == 1:
+		return true
+	}
+
+	return o.perms[6+bit] == 1
+}
+
+// object returns an object the user is allowed to act on
+func (s *onedStandIn) object(call *onedCall, kind string, id, right int) (*onedObject, error) {
+	k := s.kinds[kind]
+
+	o, ok := s.pools[kind][id]
+	if !ok {
+		return nil, onedErrorf(onedNoExistsError, "Error getting %s [%d].", k.object, id)
+	}
+	if !s.authorized(call.user, o, right) {
+		return nil, onedErrorf(onedAuthorizationError, "User [%d] : Not authorized to perform %s %s [%d].",
+			call.user.id, onedRights[right], k.element, id)
+	}
+
+	// USE and ALL locks refuse every action, MANAGE and ADMIN ones the actions
+	// needing at least these rights
+	locked := false
+	switch o.lock {
+	case 1, 4:
+		locked = right >= onedRightUse
+	case 2:
+		locked = right >= onedRightManage
+	case 3:
+		locked = right >= onedRightAdmin
+	}
+	if locked {
+		return nil, onedErrorf(onedLockedError, "User [%d] : Not authorized to perform %s %s [%d]. The object is locked.",
+			call.user.id, onedRights[right], k.element, id)
+	}
+
+	return o, nil
+}
+
+// step applies the next pending state change of the object
+func (s *onedStandIn) step(o *onedObject) {
+	if len(o.steps) == 0 {
+		return
+	}
+
+	next := o.steps[0]
+	o.steps = o.steps[1:]
+	next()
+}
+
+func (s *onedStandIn) remove(o *onedObject) {
+	delete(s.pools[o.kind.method], o.id)
+}
+
+// nameTaken returns the object already using the name, in the scope of the kind
+func (s *onedStandIn) nameTaken(kind *onedKind, name string, uid, self int) *onedObject {
+	if kind.unique == "" {
+		return nil
+	}
+
+	for _, o := range s.pools[kind.method] {
+		if o.id != self && o.name == name && (kind.unique == "global" || o.uid == uid) {
+			return o
+		}
+	}
+
+	return nil
+}
+
+// allocate creates an object of the kind of the call, owned by the caller
+func (s *onedStandIn) allocate(call *onedCall, name string) (*onedObject, error) {
+	if name == "" {
+		return nil, onedErrorf(onedAllocateError, "No NAME in template for %s.", call.kind.element)
+	}
+	if o := s.nameTaken(call.kind, name, call.user.id, -1); o != nil {
+		return nil, onedErrorf(onedAllocateError, "NAME is already taken by %s %d.", call.kind.element, o.id)
+	}
+
+	return s.newObject(call.kind.method, name, call.user.id, call.user.gid), nil
+}
+
+// allocateTemplate creates an object from a template holding its name
+func (s *onedStandIn) allocateTemplate(call *onedCall) (*onedObject, onedTemplate, error) {
+	tpl, err := parseOnedTemplate(call.str(0))
+	if err != nil {
+		return nil, nil, onedErrorf(onedInternalError, "Parse error: %s", err)
+	}
+
+	o, err := s.allocate(call, tpl.get("NAME"))
+	if err != nil {
+		return nil, nil, err
+	}
+	o.template = tpl.without("NAME")
+
+	return o, tpl, nil
+}
+
+func (s *onedStandIn) info(call *onedCall) (interface{}, error) {
+	id := call.int(0)
+	if id == -1 && call.kind.method == "user" {
+		id = call.user.id
+	} else if id == -1 && call.kind.method == "group" {
+		id = call.user.gid
+	}
+
+	o, err := s.object(call, call.kind.method, id, onedRightInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	// The object may be removed by its last step
+	s.step(o)
+	o, err = s.object(call, call.kind.method, id, onedRightInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &bytes.Buffer{}
+	s.writeObject(b, o)
+	return b.String(), nil
+}
+
+// poolInfo returns the objects matching the filter of the call: -4 the objects
+// of the user group, -3 the user objects, -2 all, -1 the user and user groups
+// objects, otherwise the objects of the given user.
+func (s *onedStandIn) poolInfo(call *onedCall) (interface{}, error) {
+	k := call.kind
+	filter := -2
+	if len(call.args) > 0 && k.owned {
+		filter = call.int(0)
+	}
+	if filter == -1 && s.isAdmin(call.user) {
+		filter = -2
+	}
+
+	b := &bytes.Buffer{}
+	b.WriteString("<" + k.element + "_POOL>")
+	for _, id := range s.ids(k.method) {
+		o := s.pools[k.method][id]
+
+		switch {
+		case !s.authorized(call.user, o, onedRightUse):
+			continue
+		case filter == -4 && o.gid != call.user.gid:
+			continue
+		case filter == -3 && o.uid != call.user.id:
+			continue
+		case filter == -1 && o.uid != call.user.id && !onedContains(call.user.ids["GROUPS"], o.gid):
+			continue
+		case filter >= 0 && o.uid != filter:
+			continue
+		}
+
+		// Done VMs are only listed when asked for explicitly
+		if k.method == "vm" && o.state == 6 && call.int(3) != -2 && call.int(3) != 6 {
+			continue
+		}
+
+		s.writeObject(b, o)
+	}
+	b.WriteString("</" + k.element + "_POOL>")
+
+	return b.String(), nil
+}
+
+// update replaces (0) or merges (1) the template, the user template for VMs
+func (s *onedStandIn) update(call *onedCall) (interface{}, error) {
+	o, err := s.object(call, call.kind.method, call.int(0), onedRightManage)
+	if err != nil {
+		return nil, err
+	}
+
+	tpl, err := parseOnedTemplate(call.str(1))
+	if err != nil {
+		return nil, onedErrorf(onedInternalError, "Parse error: %s", err)
+	}
+
+	target := &o.template
+	if o.kind.method == "vm" {
+		target = &o.user
+	}
+	previous := *target
+
+	if call.int(2) == 1 {
+		*target = target.merge(tpl)
+	} else {
+		*target = tpl
+	}
+
+	if o.kind.check != nil {
+		if err := o.kind.check(s, o); err != nil {
+			*target = previous
+			return nil, err
+		}
+	}
+
+	return o.id, nil
+}
+
+func (s *onedStandIn) rename(call *onedCall) (interface{}, error) {
+	o, err := s.object(call, call.kind.method, call.int(0), onedRightManage)
+	if err != nil {
+		return nil, err
+	}
+
+	name := call.str(1)
+	if name == "" {
+		return nil, onedErrorf(onedActionError, "New name cannot be empty.")
+	}
+	if other := s.nameTaken(o.kind, name, o.uid, o.id); other != nil {
+		return nil, onedErrorf(onedActionError, "Could not rename the %s: NAME is already taken by %s %d.",
+			o.kind.element, o.kind.element, other.id)
+	}
+	o.name = name
+
+	return o.id, nil
+}
+
+func (s *onedStandIn) delete(call *onedCall) (interface{}, error) {
+	o, err := s.object(call, call.kind.method, call.int(0), onedRightManage)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.kind.remove != nil {
+		if err := o.kind.remove(s, call, o); err != nil {
+			return nil, err
+		}
+	} else {
+		s.remove(o)
+	}
+
+	return o.id, nil
+}
+
+// chmod sets the permissions, -1 leaves the permission unchanged
+func (s *onedStandIn) chmod(call *onedCall) (interface{}, error) {
+	o, err := s.object(call, call.kind.method, call.int(0), onedRightManage)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range o.perms {
+		if p := call.int(i + 1); p == 0 || p == 1 {
+			o.perms[i] = p
+		}
+	}
+
+	return o.id, nil
+}
+
+// chown sets the owner and the group, -1 leaves them unchanged
+func (s *onedStandIn) chown(call *onedCall) (interface{}, error) {
+	o, err := s.object(call, call.kind.method, call.int(0), onedRightAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	uid, gid := call.int(1), call.int(2)
+	if _, ok := s.pools["user"][uid]; uid >= 0 && !ok {
+		return nil, onedErrorf(onedNoExistsError, "Error getting user [%d].", uid)
+	}
+	if _, ok := s.pools["group"][gid]; gid >= 0 && !ok {
+		return nil, onedErrorf(onedNoExistsError, "Error getting group [%d].", gid)
+	}
+	if uid >= 0 {
+		if other := s.nameTaken(o.kind, o.name, uid, o.id); other != nil {
+			return nil, onedErrorf(onedActionError, "Could not change owner: NAME is already taken by %s %d.", o.kind.element, other.id)
+		}
+		o.uid = uid
+	}
+	if gid >= 0 {
+		o.gid = gid
+	}
+
+	return o.id, nil
+}
+
+func (s *onedStandIn) lockObject(call *onedCall) (interface{}, error) {
+	o, err := s.object(call, call.kind.method, call.int(0), onedRightManage)
+	if err != nil {
+		return nil, err
+	}
+
+	level := call.int(1)
+	if level < 1 || level > 4 {
+		return nil, onedErrorf(onedActionError, "Wrong lock level %d.", level)
+	}
+	o.lock = level
+
+	return o.id, nil
+}
+
+func (s *onedStandIn) unlockObject(call *onedCall) (interface{}, error) {
+	o, err := s.object(call, call.kind.method, call.int(0), onedRightInfo)
+	if err != nil {
+		return nil, err
+	}
+	if !s.authorized(call.user, o, onedRightManage) {
+		return nil, onedErrorf(onedAuthorizationError, "User [%d] : Not authorized to perform MANAGE %s [%d].",
+			call.user.id, o.kind.element, o.id)
+	}
+	o.lock = 0
+
+	return o.id, nil
+}
+
+// ids returns the sorted IDs of a pool
+func (s *onedStandIn) ids(kind string) []int {
+	ids := make([]int, 0, len(s.pools[kind]))
+	for id := range s.pools[kind] {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	return ids
+}
+
+// nameOf returns the name of an object, or an empty string if it does not exist
+func (s *onedStandIn) nameOf(kind string, id int) string {
+	if o, ok := s.pools[kind][id]; ok {
+		return o.name
+	}
+
+	return ""
+}
+
+// writeObject writes the object as oned does: common elements, elements of
+// the kind and template
+func (s *onedStandIn) writeObject(b *bytes.Buffer, o *onedObject) {
+	k := o.kind
+
+	b.WriteString("<" + k.element + ">")
+	onedField(b, "ID", o.id)
+	if k.owned {
+		onedField(b, "UID", o.uid)
+		onedField(b, "GID", o.gid)
+		onedField(b, "UNAME", s.nameOf("user", o.uid))
+		onedField(b, "GNAME", s.nameOf("group", o.gid))
+	}
+	onedField(b, "NAME", o.name)
+	if k.owned {
+		b.WriteString("<PERMISSIONS>")
+		for i, name := range []string{"OWNER_U", "OWNER_M", "OWNER_A", "GROUP_U", "GROUP_M", "GROUP_A", "OTHER_U", "OTHER_M", "OTHER_A"} {
+			onedField(b, name, o.perms[i])
+		}
+		b.WriteString("</PERMISSIONS>")
+		if o.lock > 0 {
+			b.WriteString("<LOCK>")
+			onedField(b, "LOCKED", o.lock)
+			onedField(b, "OWNER", o.uid)
+			onedField(b, "TIME", o.regtime)
+			onedField(b, "REQ_ID", -1)
+			b.WriteString("</LOCK>")
+		}
+	}
+	if k.render != nil {
+		k.render(s, o, b)
+	}
+	b.WriteString("<TEMPLATE>")
+	o.template.write(b)
+	b.WriteString("</TEMPLATE>")
+	b.WriteString("</" + k.element + ">")
+}
+
+// writeFields writes the fields of the object which are set, in order
+func (o *onedObject) writeFields(b *bytes.Buffer, names ...string) {
+	for _, name := range names {
+		if value, ok := o.fields[name]; ok {
+			onedField(b, name, value)
+		}
+	}
+}
+
+func onedField(b *bytes.Buffer, name string, value interface{}) {
+	b.WriteString("<" + name + ">")
+	xml.EscapeText(b, []byte(fmt.Sprint(value)))
+	b.WriteString("</" + name + ">")
+}
+
+// onedIDs writes a collection of IDs, like the hosts of a cluster
+func onedIDs(b *bytes.Buffer, name string, ids []int) {
+	b.WriteString("<" + name + ">")
+	for _, id := range ids {
+		onedField(b, "ID", id)
+	}
+	b.WriteString("</" + name + ">")
+}
+
+func onedContains(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func onedWithout(ids []int, id int) []int {
+	list := make([]int, 0, len(ids))
+	for _, i := range ids {
+		if i != id {
+			list = append(list, i)
+		}
+	}
+
+	return list
+}
+
+// Arguments of the calls, without the session. Missing integers are -1.
+
+func (c *onedCall) int(i int) int {
+	if i >= len(c.args) {
+		return -1
+	}
+
+	switch v := c.args[i].(type) {
+	case int:
+		return v
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	case string:
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+
+	return -1
+}
+
+func (c *onedCall) str(i int) string {
+	if i >= len(c.args) {
+		return ""
+	}
+
+	return fmt.Sprint(c.args[i])
+}
+
+func (c *onedCall) bool(i int) bool {
+	return c.int(i) == 1
+}
+
+func (c *onedCall) ints(i int) []int {
+	ids := make([]int, 0)
+	if i >= len(c.args) {
+		return ids
+	}
+
+	list, _ := c.args[i].([]interface{})
+	for _, v := range list {
+		if id, ok := v.(int); ok {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+// XML-RPC encoding
+
+type onedXMLCall struct {
+	Method string         `xml:"methodName"`
+	Params []onedXMLValue `xml:"params>param>value"`
+}
+
+type onedXMLValue struct {
+	String  *string `xml:"string"`
+	Int     *string `xml:"int"`
+	I4      *string `xml:"i4"`
+	I8      *string `xml:"i8"`
+	Boolean *string `xml:"boolean"`
+	Double  *string `xml:"double"`
+	Array   *struct {
+		Values []onedXMLValue `xml:"data>value"`
+	} `xml:"array"`
+	Text string `xml:",chardata"`
+}
+
+func (v *onedXMLValue) decode() interface{} {
+	switch {
+	case v.String != nil:
+		return *v.String
+	case v.Int != nil, v.I4 != nil, v.I8 != nil:
+		raw := v.Int
+		if raw == nil {
+			raw = v.I4
+		}
+		if raw == nil {
+			raw = v.I8
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(*raw))
+		return n
+	case v.Boolean != nil:
+		return strings.TrimSpace(*v.Boolean) == "1"
+	case v.Double != nil:
+		f, _ := strconv.ParseFloat(strings.TrimSpace(*v.Double), 64)
+		return f
+	case v.Array != nil:
+		list := make([]interface{}, 0, len(v.Array.Values))
+		for _, value := range v.Array.Values {
+			list = append(list, value.decode())
+		}
+		return list
+	}
+
+	return v.Text
+}
+
+// onedResponse encodes the result of a call as oned does: an array holding
+// the success, the result or the error message, and the error code
+func onedResponse(method string, result interface{}, err error) []byte {
+	b := &bytes.Buffer{}
+	b.WriteString(`<?xml version="1.0"?><methodResponse><params><param><value><array><data>`)
+
+	if err != nil {
+		code := onedInternalError
+		if e, ok := err.(*onedError); ok {
+			code = e.code
+		}
+		b.WriteString("<value><boolean>0</boolean></value>")
+		onedValue(b, fmt.Sprintf("[%s] %s", method, err))
+		onedValue(b, code)
+	} else {
+		b.WriteString("<value><boolean>1</boolean></value>")
+		onedValue(b, result)
+		onedValue(b, 0)
+	}
+
+	b.WriteString("</data></array></value></param></params></methodResponse>")
+	return b.Bytes()
+}
+
+func onedValue(b *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case int:
+		fmt.Fprintf(b, "<value><i4>%d</i4></value>", v)
+	default:
+		b.WriteString("<value><string>")
+		xml.EscapeText(b, []byte(fmt.Sprint(v)))
+		b.WriteString("</string></value>")
+	}
+}
+
+func onedFault(code int, msg string) []byte {
+	b := &bytes.Buffer{}
+	b.WriteString(`<?xml version="1.0"?><methodResponse><fault><value><struct>`)
+	fmt.Fprintf(b, "<member><name>faultCode</name><value><int>%d</int></value></member>", code)
+	b.WriteString("<member><name>faultString</name>")
+	onedValue(b, msg)
+	b.WriteString("</member></struct></value></fault></methodResponse>")
+	return b.Bytes()
+}
+
+// Templates, in OpenNebula syntax or XML
+
+// parseOnedTemplate parses the attributes of a template, attribute names are
+// upper cased as oned does
+func parseOnedTemplate(tpl string) (onedTemplate, error) {
+	tpl = strings.TrimSpace(tpl)
+	if tpl == "" {
+		return onedTemplate{}, nil
+	}
+
+	if strings.HasPrefix(tpl, "<") {
+		dec := xml.NewDecoder(strings.NewReader(tpl))
+		for {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			if start, ok := tok.(xml.StartElement); ok {
+				root, err := parseOnedXMLElement(dec, start.Name.Local)
+				if err != nil {
+					return nil, err
+				}
+				if root.vector == nil {
+					return onedTemplate{}, nil
+				}
+				return root.vector, nil
+			}
+		}
+	}
+
+	p := &onedTemplateParser{s: tpl}
+	return p.attributes(false)
+}
+
+func parseOnedXMLElement(dec *xml.Decoder, name string) (*onedAttr, error) {
+	attr := &onedAttr{name: strings.ToUpper(name)}
+	text := ""
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			child, err := parseOnedXMLElement(dec, t.Name.Local)
+			if err != nil {
+				return nil, err
+			}
+			attr.vector = append(attr.vector, child)
+		case xml.CharData:
+			text += string(t)
+		case xml.EndElement:
+			if attr.vector == nil {
+				attr.value = text
+			}
+			return attr, nil
+		}
+	}
+}
+
+type onedTemplateParser struct {
+	s   string
+	pos int
+}
+
+// skip moves past the blanks, the commas separating vector values and the comments
+func (p *onedTemplateParser) skip() {
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		switch {
+		case c == '#':
+			for p.pos < len(p.s) && p.s[p.pos] != '\n' {
+				p.pos++
+			}
+		case c == ',' || unicode.IsSpace(rune(c)):
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (p *onedTemplateParser) skipBlanks() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *onedTemplateParser) token() string {
+	start := p.pos
+	for p.pos < len(p.s) && !strings.ContainsRune(" \t\r\n,=[]\"#", rune(p.s[p.pos])) {
+		p.pos++
+	}
+
+	return p.s[start:p.pos]
+}
+
+// quoted reads a double quoted value, \" and \\ are unescaped
+func (p *onedTemplateParser) quoted() (string, error) {
+	p.pos++
+	value := &strings.Builder{}
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		switch {
+		case c == '\\' && p.pos+1 < len(p.s) && (p.s[p.pos+1] == '"' || p.s[p.pos+1] == '\\'):
+			value.WriteByte(p.s[p.pos+1])
+			p.pos += 2
+		case c == '"':
+			p.pos++
+			return value.String(), nil
+		default:
+			value.WriteByte(c)
+			p.pos++
+		}
+	}
+
+	return "", fmt.Errorf("syntax error, unterminated string")
+}
+
+func (p *onedTemplateParser) attributes(vector bool) (onedTemplate, error) {
+	attrs := onedTemplate{}
+
+	for {
+		p.skip()
+		if p.pos >= len(p.s) {
+			if vector {
+				return nil, fmt.Errorf("syntax error, unexpected end of template, expecting ']'")
+			}
+			return attrs, nil
+		}
+		if p.s[p.pos] == ']' {
+			if !vector {
+				return nil, fmt.Errorf("syntax error, unexpected ']'")
+			}
+			p.pos++
+			return attrs, nil
+		}
+
+		name := p.token()
+		if name == "" {
+			return nil, fmt.Errorf("syntax error, unexpected '%c'", p.s[p.pos])
+		}
+		p.skipBlanks()
+		if p.pos >= len(p.s) || p.s[p.pos] != '=' {
+			return nil, fmt.Errorf("syntax error, expecting '=' after %s", name)
+		}
+		p.pos++
+		p.skipBlanks()
+
+		attr := &onedAttr{name: strings.ToUpper(name)}
+		var err error
+		switch {
+		case p.pos < len(p.s) && p.s[p.pos] == '[':
+			if vector {
+				return nil, fmt.Errorf("syntax error, vector %s inside a vector", name)
+			}
+			p.pos++
+			attr.vector, err = p.attributes(true)
+		case p.pos < len(p.s) && p.s[p.pos] == '"':
+			attr.value, err = p.quoted()
+		default:
+			attr.value = p.token()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		attrs = append(attrs, attr)
+	}
+}
+
+func (t onedTemplate) write(b *bytes.Buffer) {
+	for _, attr := range t {
+		b.WriteString("<" + attr.name + ">")
+		if attr.vector != nil {
+			attr.vector.write(b)
+		} else {
+			xml.EscapeText(b, []byte(attr.value))
+		}
+		b.WriteString("</" + attr.name + ">")
+	}
+}
+
+// get returns the value of the first single attribute with the name
+func (t onedTemplate) get(name string) string {
+	for _, attr := range t {
+		if attr.name == name && attr.vector == nil {
+			return attr.value
+		}
+	}
+
+	return ""
+}
+
+// has tells if the template holds an attribute with the name
+func (t onedTemplate) has(name string) bool {
+	for _, attr := range t {
+		if attr.name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// vectors returns the vector attributes with the name
+func (t onedTemplate) vectors(name string) []*onedAttr {
+	vectors := make([]*onedAttr, 0)
+	for _, attr := range t {
+		if attr.name == name && attr.vector != nil {
+			vectors = append(vectors, attr)
+		}
+	}
+
+	return vectors
+}
+
+// set replaces the value of a single attribute, or adds it
+func (t onedTemplate) set(name string, value interface{}) onedTemplate {
+	for _, attr := range t {
+		if attr.name == name && attr.vector == nil {
+			attr.value = fmt.Sprint(value)
+			return t
+		}
+	}
+
+	return append(t, &onedAttr{name: name, value: fmt.Sprint(value)})
+}
+
+// without returns the template without the attributes with the name
+func (t onedTemplate) without(name string) onedTemplate {
+	attrs := onedTemplate{}
+	for _, attr := range t {
+		if attr.name != name {
+			attrs = append(attrs, attr)
+		}
+	}
+
+	return attrs
+}
+
+// merge replaces the attributes of the template by the ones with the same
+// name of the other template
+func (t onedTemplate) merge(other onedTemplate) onedTemplate {
+	merged := onedTemplate{}
+	for _, attr := range t {
+		if !other.has(attr.name) {
+			merged = append(merged, attr)
+		}
+	}
+
+	return append(merged, other...)
+}
+
+// getInt returns the value of an attribute as an integer, or the default value
+func (t onedTemplate) getInt(name string, def int) int {
+	if n, err := strconv.Atoi(strings.TrimSpace(t.get(name))); err == nil {
+		return n
+	}
+
+	return def
+}
+
+// withoutAttr returns the template without the attribute
+func (t onedTemplate) withoutAttr(attr *onedAttr) onedTemplate {
+	attrs := onedTemplate{}
+	for _, a := range t {
+		if a != attr {
+			attrs = append(attrs, a)
+		}
+	}
+
+	return attrs
+}
+
+// clone returns a deep copy of the template
+func (t onedTemplate) clone() onedTemplate {
+	if t == nil {
+		return nil
+	}
+
+	attrs := make(onedTemplate, 0, len(t))
+	for _, attr := range t {
+		attrs = append(attrs, &onedAttr{name: attr.name, value: attr.value, vector: attr.vector.clone()})
+	}
+
+	return attrs
+}
+
+func onedAtoi(value string) int {
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return -1
+	}
+
+	return n
+}
+
+func TestOnedStandIn(t *testing.T) {
+	standin := newOnedStandIn()
+	defer standin.Close()
+
+	controller := goca.NewController(goca.NewClient(goca.NewConfig("oneadmin", "opennebula", standin.Endpoint()), http.DefaultClient))
+
+	vnetID, err := controller.VirtualNetworks().Create("NAME = \"net\"\nVN_MAD = \"dummy\"\nAR = [ TYPE = \"IP4\", IP = \"172.16.100.1\", SIZE = 4 ]", -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tplID, err := controller.Templates().Create("NAME = \"tpl\"\nCPU = 1\nMEMORY = 64\nNIC = [ NETWORK_ID = " + strconv.Itoa(vnetID) + " ]")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vmID, err := controller.Template(tplID).Instantiate("vm", false, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The VM is deployed on the dummy host, then boots
+	for _, lcmState := range []int{2, 3} {
+		vm, err := controller.VM(vmID).Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vm.StateRaw != 3 || vm.LCMStateRaw != lcmState {
+			t.Errorf("Expected VM %d in state 3/%d, got %d/%d", vmID, lcmState, vm.StateRaw, vm.LCMStateRaw)
+		}
+	}
+
+	vnet, err := controller.VirtualNetwork(vnetID).Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vnet.UsedLeases != 1 {
+		t.Errorf("Expected the VM to lease an address, got %d used leases", vnet.UsedLeases)
+	}
+
+	err = controller.VM(vmID).TerminateHard()
+	if err != nil {
+		t.Fatal(err)
+	}
+	controller.VM(vmID).Info()
+
+	vnet, err = controller.VirtualNetwork(vnetID).Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vnet.UsedLeases != 0 {
+		t.Errorf("Expected the lease of the terminated VM to be released, got %d used leases", vnet.UsedLeases)
+	}
+
+	_, err = controller.Image(100).Info()
+	if e, ok := err.(*goca.ResponseError); !ok || e.Code != goca.OneNoExistsError || !strings.Contains(err.Error(), "Error getting image") {
+		t.Errorf("Expected a no exists error, got %v", err)
+	}
+
+	unauthenticated := goca.NewController(goca.NewClient(goca.NewConfig("oneadmin", "wrong", standin.Endpoint()), http.DefaultClient))
+	_, err = unauthenticated.VM(vmID).Info()
+	if e, ok := err.(*goca.ResponseError); !ok || e.Code != goca.OneAuthenticationError {
+		t.Errorf("Expected an authentication error, got %v", err)
+	}
+}
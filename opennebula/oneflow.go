@@ -0,0 +1,259 @@
+package opennebula
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+)
+
+// Service states, in the order of their numerical value in OneFlow
+var flowservicestates = []string{"PENDING", "DEPLOYING", "RUNNING", "UNDEPLOYING", "WARNING", "DONE",
+	"FAILED_UNDEPLOYING", "FAILED_DEPLOYING", "SCALING", "FAILED_SCALING", "COOLDOWN"}
+
+// flowClient is a minimal client of the OneFlow REST API
+type flowClient struct {
+	endpoint   string
+	username   string
+	password   string
+	httpClient *http.Client
+}
+
+func newFlowClient(endpoint, username, password string, httpClient *http.Client) *flowClient {
+	return &flowClient{
+		endpoint:   strings.TrimRight(endpoint, "/"),
+		username:   username,
+		password:   password,
+		httpClient: httpClient,
+	}
+}
+
+// flowError is returned when OneFlow answers with an error status
+type flowError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *flowError) Error() string {
+	return fmt.Sprintf("OneFlow error (HTTP %d): %s", e.StatusCode, e.Message)
+}
+
+// isFlowNotFound returns true if the error is a not found answer of OneFlow
+func isFlowNotFound(err error) bool {
+	ferr, ok := err.(*flowError)
+	return ok && ferr.StatusCode == http.StatusNotFound
+}
+
+// flowState is a state of a service or a role, OneFlow sends it either as a
+// number or as a string
+type flowState int
+
+func (s *flowState) UnmarshalJSON(data []byte) error {
+	value, err := strconv.Atoi(strings.Trim(string(data), "\""))
+	if err != nil {
+		return fmt.Errorf("Unexpected OneFlow state %s", data)
+	}
+	*s = flowState(value)
+	return nil
+}
+
+func (s flowState) String() string {
+	if int(s) >= 0 && int(s) < len(flowservicestates) {
+		return flowservicestates[s]
+	}
+	return ""
+}
+
+type flowDocument struct {
+	Document struct {
+		ID       string `json:"ID"`
+		Name     string `json:"NAME"`
+		Template struct {
+			Body json.RawMessage `json:"BODY"`
+		} `json:"TEMPLATE"`
+	} `json:"DOCUMENT"`
+}
+
+type flowServiceTemplate struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Deployment  string     `json:"deployment,omitempty"`
+	Roles       []flowRole `json:"roles"`
+}
+
+type flowRole struct {
+	Name               string                 `json:"name"`
+	Cardinality        int                    `json:"cardinality"`
+	VMTemplate         int                    `json:"vm_template"`
+	Parents            []string               `json:"parents,omitempty"`
+	MinVMs             int                    `json:"min_vms,omitempty"`
+	MaxVMs             int                    `json:"max_vms,omitempty"`
+	Cooldown           int                    `json:"cooldown,omitempty"`
+	ElasticityPolicies []flowElasticityPolicy `json:"elasticity_policies"`
+	State              flowState              `json:"state,omitempty"`
+	Nodes              []flowNode             `json:"nodes,omitempty"`
+}
+
+type flowElasticityPolicy struct {
+	Type          string `json:"type"`
+	Adjust        int    `json:"adjust"`
+	MinAdjustStep int    `json:"min_adjust_step,omitempty"`
+	Expression    string `json:"expression"`
+	PeriodNumber  int    `json:"period_number,omitempty"`
+	Period        int    `json:"period,omitempty"`
+	Cooldown      int    `json:"cooldown,omitempty"`
+}
+
+type flowNode struct {
+	DeployID int `json:"deploy_id"`
+}
+
+type flowService struct {
+	Name       string     `json:"name"`
+	TemplateID int        `json:"template_id"`
+	State      flowState  `json:"state"`
+	Roles      []flowRole `json:"roles"`
+}
+
+type flowAction struct {
+	Action struct {
+		Perform string                 `json:"perform"`
+		Params  map[string]interface{} `json:"params,omitempty"`
+	} `json:"action"`
+}
+
+// request sends a request to OneFlow, body and result are encoded in JSON
+func (c *flowClient) request(method, path string, body, result interface{}) error {
+	var reqbody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqbody).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, c.endpoint+path, &reqbody)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.username, c.password)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respbody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &flowError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(respbody))}
+	}
+
+	if result == nil || len(respbody) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(respbody, result)
+}
+
+// document sends a request answered by a OneFlow document, its body is
+// decoded into result
+func (c *flowClient) document(method, path string, body, result interface{}) (int, error) {
+	var doc flowDocument
+
+	err := c.request(method, path, body, &doc)
+	if err != nil {
+		return -1, err
+	}
+
+	id, err := strconv.Atoi(doc.Document.ID)
+	if err != nil {
+		return -1, fmt.Errorf("Unexpected OneFlow document ID %q", doc.Document.ID)
+	}
+
+	if result != nil && len(doc.Document.Template.Body) > 0 {
+		err = json.Unmarshal(doc.Document.Template.Body, result)
+		if err != nil {
+			return id, err
+		}
+	}
+
+	return id, nil
+}
+
+func (c *flowClient) CreateServiceTemplate(tpl *flowServiceTemplate) (int, error) {
+	return c.document("POST", "/service_template", tpl, nil)
+}
+
+func (c *flowClient) ServiceTemplate(id int) (*flowServiceTemplate, error) {
+	tpl := &flowServiceTemplate{}
+	_, err := c.document("GET", fmt.Sprintf("/service_template/%d", id), nil, tpl)
+	return tpl, err
+}
+
+func (c *flowClient) UpdateServiceTemplate(id int, tpl *flowServiceTemplate) error {
+	return c.request("PUT", fmt.Sprintf("/service_template/%d", id), tpl, nil)
+}
+
+func (c *flowClient) DeleteServiceTemplate(id int) error {
+	return c.request("DELETE", fmt.Sprintf("/service_template/%d", id), nil, nil)
+}
+
+// InstantiateServiceTemplate creates a service from the template and returns its ID
+func (c *flowClient) InstantiateServiceTemplate(id int, name string) (int, error) {
+	action := &flowAction{}
+	action.Action.Perform = "instantiate"
+	if name != "" {
+		action.Action.Params = map[string]interface{}{
+			"merge_template": map[string]string{"name": name},
+		}
+	}
+
+	return c.document("POST", fmt.Sprintf("/service_template/%d/action", id), action, nil)
+}
+
+func (c *flowClient) Service(id int) (*flowService, error) {
+	service := &flowService{}
+	_, err := c.document("GET", fmt.Sprintf("/service/%d", id), nil, service)
+	return service, err
+}
+
+// ScaleServiceRole changes the cardinality of a role of the service
+func (c *flowClient) ScaleServiceRole(id int, role string, cardinality int) error {
+	body := map[string]interface{}{
+		"cardinality": cardinality,
+		"force":       false,
+	}
+
+	return c.request("PUT", fmt.Sprintf("/service/%d/role/%s", id, role), body, nil)
+}
+
+// ShutdownService undeploys the VMs of the service, the service is then
+// removed by OneFlow
+func (c *flowClient) ShutdownService(id int) error {
+	action := &flowAction{}
+	action.Action.Perform = "shutdown"
+
+	return c.request("POST", fmt.Sprintf("/service/%d/action", id), action, nil)
+}
+
+// getFlowClient returns the OneFlow client of the provider
+func getFlowClient(meta interface{}) (*flowClient, error) {
+	controller := meta.(*goca.Controller)
+
+	pc, ok := controller.Client.(*providerCaller)
+	if !ok || pc.flow == nil {
+		return nil, fmt.Errorf("The OneFlow endpoint is not configured, set oneflow_endpoint in the provider")
+	}
+
+	return pc.flow, nil
+}
@@ -0,0 +1,271 @@
+package opennebula
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// flowStandIn is an in-memory OneFlow server, services are deployed
+// immediately and scaled over the next reads of the service
+type flowStandIn struct {
+	*httptest.Server
+
+	mutex     sync.Mutex
+	nextID    int
+	nextVMID  int
+	templates map[int]*flowServiceTemplate
+	services  map[int]*flowService
+	// steps are applied to the service, one on each read
+	steps map[int][]func()
+}
+
+func newFlowStandIn() *flowStandIn {
+	f := &flowStandIn{
+		templates: make(map[int]*flowServiceTemplate),
+		services:  make(map[int]*flowService),
+		steps:     make(map[int][]func()),
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+func (f *flowStandIn) document(w http.ResponseWriter, id int, name string, body interface{}) {
+	doc := map[string]interface{}{
+		"DOCUMENT": map[string]interface{}{
+			"ID":   strconv.Itoa(id),
+			"NAME": name,
+			"TEMPLATE": map[string]interface{}{
+				"BODY": body,
+			},
+		},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(doc)
+}
+
+func (f *flowStandIn) nodes(cardinality int) []flowNode {
+	nodes := make([]flowNode, 0, cardinality)
+	for i := 0; i < cardinality; i++ {
+		nodes = append(nodes, flowNode{DeployID: f.nextVMID})
+		f.nextVMID++
+	}
+	return nodes
+}
+
+func (f *flowStandIn) serve(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if user, _, ok := r.BasicAuth(); !ok || user == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	id := -1
+	if len(path) > 1 {
+		id, _ = strconv.Atoi(path[1])
+	}
+
+	switch {
+	case path[0] == "service_template" && len(path) == 1 && r.Method == "POST":
+		tpl := &flowServiceTemplate{}
+		if err := json.NewDecoder(r.Body).Decode(tpl); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.templates[f.nextID] = tpl
+		f.document(w, f.nextID, tpl.Name, tpl)
+		f.nextID++
+	case path[0] == "service_template" && len(path) == 2:
+		tpl, ok := f.templates[id]
+		if !ok {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			f.document(w, id, tpl.Name, tpl)
+		case "PUT":
+			tpl = &flowServiceTemplate{}
+			if err := json.NewDecoder(r.Body).Decode(tpl); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			f.templates[id] = tpl
+			f.document(w, id, tpl.Name, tpl)
+		case "DELETE":
+			delete(f.templates, id)
+		}
+	case path[0] == "service_template" && len(path) == 3 && r.Method == "POST":
+		tpl, ok := f.templates[id]
+		if !ok {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		action := &flowAction{}
+		if err := json.NewDecoder(r.Body).Decode(action); err != nil || action.Action.Perform != "instantiate" {
+			http.Error(w, "Bad action", http.StatusBadRequest)
+			return
+		}
+		service := &flowService{Name: tpl.Name, TemplateID: id, State: 2}
+		if merge, ok := action.Action.Params["merge_template"].(map[string]interface{}); ok {
+			service.Name = merge["name"].(string)
+		}
+		for _, role := range tpl.Roles {
+			role.State = 2
+			role.Nodes = f.nodes(role.Cardinality)
+			service.Roles = append(service.Roles, role)
+		}
+		f.services[f.nextID] = service
+		f.document(w, f.nextID, service.Name, service)
+		f.nextID++
+	case path[0] == "service" && len(path) > 1:
+		service, ok := f.services[id]
+		if !ok {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		switch {
+		case len(path) == 2 && r.Method == "GET":
+			if steps := f.steps[id]; len(steps) > 0 {
+				steps[0]()
+				f.steps[id] = steps[1:]
+			}
+			f.document(w, id, service.Name, service)
+		case len(path) == 3 && r.Method == "POST":
+			// shutdown, the service is removed once undeployed
+			delete(f.services, id)
+			delete(f.steps, id)
+		case len(path) == 4 && r.Method == "PUT":
+			body := map[string]int{}
+			json.NewDecoder(r.Body).Decode(&body)
+			for i := range service.Roles {
+				if service.Roles[i].Name != path[3] {
+					continue
+				}
+				role := &service.Roles[i]
+				role.Cardinality = body["cardinality"]
+				// The service stays RUNNING until OneFlow starts scaling
+				f.steps[id] = []func(){
+					func() {},
+					func() {
+						service.State = 8
+						role.Nodes = f.nodes(role.Cardinality)
+					},
+					func() { service.State = 10 },
+					func() { service.State = 2 },
+				}
+			}
+		}
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+func TestFlowState(t *testing.T) {
+	var states struct {
+		Number flowState `json:"number"`
+		String flowState `json:"string"`
+	}
+
+	err := json.Unmarshal([]byte(`{"number": 2, "string": "10"}`), &states)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if states.Number.String() != "RUNNING" || states.String.String() != "COOLDOWN" {
+		t.Errorf("Unexpected states %s, %s", states.Number, states.String)
+	}
+}
+
+func TestFlowClient(t *testing.T) {
+	standin := newFlowStandIn()
+	defer standin.Close()
+
+	flow := newFlowClient(standin.URL+"/", "oneadmin", "password", http.DefaultClient)
+
+	tplID, err := flow.CreateServiceTemplate(&flowServiceTemplate{
+		Name:  "app",
+		Roles: []flowRole{{Name: "frontend", Cardinality: 2, VMTemplate: 0}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tpl, err := flow.ServiceTemplate(tplID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tpl.Name != "app" || len(tpl.Roles) != 1 || tpl.Roles[0].Cardinality != 2 {
+		t.Errorf("Unexpected service template %+v", tpl)
+	}
+
+	serviceID, err := flow.InstantiateServiceTemplate(tplID, "app-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = flow.ScaleServiceRole(serviceID, "frontend", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service, err := flow.Service(serviceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if service.Name != "app-1" || service.TemplateID != tplID || service.State.String() != "RUNNING" || len(service.Roles[0].Nodes) != 2 {
+		t.Errorf("Expected the service to be scaled asynchronously, got %+v", service)
+	}
+
+	service, err = flow.Service(serviceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if service.State.String() != "SCALING" || service.Roles[0].Cardinality != 3 || len(service.Roles[0].Nodes) != 3 {
+		t.Errorf("Unexpected service %+v", service)
+	}
+
+	err = flow.ShutdownService(serviceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = flow.Service(serviceID)
+	if !isFlowNotFound(err) {
+		t.Errorf("Expected a not found error, got %v", err)
+	}
+
+	err = flow.DeleteServiceTemplate(tplID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = flow.ServiceTemplate(tplID)
+	if !isFlowNotFound(err) {
+		t.Errorf("Expected a not found error, got %v", err)
+	}
+
+	unauthorized := newFlowClient(standin.URL, "", "", http.DefaultClient)
+	_, err = unauthorized.ServiceTemplate(tplID)
+	if ferr, ok := err.(*flowError); !ok || ferr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected an unauthorized error, got %v", err)
+	}
+}
+
+func testAccFlowProviderConfig(standin *flowStandIn) string {
+	return fmt.Sprintf(`
+provider "opennebula" {
+  endpoint = "http://localhost:2633/RPC2"
+  username = "oneadmin"
+  password = "opennebula"
+  oneflow_endpoint = "%s"
+}
+`, standin.URL)
+}
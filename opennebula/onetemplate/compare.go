@@ -0,0 +1,68 @@
+package onetemplate
+
+import (
+	"sort"
+	"strings"
+)
+
+// Decode reads a template in OpenNebula syntax or, if it starts with '<', in
+// XML form
+func Decode(s string) (*Template, error) {
+	if strings.HasPrefix(strings.TrimSpace(s), "<") {
+		return ParseXML(s)
+	}
+	return Parse(s)
+}
+
+// canonical returns a form of the attribute independent of the case of the
+// names, the blanks around the values and the order of the pairs of vectors
+func canonical(e Element) string {
+	switch a := e.(type) {
+	case *Pair:
+		return strings.ToUpper(a.Key) + "=" + Quote(strings.TrimSpace(a.Value))
+	case *Vector:
+		pairs := make([]string, 0, len(a.Pairs))
+		for i := range a.Pairs {
+			pairs = append(pairs, canonical(&a.Pairs[i]))
+		}
+		sort.Strings(pairs)
+		return strings.ToUpper(a.Key) + "=[" + strings.Join(pairs, ",") + "]"
+	}
+	return ""
+}
+
+// byName groups the canonical forms of the attributes by name, keeping the
+// order of the attributes sharing a name
+func byName(t *Template) map[string][]string {
+	names := make(map[string][]string)
+	for _, e := range t.Elements {
+		name := strings.ToUpper(e.Name())
+		names[name] = append(names[name], canonical(e))
+	}
+	return names
+}
+
+// Equal returns true if both templates hold the same attributes. The order of
+// the attributes does not matter, except between attributes sharing a name
+// like the DISK vectors, as it is meaningful for OpenNebula.
+func (t *Template) Equal(other *Template) bool {
+	names := byName(t)
+	othernames := byName(other)
+	if len(names) != len(othernames) {
+		return false
+	}
+
+	for name, attrs := range names {
+		otherattrs, ok := othernames[name]
+		if !ok || len(attrs) != len(otherattrs) {
+			return false
+		}
+		for i := range attrs {
+			if attrs[i] != otherattrs[i] {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,75 @@
+package onetemplate
+
+import (
+	"testing"
+)
+
+func TestEqual(t *testing.T) {
+	cases := []struct {
+		name     string
+		a        string
+		b        string
+		expected bool
+	}{
+		{
+			name:     "order and blanks",
+			a:        "CPU=1\nMEMORY=128\nCONTEXT=[NETWORK=YES,SET_HOSTNAME=\"$NAME\"]",
+			b:        "  memory = \"128\"\n\n  context = [\n    set_hostname = \"$NAME\",\n    network = \"YES\" ]\n  cpu = \"1\"",
+			expected: true,
+		},
+		{
+			name:     "XML and string forms",
+			a:        `<TEMPLATE><DESCRIPTION><![CDATA[a "quoted" value]]></DESCRIPTION><DISK><IMAGE_ID>1</IMAGE_ID></DISK></TEMPLATE>`,
+			b:        `DESCRIPTION = "a \"quoted\" value" DISK = [ IMAGE_ID = 1 ]`,
+			expected: true,
+		},
+		{
+			name:     "empty templates",
+			a:        "",
+			b:        "# only a comment",
+			expected: true,
+		},
+		{
+			name:     "different values",
+			a:        "CPU=1",
+			b:        "CPU=2",
+			expected: false,
+		},
+		{
+			name:     "missing attribute",
+			a:        "CPU=1\nMEMORY=128",
+			b:        "CPU=1",
+			expected: false,
+		},
+		{
+			name:     "order of the disks",
+			a:        "DISK=[IMAGE_ID=1]\nDISK=[IMAGE_ID=2]",
+			b:        "DISK=[IMAGE_ID=2]\nDISK=[IMAGE_ID=1]",
+			expected: false,
+		},
+		{
+			name:     "pair and vector",
+			a:        "OS=x86_64",
+			b:        "OS=[ARCH=x86_64]",
+			expected: false,
+		},
+	}
+
+	for _, tc := range cases {
+		a, err := Decode(tc.a)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tc.name, err)
+		}
+		b, err := Decode(tc.b)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tc.name, err)
+		}
+
+		if a.Equal(b) != tc.expected {
+			t.Errorf("%s: expected Equal to be %t", tc.name, tc.expected)
+		}
+		if b.Equal(a) != tc.expected {
+			t.Errorf("%s: expected Equal to be symmetric", tc.name)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package onetemplate
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type parser struct {
+	s    string
+	pos  int
+	line int
+}
+
+// Parse reads a template in OpenNebula syntax. Values may be double quoted,
+// with escaped double quotes and backslashes, or bare words. Comments start
+// with '#' and run to the end of the line.
+func Parse(s string) (*Template, error) {
+	p := &parser{s: s, line: 1}
+	t := &Template{}
+
+	for {
+		p.skip(false)
+		if p.eof() {
+			return t, nil
+		}
+
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.peek() == '[' {
+			p.pos++
+			v := &Vector{Key: key}
+			if err := p.vector(v); err != nil {
+				return nil, err
+			}
+			t.Elements = append(t.Elements, v)
+			continue
+		}
+
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		t.Elements = append(t.Elements, &Pair{Key: key, Value: value})
+	}
+}
+
+func (p *parser) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("template syntax error at line %d: %s", p.line, fmt.Sprintf(format, a...))
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *parser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *parser) next() byte {
+	c := p.s[p.pos]
+	if c == '\n' {
+		p.line++
+	}
+	p.pos++
+	return c
+}
+
+// skip moves past blanks and comments, and the commas separating the pairs
+// of a vector when inVector is set
+func (p *parser) skip(inVector bool) {
+	for !p.eof() {
+		c := p.peek()
+		switch {
+		case c == '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.next()
+			}
+		case inVector && c == ',':
+			p.next()
+		case unicode.IsSpace(rune(c)):
+			p.next()
+		default:
+			return
+		}
+	}
+}
+
+// skipBlanks moves past spaces and tabs only
+func (p *parser) skipBlanks() {
+	for p.peek() == ' ' || p.peek() == '\t' {
+		p.next()
+	}
+}
+
+func isWordByte(c byte) bool {
+	return c != 0 && !strings.ContainsRune(" \t\r\n,=[]\"#", rune(c))
+}
+
+func (p *parser) word() string {
+	start := p.pos
+	for isWordByte(p.peek()) {
+		p.next()
+	}
+	return p.s[start:p.pos]
+}
+
+// key reads an attribute name and the following '='
+func (p *parser) key() (string, error) {
+	key := p.word()
+	if key == "" {
+		return "", p.errorf("unexpected '%c', expecting an attribute name", p.peek())
+	}
+
+	p.skipBlanks()
+	if p.peek() != '=' {
+		return "", p.errorf("expecting '=' after %s", key)
+	}
+	p.next()
+	p.skipBlanks()
+
+	return key, nil
+}
+
+// value reads a quoted or a bare value, it may be empty
+func (p *parser) value() (string, error) {
+	if p.peek() != '"' {
+		return p.word(), nil
+	}
+	p.next()
+
+	b := &strings.Builder{}
+	for !p.eof() {
+		c := p.next()
+		switch {
+		case c == '\\' && (p.peek() == '"' || p.peek() == '\\'):
+			b.WriteByte(p.next())
+		case c == '"':
+			return b.String(), nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return "", p.errorf("unterminated string")
+}
+
+// vector reads the pairs of a vector, up to the closing ']'
+func (p *parser) vector(v *Vector) error {
+	for {
+		p.skip(true)
+		if p.eof() {
+			return p.errorf("unexpected end of template in vector %s, expecting ']'", v.Key)
+		}
+		if p.peek() == ']' {
+			p.next()
+			return nil
+		}
+
+		key, err := p.key()
+		if err != nil {
+			return err
+		}
+		if p.peek() == '[' {
+			return p.errorf("vector %s inside vector %s", key, v.Key)
+		}
+
+		value, err := p.value()
+		if err != nil {
+			return err
+		}
+		v.Pairs = append(v.Pairs, Pair{Key: key, Value: value})
+	}
+}
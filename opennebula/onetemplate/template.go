@@ -0,0 +1,187 @@
+// Package onetemplate parses and serializes OpenNebula templates, made of
+// single attributes (KEY = "value") and vector attributes
+// (KEY = [ SUBKEY = "value", ... ]).
+package onetemplate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Element is an attribute of a template, a Pair or a Vector
+type Element interface {
+	// Name returns the name of the attribute
+	Name() string
+
+	encode(b *strings.Builder)
+}
+
+// Pair is a single attribute
+type Pair struct {
+	Key   string
+	Value string
+}
+
+// Name returns the name of the attribute
+func (p *Pair) Name() string {
+	return p.Key
+}
+
+func (p *Pair) encode(b *strings.Builder) {
+	b.WriteString(p.Key)
+	b.WriteString(" = ")
+	b.WriteString(Quote(p.Value))
+}
+
+// String returns the attribute in OpenNebula syntax
+func (p *Pair) String() string {
+	b := &strings.Builder{}
+	p.encode(b)
+	return b.String()
+}
+
+// Vector is an attribute holding a list of pairs
+type Vector struct {
+	Key   string
+	Pairs []Pair
+}
+
+// Name returns the name of the attribute
+func (v *Vector) Name() string {
+	return v.Key
+}
+
+// AddPair appends a pair to the vector, see FormatValue for the value
+func (v *Vector) AddPair(key string, value interface{}) {
+	v.Pairs = append(v.Pairs, Pair{Key: key, Value: FormatValue(value)})
+}
+
+// Get returns the value of the first pair of the vector with this key
+func (v *Vector) Get(key string) (string, bool) {
+	for _, p := range v.Pairs {
+		if strings.EqualFold(p.Key, key) {
+			return p.Value, true
+		}
+	}
+	return "", false
+}
+
+func (v *Vector) encode(b *strings.Builder) {
+	b.WriteString(v.Key)
+	b.WriteString(" = [")
+	for i := range v.Pairs {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n  ")
+		v.Pairs[i].encode(b)
+	}
+	b.WriteString(" ]")
+}
+
+// String returns the attribute in OpenNebula syntax
+func (v *Vector) String() string {
+	b := &strings.Builder{}
+	v.encode(b)
+	return b.String()
+}
+
+// Template is an ordered list of attributes
+type Template struct {
+	Elements []Element
+}
+
+// AddPair appends a single attribute, see FormatValue for the value
+func (t *Template) AddPair(key string, value interface{}) {
+	t.Elements = append(t.Elements, &Pair{Key: key, Value: FormatValue(value)})
+}
+
+// AddVector appends an empty vector attribute and returns it
+func (t *Template) AddVector(key string) *Vector {
+	v := &Vector{Key: key}
+	t.Elements = append(t.Elements, v)
+	return v
+}
+
+// Append adds the attributes of another template at the end of this one
+func (t *Template) Append(other *Template) {
+	t.Elements = append(t.Elements, other.Elements...)
+}
+
+// Get returns the value of the first single attribute with this key. As in
+// OpenNebula, keys are not case sensitive.
+func (t *Template) Get(key string) (string, bool) {
+	for _, e := range t.Elements {
+		if p, ok := e.(*Pair); ok && strings.EqualFold(p.Key, key) {
+			return p.Value, true
+		}
+	}
+	return "", false
+}
+
+// GetVectors returns the vector attributes with this key
+func (t *Template) GetVectors(key string) []*Vector {
+	vectors := make([]*Vector, 0)
+	for _, e := range t.Elements {
+		if v, ok := e.(*Vector); ok && strings.EqualFold(v.Key, key) {
+			vectors = append(vectors, v)
+		}
+	}
+	return vectors
+}
+
+// Del removes all the attributes with this key
+func (t *Template) Del(key string) {
+	elements := t.Elements[:0]
+	for _, e := range t.Elements {
+		if !strings.EqualFold(e.Name(), key) {
+			elements = append(elements, e)
+		}
+	}
+	t.Elements = elements
+}
+
+// String returns the template in OpenNebula syntax, one attribute per line
+func (t *Template) String() string {
+	b := &strings.Builder{}
+	for i, e := range t.Elements {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		e.encode(b)
+	}
+	return b.String()
+}
+
+// Quote returns the value as a double quoted string, in which backslashes and
+// double quotes are escaped. Newlines are allowed as is inside the quotes.
+func Quote(value string) string {
+	b := &strings.Builder{}
+	b.WriteByte('"')
+	for i := 0; i < len(value); i++ {
+		if value[i] == '"' || value[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(value[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// FormatValue returns the string form of an attribute value. Floats are
+// written without exponent, other types use their default format.
+func FormatValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,113 @@
+package onetemplate
+
+import (
+	"testing"
+)
+
+func TestTemplateString(t *testing.T) {
+	tpl := &Template{}
+	tpl.AddPair("NAME", "test")
+	tpl.AddPair("DESCRIPTION", "a \"quoted\" C:\\path\nSECURITY_GROUPS = \"0\"")
+	tpl.AddPair("CPU", 0.5)
+	tpl.AddPair("MEMORY", 128)
+	ar := tpl.AddVector("AR")
+	ar.AddPair("TYPE", "IP4")
+	ar.AddPair("SIZE", 16)
+
+	expected := `NAME = "test"
+DESCRIPTION = "a \"quoted\" C:\\path
+SECURITY_GROUPS = \"0\""
+CPU = "0.5"
+MEMORY = "128"
+AR = [
+  TYPE = "IP4",
+  SIZE = "16" ]`
+	if tpl.String() != expected {
+		t.Errorf("unexpected template:\n%s\nexpected:\n%s", tpl.String(), expected)
+	}
+
+	// The injected attribute stays in the description
+	parsed, err := Parse(tpl.String())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(parsed.Elements) != 5 {
+		t.Errorf("expected 5 attributes, got %d", len(parsed.Elements))
+	}
+	if _, ok := parsed.Get("SECURITY_GROUPS"); ok {
+		t.Errorf("SECURITY_GROUPS must not be an attribute")
+	}
+	if description, _ := parsed.Get("DESCRIPTION"); description != "a \"quoted\" C:\\path\nSECURITY_GROUPS = \"0\"" {
+		t.Errorf("unexpected description: %s", description)
+	}
+	if parsed.String() != expected {
+		t.Errorf("unexpected template after parsing:\n%s", parsed.String())
+	}
+}
+
+func TestParse(t *testing.T) {
+	tpl, err := Parse(`# Comment
+NAME=test
+  memory = 128 # trailing comment
+EMPTY=""
+CONTEXT = [ NETWORK = "YES", SSH_PUBLIC_KEY="ssh-rsa AAAA",
+  START_SCRIPT = "echo \"hello\" > /tmp/out" ]
+DISK=[IMAGE_ID=1]
+DISK=[IMAGE_ID=2,TARGET=vdb]`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	cases := []struct {
+		key   string
+		value string
+	}{
+		{"NAME", "test"},
+		{"MEMORY", "128"},
+		{"EMPTY", ""},
+	}
+	for _, tc := range cases {
+		value, ok := tpl.Get(tc.key)
+		if !ok || value != tc.value {
+			t.Errorf("%s: expected %q, got %q", tc.key, tc.value, value)
+		}
+	}
+
+	context := tpl.GetVectors("CONTEXT")
+	if len(context) != 1 {
+		t.Fatalf("expected 1 CONTEXT vector, got %d", len(context))
+	}
+	if script, _ := context[0].Get("START_SCRIPT"); script != `echo "hello" > /tmp/out` {
+		t.Errorf("unexpected START_SCRIPT: %s", script)
+	}
+
+	disks := tpl.GetVectors("DISK")
+	if len(disks) != 2 {
+		t.Fatalf("expected 2 DISK vectors, got %d", len(disks))
+	}
+	if target, _ := disks[1].Get("TARGET"); target != "vdb" {
+		t.Errorf("unexpected TARGET: %s", target)
+	}
+
+	tpl.Del("DISK")
+	if len(tpl.GetVectors("DISK")) != 0 {
+		t.Errorf("DISK vectors have not been deleted")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []string{
+		`NAME "test"`,
+		`NAME = "test`,
+		`CONTEXT = [ NETWORK = YES`,
+		`CONTEXT = [ NIC = [ ID = 0 ] ]`,
+		`= test`,
+		`]`,
+	}
+
+	for _, tc := range cases {
+		if _, err := Parse(tc); err == nil {
+			t.Errorf("expected an error parsing %q", tc)
+		}
+	}
+}
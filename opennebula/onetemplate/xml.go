@@ -0,0 +1,79 @@
+package onetemplate
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// ParseXML reads a template in XML form, the attributes are the children of
+// the root element, whatever its name. Elements holding other elements are
+// vectors.
+func ParseXML(s string) (*Template, error) {
+	dec := xml.NewDecoder(strings.NewReader(s))
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("template XML error: %s", err)
+		}
+		if _, ok := tok.(xml.StartElement); ok {
+			break
+		}
+	}
+
+	t := &Template{}
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("template XML error: %s", err)
+		}
+
+		switch e := tok.(type) {
+		case xml.StartElement:
+			element, err := decodeXMLElement(dec, e.Name.Local, false)
+			if err != nil {
+				return nil, err
+			}
+			t.Elements = append(t.Elements, element)
+		case xml.EndElement:
+			return t, nil
+		}
+	}
+}
+
+// decodeXMLElement reads the content of an element up to its end, as a Pair
+// or, if it has children, as a Vector
+func decodeXMLElement(dec *xml.Decoder, name string, inVector bool) (Element, error) {
+	text := &strings.Builder{}
+	var vector *Vector
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("template XML error: %s", err)
+		}
+
+		switch e := tok.(type) {
+		case xml.StartElement:
+			if inVector {
+				return nil, fmt.Errorf("template XML error: vector %s inside a vector", e.Name.Local)
+			}
+			if vector == nil {
+				vector = &Vector{Key: name}
+			}
+			element, err := decodeXMLElement(dec, e.Name.Local, true)
+			if err != nil {
+				return nil, err
+			}
+			vector.Pairs = append(vector.Pairs, *element.(*Pair))
+		case xml.CharData:
+			text.Write(e)
+		case xml.EndElement:
+			if vector != nil {
+				return vector, nil
+			}
+			return &Pair{Key: name, Value: text.String()}, nil
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package onetemplate
+
+import (
+	"testing"
+)
+
+func TestParseXML(t *testing.T) {
+	tpl, err := ParseXML(`<TEMPLATE>
+  <CPU><![CDATA[0.5]]></CPU>
+  <DESCRIPTION>a &quot;quoted&quot; &amp; escaped value</DESCRIPTION>
+  <EMPTY/>
+  <DISK><IMAGE_ID>1</IMAGE_ID><TARGET>vda</TARGET></DISK>
+</TEMPLATE>`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := `CPU = "0.5"
+DESCRIPTION = "a \"quoted\" & escaped value"
+EMPTY = ""
+DISK = [
+  IMAGE_ID = "1",
+  TARGET = "vda" ]`
+	if tpl.String() != expected {
+		t.Errorf("unexpected template:\n%s\nexpected:\n%s", tpl.String(), expected)
+	}
+
+	cases := []string{
+		`<TEMPLATE><CPU>1</CPU>`,
+		`<TEMPLATE><DISK><NIC><ID>0</ID></NIC></DISK></TEMPLATE>`,
+		``,
+	}
+	for _, tc := range cases {
+		if _, err := ParseXML(tc); err == nil {
+			t.Errorf("expected an error parsing %q", tc)
+		}
+	}
+}
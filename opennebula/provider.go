@@ -1,7 +1,14 @@
 package opennebula
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"github.com/hashicorp/terraform/helper/schema"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
 
 	"github.com/OpenNebula/one/src/oca/go/src/goca"
 )
@@ -27,25 +34,124 @@ func Provider() *schema.Provider {
 				Description: "The password for the user",
 				DefaultFunc: schema.EnvDefaultFunc("OPENNEBULA_PASSWORD", nil),
 			},
+			"zone_id": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Description: "ID of the Zone managed by the provider, its endpoint is read from the zone of the endpoint argument",
+				DefaultFunc: schema.EnvDefaultFunc("OPENNEBULA_ZONE_ID", -1),
+			},
+			"oneflow_endpoint": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The URL of the OneFlow REST API, it uses the username and password of the provider",
+				DefaultFunc: schema.EnvDefaultFunc("OPENNEBULA_FLOW_ENDPOINT", nil),
+			},
+			"ca_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Path to a PEM bundle of CAs used to verify the endpoint certificate",
+				DefaultFunc: schema.EnvDefaultFunc("OPENNEBULA_CA_FILE", nil),
+			},
+			"client_cert_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Path to the PEM client certificate used for mutual TLS",
+				DefaultFunc: schema.EnvDefaultFunc("OPENNEBULA_CLIENT_CERT_FILE", nil),
+			},
+			"client_key_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Path to the PEM client private key used for mutual TLS",
+				DefaultFunc: schema.EnvDefaultFunc("OPENNEBULA_CLIENT_KEY_FILE", nil),
+			},
+			"insecure": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "Disable the verification of the endpoint certificate",
+				DefaultFunc: schema.EnvDefaultFunc("OPENNEBULA_INSECURE", false),
+			},
+			"max_concurrent_requests": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     0,
+				Description: "Maximum number of concurrent XML-RPC calls, 0 means unlimited (default: 0)",
+			},
+			"requests_per_second": {
+				Type:        schema.TypeFloat,
+				Optional:    true,
+				Default:     0,
+				Description: "Maximum rate of XML-RPC calls, 0 means unlimited (default: 0)",
+			},
+			"retry_max_attempts": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     3,
+				Description: "Maximum number of attempts of an XML-RPC call failing with a retryable error (default: 3)",
+			},
+			"retry_min_backoff": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "1s",
+				Description:  "Delay before the first retry, doubled at each attempt (default: 1s)",
+				ValidateFunc: validateDuration,
+			},
+			"retry_max_backoff": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "30s",
+				Description:  "Maximum delay between two attempts (default: 30s)",
+				ValidateFunc: validateDuration,
+			},
+			"retryable_errors": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Classes of errors to retry: network, locked, internal, action (default: network, locked)",
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+					ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+						value := v.(string)
+
+						if inArray(value, retryableerrors) < 0 {
+							errors = append(errors, fmt.Errorf("%q must be one of: %s", k, strings.Join(retryableerrors, ",")))
+						}
+
+						return
+					},
+				},
+			},
 		},
 
 		DataSourcesMap: map[string]*schema.Resource{
 			"opennebula_group":               dataOpennebulaGroup(),
 			"opennebula_image":               dataOpennebulaImage(),
+			"opennebula_marketplace_app":     dataOpennebulaMarketPlaceApp(),
 			"opennebula_security_group":      dataOpennebulaSecurityGroup(),
 			"opennebula_template":            dataOpennebulaTemplate(),
 			"opennebula_virtual_data_center": dataOpennebulaVirtualDataCenter(),
 			"opennebula_virtual_network":     dataOpennebulaVirtualNetwork(),
+			"opennebula_zone":                dataOpennebulaZone(),
 		},
 
 		ResourcesMap: map[string]*schema.Resource{
-			"opennebula_group":               resourceOpennebulaGroup(),
-			"opennebula_image":               resourceOpennebulaImage(),
-			"opennebula_security_group":      resourceOpennebulaSecurityGroup(),
-			"opennebula_template":            resourceOpennebulaTemplate(),
-			"opennebula_virtual_data_center": resourceOpennebulaVirtualDataCenter(),
-			"opennebula_virtual_machine":     resourceOpennebulaVirtualMachine(),
-			"opennebula_virtual_network":     resourceOpennebulaVirtualNetwork(),
+			"opennebula_acl":                   resourceOpennebulaACL(),
+			"opennebula_cluster":               resourceOpennebulaCluster(),
+			"opennebula_datastore":             resourceOpennebulaDatastore(),
+			"opennebula_group":                 resourceOpennebulaGroup(),
+			"opennebula_host":                  resourceOpennebulaHost(),
+			"opennebula_image":                 resourceOpennebulaImage(),
+			"opennebula_marketplace_app":       resourceOpennebulaMarketPlaceApp(),
+			"opennebula_security_group":        resourceOpennebulaSecurityGroup(),
+			"opennebula_service":               resourceOpennebulaService(),
+			"opennebula_service_template":      resourceOpennebulaServiceTemplate(),
+			"opennebula_template":              resourceOpennebulaTemplate(),
+			"opennebula_user":                  resourceOpennebulaUser(),
+			"opennebula_user_quota":            resourceOpennebulaUserQuota(),
+			"opennebula_virtual_data_center":   resourceOpennebulaVirtualDataCenter(),
+			"opennebula_virtual_machine":       resourceOpennebulaVirtualMachine(),
+			"opennebula_virtual_machine_group": resourceOpennebulaVirtualMachineGroup(),
+			"opennebula_virtual_network":       resourceOpennebulaVirtualNetwork(),
+			"opennebula_virtual_router":        resourceOpennebulaVirtualRouter(),
+			"opennebula_zone":                  resourceOpennebulaZone(),
 		},
 
 		ConfigureFunc: providerConfigure,
@@ -53,9 +159,101 @@ func Provider() *schema.Provider {
 }
 
 func providerConfigure(d *schema.ResourceData) (interface{}, error) {
-	client := goca.NewClient(goca.NewConfig(d.Get("username").(string),
-		d.Get("password").(string),
-		d.Get("endpoint").(string)))
+	tlsConfig, err := providerTLSConfig(d)
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}
+
+	minBackoff, _ := time.ParseDuration(d.Get("retry_min_backoff").(string))
+	maxBackoff, _ := time.ParseDuration(d.Get("retry_max_backoff").(string))
+
+	retryOn := []string{"network", "locked"}
+	if v, ok := d.GetOk("retryable_errors"); ok {
+		retryOn = []string{}
+		for _, class := range v.([]interface{}) {
+			retryOn = append(retryOn, class.(string))
+		}
+	}
+
+	username := d.Get("username").(string)
+	password := d.Get("password").(string)
+	maxConcurrent := d.Get("max_concurrent_requests").(int)
+	requestsPerSecond := d.Get("requests_per_second").(float64)
+	maxAttempts := d.Get("retry_max_attempts").(int)
+
+	// Each zone endpoint gets its own client, limits and retries
+	newCaller := func(endpoint string) goca.RPCCaller {
+		client := goca.NewClient(goca.NewConfig(username, password, endpoint), httpClient)
+
+		// Limit the calls sent to oned, retried calls included
+		limiter := newLimitCaller(client, maxConcurrent, requestsPerSecond)
+		return newRetryCaller(limiter, maxAttempts, minBackoff, maxBackoff, retryOn)
+	}
+
+	caller := newZoneCaller(newCaller(d.Get("endpoint").(string)), newCaller)
+
+	// Send the calls to the endpoint of the provider zone, if set
+	if zoneID := d.Get("zone_id").(int); zoneID >= 0 {
+		zc, err := caller.Zone(zoneID)
+		if err != nil {
+			return nil, err
+		}
+		caller.RPCCaller = zc
+	}
+
+	pc := &providerCaller{zoneCaller: caller}
+	if endpoint, ok := d.GetOk("oneflow_endpoint"); ok {
+		pc.flow = newFlowClient(endpoint.(string), username, password, httpClient)
+	}
+
+	return goca.NewController(pc), nil
+}
+
+// providerCaller is the caller of the controller given to the resources, it
+// also holds the clients of the other OpenNebula services
+type providerCaller struct {
+	*zoneCaller
+	flow *flowClient
+}
+
+// providerTLSConfig builds the TLS configuration used to reach the XML-RPC endpoint
+func providerTLSConfig(d *schema.ResourceData) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: d.Get("insecure").(bool),
+	}
+
+	if cafile, ok := d.GetOk("ca_file"); ok {
+		pem, err := ioutil.ReadFile(cafile.(string))
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read CA file %s: %s", cafile, err)
+		}
+
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No valid certificate found in CA file %s", cafile)
+		}
+		tlsConfig.RootCAs = certPool
+	}
+
+	certfile, certok := d.GetOk("client_cert_file")
+	keyfile, keyok := d.GetOk("client_key_file")
+	if certok != keyok {
+		return nil, fmt.Errorf("client_cert_file and client_key_file must be set together")
+	}
+	if certok {
+		cert, err := tls.LoadX509KeyPair(certfile.(string), keyfile.(string))
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
 
-	return goca.NewController(client), nil
+	return tlsConfig, nil
 }
@@ -1,12 +1,47 @@
 package opennebula
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"github.com/hashicorp/terraform/helper/hashcode"
+	"github.com/hashicorp/terraform/helper/resource"
 	"github.com/hashicorp/terraform/helper/schema"
 	"github.com/hashicorp/terraform/terraform"
+	"io/ioutil"
+	"math/big"
 	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
+	"time"
 )
 
+// TestMain runs the acceptance tests against an in-memory oned when no
+// OpenNebula endpoint is given
+func TestMain(m *testing.M) {
+	var standin *onedStandIn
+	if os.Getenv("OPENNEBULA_ENDPOINT") == "" {
+		standin = newOnedStandIn()
+		os.Setenv("OPENNEBULA_ENDPOINT", standin.Endpoint())
+		os.Setenv("OPENNEBULA_USERNAME", "oneadmin")
+		os.Setenv("OPENNEBULA_PASSWORD", "opennebula")
+		if os.Getenv(resource.TestEnvVar) == "" {
+			os.Setenv(resource.TestEnvVar, "1")
+		}
+	}
+
+	code := m.Run()
+	if standin != nil {
+		standin.Close()
+	}
+	os.Exit(code)
+}
+
 func TestProvider(t *testing.T) {
 	if err := Provider().InternalValidate(); err != nil {
 		t.Fatalf("err: %s", err)
@@ -17,6 +52,143 @@ func TestProvider_impl(t *testing.T) {
 	var _ terraform.ResourceProvider = Provider()
 }
 
+// writeTestCertificate writes a self-signed certificate and its key as PEM
+// files in the directory
+func writeTestCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "opennebula"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyder, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certfile := filepath.Join(dir, "cert.pem")
+	keyfile := filepath.Join(dir, "key.pem")
+	err = ioutil.WriteFile(certfile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(keyfile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyder}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return certfile, keyfile
+}
+
+func TestProviderTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opennebula-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certfile, keyfile := writeTestCertificate(t, dir)
+	invalidfile := filepath.Join(dir, "invalid.pem")
+	if err := ioutil.WriteFile(invalidfile, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name         string
+		config       map[string]interface{}
+		expectError  bool
+		insecure     bool
+		rootCAs      bool
+		certificates int
+	}{
+		{
+			name:   "defaults",
+			config: map[string]interface{}{},
+		},
+		{
+			name:     "insecure",
+			config:   map[string]interface{}{"insecure": true},
+			insecure: true,
+		},
+		{
+			name:    "CA file",
+			config:  map[string]interface{}{"ca_file": certfile},
+			rootCAs: true,
+		},
+		{
+			name: "client certificate",
+			config: map[string]interface{}{
+				"ca_file":          certfile,
+				"client_cert_file": certfile,
+				"client_key_file":  keyfile,
+			},
+			rootCAs:      true,
+			certificates: 1,
+		},
+		{
+			name:        "missing CA file",
+			config:      map[string]interface{}{"ca_file": filepath.Join(dir, "missing.pem")},
+			expectError: true,
+		},
+		{
+			name:        "invalid CA file",
+			config:      map[string]interface{}{"ca_file": invalidfile},
+			expectError: true,
+		},
+		{
+			name:        "client certificate without key",
+			config:      map[string]interface{}{"client_cert_file": certfile},
+			expectError: true,
+		},
+		{
+			name: "client key mismatch",
+			config: map[string]interface{}{
+				"client_cert_file": certfile,
+				"client_key_file":  invalidfile,
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range cases {
+		d := schema.TestResourceDataRaw(t, Provider().Schema, tc.config)
+
+		tlsConfig, err := providerTLSConfig(d)
+		if tc.expectError {
+			if err == nil {
+				t.Errorf("%s: expected an error", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.name, err)
+			continue
+		}
+
+		if tlsConfig.InsecureSkipVerify != tc.insecure {
+			t.Errorf("%s: expected InsecureSkipVerify to be %t", tc.name, tc.insecure)
+		}
+		if (tlsConfig.RootCAs != nil) != tc.rootCAs {
+			t.Errorf("%s: expected RootCAs to be set: %t", tc.name, tc.rootCAs)
+		}
+		if len(tlsConfig.Certificates) != tc.certificates {
+			t.Errorf("%s: expected %d client certificates, got %d", tc.name, tc.certificates, len(tlsConfig.Certificates))
+		}
+	}
+}
+
 var testAccProviders map[string]terraform.ResourceProvider
 var testAccProvider *schema.Provider
 
@@ -38,3 +210,25 @@ func testEnvIsSet(k string, t *testing.T) {
 		t.Fatalf("%s must be set for acceptance tests", k)
 	}
 }
+
+// testAccCheckSetAttrID checks the set of IDs of a resource holds the ID of
+// another resource
+func testAccCheckSetAttrID(name, key, other string) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		rs, ok := s.RootModule().Resources[name]
+		if !ok {
+			return fmt.Errorf("Resource %s not found", name)
+		}
+		otherrs, ok := s.RootModule().Resources[other]
+		if !ok {
+			return fmt.Errorf("Resource %s not found", other)
+		}
+
+		id := otherrs.Primary.ID
+		if rs.Primary.Attributes[key+"."+strconv.Itoa(hashcode.String(id))] != id {
+			return fmt.Errorf("Expected %s of %s to hold the ID %s of %s", key, name, id, other)
+		}
+
+		return nil
+	}
+}
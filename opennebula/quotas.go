@@ -0,0 +1,192 @@
+package opennebula
+
+import (
+	"github.com/hashicorp/terraform/helper/schema"
+
+	"github.com/OpenNebula/addon-terraform/opennebula/onetemplate"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/shared"
+)
+
+// generateQuotas builds the quota template from a map holding the datastore,
+// network, image and vm quota lists
+func generateQuotas(quotasMap map[string]interface{}) string {
+	datastore := quotasMap["datastore"].([]interface{})
+	network := quotasMap["network"].([]interface{})
+	image := quotasMap["image"].([]interface{})
+
+	var vm []interface{}
+	switch v := quotasMap["vm"].(type) {
+	case *schema.Set:
+		vm = v.List()
+	case []interface{}:
+		vm = v
+	}
+
+	quotastpl := &onetemplate.Template{}
+
+	for i := 0; i < len(datastore); i++ {
+		datastoreMap := datastore[i].(map[string]interface{})
+		vector := quotastpl.AddVector("DATASTORE")
+		vector.AddPair("ID", datastoreMap["datastore_id"].(int))
+		vector.AddPair("IMAGES", datastoreMap["images"].(int))
+		vector.AddPair("SIZE", datastoreMap["size"].(int))
+	}
+
+	for i := 0; i < len(network); i++ {
+		networkMap := network[i].(map[string]interface{})
+		vector := quotastpl.AddVector("NETWORK")
+		vector.AddPair("ID", networkMap["network_id"].(int))
+		vector.AddPair("LEASES", networkMap["leases"].(int))
+	}
+
+	for i := 0; i < len(image); i++ {
+		imageMap := image[i].(map[string]interface{})
+		vector := quotastpl.AddVector("IMAGE")
+		vector.AddPair("ID", imageMap["image_id"].(int))
+		vector.AddPair("RVMS", imageMap["running_vms"].(int))
+	}
+
+	if len(vm) > 0 {
+		vmMap := vm[0].(map[string]interface{})
+		vector := quotastpl.AddVector("VM")
+		vector.AddPair("CPU", vmMap["cpu"].(int))
+		vector.AddPair("MEMORY", vmMap["memory"].(int))
+		vector.AddPair("RUNNING_CPU", vmMap["running_cpu"].(int))
+		vector.AddPair("RUNNING_MEMORY", vmMap["running_memory"].(int))
+		vector.AddPair("RUNNING_VMS", vmMap["running_vms"].(int))
+		vector.AddPair("SYSTEM_DISK_SIZE", vmMap["system_disk_size"].(int))
+		vector.AddPair("VMS", vmMap["vms"].(int))
+	}
+
+	return quotastpl.String()
+}
+
+// defaultQuotas returns the quotas of the map with their limits set back to
+// the default value (-1), only the IDs are kept
+func defaultQuotas(quotasMap map[string]interface{}) map[string]interface{} {
+	defaultsMap := map[string]interface{}{}
+
+	for _, key := range []string{"datastore", "network", "image", "vm"} {
+		var quotas []interface{}
+		switch v := quotasMap[key].(type) {
+		case *schema.Set:
+			quotas = v.List()
+		case []interface{}:
+			quotas = v
+		}
+
+		defaults := make([]interface{}, 0, len(quotas))
+		for _, q := range quotas {
+			quota := map[string]interface{}{}
+			for k, v := range q.(map[string]interface{}) {
+				// Keep the IDs, reset the limits
+				if k == "datastore_id" || k == "network_id" || k == "image_id" {
+					quota[k] = v
+				} else if _, ok := v.(int); ok {
+					quota[k] = -1
+				}
+			}
+			defaults = append(defaults, quota)
+		}
+		defaultsMap[key] = defaults
+	}
+
+	return defaultsMap
+}
+
+// isDefaultQuota returns true if all the limits are the default (-1) value,
+// such quotas are created by OpenNebula to track usage. Unlimited (-2) limits
+// are set by the configuration.
+func isDefaultQuota(limits ...int) bool {
+	for _, limit := range limits {
+		if limit != -1 {
+			return false
+		}
+	}
+	return true
+}
+
+// flattenQuotas converts the quotas of a User or a Group into the datastore,
+// network, image and vm lists used in the schemas. The usage of each quota is
+// added when withUsage is set.
+func flattenQuotas(quotas shared.QuotasList, withUsage bool) map[string][]map[string]interface{} {
+	datastores := make([]map[string]interface{}, 0)
+	for _, q := range quotas.DatastoreQuotas {
+		if isDefaultQuota(q.Images, q.Size) {
+			continue
+		}
+		datastore := map[string]interface{}{
+			"datastore_id": q.ID,
+			"images":       q.Images,
+			"size":         q.Size,
+		}
+		if withUsage {
+			datastore["images_used"] = q.ImagesUsed
+			datastore["size_used"] = q.SizeUsed
+		}
+		datastores = append(datastores, datastore)
+	}
+
+	networks := make([]map[string]interface{}, 0)
+	for _, q := range quotas.NetworkQuotas {
+		if isDefaultQuota(q.Leases) {
+			continue
+		}
+		network := map[string]interface{}{
+			"network_id": q.ID,
+			"leases":     q.Leases,
+		}
+		if withUsage {
+			network["leases_used"] = q.LeasesUsed
+		}
+		networks = append(networks, network)
+	}
+
+	images := make([]map[string]interface{}, 0)
+	for _, q := range quotas.ImageQuotas {
+		if isDefaultQuota(q.RVMs) {
+			continue
+		}
+		image := map[string]interface{}{
+			"image_id":    q.ID,
+			"running_vms": q.RVMs,
+		}
+		if withUsage {
+			image["running_vms_used"] = q.RVMsUsed
+		}
+		images = append(images, image)
+	}
+
+	vms := make([]map[string]interface{}, 0)
+	for _, q := range quotas.VMQuotas {
+		if isDefaultQuota(int(q.CPU), q.Memory, int(q.RunningCPU), q.RunningMemory, q.RunningVMs, int(q.SystemDiskSize), q.VMs) {
+			continue
+		}
+		vm := map[string]interface{}{
+			"cpu":              int(q.CPU),
+			"memory":           q.Memory,
+			"running_cpu":      int(q.RunningCPU),
+			"running_memory":   q.RunningMemory,
+			"running_vms":      q.RunningVMs,
+			"system_disk_size": int(q.SystemDiskSize),
+			"vms":              q.VMs,
+		}
+		if withUsage {
+			vm["cpu_used"] = float64(q.CPUUsed)
+			vm["memory_used"] = q.MemoryUsed
+			vm["running_cpu_used"] = float64(q.RunningCPUUsed)
+			vm["running_memory_used"] = q.RunningMemoryUsed
+			vm["running_vms_used"] = q.RunningVMsUsed
+			vm["system_disk_size_used"] = int(q.SystemDiskSizeUsed)
+			vm["vms_used"] = q.VMsUsed
+		}
+		vms = append(vms, vm)
+	}
+
+	return map[string][]map[string]interface{}{
+		"datastore": datastores,
+		"network":   networks,
+		"image":     images,
+		"vm":        vms,
+	}
+}
@@ -0,0 +1,64 @@
+package opennebula
+
+import (
+	"sync"
+	"time"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+)
+
+// limitCaller wraps a goca RPCCaller to cap the number of concurrent calls and
+// to space out the calls so that the rate of requests sent to oned stays under
+// the configured limit. A zero limit disables the corresponding mechanism.
+type limitCaller struct {
+	caller   goca.RPCCaller
+	slots    chan struct{}
+	interval time.Duration
+
+	mutex sync.Mutex
+	next  time.Time
+}
+
+func newLimitCaller(caller goca.RPCCaller, maxConcurrent int, requestsPerSecond float64) *limitCaller {
+	lc := &limitCaller{
+		caller: caller,
+	}
+
+	if maxConcurrent > 0 {
+		lc.slots = make(chan struct{}, maxConcurrent)
+	}
+	if requestsPerSecond > 0 {
+		lc.interval = time.Duration(float64(time.Second) / requestsPerSecond)
+	}
+
+	return lc
+}
+
+// Call waits for a free slot and for its turn before performing the XML-RPC call
+func (c *limitCaller) Call(method string, args ...interface{}) (*goca.Response, error) {
+	if c.slots != nil {
+		c.slots <- struct{}{}
+		defer func() { <-c.slots }()
+	}
+
+	if c.interval > 0 {
+		time.Sleep(c.reserve())
+	}
+
+	return c.caller.Call(method, args...)
+}
+
+// reserve books the next call slot and returns how long to wait for it
+func (c *limitCaller) reserve() time.Duration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	if c.next.Before(now) {
+		c.next = now
+	}
+	wait := c.next.Sub(now)
+	c.next = c.next.Add(c.interval)
+
+	return wait
+}
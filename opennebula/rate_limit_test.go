@@ -0,0 +1,71 @@
+package opennebula
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+)
+
+type countingCaller struct {
+	mutex   sync.Mutex
+	current int
+	max     int
+	calls   int
+}
+
+func (c *countingCaller) Call(method string, args ...interface{}) (*goca.Response, error) {
+	c.mutex.Lock()
+	c.current++
+	c.calls++
+	if c.current > c.max {
+		c.max = c.current
+	}
+	c.mutex.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	c.mutex.Lock()
+	c.current--
+	c.mutex.Unlock()
+
+	return &goca.Response{}, nil
+}
+
+func TestLimitCallerConcurrency(t *testing.T) {
+	caller := &countingCaller{}
+	lc := newLimitCaller(caller, 2, 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lc.Call("one.vm.info", 0)
+		}()
+	}
+	wg.Wait()
+
+	if caller.calls != 10 {
+		t.Errorf("Expected 10 calls, got %d", caller.calls)
+	}
+	if caller.max > 2 {
+		t.Errorf("Expected at most 2 concurrent calls, got %d", caller.max)
+	}
+}
+
+func TestLimitCallerRate(t *testing.T) {
+	caller := &countingCaller{}
+	lc := newLimitCaller(caller, 0, 100)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		lc.Call("one.vm.info", 0)
+	}
+
+	// 5 calls at 100 requests per second need at least 40ms
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("Expected calls to be spaced out, took %s", elapsed)
+	}
+}
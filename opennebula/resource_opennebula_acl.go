@@ -0,0 +1,362 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/schema"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+)
+
+// ACL selector flags, the ID of the object is stored in the lower 32 bits
+var aclSelectors = map[string]uint64{
+	"id":      0x100000000,
+	"group":   0x200000000,
+	"all":     0x400000000,
+	"cluster": 0x800000000,
+}
+
+var aclResources = map[string]uint64{
+	"VM":             0x1000000000,
+	"HOST":           0x2000000000,
+	"NET":            0x4000000000,
+	"IMAGE":          0x8000000000,
+	"USER":           0x10000000000,
+	"TEMPLATE":       0x20000000000,
+	"GROUP":          0x40000000000,
+	"DATASTORE":      0x100000000000,
+	"CLUSTER":        0x200000000000,
+	"DOCUMENT":       0x400000000000,
+	"ZONE":           0x800000000000,
+	"SECGROUP":       0x1000000000000,
+	"VDC":            0x2000000000000,
+	"VROUTER":        0x4000000000000,
+	"MARKETPLACE":    0x8000000000000,
+	"MARKETPLACEAPP": 0x10000000000000,
+	"VMGROUP":        0x20000000000000,
+	"VNTEMPLATE":     0x40000000000000,
+}
+
+var aclRights = map[string]uint64{
+	"USE":    0x1,
+	"MANAGE": 0x2,
+	"ADMIN":  0x4,
+	"CREATE": 0x8,
+}
+
+var aclusertypes = []string{"id", "group", "all", "cluster"}
+var aclresourcetypes = []string{"id", "group", "all", "cluster"}
+var aclzonetypes = []string{"id", "all"}
+
+func resourceOpennebulaACL() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceOpennebulaACLCreate,
+		Read:   resourceOpennebulaACLRead,
+		Delete: resourceOpennebulaACLDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"user_type": {
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "Type of the users the rule applies to: id (a user), group, all, cluster",
+				ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+					value := v.(string)
+
+					if inArray(value, aclusertypes) < 0 {
+						errors = append(errors, fmt.Errorf("Type %q must be one of: %s", k, strings.Join(aclusertypes, ",")))
+					}
+
+					return
+				},
+			},
+			"user_id": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				ForceNew:    true,
+				Default:     0,
+				Description: "ID of the user, group or cluster the rule applies to. Ignored if user_type is 'all'",
+			},
+			"resources": {
+				Type:        schema.TypeList,
+				Required:    true,
+				ForceNew:    true,
+				MinItems:    1,
+				Description: "List of resource types: VM, HOST, NET, IMAGE, USER, TEMPLATE, GROUP, DATASTORE, CLUSTER, DOCUMENT, ZONE, SECGROUP, VDC, VROUTER, MARKETPLACE, MARKETPLACEAPP, VMGROUP, VNTEMPLATE",
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+					ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+						value := v.(string)
+
+						if _, ok := aclResources[value]; !ok {
+							errors = append(errors, fmt.Errorf("Resource %q is not a valid ACL resource type: %s", k, value))
+						}
+
+						return
+					},
+				},
+			},
+			"resource_type": {
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "Type of the resource selector: id (a resource), group, all, cluster",
+				ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+					value := v.(string)
+
+					if inArray(value, aclresourcetypes) < 0 {
+						errors = append(errors, fmt.Errorf("Type %q must be one of: %s", k, strings.Join(aclresourcetypes, ",")))
+					}
+
+					return
+				},
+			},
+			"resource_id": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				ForceNew:    true,
+				Default:     0,
+				Description: "ID of the resource, group or cluster selected. Ignored if resource_type is 'all'",
+			},
+			"rights": {
+				Type:        schema.TypeList,
+				Required:    true,
+				ForceNew:    true,
+				MinItems:    1,
+				Description: "List of rights: USE, MANAGE, ADMIN, CREATE",
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+					ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+						value := v.(string)
+
+						if _, ok := aclRights[value]; !ok {
+							errors = append(errors, fmt.Errorf("Right %q must be one of: USE,MANAGE,ADMIN,CREATE", k))
+						}
+
+						return
+					},
+				},
+			},
+			"zone_type": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
+				ForceNew:    true,
+				Description: "Type of the zone selector: id (a zone), all. If empty, the rule applies to the zone of the provider",
+				ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+					value := v.(string)
+
+					if inArray(value, aclzonetypes) < 0 {
+						errors = append(errors, fmt.Errorf("Type %q must be one of: %s", k, strings.Join(aclzonetypes, ",")))
+					}
+
+					return
+				},
+			},
+			"zone_id": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Computed:    true,
+				ForceNew:    true,
+				Description: "ID of the zone. Only used if zone_type is 'id', defaults to the zone of the provider",
+			},
+			"rule": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "String representation of the rule, as displayed by oneacl",
+			},
+		},
+	}
+}
+
+// aclSelector encodes a selector (user, resource ID or zone)
+func aclSelector(seltype string, id int) uint64 {
+	if seltype == "all" {
+		return aclSelectors[seltype]
+	}
+	return aclSelectors[seltype] | uint64(uint32(id))
+}
+
+// parseACLSelector decodes a selector into its type and its ID
+func parseACLSelector(selector uint64) (string, int, error) {
+	for _, seltype := range aclusertypes {
+		if selector&aclSelectors[seltype] != 0 {
+			if seltype == "all" {
+				return seltype, 0, nil
+			}
+			return seltype, int(uint32(selector)), nil
+		}
+	}
+	return "", 0, fmt.Errorf("Unexpected ACL selector %x", selector)
+}
+
+// generateACLRule returns the user, resource, rights and zone components of
+// the rule as hexadecimal strings
+func generateACLRule(d *schema.ResourceData) (string, string, string, string) {
+	user := aclSelector(d.Get("user_type").(string), d.Get("user_id").(int))
+
+	resource := aclSelector(d.Get("resource_type").(string), d.Get("resource_id").(int))
+	for _, r := range d.Get("resources").([]interface{}) {
+		resource |= aclResources[r.(string)]
+	}
+
+	var rights uint64
+	for _, r := range d.Get("rights").([]interface{}) {
+		rights |= aclRights[r.(string)]
+	}
+
+	zone := ""
+	if zonetype, ok := d.GetOk("zone_type"); ok {
+		zone = strconv.FormatUint(aclSelector(zonetype.(string), d.Get("zone_id").(int)), 16)
+	}
+
+	return strconv.FormatUint(user, 16), strconv.FormatUint(resource, 16), strconv.FormatUint(rights, 16), zone
+}
+
+// parseACLFlags returns the sorted names of the flags set in value
+func parseACLFlags(value uint64, flags map[string]uint64) []string {
+	names := make([]string, 0)
+	for name, flag := range flags {
+		if value&flag != 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sortedACLList returns the configured list sorted, to be compared with the
+// lists read from OpenNebula
+func sortedACLList(list []interface{}) []string {
+	names := make([]string, 0, len(list))
+	for _, v := range list {
+		names = append(names, v.(string))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func resourceOpennebulaACLCreate(d *schema.ResourceData, meta interface{}) error {
+	controller := meta.(*goca.Controller)
+
+	user, resource, rights, zone := generateACLRule(d)
+	log.Printf("[DEBUG] ACL rule: user %s, resource %s, rights %s, zone %s", user, resource, rights, zone)
+
+	var aclID int
+	var err error
+	if zone != "" {
+		aclID, err = controller.ACLs().CreateRule(user, resource, rights, zone)
+	} else {
+		aclID, err = controller.ACLs().CreateRule(user, resource, rights)
+	}
+	if err != nil {
+		return err
+	}
+
+	d.SetId(fmt.Sprintf("%v", aclID))
+
+	return resourceOpennebulaACLRead(d, meta)
+}
+
+func resourceOpennebulaACLRead(d *schema.ResourceData, meta interface{}) error {
+	controller := meta.(*goca.Controller)
+
+	aclID, err := strconv.Atoi(d.Id())
+	if err != nil {
+		return fmt.Errorf("ACL Id (%s) is not an integer", d.Id())
+	}
+
+	acls, err := controller.ACLs().Info()
+	if err != nil {
+		return err
+	}
+
+	for _, acl := range acls.ACLs {
+		if acl.ID != aclID {
+			continue
+		}
+
+		user, err := strconv.ParseUint(acl.User, 16, 64)
+		if err != nil {
+			return err
+		}
+		resource, err := strconv.ParseUint(acl.Resource, 16, 64)
+		if err != nil {
+			return err
+		}
+		rights, err := strconv.ParseUint(acl.Rights, 16, 64)
+		if err != nil {
+			return err
+		}
+
+		usertype, userid, err := parseACLSelector(user)
+		if err != nil {
+			return err
+		}
+		d.Set("user_type", usertype)
+		d.Set("user_id", userid)
+
+		// The resource selector flags are in the lower 36 bits
+		resourcetype, resourceid, err := parseACLSelector(resource & 0xFFFFFFFFF)
+		if err != nil {
+			return err
+		}
+		d.Set("resource_type", resourcetype)
+		d.Set("resource_id", resourceid)
+
+		// Keep the configured order if the lists are the same
+		resources := parseACLFlags(resource, aclResources)
+		if strings.Join(resources, ",") != strings.Join(sortedACLList(d.Get("resources").([]interface{})), ",") {
+			d.Set("resources", resources)
+		}
+		rightslist := parseACLFlags(rights, aclRights)
+		if strings.Join(rightslist, ",") != strings.Join(sortedACLList(d.Get("rights").([]interface{})), ",") {
+			d.Set("rights", rightslist)
+		}
+
+		// oned sets the zone of the rule to its own zone when none is given
+		zone, err := strconv.ParseUint(acl.Zone, 16, 64)
+		if err != nil {
+			return err
+		}
+		zonetype, zoneid, err := parseACLSelector(zone)
+		if err != nil {
+			return err
+		}
+		d.Set("zone_type", zonetype)
+		d.Set("zone_id", zoneid)
+
+		d.Set("rule", acl.String)
+
+		return nil
+	}
+
+	log.Printf("[WARN] ACL rule %s not found, removing it from the state", d.Id())
+	d.SetId("")
+
+	return nil
+}
+
+func resourceOpennebulaACLDelete(d *schema.ResourceData, meta interface{}) error {
+	controller := meta.(*goca.Controller)
+
+	aclID, err := strconv.Atoi(d.Id())
+	if err != nil {
+		return fmt.Errorf("ACL Id (%s) is not an integer", d.Id())
+	}
+
+	err = controller.ACLs().DeleteRule(aclID)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Successfully deleted ACL rule ID %s\n", d.Id())
+
+	return nil
+}
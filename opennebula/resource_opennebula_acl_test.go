@@ -0,0 +1,128 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/terraform"
+	"strconv"
+	"testing"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+)
+
+func TestACLSelector(t *testing.T) {
+	cases := []struct {
+		seltype string
+		id      int
+		hex     string
+	}{
+		{"id", 5, "100000005"},
+		{"group", 1, "200000001"},
+		{"all", 0, "400000000"},
+		{"cluster", 100, "800000064"},
+	}
+
+	for _, c := range cases {
+		selector := aclSelector(c.seltype, c.id)
+		if got := strconv.FormatUint(selector, 16); got != c.hex {
+			t.Errorf("aclSelector(%q, %d) = %s, expected %s", c.seltype, c.id, got, c.hex)
+		}
+
+		seltype, id, err := parseACLSelector(selector)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seltype != c.seltype || id != c.id {
+			t.Errorf("parseACLSelector(%s) = %s, %d, expected %s, %d", c.hex, seltype, id, c.seltype, c.id)
+		}
+	}
+
+	if _, _, err := parseACLSelector(0); err == nil {
+		t.Error("Expected an error for an empty selector")
+	}
+}
+
+func TestACLFlags(t *testing.T) {
+	resource := aclSelector("all", 0) | aclResources["VM"] | aclResources["IMAGE"] | aclResources["NET"]
+	if got := strconv.FormatUint(resource, 16); got != "d400000000" {
+		t.Errorf("Resource component is %s, expected d400000000", got)
+	}
+
+	resources := parseACLFlags(resource, aclResources)
+	if fmt.Sprintf("%v", resources) != "[IMAGE NET VM]" {
+		t.Errorf("Unexpected resources: %v", resources)
+	}
+
+	rights := parseACLFlags(aclRights["USE"]|aclRights["CREATE"], aclRights)
+	if fmt.Sprintf("%v", rights) != "[CREATE USE]" {
+		t.Errorf("Unexpected rights: %v", rights)
+	}
+}
+
+func TestAccACL(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckACLDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccACLConfigBasic,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_acl.acl", "user_type", "group"),
+					resource.TestCheckResourceAttr("opennebula_acl.acl", "user_id", "1"),
+					resource.TestCheckResourceAttr("opennebula_acl.acl", "resources.#", "2"),
+					resource.TestCheckResourceAttr("opennebula_acl.acl", "resource_type", "all"),
+					resource.TestCheckResourceAttr("opennebula_acl.acl", "rights.#", "1"),
+					resource.TestCheckResourceAttr("opennebula_acl.acl", "rights.0", "USE"),
+					resource.TestCheckResourceAttr("opennebula_acl.acl", "zone_type", "all"),
+					resource.TestCheckResourceAttr("opennebula_acl.acl", "rule", "@1 VM+IMAGE/* USE *"),
+					resource.TestCheckResourceAttr("opennebula_acl.local", "zone_type", "id"),
+					resource.TestCheckResourceAttr("opennebula_acl.local", "zone_id", "0"),
+					resource.TestCheckResourceAttr("opennebula_acl.local", "rule", "#0 HOST/* MANAGE #0"),
+				),
+			},
+		},
+	})
+}
+
+func testAccCheckACLDestroy(s *terraform.State) error {
+	controller := testAccProvider.Meta().(*goca.Controller)
+
+	acls, err := controller.ACLs().Info()
+	if err != nil {
+		return err
+	}
+
+	for _, rs := range s.RootModule().Resources {
+		if rs.Type != "opennebula_acl" {
+			continue
+		}
+		aclID, _ := strconv.Atoi(rs.Primary.ID)
+		for _, acl := range acls.ACLs {
+			if acl.ID == aclID {
+				return fmt.Errorf("Expected ACL rule %s to have been destroyed", rs.Primary.ID)
+			}
+		}
+	}
+
+	return nil
+}
+
+var testAccACLConfigBasic = `
+resource "opennebula_acl" "acl" {
+  user_type = "group"
+  user_id = 1
+  resources = ["VM", "IMAGE"]
+  resource_type = "all"
+  rights = ["USE"]
+  zone_type = "all"
+}
+
+resource "opennebula_acl" "local" {
+  user_type = "id"
+  user_id = 0
+  resources = ["HOST"]
+  resource_type = "all"
+  rights = ["MANAGE"]
+}
+`
@@ -0,0 +1,322 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/schema"
+	"log"
+	"strconv"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+)
+
+func resourceOpennebulaCluster() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceOpennebulaClusterCreate,
+		Read:   resourceOpennebulaClusterRead,
+		Exists: resourceOpennebulaClusterExists,
+		Update: resourceOpennebulaClusterUpdate,
+		Delete: resourceOpennebulaClusterDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Name of the Cluster",
+			},
+			"zone_id": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				ForceNew:    true,
+				Default:     -1,
+				Description: "ID of the Zone of the Cluster. If not set, it uses the zone of the provider",
+			},
+			"template": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				DiffSuppressFunc: templateDiffSuppress,
+				Description:      "Cluster template content, in OpenNebula XML or String format",
+			},
+			// Only the members listed here are managed by the Cluster, the
+			// others may be managed by the cluster_id of the hosts and the
+			// clusters of the datastores and virtual networks
+			"hosts": {
+				Type:        schema.TypeSet,
+				Optional:    true,
+				Computed:    true,
+				Description: "List of Host IDs member of the Cluster",
+				Elem: &schema.Schema{
+					Type: schema.TypeInt,
+				},
+				Set: schema.HashInt,
+			},
+			"datastores": {
+				Type:        schema.TypeSet,
+				Optional:    true,
+				Computed:    true,
+				Description: "List of Datastore IDs member of the Cluster",
+				Elem: &schema.Schema{
+					Type: schema.TypeInt,
+				},
+				Set: schema.HashInt,
+			},
+			"virtual_networks": {
+				Type:        schema.TypeSet,
+				Optional:    true,
+				Computed:    true,
+				Description: "List of Virtual Network IDs member of the Cluster",
+				Elem: &schema.Schema{
+					Type: schema.TypeInt,
+				},
+				Set: schema.HashInt,
+			},
+		},
+	}
+}
+
+func getClusterController(d *schema.ResourceData, meta interface{}) (*goca.ClusterController, error) {
+	controller := zoneController(d, meta)
+	var cc *goca.ClusterController
+
+	// Try to find the Cluster by ID, if specified
+	if d.Id() != "" {
+		clusterid, err := strconv.ParseUint(d.Id(), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Cluster Id (%s) is not an integer", d.Id())
+		}
+		cc = controller.Cluster(int(clusterid))
+	}
+
+	// Otherwise, try to find the Cluster by name as the de facto compound primary key
+	if d.Id() == "" {
+		clusterid, err := controller.Clusters().ByName(d.Get("name").(string))
+		if err != nil {
+			d.SetId("")
+			return nil, fmt.Errorf("Could not find Cluster with name %s", d.Get("name").(string))
+		}
+		cc = controller.Cluster(clusterid)
+	}
+
+	return cc, nil
+}
+
+func resourceOpennebulaClusterCreate(d *schema.ResourceData, meta interface{}) error {
+	controller := zoneController(d, meta)
+
+	clusterID, err := controller.Clusters().Create(d.Get("name").(string))
+	if err != nil {
+		return err
+	}
+	d.SetId(fmt.Sprintf("%v", clusterID))
+
+	cc := controller.Cluster(clusterID)
+
+	// add template description
+	if d.Get("template") != "" {
+		// Erase previous template
+		err = cc.Update(d.Get("template").(string), 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, h := range d.Get("hosts").(*schema.Set).List() {
+		err = cc.AddHost(h.(int))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, ds := range d.Get("datastores").(*schema.Set).List() {
+		err = cc.AddDatastore(ds.(int))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, vn := range d.Get("virtual_networks").(*schema.Set).List() {
+		err = cc.AddVnet(vn.(int))
+		if err != nil {
+			return err
+		}
+	}
+
+	return resourceOpennebulaClusterRead(d, meta)
+}
+
+// managedMembers returns the members of the Cluster also found in the set,
+// the members managed by other resources are left out
+func managedMembers(members []int, managed *schema.Set) []int {
+	ids := make([]int, 0, len(members))
+	for _, id := range members {
+		if managed.Contains(id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func resourceOpennebulaClusterRead(d *schema.ResourceData, meta interface{}) error {
+	cc, err := getClusterController(d, meta)
+	if err != nil {
+		return err
+	}
+
+	cluster, err := cc.Info()
+	if err != nil {
+		return err
+	}
+
+	d.SetId(fmt.Sprintf("%v", cluster.ID))
+	readZoneID(d)
+	d.Set("name", cluster.Name)
+
+	tpl, err := getObjectTemplate(zoneController(d, meta), "one.cluster.info", cluster.ID)
+	if err != nil {
+		return err
+	}
+	d.Set("template", managedTemplate(tpl, d.Get("template").(string)))
+
+	err = d.Set("hosts", managedMembers(cluster.HostsID, d.Get("hosts").(*schema.Set)))
+	if err != nil {
+		log.Printf("[DEBUG] Error setting hosts on cluster: %s", err)
+	}
+	err = d.Set("datastores", managedMembers(cluster.DatastoresID, d.Get("datastores").(*schema.Set)))
+	if err != nil {
+		log.Printf("[DEBUG] Error setting datastores on cluster: %s", err)
+	}
+	err = d.Set("virtual_networks", managedMembers(cluster.VnetsID, d.Get("virtual_networks").(*schema.Set)))
+	if err != nil {
+		log.Printf("[DEBUG] Error setting virtual networks on cluster: %s", err)
+	}
+
+	return nil
+}
+
+func resourceOpennebulaClusterExists(d *schema.ResourceData, meta interface{}) (bool, error) {
+	err := resourceOpennebulaClusterRead(d, meta)
+	if err != nil || d.Id() == "" {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func resourceOpennebulaClusterUpdate(d *schema.ResourceData, meta interface{}) error {
+	cc, err := getClusterController(d, meta)
+	if err != nil {
+		return err
+	}
+
+	if d.HasChange("name") {
+		err = cc.Rename(d.Get("name").(string))
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated name for Cluster %s\n", d.Get("name"))
+	}
+
+	if d.HasChange("template") {
+		// Erase previous template
+		err = cc.Update(d.Get("template").(string), 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	if d.HasChange("hosts") {
+		ohosts, nhosts := d.GetChange("hosts")
+		addhost, delhost := getAddDelIntList(nhosts.(*schema.Set).List(), ohosts.(*schema.Set).List())
+
+		// Removed hosts go back to the default cluster
+		for _, h := range delhost {
+			err = cc.DelHost(h)
+			if err != nil {
+				return err
+			}
+		}
+		for _, h := range addhost {
+			err = cc.AddHost(h)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if d.HasChange("datastores") {
+		odatastores, ndatastores := d.GetChange("datastores")
+		addds, delds := getAddDelIntList(ndatastores.(*schema.Set).List(), odatastores.(*schema.Set).List())
+
+		for _, ds := range delds {
+			err = cc.DelDatastore(ds)
+			if err != nil {
+				return err
+			}
+		}
+		for _, ds := range addds {
+			err = cc.AddDatastore(ds)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if d.HasChange("virtual_networks") {
+		ovnets, nvnets := d.GetChange("virtual_networks")
+		addvnet, delvnet := getAddDelIntList(nvnets.(*schema.Set).List(), ovnets.(*schema.Set).List())
+
+		for _, vn := range delvnet {
+			err = cc.DelVnet(vn)
+			if err != nil {
+				return err
+			}
+		}
+		for _, vn := range addvnet {
+			err = cc.AddVnet(vn)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return resourceOpennebulaClusterRead(d, meta)
+}
+
+func resourceOpennebulaClusterDelete(d *schema.ResourceData, meta interface{}) error {
+	cc, err := getClusterController(d, meta)
+	if err != nil {
+		return err
+	}
+
+	// A Cluster must be empty to be deleted, the members managed by other
+	// resources leave it when they are destroyed or updated
+	for _, h := range d.Get("hosts").(*schema.Set).List() {
+		err = cc.DelHost(h.(int))
+		if err != nil {
+			return err
+		}
+	}
+	for _, ds := range d.Get("datastores").(*schema.Set).List() {
+		err = cc.DelDatastore(ds.(int))
+		if err != nil {
+			return err
+		}
+	}
+	for _, vn := range d.Get("virtual_networks").(*schema.Set).List() {
+		err = cc.DelVnet(vn.(int))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = cc.Delete()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Successfully deleted Cluster ID %s\n", d.Id())
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/terraform"
+	"strconv"
+	"testing"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+)
+
+func TestAccCluster(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckClusterDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccClusterConfigBasic,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_cluster.cluster", "name", "terracluster"),
+					resource.TestCheckResourceAttr("opennebula_cluster.cluster", "template", "RESERVED_CPU = \"10\""),
+					resource.TestCheckResourceAttr("opennebula_cluster.cluster", "hosts.#", "0"),
+					resource.TestCheckResourceAttr("opennebula_cluster.cluster", "datastores.#", "1"),
+					testAccCheckClusterMembers("opennebula_cluster.cluster", 1, 0),
+					resource.TestCheckResourceAttr("opennebula_cluster.cluster", "virtual_networks.#", "0"),
+				),
+			},
+			{
+				Config: testAccClusterConfigUpdate,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_cluster.cluster", "name", "terracluster-renamed"),
+					resource.TestCheckResourceAttr("opennebula_cluster.cluster", "template", "RESERVED_CPU = \"20\""),
+					resource.TestCheckResourceAttrPair("opennebula_virtual_network.vnet", "clusters.0", "opennebula_cluster.cluster", "id"),
+					testAccCheckSetAttrID("opennebula_datastore.datastore", "clusters", "opennebula_cluster.cluster"),
+					// The members managed by the datastore and the virtual
+					// network are not part of the cluster sets
+					resource.TestCheckResourceAttr("opennebula_cluster.cluster", "datastores.#", "1"),
+					resource.TestCheckResourceAttr("opennebula_cluster.cluster", "virtual_networks.#", "0"),
+					testAccCheckClusterMembers("opennebula_cluster.cluster", 2, 1),
+				),
+			},
+		},
+	})
+}
+
+// testAccCheckClusterMembers checks the number of datastores and virtual
+// networks of the cluster
+func testAccCheckClusterMembers(name string, datastores, vnets int) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		rs, ok := s.RootModule().Resources[name]
+		if !ok {
+			return fmt.Errorf("Resource %s not found", name)
+		}
+		clusterID, _ := strconv.ParseUint(rs.Primary.ID, 10, 64)
+
+		controller := testAccProvider.Meta().(*goca.Controller)
+		cluster, err := controller.Cluster(int(clusterID)).Info()
+		if err != nil {
+			return err
+		}
+		if len(cluster.DatastoresID) != datastores || len(cluster.VnetsID) != vnets {
+			return fmt.Errorf("Expected cluster %s to have %d datastores and %d virtual networks, got %v and %v",
+				rs.Primary.ID, datastores, vnets, cluster.DatastoresID, cluster.VnetsID)
+		}
+
+		return nil
+	}
+}
+
+func testAccCheckClusterDestroy(s *terraform.State) error {
+	controller := testAccProvider.Meta().(*goca.Controller)
+
+	for _, rs := range s.RootModule().Resources {
+		if rs.Type != "opennebula_cluster" {
+			continue
+		}
+		clusterID, _ := strconv.ParseUint(rs.Primary.ID, 10, 64)
+		cc := controller.Cluster(int(clusterID))
+		// Get Cluster Info
+		cluster, _ := cc.Info()
+		if cluster != nil {
+			return fmt.Errorf("Expected cluster %s to have been destroyed", rs.Primary.ID)
+		}
+	}
+
+	return nil
+}
+
+var testAccClusterConfigBasic = `
+resource "opennebula_cluster" "cluster" {
+  name = "terracluster"
+  template = <<EOF
+    RESERVED_CPU = "10"
+    EOF
+  datastores = [1]
+}
+`
+
+var testAccClusterConfigUpdate = `
+resource "opennebula_virtual_network" "vnet" {
+  name = "terracluster-vnet"
+  physical_device = "dummy0"
+  type            = "vxlan"
+  vlan_id         = "8000047"
+  mtu             = 1500
+  ar {
+    ar_type = "IP4"
+    size    = 16
+    ip4     = "172.16.101.110"
+  }
+  permissions = "642"
+  security_groups = [0]
+  clusters = ["${opennebula_cluster.cluster.id}"]
+}
+
+resource "opennebula_datastore" "datastore" {
+  name = "terracluster-datastore"
+  type = "image"
+  ds_mad = "dummy"
+  tm_mad = "dummy"
+  clusters = ["${opennebula_cluster.cluster.id}"]
+}
+
+resource "opennebula_cluster" "cluster" {
+  name = "terracluster-renamed"
+  template = <<EOF
+    RESERVED_CPU = "20"
+    EOF
+  datastores = [2]
+}
+`
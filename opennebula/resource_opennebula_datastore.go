@@ -0,0 +1,341 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/schema"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/OpenNebula/addon-terraform/opennebula/onetemplate"
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+)
+
+// Datastore types, in the order of their numerical value in OpenNebula
+var datastoretypes = []string{"image", "system", "file"}
+
+func resourceOpennebulaDatastore() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceOpennebulaDatastoreCreate,
+		Read:   resourceOpennebulaDatastoreRead,
+		Exists: resourceOpennebulaDatastoreExists,
+		Update: resourceOpennebulaDatastoreUpdate,
+		Delete: resourceOpennebulaDatastoreDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Name of the Datastore",
+			},
+			"zone_id": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				ForceNew:    true,
+				Default:     -1,
+				Description: "ID of the Zone of the Datastore. If not set, it uses the zone of the provider",
+			},
+			"type": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Default:     "image",
+				Description: "Type of the Datastore: image, system, file. Default is 'image'",
+				ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+					value := v.(string)
+
+					if inArray(value, datastoretypes) < 0 {
+						errors = append(errors, fmt.Errorf("Type %q must be one of: %s", k, strings.Join(datastoretypes, ",")))
+					}
+
+					return
+				},
+			},
+			"ds_mad": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Datastore driver: fs, ceph, dev, iscsi_libvirt... Not used by system datastores",
+			},
+			"tm_mad": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Transfer driver: shared, ssh, qcow2, ceph, fs_lvm...",
+			},
+			"clusters": {
+				Type:        schema.TypeSet,
+				Optional:    true,
+				Computed:    true,
+				Description: "List of Cluster IDs hosting the Datastore. If empty, it uses the default cluster",
+				Elem: &schema.Schema{
+					Type: schema.TypeInt,
+				},
+				Set: schema.HashInt,
+			},
+			"limit_mb": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Description: "Maximum capacity allowed for the Datastore in MB",
+			},
+			"enabled": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     true,
+				Description: "Enable or disable the Datastore. Default is true",
+			},
+			"template": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				DiffSuppressFunc: templateDiffSuppress,
+				Description:      "Additional Datastore attributes (e.g. CEPH_HOST, POOL_NAME), in OpenNebula XML or String format",
+			},
+			"total_mb": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Total capacity of the Datastore in MB",
+			},
+			"free_mb": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Free capacity of the Datastore in MB",
+			},
+			"used_mb": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Used capacity of the Datastore in MB",
+			},
+		},
+	}
+}
+
+func getDatastoreController(d *schema.ResourceData, meta interface{}) (*goca.DatastoreController, error) {
+	controller := zoneController(d, meta)
+	var dc *goca.DatastoreController
+
+	// Try to find the Datastore by ID, if specified
+	if d.Id() != "" {
+		dsid, err := strconv.ParseUint(d.Id(), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Datastore Id (%s) is not an integer", d.Id())
+		}
+		dc = controller.Datastore(int(dsid))
+	}
+
+	// Otherwise, try to find the Datastore by name as the de facto compound primary key
+	if d.Id() == "" {
+		dsid, err := controller.Datastores().ByName(d.Get("name").(string))
+		if err != nil {
+			d.SetId("")
+			return nil, fmt.Errorf("Could not find Datastore with name %s", d.Get("name").(string))
+		}
+		dc = controller.Datastore(dsid)
+	}
+
+	return dc, nil
+}
+
+// generateDatastoreTemplate returns the attributes of the Datastore managed
+// by its arguments, followed by the content of the template argument
+func generateDatastoreTemplate(d *schema.ResourceData) (*onetemplate.Template, error) {
+	dstpl := &onetemplate.Template{}
+
+	if dsmad, ok := d.GetOk("ds_mad"); ok {
+		dstpl.AddPair("DS_MAD", dsmad.(string))
+	}
+	dstpl.AddPair("TM_MAD", d.Get("tm_mad").(string))
+	if limit, ok := d.GetOk("limit_mb"); ok {
+		dstpl.AddPair("LIMIT_MB", limit.(int))
+	}
+	if tpl, ok := d.GetOk("template"); ok {
+		usertpl, err := onetemplate.Decode(tpl.(string))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid template of Datastore: %s", err)
+		}
+		dstpl.Append(usertpl)
+	}
+
+	return dstpl, nil
+}
+
+func resourceOpennebulaDatastoreCreate(d *schema.ResourceData, meta interface{}) error {
+	controller := zoneController(d, meta)
+
+	dstpl, err := generateDatastoreTemplate(d)
+	if err != nil {
+		return err
+	}
+	dstpl.AddPair("NAME", d.Get("name").(string))
+	dstpl.AddPair("TYPE", strings.ToUpper(d.Get("type").(string))+"_DS")
+
+	// The Datastore is created in the first cluster, the others are added later
+	clusters := d.Get("clusters").(*schema.Set).List()
+	clusterid := -1
+	if len(clusters) > 0 {
+		clusterid = clusters[0].(int)
+	}
+
+	dsID, err := controller.Datastores().Create(dstpl.String(), clusterid)
+	if err != nil {
+		return err
+	}
+	d.SetId(fmt.Sprintf("%v", dsID))
+
+	for i := 1; i < len(clusters); i++ {
+		err = controller.Cluster(clusters[i].(int)).AddDatastore(dsID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !d.Get("enabled").(bool) {
+		err = controller.Datastore(dsID).Enable(false)
+		if err != nil {
+			return err
+		}
+	}
+
+	return resourceOpennebulaDatastoreRead(d, meta)
+}
+
+func resourceOpennebulaDatastoreRead(d *schema.ResourceData, meta interface{}) error {
+	dc, err := getDatastoreController(d, meta)
+	if err != nil {
+		return err
+	}
+
+	ds, err := dc.Info()
+	if err != nil {
+		return err
+	}
+
+	d.SetId(fmt.Sprintf("%v", ds.ID))
+	readZoneID(d)
+	d.Set("name", ds.Name)
+	if ds.TypeRaw >= 0 && ds.TypeRaw < len(datastoretypes) {
+		d.Set("type", datastoretypes[ds.TypeRaw])
+	}
+	// System datastores report the DS_MAD of their own, ignore it if not managed
+	if _, ok := d.GetOk("ds_mad"); ok || ds.TypeRaw != 1 {
+		d.Set("ds_mad", ds.DSMad)
+	}
+	d.Set("tm_mad", ds.TMMad)
+	// A Datastore in state 0 is ready, 1 is disabled
+	d.Set("enabled", ds.StateRaw == 0)
+	d.Set("total_mb", ds.TotalMB)
+	d.Set("free_mb", ds.FreeMB)
+	d.Set("used_mb", ds.UsedMB)
+
+	tpl, err := getObjectTemplate(zoneController(d, meta), "one.datastore.info", ds.ID)
+	if err != nil {
+		return err
+	}
+	// An empty LIMIT_MB removes the limit
+	limit, _ := tpl.Get("LIMIT_MB")
+	limitmb, _ := strconv.Atoi(limit)
+	d.Set("limit_mb", limitmb)
+	d.Set("template", managedTemplate(tpl, d.Get("template").(string)))
+
+	err = d.Set("clusters", ds.ClustersID)
+	if err != nil {
+		log.Printf("[DEBUG] Error setting clusters on datastore: %s", err)
+	}
+
+	return nil
+}
+
+func resourceOpennebulaDatastoreExists(d *schema.ResourceData, meta interface{}) (bool, error) {
+	err := resourceOpennebulaDatastoreRead(d, meta)
+	if err != nil || d.Id() == "" {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func resourceOpennebulaDatastoreUpdate(d *schema.ResourceData, meta interface{}) error {
+	controller := zoneController(d, meta)
+
+	dc, err := getDatastoreController(d, meta)
+	if err != nil {
+		return err
+	}
+
+	if d.HasChange("name") {
+		err = dc.Rename(d.Get("name").(string))
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated name for Datastore %s\n", d.Get("name"))
+	}
+
+	if d.HasChange("ds_mad") || d.HasChange("tm_mad") || d.HasChange("limit_mb") || d.HasChange("template") {
+		dstpl, err := generateDatastoreTemplate(d)
+		if err != nil {
+			return err
+		}
+		current, err := getObjectTemplate(controller, "one.datastore.info", dc.ID)
+		if err != nil {
+			return err
+		}
+		if _, ok := d.GetOk("limit_mb"); !ok {
+			current.Del("LIMIT_MB")
+		}
+
+		// Replace the template to remove the attributes dropped from the
+		// configuration, keeping the ones set by OpenNebula
+		otpl, _ := d.GetChange("template")
+		err = dc.Update(replaceManagedTemplate(current, otpl.(string), dstpl).String(), 0)
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated template for Datastore %s\n", d.Get("name"))
+	}
+
+	if d.HasChange("clusters") {
+		oclusters, nclusters := d.GetChange("clusters")
+		addcluster, delcluster := getAddDelIntList(nclusters.(*schema.Set).List(), oclusters.(*schema.Set).List())
+
+		// Add new clusters first, the Datastore is moved to the default
+		// cluster when removed from its last one
+		for _, c := range addcluster {
+			err = controller.Cluster(c).AddDatastore(dc.ID)
+			if err != nil {
+				return err
+			}
+		}
+		for _, c := range delcluster {
+			err = controller.Cluster(c).DelDatastore(dc.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if d.HasChange("enabled") {
+		err = dc.Enable(d.Get("enabled").(bool))
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated status for Datastore %s\n", d.Get("name"))
+	}
+
+	return resourceOpennebulaDatastoreRead(d, meta)
+}
+
+func resourceOpennebulaDatastoreDelete(d *schema.ResourceData, meta interface{}) error {
+	dc, err := getDatastoreController(d, meta)
+	if err != nil {
+		return err
+	}
+
+	err = dc.Delete()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Successfully deleted Datastore ID %s\n", d.Id())
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/terraform"
+	"strconv"
+	"testing"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+)
+
+func TestAccDatastore(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckDatastoreDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccDatastoreConfigBasic,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_datastore.datastore", "name", "terradatastore"),
+					resource.TestCheckResourceAttr("opennebula_datastore.datastore", "type", "image"),
+					resource.TestCheckResourceAttr("opennebula_datastore.datastore", "ds_mad", "dummy"),
+					resource.TestCheckResourceAttr("opennebula_datastore.datastore", "tm_mad", "dummy"),
+					resource.TestCheckResourceAttr("opennebula_datastore.datastore", "clusters.#", "1"),
+					resource.TestCheckResourceAttr("opennebula_datastore.datastore", "enabled", "true"),
+					resource.TestCheckResourceAttrSet("opennebula_datastore.datastore", "total_mb"),
+					resource.TestCheckResourceAttr("opennebula_datastore.datastore", "limit_mb", "0"),
+					resource.TestCheckResourceAttr("opennebula_datastore.datastore", "template", "RESTRICTED_DIRS = \"/\"\nSAFE_DIRS = \"/var/tmp\""),
+				),
+			},
+			{
+				Config: testAccDatastoreConfigUpdate,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_datastore.datastore", "name", "terradatastore-renamed"),
+					resource.TestCheckResourceAttr("opennebula_datastore.datastore", "limit_mb", "1024"),
+					resource.TestCheckResourceAttr("opennebula_datastore.datastore", "enabled", "false"),
+					resource.TestCheckResourceAttr("opennebula_datastore.datastore", "template", "RESTRICTED_DIRS = \"/\""),
+					testAccCheckDatastoreTemplate("opennebula_datastore.datastore", "SAFE_DIRS", ""),
+					testAccCheckDatastoreTemplate("opennebula_datastore.datastore", "DS_MAD", "dummy"),
+				),
+			},
+		},
+	})
+}
+
+// testAccCheckDatastoreTemplate checks the value of an attribute of the
+// datastore template, an empty value means the attribute is not set
+func testAccCheckDatastoreTemplate(name, key, expected string) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		rs, ok := s.RootModule().Resources[name]
+		if !ok {
+			return fmt.Errorf("Resource %s not found", name)
+		}
+		dsID, _ := strconv.Atoi(rs.Primary.ID)
+
+		controller := testAccProvider.Meta().(*goca.Controller)
+		tpl, err := getObjectTemplate(controller, "one.datastore.info", dsID)
+		if err != nil {
+			return err
+		}
+		if value, _ := tpl.Get(key); value != expected {
+			return fmt.Errorf("Expected %s of datastore %s to be %q, got %q", key, rs.Primary.ID, expected, value)
+		}
+
+		return nil
+	}
+}
+
+func testAccCheckDatastoreDestroy(s *terraform.State) error {
+	controller := testAccProvider.Meta().(*goca.Controller)
+
+	for _, rs := range s.RootModule().Resources {
+		if rs.Type != "opennebula_datastore" {
+			continue
+		}
+		dsID, _ := strconv.ParseUint(rs.Primary.ID, 10, 64)
+		dc := controller.Datastore(int(dsID))
+		// Get Datastore Info
+		ds, _ := dc.Info()
+		if ds != nil {
+			return fmt.Errorf("Expected datastore %s to have been destroyed", rs.Primary.ID)
+		}
+	}
+
+	return nil
+}
+
+var testAccDatastoreConfigBasic = `
+resource "opennebula_datastore" "datastore" {
+  name = "terradatastore"
+  type = "image"
+  ds_mad = "dummy"
+  tm_mad = "dummy"
+  template = <<EOF
+    RESTRICTED_DIRS = "/"
+    SAFE_DIRS = "/var/tmp"
+    EOF
+}
+`
+
+var testAccDatastoreConfigUpdate = `
+resource "opennebula_datastore" "datastore" {
+  name = "terradatastore-renamed"
+  type = "image"
+  ds_mad = "dummy"
+  tm_mad = "dummy"
+  limit_mb = 1024
+  enabled = false
+  template = "<TEMPLATE><RESTRICTED_DIRS>/</RESTRICTED_DIRS></TEMPLATE>"
+}
+`
@@ -3,7 +3,9 @@ package opennebula
 import (
 	"fmt"
 	"github.com/hashicorp/terraform/helper/schema"
+	"log"
 	"strconv"
+	"strings"
 
 	"github.com/OpenNebula/one/src/oca/go/src/goca"
 )
@@ -14,6 +16,9 @@ func resourceOpennebulaGroup() *schema.Resource {
 		Read:   resourceOpennebulaGroupRead,
 		Update: resourceOpennebulaGroupUpdate,
 		Delete: resourceOpennebulaGroupDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
 
 		Schema: map[string]*schema.Schema{
 			"name": {
@@ -22,9 +27,10 @@ func resourceOpennebulaGroup() *schema.Resource {
 				Description: "Name of the Group",
 			},
 			"template": {
-				Type:        schema.TypeString,
-				Required:    true,
-				Description: "Group template content, in OpenNebula XML or String format",
+				Type:             schema.TypeString,
+				Required:         true,
+				DiffSuppressFunc: templateDiffSuppress,
+				Description:      "Group template content, in OpenNebula XML or String format",
 			},
 			"delete_on_destruction": {
 				Type:        schema.TypeBool,
@@ -33,12 +39,13 @@ func resourceOpennebulaGroup() *schema.Resource {
 				Description: "Flag to delete group on destruction, by default it is set to false",
 			},
 			"admins": {
-				Type:        schema.TypeList,
+				Type:        schema.TypeSet,
 				Optional:    true,
 				Description: "List of Admin user IDs part of the group",
 				Elem: &schema.Schema{
 					Type: schema.TypeInt,
 				},
+				Set: schema.HashInt,
 			},
 			"quotas": {
 				Type:        schema.TypeSet,
@@ -218,7 +225,7 @@ func resourceOpennebulaGroupCreate(d *schema.ResourceData, meta interface{}) err
 
 	// add admins if list provided
 	if adminids, ok := d.GetOk("admins"); ok {
-		adminlist := adminids.([]interface{})
+		adminlist := adminids.(*schema.Set).List()
 		for i := 0; i < len(adminlist); i++ {
 			err = gc.AddAdmin(adminlist[i].(int))
 			if err != nil {
@@ -227,7 +234,7 @@ func resourceOpennebulaGroupCreate(d *schema.ResourceData, meta interface{}) err
 		}
 	}
 
-	if d.Get("quotas") != nil {
+	if _, ok := d.GetOk("quotas"); ok {
 		err = gc.Quota(generateGroupQuotas(d))
 		if err != nil {
 			return err
@@ -250,7 +257,33 @@ func resourceOpennebulaGroupRead(d *schema.ResourceData, meta interface{}) error
 
 	d.SetId(strconv.FormatUint(uint64(group.ID), 10))
 	d.Set("name", group.Name)
-	d.Set("template", group.Template)
+
+	tpl, err := getObjectTemplate(meta.(*goca.Controller), "one.group.info", group.ID)
+	if err != nil {
+		return err
+	}
+	d.Set("template", tpl.String())
+
+	err = d.Set("admins", group.AdminsID)
+	if err != nil {
+		log.Printf("[DEBUG] Error setting admins on group: %s", err)
+	}
+
+	// Quotas left to their default values are not part of the configuration
+	quotas := flattenQuotas(group.QuotasList, false)
+	groupquotas := make([]map[string]interface{}, 0, 1)
+	if len(quotas["datastore"])+len(quotas["network"])+len(quotas["image"])+len(quotas["vm"]) > 0 {
+		groupquotas = append(groupquotas, map[string]interface{}{
+			"datastore": quotas["datastore"],
+			"network":   quotas["network"],
+			"image":     quotas["image"],
+			"vm":        quotas["vm"],
+		})
+	}
+	err = d.Set("quotas", groupquotas)
+	if err != nil {
+		log.Printf("[WARN] Error setting quotas for Group %d, error: %s", group.ID, err)
+	}
 
 	return nil
 }
@@ -269,11 +302,39 @@ func resourceOpennebulaGroupUpdate(d *schema.ResourceData, meta interface{}) err
 		}
 	}
 
+	if d.HasChange("admins") {
+		oadmins, nadmins := d.GetChange("admins")
+
+		addadmins, deladmins := getAddDelIntList(nadmins.(*schema.Set).List(), oadmins.(*schema.Set).List())
+
+		for _, a := range addadmins {
+			err = gc.AddAdmin(a)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, a := range deladmins {
+			err = gc.DelAdmin(a)
+			if err != nil {
+				return err
+			}
+		}
+		log.Printf("[INFO] Successfully updated admins for Group %s\n", d.Get("name"))
+	}
+
 	if d.HasChange("quotas") {
-		err = gc.Quota(generateGroupQuotas(d))
-		if err != nil {
-			return err
+		// Quotas removed from the configuration get back to the default limits
+		oquotas, _ := d.GetChange("quotas")
+		quotastpl := generateQuotas(defaultQuotas(groupQuotasMap(oquotas))) + "\n" + generateGroupQuotas(d)
+
+		if strings.TrimSpace(quotastpl) != "" {
+			err = gc.Quota(quotastpl)
+			if err != nil {
+				return err
+			}
 		}
+		log.Printf("[INFO] Successfully updated quotas for Group %s\n", d.Get("name"))
 	}
 
 	return resourceOpennebulaGroupRead(d, meta)
@@ -295,49 +356,22 @@ func resourceOpennebulaGroupDelete(d *schema.ResourceData, meta interface{}) err
 	return nil
 }
 
-func generateGroupQuotas(d *schema.ResourceData) string {
-	quotas := d.Get("quotas").(*schema.Set).List()
-
-	quotasMap := quotas[0].(map[string]interface{})
-	datastore := quotasMap["datastore"].([]interface{})
-	network := quotasMap["network"].([]interface{})
-	image := quotasMap["image"].([]interface{})
-	vm := quotasMap["vm"].(*schema.Set).List()
-	quotastr := ""
-
-	for i := 0; i < len(datastore); i++ {
-		datastoreMap := datastore[i].(map[string]interface{})
-		quotastr = fmt.Sprintf("%s\n%s", quotastr, fmt.Sprintf("DATASTORE = [\n  ID = %d,\n  IMAGE = %d,\n  SIZE = %d\n]",
-			datastoreMap["datastore_id"].(int),
-			datastoreMap["images"].(int),
-			datastoreMap["size"].(int)))
-	}
-
-	for i := 0; i < len(network); i++ {
-		networkMap := network[i].(map[string]interface{})
-		quotastr = fmt.Sprintf("%s\n%s", quotastr, fmt.Sprintf("NETWORK = [\n  ID = %d,\n  LEASES = %d\n]",
-			networkMap["network_id"].(int),
-			networkMap["leases"].(int)))
-	}
-
-	for i := 0; i < len(image); i++ {
-		imageMap := image[i].(map[string]interface{})
-		quotastr = fmt.Sprintf("%s\n%s", quotastr, fmt.Sprintf("IMAGE = [\n  ID = %d,\n  RVMS = %d\n]",
-			imageMap["image_id"].(int),
-			imageMap["running_vms"].(int)))
+// groupQuotasMap returns the content of the quotas argument, with empty lists
+// when it is not set
+func groupQuotasMap(quotas interface{}) map[string]interface{} {
+	list := quotas.(*schema.Set).List()
+	if len(list) == 0 {
+		return map[string]interface{}{
+			"datastore": []interface{}{},
+			"network":   []interface{}{},
+			"image":     []interface{}{},
+			"vm":        []interface{}{},
+		}
 	}
 
-	if len(vm) > 0 {
-		vmMap := vm[0].(map[string]interface{})
-		quotastr = fmt.Sprintf("%s\n%s", quotastr, fmt.Sprintf("VM = [\n  CPU = %d,\n  MEMORY = %d,\n  RUNNING_CPU = %d,\n  RUNNING_MEMORY = %d,\n  RUNNING_VMS = %d,\n  SYSTEM_DISK_SIZE = %d,\n  VMS = %d\n]",
-			vmMap["cpu"].(int),
-			vmMap["memory"].(int),
-			vmMap["running_cpu"].(int),
-			vmMap["running_memory"].(int),
-			vmMap["running_vms"].(int),
-			vmMap["system_disk_size"].(int),
-			vmMap["vms"].(int)))
-	}
+	return list[0].(map[string]interface{})
+}
 
-	return quotastr
+func generateGroupQuotas(d *schema.ResourceData) string {
+	return generateQuotas(groupQuotasMap(d.Get("quotas")))
 }
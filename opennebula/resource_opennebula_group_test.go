@@ -21,8 +21,15 @@ func TestAccGroup(t *testing.T) {
 				Check: resource.ComposeTestCheckFunc(
 					resource.TestCheckResourceAttr("opennebula_group.group", "name", "iamgroup"),
 					resource.TestCheckResourceAttr("opennebula_group.group", "delete_on_destruction", "false"),
+					resource.TestCheckResourceAttr("opennebula_group.group", "quotas.#", "1"),
 				),
 			},
+			{
+				ResourceName:            "opennebula_group.group",
+				ImportState:             true,
+				ImportStateVerify:       true,
+				ImportStateVerifyIgnore: []string{"delete_on_destruction"},
+			},
 			{
 				Config: testAccGroupConfigUpdate,
 				Check: resource.ComposeTestCheckFunc(
@@ -73,6 +80,9 @@ resource "opennebula_group" "group" {
             images = 2
             size = 50
         }
+        network {
+            network_id = 0
+        }
         vm {
             cpu = 4
             memory = 8192
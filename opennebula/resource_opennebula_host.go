@@ -0,0 +1,343 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/helper/schema"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/OpenNebula/addon-terraform/opennebula/onetemplate"
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/host"
+)
+
+var defaultHostTimeout = 5 * time.Minute
+
+// Values of the host status action, in the order expected by OpenNebula
+var hoststatuses = []string{"enabled", "disabled", "offline"}
+
+func resourceOpennebulaHost() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceOpennebulaHostCreate,
+		Read:   resourceOpennebulaHostRead,
+		Exists: resourceOpennebulaHostExists,
+		Update: resourceOpennebulaHostUpdate,
+		Delete: resourceOpennebulaHostDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(defaultHostTimeout),
+			Update: schema.DefaultTimeout(defaultHostTimeout),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Hostname of the Host",
+			},
+			"zone_id": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				ForceNew:    true,
+				Default:     -1,
+				Description: "ID of the Zone of the Host. If not set, it uses the zone of the provider",
+			},
+			"im_mad": {
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "Information Manager driver of the Host: kvm, lxd, vcenter, firecracker, dummy...",
+			},
+			"vm_mad": {
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "Virtualization driver of the Host: kvm, lxd, vcenter, firecracker, dummy...",
+			},
+			"cluster_id": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Computed:    true,
+				Description: "ID of the Cluster of the Host. If empty, it uses the default cluster",
+			},
+			"status": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "enabled",
+				Description: "Status of the Host: enabled, disabled, offline. Default is 'enabled'",
+				ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+					value := v.(string)
+
+					if inArray(value, hoststatuses) < 0 {
+						errors = append(errors, fmt.Errorf("Status %q must be one of: %s", k, strings.Join(hoststatuses, ",")))
+					}
+
+					return
+				},
+			},
+			"template": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				DiffSuppressFunc: templateDiffSuppress,
+				Description:      "Host template content, in OpenNebula XML or String format. Only these attributes are read back, the monitoring sets the others",
+			},
+			"state": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Current state of the Host",
+			},
+		},
+	}
+}
+
+func getHostController(d *schema.ResourceData, meta interface{}) (*goca.HostController, error) {
+	controller := zoneController(d, meta)
+	var hc *goca.HostController
+
+	// Try to find the Host by ID, if specified
+	if d.Id() != "" {
+		hostid, err := strconv.ParseUint(d.Id(), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Host Id (%s) is not an integer", d.Id())
+		}
+		hc = controller.Host(int(hostid))
+	}
+
+	// Otherwise, try to find the Host by name as the de facto compound primary key
+	if d.Id() == "" {
+		hostid, err := controller.Hosts().ByName(d.Get("name").(string))
+		if err != nil {
+			d.SetId("")
+			return nil, fmt.Errorf("Could not find Host with name %s", d.Get("name").(string))
+		}
+		hc = controller.Host(hostid)
+	}
+
+	return hc, nil
+}
+
+func resourceOpennebulaHostCreate(d *schema.ResourceData, meta interface{}) error {
+	controller := zoneController(d, meta)
+
+	clusterid := -1
+	if cid, ok := d.GetOk("cluster_id"); ok {
+		clusterid = cid.(int)
+	}
+
+	hostID, err := controller.Hosts().Create(d.Get("name").(string),
+		d.Get("im_mad").(string),
+		d.Get("vm_mad").(string),
+		clusterid)
+	if err != nil {
+		return err
+	}
+	d.SetId(fmt.Sprintf("%v", hostID))
+
+	hc := controller.Host(hostID)
+
+	// add template description
+	if d.Get("template") != "" {
+		// Merge the template with the attributes set by the monitoring
+		err = hc.Update(d.Get("template").(string), 1)
+		if err != nil {
+			return err
+		}
+	}
+
+	status := d.Get("status").(string)
+	if status != "enabled" {
+		err = hc.Status(inArray(status, hoststatuses))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = waitForHostState(d, meta, status, d.Timeout(schema.TimeoutCreate))
+	if err != nil {
+		return fmt.Errorf("Error waiting for Host (%s) to be in state %s: %s", d.Id(), status, err)
+	}
+
+	return resourceOpennebulaHostRead(d, meta)
+}
+
+// hostStatus returns the status of the Host, as set by the status action
+func hostStatus(state string) string {
+	switch state {
+	case "DISABLED", "MONITORING_DISABLED":
+		return "disabled"
+	case "OFFLINE":
+		return "offline"
+	}
+	return "enabled"
+}
+
+func resourceOpennebulaHostRead(d *schema.ResourceData, meta interface{}) error {
+	hc, err := getHostController(d, meta)
+	if err != nil {
+		return err
+	}
+
+	host, err := hc.Info()
+	if err != nil {
+		return err
+	}
+
+	state, err := host.StateString()
+	if err != nil {
+		return err
+	}
+
+	d.SetId(fmt.Sprintf("%v", host.ID))
+	readZoneID(d)
+	d.Set("name", host.Name)
+	d.Set("im_mad", host.IMMAD)
+	d.Set("vm_mad", host.VMMAD)
+	d.Set("cluster_id", host.ClusterID)
+	d.Set("status", hostStatus(state))
+	d.Set("state", state)
+
+	tpl, err := getObjectTemplate(zoneController(d, meta), "one.host.info", host.ID)
+	if err != nil {
+		return err
+	}
+	d.Set("template", managedTemplate(tpl, d.Get("template").(string)))
+
+	return nil
+}
+
+func resourceOpennebulaHostExists(d *schema.ResourceData, meta interface{}) (bool, error) {
+	err := resourceOpennebulaHostRead(d, meta)
+	if err != nil || d.Id() == "" {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func resourceOpennebulaHostUpdate(d *schema.ResourceData, meta interface{}) error {
+	controller := zoneController(d, meta)
+
+	hc, err := getHostController(d, meta)
+	if err != nil {
+		return err
+	}
+
+	if d.HasChange("name") {
+		err = hc.Rename(d.Get("name").(string))
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated name for Host %s\n", d.Get("name"))
+	}
+
+	if d.HasChange("template") {
+		hosttpl, err := onetemplate.Decode(d.Get("template").(string))
+		if err != nil {
+			return fmt.Errorf("Invalid template of Host: %s", err)
+		}
+		current, err := getObjectTemplate(controller, "one.host.info", hc.ID)
+		if err != nil {
+			return err
+		}
+
+		// Replace the template to remove the attributes dropped from the
+		// configuration, keeping the ones set by the monitoring
+		otpl, _ := d.GetChange("template")
+		err = hc.Update(replaceManagedTemplate(current, otpl.(string), hosttpl).String(), 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	if d.HasChange("cluster_id") {
+		err = controller.Cluster(d.Get("cluster_id").(int)).AddHost(hc.ID)
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully moved Host %s to Cluster %d\n", d.Get("name"), d.Get("cluster_id"))
+	}
+
+	if d.HasChange("status") {
+		status := d.Get("status").(string)
+		err = hc.Status(inArray(status, hoststatuses))
+		if err != nil {
+			return err
+		}
+
+		_, err = waitForHostState(d, meta, status, d.Timeout(schema.TimeoutUpdate))
+		if err != nil {
+			return fmt.Errorf("Error waiting for Host (%s) to be in state %s: %s", d.Id(), status, err)
+		}
+		log.Printf("[INFO] Successfully updated status for Host %s\n", d.Get("name"))
+	}
+
+	return resourceOpennebulaHostRead(d, meta)
+}
+
+func resourceOpennebulaHostDelete(d *schema.ResourceData, meta interface{}) error {
+	hc, err := getHostController(d, meta)
+	if err != nil {
+		return err
+	}
+
+	err = hc.Delete()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Successfully deleted Host ID %s\n", d.Id())
+
+	return nil
+}
+
+func waitForHostState(d *schema.ResourceData, meta interface{}, status string, timeout time.Duration) (interface{}, error) {
+	var host *host.Host
+
+	hc, err := getHostController(d, meta)
+	if err != nil {
+		return host, err
+	}
+
+	// The Host may go through the other statuses while it is being monitored
+	pending := []string{"anythingelse"}
+	for _, s := range hoststatuses {
+		if s != status {
+			pending = append(pending, s)
+		}
+	}
+
+	stateConf := &resource.StateChangeConf{
+		Pending: pending,
+		Target:  []string{status},
+		Refresh: func() (interface{}, string, error) {
+			log.Println("Refreshing Host state...")
+			host, err = hc.Info()
+			if err != nil {
+				return host, "", err
+			}
+			state, err := host.StateString()
+			if err != nil {
+				return host, "", err
+			}
+			log.Printf("Host %v is currently in state %v", host.ID, state)
+			switch state {
+			case "MONITORED", "DISABLED", "OFFLINE":
+				return host, hostStatus(state), nil
+			case "ERROR":
+				return host, "error", fmt.Errorf("Host ID %v entered error state.", d.Id())
+			default:
+				return host, "anythingelse", nil
+			}
+		},
+		Timeout:    timeout,
+		Delay:      5 * time.Second,
+		MinTimeout: 3 * time.Second,
+	}
+
+	return stateConf.WaitForState()
+}
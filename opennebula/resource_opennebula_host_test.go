@@ -0,0 +1,107 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/terraform"
+	"strconv"
+	"testing"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+)
+
+func TestAccHost(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckHostDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccHostConfigBasic,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_host.host", "name", "terrahost"),
+					resource.TestCheckResourceAttr("opennebula_host.host", "im_mad", "dummy"),
+					resource.TestCheckResourceAttr("opennebula_host.host", "vm_mad", "dummy"),
+					resource.TestCheckResourceAttr("opennebula_host.host", "cluster_id", "0"),
+					resource.TestCheckResourceAttr("opennebula_host.host", "status", "enabled"),
+					resource.TestCheckResourceAttr("opennebula_host.host", "state", "MONITORED"),
+					resource.TestCheckResourceAttr("opennebula_host.host", "template", "RESERVED_CPU = \"100\"\nRESERVED_MEM = \"1048576\""),
+				),
+			},
+			{
+				Config: testAccHostConfigUpdate,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_host.host", "name", "terrahost"),
+					resource.TestCheckResourceAttr("opennebula_host.host", "status", "disabled"),
+					resource.TestCheckResourceAttr("opennebula_host.host", "state", "DISABLED"),
+					resource.TestCheckResourceAttr("opennebula_host.host", "template", "RESERVED_CPU = \"200\""),
+					testAccCheckHostTemplate("opennebula_host.host", "RESERVED_MEM", ""),
+				),
+			},
+		},
+	})
+}
+
+func testAccCheckHostTemplate(name, key, expected string) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		rs, ok := s.RootModule().Resources[name]
+		if !ok {
+			return fmt.Errorf("Resource %s not found", name)
+		}
+		hostID, _ := strconv.Atoi(rs.Primary.ID)
+
+		controller := testAccProvider.Meta().(*goca.Controller)
+		tpl, err := getObjectTemplate(controller, "one.host.info", hostID)
+		if err != nil {
+			return err
+		}
+		if value, _ := tpl.Get(key); value != expected {
+			return fmt.Errorf("Expected %s of host %s to be %q, got %q", key, rs.Primary.ID, expected, value)
+		}
+
+		return nil
+	}
+}
+
+func testAccCheckHostDestroy(s *terraform.State) error {
+	controller := testAccProvider.Meta().(*goca.Controller)
+
+	for _, rs := range s.RootModule().Resources {
+		if rs.Type != "opennebula_host" {
+			continue
+		}
+		hostID, _ := strconv.ParseUint(rs.Primary.ID, 10, 64)
+		hc := controller.Host(int(hostID))
+		// Get Host Info
+		host, _ := hc.Info()
+		if host != nil {
+			return fmt.Errorf("Expected host %s to have been destroyed", rs.Primary.ID)
+		}
+	}
+
+	return nil
+}
+
+var testAccHostConfigBasic = `
+resource "opennebula_host" "host" {
+  name = "terrahost"
+  im_mad = "dummy"
+  vm_mad = "dummy"
+  template = <<EOF
+    RESERVED_CPU = "100"
+    RESERVED_MEM = "1048576"
+    EOF
+}
+`
+
+var testAccHostConfigUpdate = `
+resource "opennebula_host" "host" {
+  name = "terrahost"
+  im_mad = "dummy"
+  vm_mad = "dummy"
+  status = "disabled"
+  template = <<EOF
+    RESERVED_CPU = "200"
+    EOF
+}
+`
@@ -11,6 +11,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/OpenNebula/addon-terraform/opennebula/onetemplate"
 	"github.com/OpenNebula/one/src/oca/go/src/goca"
 	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/image"
 	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/shared"
@@ -24,6 +25,8 @@ type ImageTemplate struct {
 	Description string `xml:"DESCRIPTION,omitempty"`
 }
 
+var defaultImageTimeout = 10 * time.Minute
+
 var imagetypes = []string{"OS", "CDROM", "DATABLOCK", "KERNEL", "RAMDISK", "CONTEXT"}
 var locktypes = []string{"USE", "MANAGE", "ADMIN", "ALL", "UNLOCK"}
 
@@ -37,6 +40,11 @@ func resourceOpennebulaImage() *schema.Resource {
 		Importer: &schema.ResourceImporter{
 			State: schema.ImportStatePassthrough,
 		},
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(defaultImageTimeout),
+			Update: schema.DefaultTimeout(defaultImageTimeout),
+			Delete: schema.DefaultTimeout(defaultImageTimeout),
+		},
 
 		Schema: map[string]*schema.Schema{
 			"name": {
@@ -44,6 +52,13 @@ func resourceOpennebulaImage() *schema.Resource {
 				Required:    true,
 				Description: "Name of the Image",
 			},
+			"zone_id": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				ForceNew:    true,
+				Default:     -1,
+				Description: "ID of the Zone of the Image. If not set, it uses the zone of the provider",
+			},
 			"description": {
 				Type:        schema.TypeString,
 				Optional:    true,
@@ -100,7 +115,14 @@ func resourceOpennebulaImage() *schema.Resource {
 				Optional:      true,
 				ForceNew:      true,
 				Description:   "ID or name of the Image to be cloned from",
-				ConflictsWith: []string{"path", "size", "type"},
+				ConflictsWith: []string{"path", "size", "type", "marketplace_app_id"},
+			},
+			"marketplace_app_id": {
+				Type:          schema.TypeInt,
+				Optional:      true,
+				ForceNew:      true,
+				Description:   "ID of the Marketplace App to be imported",
+				ConflictsWith: []string{"path", "size", "type", "clone_from_image"},
 			},
 			"datastore_id": {
 				Type:        schema.TypeInt,
@@ -135,13 +157,13 @@ func resourceOpennebulaImage() *schema.Resource {
 				Computed:      true,
 				ForceNew:      true,
 				Description:   "Path to the new image (local path on the OpenNebula server or URL)",
-				ConflictsWith: []string{"clone_from_image"},
+				ConflictsWith: []string{"clone_from_image", "marketplace_app_id"},
 			},
 			"type": {
 				Type:          schema.TypeString,
 				Optional:      true,
 				Computed:      true,
-				ConflictsWith: []string{"clone_from_image"},
+				ConflictsWith: []string{"clone_from_image", "marketplace_app_id"},
 				Description:   "Type of the new Image: OS, CDROM, DATABLOCK, KERNEL, RAMDISK, CONTEXT",
 				ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
 					value := v.(string)
@@ -158,7 +180,7 @@ func resourceOpennebulaImage() *schema.Resource {
 				Optional:      true,
 				Computed:      true,
 				ForceNew:      true,
-				ConflictsWith: []string{"clone_from_image"},
+				ConflictsWith: []string{"clone_from_image", "marketplace_app_id"},
 				Description:   "Size of the new image in MB",
 			},
 			"dev_prefix": {
@@ -200,7 +222,7 @@ func resourceOpennebulaImage() *schema.Resource {
 // * args: Viable arguments to manage ImagePool variable arguments
 //   see http://docs.opennebula.org/5.8/integration/system_interfaces/api.html#one-imagepool-info for details
 func getImageController(d *schema.ResourceData, meta interface{}, args ...int) (*goca.ImageController, error) {
-	controller := meta.(*goca.Controller)
+	controller := zoneController(d, meta)
 	var ic *goca.ImageController
 
 	// Try to find the Image by ID, if specified
@@ -227,7 +249,7 @@ func getImageController(d *schema.ResourceData, meta interface{}, args ...int) (
 
 // changeImageGroup: function to change Image Group ownership
 func changeImageGroup(d *schema.ResourceData, meta interface{}) error {
-	controller := meta.(*goca.Controller)
+	controller := zoneController(d, meta)
 	var gid int
 
 	ic, err := getImageController(d, meta)
@@ -251,7 +273,7 @@ func changeImageGroup(d *schema.ResourceData, meta interface{}) error {
 }
 
 func resourceOpennebulaImageCreate(d *schema.ResourceData, meta interface{}) error {
-	controller := meta.(*goca.Controller)
+	controller := zoneController(d, meta)
 	var imageID int
 	var err error
 
@@ -261,6 +283,11 @@ func resourceOpennebulaImageCreate(d *schema.ResourceData, meta interface{}) err
 		if err != nil {
 			return err
 		}
+	} else if _, ok := d.GetOk("marketplace_app_id"); ok { // Or import it from a Marketplace App
+		imageID, err = resourceOpennebulaImageFromApp(d, meta)
+		if err != nil {
+			return err
+		}
 	} else { //Otherwise allocate a new image
 		var err error
 
@@ -287,7 +314,7 @@ func resourceOpennebulaImageCreate(d *schema.ResourceData, meta interface{}) err
 
 	d.SetId(fmt.Sprintf("%v", imageID))
 
-	_, err = waitForImageState(d, meta, "ready")
+	_, err = waitForImageState(d, meta, "ready", d.Timeout(schema.TimeoutCreate))
 	if err != nil {
 		return fmt.Errorf("Error waiting for Image (%s) to be in state READY: %s", d.Id(), err)
 	}
@@ -339,7 +366,7 @@ func resourceOpennebulaImageCreate(d *schema.ResourceData, meta interface{}) err
 }
 
 func resourceOpennebulaImageClone(d *schema.ResourceData, meta interface{}) (int, error) {
-	controller := meta.(*goca.Controller)
+	controller := zoneController(d, meta)
 	var originalic *goca.ImageController
 
 	//Test if clone_from_image is an integer or not
@@ -357,7 +384,33 @@ func resourceOpennebulaImageClone(d *schema.ResourceData, meta interface{}) (int
 	return originalic.Clone(d.Get("name").(string), d.Get("datastore_id").(int))
 }
 
-func waitForImageState(d *schema.ResourceData, meta interface{}, state string) (interface{}, error) {
+func resourceOpennebulaImageFromApp(d *schema.ResourceData, meta interface{}) (int, error) {
+	controller := zoneController(d, meta)
+
+	app, err := controller.MarketPlaceApp(d.Get("marketplace_app_id").(int)).Info()
+	if err != nil {
+		return 0, fmt.Errorf("Unable to find Marketplace App %d: %s", d.Get("marketplace_app_id"), err)
+	}
+
+	apptpl, err := decodeMarketPlaceAppTemplate(app)
+	if err != nil {
+		return 0, err
+	}
+	imagetpl, err := onetemplate.Parse(apptpl)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid template of Marketplace App %d: %s", app.ID, err)
+	}
+
+	// OpenNebula copies the Image from the Marketplace when FROM_APP is set
+	imagetpl.Del("NAME")
+	imagetpl.AddPair("NAME", d.Get("name").(string))
+	imagetpl.AddPair("FROM_APP", app.ID)
+	log.Printf("[INFO] Image Definition from Marketplace App: %s", imagetpl)
+
+	return controller.Images().Create(imagetpl.String(), uint(d.Get("datastore_id").(int)))
+}
+
+func waitForImageState(d *schema.ResourceData, meta interface{}, state string, timeout time.Duration) (interface{}, error) {
 	var ic *goca.ImageController
 	var image *image.Image
 	var err error
@@ -403,7 +456,7 @@ func waitForImageState(d *schema.ResourceData, meta interface{}, state string) (
 				return image, "anythingelse", nil
 			}
 		},
-		Timeout:    10 * time.Minute,
+		Timeout:    timeout,
 		Delay:      10 * time.Second,
 		MinTimeout: 3 * time.Second,
 	}
@@ -433,6 +486,7 @@ func resourceOpennebulaImageRead(d *schema.ResourceData, meta interface{}) error
 	}
 
 	d.SetId(fmt.Sprintf("%v", image.ID))
+	readZoneID(d)
 	d.Set("name", image.Name)
 	d.Set("uid", image.UID)
 	d.Set("gid", image.GID)
@@ -555,6 +609,13 @@ func resourceOpennebulaImageUpdate(d *schema.ResourceData, meta interface{}) err
 		log.Printf("[INFO] Successfully updated Image Type %s\n", image.Name)
 	}
 
+	if d.HasChange("persistent") || d.HasChange("type") {
+		_, err = waitForImageState(d, meta, "ready", d.Timeout(schema.TimeoutUpdate))
+		if err != nil {
+			return fmt.Errorf("Error waiting for Image (%s) to be in state READY: %s", d.Id(), err)
+		}
+	}
+
 	return nil
 }
 
@@ -570,7 +631,7 @@ func resourceOpennebulaImageDelete(d *schema.ResourceData, meta interface{}) err
 	}
 	log.Printf("[INFO] Successfully deleted Image ID %s\n", d.Id())
 
-	_, err = waitForImageState(d, meta, "notfound")
+	_, err = waitForImageState(d, meta, "notfound", d.Timeout(schema.TimeoutDelete))
 	if err != nil {
 		return fmt.Errorf("Error waiting for Image (%s) to be in state NOTFOUND: %s", d.Id(), err)
 	}
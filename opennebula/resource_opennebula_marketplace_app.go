@@ -0,0 +1,271 @@
+package opennebula
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/helper/schema"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/OpenNebula/addon-terraform/opennebula/onetemplate"
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/marketplaceapp"
+)
+
+var defaultMarketPlaceAppTimeout = 30 * time.Minute
+
+// Marketplace App types and states, in the order of their numerical value in OpenNebula
+var marketapptypes = []string{"UNKNOWN", "IMAGE", "VMTEMPLATE", "SERVICE_TEMPLATE"}
+var marketappstates = []string{"INIT", "READY", "LOCKED", "ERROR", "DISABLED"}
+
+func resourceOpennebulaMarketPlaceApp() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceOpennebulaMarketPlaceAppCreate,
+		Read:   resourceOpennebulaMarketPlaceAppRead,
+		Exists: resourceOpennebulaMarketPlaceAppExists,
+		Update: resourceOpennebulaMarketPlaceAppUpdate,
+		Delete: resourceOpennebulaMarketPlaceAppDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(defaultMarketPlaceAppTimeout),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Name of the Marketplace App",
+			},
+			"market_id": {
+				Type:        schema.TypeInt,
+				Required:    true,
+				ForceNew:    true,
+				Description: "ID of the Marketplace where the App is exported",
+			},
+			"origin_id": {
+				Type:        schema.TypeInt,
+				Required:    true,
+				ForceNew:    true,
+				Description: "ID of the Image or VM Template exported",
+			},
+			"type": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Default:     "IMAGE",
+				Description: "Type of the exported object: IMAGE, VMTEMPLATE. Default is 'IMAGE'",
+				ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+					value := v.(string)
+
+					if value != "IMAGE" && value != "VMTEMPLATE" {
+						errors = append(errors, fmt.Errorf("Type %q must be one of: IMAGE,VMTEMPLATE", k))
+					}
+
+					return
+				},
+			},
+			"description": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Description of the Marketplace App",
+			},
+			"version": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
+				Description: "Version of the Marketplace App",
+			},
+			"size": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Size of the Marketplace App in MB",
+			},
+			"format": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Format of the Marketplace App image",
+			},
+			"state": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "State of the Marketplace App",
+			},
+		},
+	}
+}
+
+func getMarketPlaceAppController(d *schema.ResourceData, meta interface{}) (*goca.MarketPlaceAppController, error) {
+	controller := meta.(*goca.Controller)
+
+	appid, err := strconv.ParseUint(d.Id(), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Marketplace App Id (%s) is not an integer", d.Id())
+	}
+
+	return controller.MarketPlaceApp(int(appid)), nil
+}
+
+func resourceOpennebulaMarketPlaceAppCreate(d *schema.ResourceData, meta interface{}) error {
+	controller := meta.(*goca.Controller)
+
+	apptpl := &onetemplate.Template{}
+	apptpl.AddPair("NAME", d.Get("name").(string))
+	apptpl.AddPair("ORIGIN_ID", d.Get("origin_id").(int))
+	apptpl.AddPair("TYPE", d.Get("type").(string))
+	apptpl.AddPair("DESCRIPTION", d.Get("description").(string))
+	if version, ok := d.GetOk("version"); ok {
+		apptpl.AddPair("VERSION", version.(string))
+	}
+
+	appID, err := controller.MarketPlaceApps().Create(apptpl.String(), d.Get("market_id").(int))
+	if err != nil {
+		return err
+	}
+	d.SetId(fmt.Sprintf("%v", appID))
+
+	_, err = waitForMarketPlaceAppState(d, meta, "READY", d.Timeout(schema.TimeoutCreate))
+	if err != nil {
+		return fmt.Errorf("Error waiting for Marketplace App (%s) to be in state READY: %s", d.Id(), err)
+	}
+
+	return resourceOpennebulaMarketPlaceAppRead(d, meta)
+}
+
+func resourceOpennebulaMarketPlaceAppRead(d *schema.ResourceData, meta interface{}) error {
+	mac, err := getMarketPlaceAppController(d, meta)
+	if err != nil {
+		return err
+	}
+
+	app, err := mac.Info()
+	if err != nil {
+		return err
+	}
+
+	d.Set("name", app.Name)
+	d.Set("market_id", app.MarketPlaceID)
+	if originid, err := strconv.Atoi(app.OriginID); err == nil {
+		d.Set("origin_id", originid)
+	}
+	if app.Type >= 0 && app.Type < len(marketapptypes) {
+		d.Set("type", marketapptypes[app.Type])
+	}
+	d.Set("description", app.Description)
+	d.Set("version", app.Version)
+	d.Set("size", app.Size)
+	d.Set("format", app.Format)
+	d.Set("state", marketAppState(app))
+
+	return nil
+}
+
+func resourceOpennebulaMarketPlaceAppExists(d *schema.ResourceData, meta interface{}) (bool, error) {
+	err := resourceOpennebulaMarketPlaceAppRead(d, meta)
+	if err != nil || d.Id() == "" {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func resourceOpennebulaMarketPlaceAppUpdate(d *schema.ResourceData, meta interface{}) error {
+	mac, err := getMarketPlaceAppController(d, meta)
+	if err != nil {
+		return err
+	}
+
+	if d.HasChange("name") {
+		err = mac.Rename(d.Get("name").(string))
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated name for Marketplace App %s\n", d.Get("name"))
+	}
+
+	if d.HasChange("description") || d.HasChange("version") {
+		apptpl := &onetemplate.Template{}
+		apptpl.AddPair("DESCRIPTION", d.Get("description").(string))
+		apptpl.AddPair("VERSION", d.Get("version").(string))
+
+		// Merge the template to keep the attributes set by the marketplace
+		err = mac.Update(apptpl.String(), 1)
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated template for Marketplace App %s\n", d.Get("name"))
+	}
+
+	return resourceOpennebulaMarketPlaceAppRead(d, meta)
+}
+
+func resourceOpennebulaMarketPlaceAppDelete(d *schema.ResourceData, meta interface{}) error {
+	mac, err := getMarketPlaceAppController(d, meta)
+	if err != nil {
+		return err
+	}
+
+	err = mac.Delete()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Successfully deleted Marketplace App ID %s\n", d.Id())
+
+	return nil
+}
+
+func marketAppState(app *marketplaceapp.MarketPlaceApp) string {
+	if app.State >= 0 && app.State < len(marketappstates) {
+		return marketappstates[app.State]
+	}
+	return ""
+}
+
+func waitForMarketPlaceAppState(d *schema.ResourceData, meta interface{}, state string, timeout time.Duration) (interface{}, error) {
+	var app *marketplaceapp.MarketPlaceApp
+
+	mac, err := getMarketPlaceAppController(d, meta)
+	if err != nil {
+		return app, err
+	}
+
+	stateConf := &resource.StateChangeConf{
+		Pending: []string{"anythingelse"},
+		Target:  []string{state},
+		Refresh: func() (interface{}, string, error) {
+			log.Println("Refreshing Marketplace App state...")
+			app, err = mac.Info()
+			if err != nil {
+				return app, "", err
+			}
+			appstate := marketAppState(app)
+			log.Printf("Marketplace App %v is currently in state %v", app.ID, appstate)
+			if appstate == state {
+				return app, appstate, nil
+			} else if appstate == "ERROR" {
+				return app, "error", fmt.Errorf("Marketplace App ID %v entered error state.", d.Id())
+			}
+			return app, "anythingelse", nil
+		},
+		Timeout:    timeout,
+		Delay:      10 * time.Second,
+		MinTimeout: 3 * time.Second,
+	}
+
+	return stateConf.WaitForState()
+}
+
+// decodeMarketPlaceAppTemplate returns the template used to import the App
+func decodeMarketPlaceAppTemplate(app *marketplaceapp.MarketPlaceApp) (string, error) {
+	tpl, err := base64.StdEncoding.DecodeString(app.AppTemplate64)
+	if err != nil {
+		return "", fmt.Errorf("Error decoding template of Marketplace App %d: %s", app.ID, err)
+	}
+
+	return strings.TrimSpace(string(tpl)), nil
+}
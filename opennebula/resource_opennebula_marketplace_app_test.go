@@ -0,0 +1,152 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/terraform"
+	"strconv"
+	"testing"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+)
+
+func TestAccMarketPlaceAppImport(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckImageDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccMarketPlaceAppConfigImport,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("data.opennebula_marketplace_app.ttylinux", "market_id", "0"),
+					resource.TestCheckResourceAttr("data.opennebula_marketplace_app.ttylinux", "type", "IMAGE"),
+					resource.TestCheckResourceAttrSet("data.opennebula_marketplace_app.ttylinux", "app_template"),
+					resource.TestCheckResourceAttr("opennebula_image.ttylinux", "name", "test-image-ttylinux"),
+					resource.TestCheckResourceAttr("opennebula_image.ttylinux", "datastore_id", "1"),
+					resource.TestCheckResourceAttrPair("opennebula_image.ttylinux", "marketplace_app_id", "data.opennebula_marketplace_app.ttylinux", "id"),
+				),
+			},
+		},
+	})
+}
+
+// TestAccMarketPlaceAppExport exports an image to the private marketplace 1,
+// looks the app up and imports it back as an image
+func TestAccMarketPlaceAppExport(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:  func() { testAccPreCheck(t) },
+		Providers: testAccProviders,
+		CheckDestroy: resource.ComposeTestCheckFunc(
+			testAccCheckMarketPlaceAppDestroy,
+			testAccCheckImageDestroy,
+		),
+		Steps: []resource.TestStep{
+			{
+				Config: testAccMarketPlaceAppConfigExport,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_marketplace_app.app", "name", "terraapp"),
+					resource.TestCheckResourceAttr("opennebula_marketplace_app.app", "market_id", "1"),
+					resource.TestCheckResourceAttr("opennebula_marketplace_app.app", "type", "IMAGE"),
+					resource.TestCheckResourceAttr("opennebula_marketplace_app.app", "state", "READY"),
+					resource.TestCheckResourceAttr("opennebula_marketplace_app.app", "description", "Terraform app"),
+					resource.TestCheckResourceAttr("opennebula_marketplace_app.app", "size", "64"),
+					resource.TestCheckResourceAttrPair("opennebula_marketplace_app.app", "origin_id", "opennebula_image.origin", "id"),
+					resource.TestCheckResourceAttrPair("data.opennebula_marketplace_app.app", "id", "opennebula_marketplace_app.app", "id"),
+					resource.TestCheckResourceAttr("data.opennebula_marketplace_app.app", "type", "IMAGE"),
+					resource.TestCheckResourceAttr("data.opennebula_marketplace_app.app", "description", "Terraform app"),
+					resource.TestCheckResourceAttr("data.opennebula_marketplace_app.app", "app_template", "DEV_PREFIX=\"vd\""),
+					resource.TestCheckResourceAttrPair("opennebula_image.imported", "marketplace_app_id", "opennebula_marketplace_app.app", "id"),
+				),
+			},
+			{
+				Config: testAccMarketPlaceAppConfigExportUpdate,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_marketplace_app.app", "name", "terraapp-renamed"),
+					resource.TestCheckResourceAttr("opennebula_marketplace_app.app", "description", "Terraform app, renamed"),
+					resource.TestCheckResourceAttr("opennebula_marketplace_app.app", "version", "1.1"),
+				),
+			},
+		},
+	})
+}
+
+func testAccCheckMarketPlaceAppDestroy(s *terraform.State) error {
+	controller := testAccProvider.Meta().(*goca.Controller)
+
+	for _, rs := range s.RootModule().Resources {
+		if rs.Type != "opennebula_marketplace_app" {
+			continue
+		}
+		appID, _ := strconv.ParseUint(rs.Primary.ID, 10, 64)
+		mac := controller.MarketPlaceApp(int(appID))
+		// Get Marketplace App Info
+		app, _ := mac.Info()
+		if app != nil {
+			return fmt.Errorf("Expected marketplace app %s to have been destroyed", rs.Primary.ID)
+		}
+	}
+
+	return nil
+}
+
+var testAccMarketPlaceAppConfigExport = `
+resource "opennebula_image" "origin" {
+  name = "terraapp-origin"
+  datastore_id = 1
+  type = "DATABLOCK"
+  size = "64"
+  dev_prefix = "vd"
+}
+
+resource "opennebula_marketplace_app" "app" {
+  name = "terraapp"
+  market_id = 1
+  origin_id = "${opennebula_image.origin.id}"
+  description = "Terraform app"
+  version = "1.0"
+}
+
+data "opennebula_marketplace_app" "app" {
+  name = "${opennebula_marketplace_app.app.name}"
+  market_id = "${opennebula_marketplace_app.app.market_id}"
+}
+
+resource "opennebula_image" "imported" {
+  name = "terraapp-imported"
+  datastore_id = 1
+  marketplace_app_id = "${data.opennebula_marketplace_app.app.id}"
+}
+`
+
+var testAccMarketPlaceAppConfigExportUpdate = `
+resource "opennebula_image" "origin" {
+  name = "terraapp-origin"
+  datastore_id = 1
+  type = "DATABLOCK"
+  size = "64"
+  dev_prefix = "vd"
+}
+
+resource "opennebula_marketplace_app" "app" {
+  name = "terraapp-renamed"
+  market_id = 1
+  origin_id = "${opennebula_image.origin.id}"
+  description = "Terraform app, renamed"
+  version = "1.1"
+}
+`
+
+var testAccMarketPlaceAppConfigImport = `
+data "opennebula_marketplace_app" "ttylinux" {
+  name = "ttylinux - KVM"
+  market_id = 0
+}
+
+resource "opennebula_image" "ttylinux" {
+  name = "test-image-ttylinux"
+  datastore_id = 1
+  marketplace_app_id = "${data.opennebula_marketplace_app.ttylinux.id}"
+  permissions = "660"
+}
+`
@@ -45,6 +45,13 @@ func resourceOpennebulaSecurityGroup() *schema.Resource {
 				Required:    true,
 				Description: "Name of the Security Group",
 			},
+			"zone_id": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				ForceNew:    true,
+				Default:     -1,
+				Description: "ID of the Zone of the Security Group. If not set, it uses the zone of the provider",
+			},
 			"description": {
 				Type:        schema.TypeString,
 				Optional:    true,
@@ -178,7 +185,7 @@ func resourceOpennebulaSecurityGroup() *schema.Resource {
 }
 
 func getSecurityGroupController(d *schema.ResourceData, meta interface{}, args ...int) (*goca.SecurityGroupController, error) {
-	controller := meta.(*goca.Controller)
+	controller := zoneController(d, meta)
 	var sgc *goca.SecurityGroupController
 
 	// Try to find the Security Group by ID, if specified
@@ -204,7 +211,7 @@ func getSecurityGroupController(d *schema.ResourceData, meta interface{}, args .
 }
 
 func changeSecurityGroupGroup(d *schema.ResourceData, meta interface{}) error {
-	controller := meta.(*goca.Controller)
+	controller := zoneController(d, meta)
 	var gid int
 
 	sgc, err := getSecurityGroupController(d, meta)
@@ -242,6 +249,7 @@ func resourceOpennebulaSecurityGroupRead(d *schema.ResourceData, meta interface{
 	}
 
 	d.SetId(fmt.Sprintf("%v", securitygroup.ID))
+	readZoneID(d)
 	d.Set("uid", securitygroup.UID)
 	d.Set("gid", securitygroup.GID)
 	d.Set("uname", securitygroup.UName)
@@ -278,7 +286,7 @@ func resourceOpennebulaSecurityGroupExists(d *schema.ResourceData, meta interfac
 }
 
 func resourceOpennebulaSecurityGroupCreate(d *schema.ResourceData, meta interface{}) error {
-	controller := meta.(*goca.Controller)
+	controller := zoneController(d, meta)
 
 	secgroupxml, xmlerr := generateSecurityGroupXML(d)
 	if xmlerr != nil {
@@ -0,0 +1,370 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/helper/schema"
+	"log"
+	"strconv"
+	"time"
+)
+
+var defaultServiceTimeout = 20 * time.Minute
+
+func resourceOpennebulaService() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceOpennebulaServiceCreate,
+		Read:   resourceOpennebulaServiceRead,
+		Exists: resourceOpennebulaServiceExists,
+		Update: resourceOpennebulaServiceUpdate,
+		Delete: resourceOpennebulaServiceDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(defaultServiceTimeout),
+			Update: schema.DefaultTimeout(defaultServiceTimeout),
+			Delete: schema.DefaultTimeout(defaultServiceTimeout),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "Name of the Service",
+			},
+			"template_id": {
+				Type:        schema.TypeInt,
+				Required:    true,
+				ForceNew:    true,
+				Description: "ID of the Service Template to instantiate",
+			},
+			"cardinalities": {
+				Type:        schema.TypeMap,
+				Optional:    true,
+				Description: "Number of VMs of the roles to scale, by role name. Other roles keep the cardinality of the template",
+				Elem: &schema.Schema{
+					Type: schema.TypeInt,
+				},
+			},
+			"state": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "State of the Service",
+			},
+			"roles": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "Current roles of the Service",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"cardinality": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"state": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"vm_ids": {
+							Type:     schema.TypeList,
+							Computed: true,
+							Elem: &schema.Schema{
+								Type: schema.TypeInt,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func resourceOpennebulaServiceCreate(d *schema.ResourceData, meta interface{}) error {
+	flow, err := getFlowClient(meta)
+	if err != nil {
+		return err
+	}
+
+	serviceID, err := flow.InstantiateServiceTemplate(d.Get("template_id").(int), d.Get("name").(string))
+	if err != nil {
+		return err
+	}
+	d.SetId(fmt.Sprintf("%v", serviceID))
+
+	_, err = waitForServiceState(d, meta, "RUNNING", d.Timeout(schema.TimeoutCreate))
+	if err != nil {
+		return fmt.Errorf("Error waiting for Service (%s) to be in state RUNNING: %s", d.Id(), err)
+	}
+
+	if _, ok := d.GetOk("cardinalities"); ok {
+		err = scaleService(d, meta, d.Timeout(schema.TimeoutCreate))
+		if err != nil {
+			return err
+		}
+	}
+
+	return resourceOpennebulaServiceRead(d, meta)
+}
+
+func resourceOpennebulaServiceRead(d *schema.ResourceData, meta interface{}) error {
+	flow, err := getFlowClient(meta)
+	if err != nil {
+		return err
+	}
+
+	serviceID, err := strconv.Atoi(d.Id())
+	if err != nil {
+		return fmt.Errorf("Service Id (%s) is not an integer", d.Id())
+	}
+
+	service, err := flow.Service(serviceID)
+	if err != nil {
+		if isFlowNotFound(err) {
+			log.Printf("[WARN] Service %s not found, removing it from the state", d.Id())
+			d.SetId("")
+			return nil
+		}
+		return err
+	}
+
+	d.Set("name", service.Name)
+	d.Set("template_id", service.TemplateID)
+	d.Set("state", service.State.String())
+
+	roles := make([]map[string]interface{}, 0, len(service.Roles))
+	for _, role := range service.Roles {
+		vmids := make([]int, 0, len(role.Nodes))
+		for _, node := range role.Nodes {
+			vmids = append(vmids, node.DeployID)
+		}
+
+		roles = append(roles, map[string]interface{}{
+			"name":        role.Name,
+			"cardinality": role.Cardinality,
+			"state":       role.State.String(),
+			"vm_ids":      vmids,
+		})
+	}
+	err = d.Set("roles", roles)
+	if err != nil {
+		log.Printf("[DEBUG] Error setting roles on service: %s", err)
+	}
+
+	return nil
+}
+
+func resourceOpennebulaServiceExists(d *schema.ResourceData, meta interface{}) (bool, error) {
+	err := resourceOpennebulaServiceRead(d, meta)
+	if err != nil || d.Id() == "" {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func resourceOpennebulaServiceUpdate(d *schema.ResourceData, meta interface{}) error {
+	if d.HasChange("cardinalities") {
+		err := scaleService(d, meta, d.Timeout(schema.TimeoutUpdate))
+		if err != nil {
+			return err
+		}
+	}
+
+	return resourceOpennebulaServiceRead(d, meta)
+}
+
+// scaleService sets the cardinality of the roles listed in cardinalities,
+// one role after the other. The roles removed from cardinalities get back
+// the cardinality of the template.
+func scaleService(d *schema.ResourceData, meta interface{}, timeout time.Duration) error {
+	flow, err := getFlowClient(meta)
+	if err != nil {
+		return err
+	}
+
+	serviceID, err := strconv.Atoi(d.Id())
+	if err != nil {
+		return fmt.Errorf("Service Id (%s) is not an integer", d.Id())
+	}
+
+	service, err := flow.Service(serviceID)
+	if err != nil {
+		return err
+	}
+
+	cardinalities := make(map[string]interface{})
+	for name, cardinality := range d.Get("cardinalities").(map[string]interface{}) {
+		cardinalities[name] = cardinality
+	}
+
+	ocardinalities, _ := d.GetChange("cardinalities")
+	for name := range ocardinalities.(map[string]interface{}) {
+		if _, ok := cardinalities[name]; ok {
+			continue
+		}
+
+		tpl, err := flow.ServiceTemplate(d.Get("template_id").(int))
+		if err != nil {
+			return err
+		}
+		for _, role := range tpl.Roles {
+			if role.Name == name {
+				cardinalities[name] = role.Cardinality
+			}
+		}
+	}
+
+	for name, cardinality := range cardinalities {
+		found := false
+		for _, role := range service.Roles {
+			if role.Name != name {
+				continue
+			}
+			found = true
+
+			if role.Cardinality == cardinality.(int) {
+				break
+			}
+
+			log.Printf("[DEBUG] Scaling role %s of Service %s to %d VMs", name, d.Id(), cardinality.(int))
+			err = flow.ScaleServiceRole(serviceID, name, cardinality.(int))
+			if err != nil {
+				return fmt.Errorf("Error scaling role %s of service (%s): %s", name, d.Id(), err)
+			}
+
+			_, err = waitForServiceRole(d, meta, name, cardinality.(int), timeout)
+			if err != nil {
+				return fmt.Errorf("Error waiting for role %s of Service (%s) to be scaled: %s", name, d.Id(), err)
+			}
+			break
+		}
+		if !found {
+			return fmt.Errorf("Service (%s) has no role %s", d.Id(), name)
+		}
+	}
+
+	return nil
+}
+
+func resourceOpennebulaServiceDelete(d *schema.ResourceData, meta interface{}) error {
+	flow, err := getFlowClient(meta)
+	if err != nil {
+		return err
+	}
+
+	serviceID, err := strconv.Atoi(d.Id())
+	if err != nil {
+		return fmt.Errorf("Service Id (%s) is not an integer", d.Id())
+	}
+
+	err = flow.ShutdownService(serviceID)
+	if err != nil {
+		return err
+	}
+
+	_, err = waitForServiceState(d, meta, "DONE", d.Timeout(schema.TimeoutDelete))
+	if err != nil {
+		return fmt.Errorf("Error waiting for Service (%s) to be in state DONE: %s", d.Id(), err)
+	}
+
+	log.Printf("[INFO] Successfully undeployed Service ID %s\n", d.Id())
+
+	return nil
+}
+
+func waitForServiceState(d *schema.ResourceData, meta interface{}, state string, timeout time.Duration) (interface{}, error) {
+	var service *flowService
+
+	flow, err := getFlowClient(meta)
+	if err != nil {
+		return service, err
+	}
+
+	serviceID, err := strconv.Atoi(d.Id())
+	if err != nil {
+		return service, fmt.Errorf("Service Id (%s) is not an integer", d.Id())
+	}
+
+	stateConf := &resource.StateChangeConf{
+		Pending: []string{"anythingelse"},
+		Target:  []string{state},
+		Refresh: func() (interface{}, string, error) {
+			log.Println("Refreshing Service state...")
+			service, err = flow.Service(serviceID)
+			if err != nil {
+				// OneFlow removes the Services once undeployed
+				if isFlowNotFound(err) {
+					return service, "DONE", nil
+				}
+				return service, "", err
+			}
+			servicestate := service.State.String()
+			log.Printf("Service %v is currently in state %v", serviceID, servicestate)
+			switch servicestate {
+			case state:
+				return service, servicestate, nil
+			case "FAILED_DEPLOYING", "FAILED_UNDEPLOYING", "FAILED_SCALING":
+				return service, "failed", fmt.Errorf("Service ID %v entered %s state.", d.Id(), servicestate)
+			}
+			return service, "anythingelse", nil
+		},
+		Timeout:    timeout,
+		Delay:      5 * time.Second,
+		MinTimeout: 3 * time.Second,
+	}
+
+	return stateConf.WaitForState()
+}
+
+// waitForServiceRole waits for the service to be RUNNING with the VMs of the
+// role matching its cardinality. OneFlow scales the role asynchronously, the
+// service is still RUNNING right after the request.
+func waitForServiceRole(d *schema.ResourceData, meta interface{}, name string, cardinality int, timeout time.Duration) (interface{}, error) {
+	var service *flowService
+
+	flow, err := getFlowClient(meta)
+	if err != nil {
+		return service, err
+	}
+
+	serviceID, err := strconv.Atoi(d.Id())
+	if err != nil {
+		return service, fmt.Errorf("Service Id (%s) is not an integer", d.Id())
+	}
+
+	stateConf := &resource.StateChangeConf{
+		Pending: []string{"anythingelse"},
+		Target:  []string{"RUNNING"},
+		Refresh: func() (interface{}, string, error) {
+			log.Println("Refreshing Service state...")
+			service, err = flow.Service(serviceID)
+			if err != nil {
+				return service, "", err
+			}
+			servicestate := service.State.String()
+			log.Printf("Service %v is currently in state %v", serviceID, servicestate)
+			switch servicestate {
+			case "FAILED_DEPLOYING", "FAILED_UNDEPLOYING", "FAILED_SCALING":
+				return service, "failed", fmt.Errorf("Service ID %v entered %s state.", d.Id(), servicestate)
+			case "RUNNING":
+				for _, role := range service.Roles {
+					if role.Name == name && role.Cardinality == cardinality && len(role.Nodes) == cardinality {
+						return service, servicestate, nil
+					}
+				}
+			}
+			return service, "anythingelse", nil
+		},
+		Timeout:    timeout,
+		Delay:      5 * time.Second,
+		MinTimeout: 3 * time.Second,
+	}
+
+	return stateConf.WaitForState()
+}
@@ -0,0 +1,330 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/schema"
+	"log"
+	"strconv"
+	"strings"
+)
+
+var flowdeployments = []string{"none", "straight"}
+var flowpolicytypes = []string{"CHANGE", "CARDINALITY", "PERCENTAGE_CHANGE"}
+
+func resourceOpennebulaServiceTemplate() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceOpennebulaServiceTemplateCreate,
+		Read:   resourceOpennebulaServiceTemplateRead,
+		Exists: resourceOpennebulaServiceTemplateExists,
+		Update: resourceOpennebulaServiceTemplateUpdate,
+		Delete: resourceOpennebulaServiceTemplateDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Name of the Service Template",
+			},
+			"description": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Description of the Service Template",
+			},
+			"deployment": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "none",
+				Description: "Deployment strategy of the roles: none, straight (follows the parents of the roles). Default is 'none'",
+				ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+					value := v.(string)
+
+					if inArray(value, flowdeployments) < 0 {
+						errors = append(errors, fmt.Errorf("Deployment %q must be one of: %s", k, strings.Join(flowdeployments, ",")))
+					}
+
+					return
+				},
+			},
+			"role": {
+				Type:        schema.TypeList,
+				Required:    true,
+				MinItems:    1,
+				Description: "Definition of the roles of the Service",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"vm_template_id": {
+							Type:        schema.TypeInt,
+							Required:    true,
+							Description: "ID of the VM template of the role",
+						},
+						"cardinality": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Default:     1,
+							Description: "Number of VMs of the role. Default is 1",
+						},
+						"parents": {
+							Type:        schema.TypeList,
+							Optional:    true,
+							Description: "Names of the roles deployed before this one",
+							Elem: &schema.Schema{
+								Type: schema.TypeString,
+							},
+						},
+						"min_vms": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Description: "Minimum number of VMs for elasticity adjustments",
+						},
+						"max_vms": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Description: "Maximum number of VMs for elasticity adjustments",
+						},
+						"cooldown": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Description: "Cooldown period in seconds after a scale operation",
+						},
+						"elasticity_policy": {
+							Type:        schema.TypeList,
+							Optional:    true,
+							Description: "Policies adjusting the cardinality of the role",
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"type": {
+										Type:        schema.TypeString,
+										Required:    true,
+										Description: "Type of adjustment: CHANGE, CARDINALITY, PERCENTAGE_CHANGE",
+										ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+											value := v.(string)
+
+											if inArray(value, flowpolicytypes) < 0 {
+												errors = append(errors, fmt.Errorf("Type %q must be one of: %s", k, strings.Join(flowpolicytypes, ",")))
+											}
+
+											return
+										},
+									},
+									"adjust": {
+										Type:        schema.TypeInt,
+										Required:    true,
+										Description: "Value of the adjustment",
+									},
+									"min_adjust_step": {
+										Type:        schema.TypeInt,
+										Optional:    true,
+										Description: "Minimum number of VMs added or removed by a PERCENTAGE_CHANGE",
+									},
+									"expression": {
+										Type:        schema.TypeString,
+										Required:    true,
+										Description: "Expression triggering the adjustment, e.g. 'CPU > 80'",
+									},
+									"period_number": {
+										Type:        schema.TypeInt,
+										Optional:    true,
+										Description: "Number of periods the expression must be true",
+									},
+									"period": {
+										Type:        schema.TypeInt,
+										Optional:    true,
+										Description: "Duration of a period in seconds",
+									},
+									"cooldown": {
+										Type:        schema.TypeInt,
+										Optional:    true,
+										Description: "Cooldown period in seconds after the adjustment",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func generateFlowServiceTemplate(d *schema.ResourceData) *flowServiceTemplate {
+	tpl := &flowServiceTemplate{
+		Name:        d.Get("name").(string),
+		Description: d.Get("description").(string),
+		Deployment:  d.Get("deployment").(string),
+		Roles:       make([]flowRole, 0),
+	}
+
+	for _, r := range d.Get("role").([]interface{}) {
+		rolemap := r.(map[string]interface{})
+
+		role := flowRole{
+			Name:               rolemap["name"].(string),
+			VMTemplate:         rolemap["vm_template_id"].(int),
+			Cardinality:        rolemap["cardinality"].(int),
+			MinVMs:             rolemap["min_vms"].(int),
+			MaxVMs:             rolemap["max_vms"].(int),
+			Cooldown:           rolemap["cooldown"].(int),
+			ElasticityPolicies: make([]flowElasticityPolicy, 0),
+		}
+
+		for _, parent := range rolemap["parents"].([]interface{}) {
+			role.Parents = append(role.Parents, parent.(string))
+		}
+
+		for _, p := range rolemap["elasticity_policy"].([]interface{}) {
+			policymap := p.(map[string]interface{})
+			role.ElasticityPolicies = append(role.ElasticityPolicies, flowElasticityPolicy{
+				Type:          policymap["type"].(string),
+				Adjust:        policymap["adjust"].(int),
+				MinAdjustStep: policymap["min_adjust_step"].(int),
+				Expression:    policymap["expression"].(string),
+				PeriodNumber:  policymap["period_number"].(int),
+				Period:        policymap["period"].(int),
+				Cooldown:      policymap["cooldown"].(int),
+			})
+		}
+
+		tpl.Roles = append(tpl.Roles, role)
+	}
+
+	return tpl
+}
+
+func flattenFlowRoles(roles []flowRole) []map[string]interface{} {
+	rolelist := make([]map[string]interface{}, 0, len(roles))
+
+	for _, role := range roles {
+		policies := make([]map[string]interface{}, 0, len(role.ElasticityPolicies))
+		for _, policy := range role.ElasticityPolicies {
+			policies = append(policies, map[string]interface{}{
+				"type":            policy.Type,
+				"adjust":          policy.Adjust,
+				"min_adjust_step": policy.MinAdjustStep,
+				"expression":      policy.Expression,
+				"period_number":   policy.PeriodNumber,
+				"period":          policy.Period,
+				"cooldown":        policy.Cooldown,
+			})
+		}
+
+		rolelist = append(rolelist, map[string]interface{}{
+			"name":              role.Name,
+			"vm_template_id":    role.VMTemplate,
+			"cardinality":       role.Cardinality,
+			"parents":           role.Parents,
+			"min_vms":           role.MinVMs,
+			"max_vms":           role.MaxVMs,
+			"cooldown":          role.Cooldown,
+			"elasticity_policy": policies,
+		})
+	}
+
+	return rolelist
+}
+
+func resourceOpennebulaServiceTemplateCreate(d *schema.ResourceData, meta interface{}) error {
+	flow, err := getFlowClient(meta)
+	if err != nil {
+		return err
+	}
+
+	tplID, err := flow.CreateServiceTemplate(generateFlowServiceTemplate(d))
+	if err != nil {
+		return err
+	}
+	d.SetId(fmt.Sprintf("%v", tplID))
+
+	return resourceOpennebulaServiceTemplateRead(d, meta)
+}
+
+func resourceOpennebulaServiceTemplateRead(d *schema.ResourceData, meta interface{}) error {
+	flow, err := getFlowClient(meta)
+	if err != nil {
+		return err
+	}
+
+	tplID, err := strconv.Atoi(d.Id())
+	if err != nil {
+		return fmt.Errorf("Service Template Id (%s) is not an integer", d.Id())
+	}
+
+	tpl, err := flow.ServiceTemplate(tplID)
+	if err != nil {
+		if isFlowNotFound(err) {
+			log.Printf("[WARN] Service Template %s not found, removing it from the state", d.Id())
+			d.SetId("")
+			return nil
+		}
+		return err
+	}
+
+	d.Set("name", tpl.Name)
+	d.Set("description", tpl.Description)
+	if tpl.Deployment != "" {
+		d.Set("deployment", tpl.Deployment)
+	}
+	err = d.Set("role", flattenFlowRoles(tpl.Roles))
+	if err != nil {
+		log.Printf("[DEBUG] Error setting roles on service template: %s", err)
+	}
+
+	return nil
+}
+
+func resourceOpennebulaServiceTemplateExists(d *schema.ResourceData, meta interface{}) (bool, error) {
+	err := resourceOpennebulaServiceTemplateRead(d, meta)
+	if err != nil || d.Id() == "" {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func resourceOpennebulaServiceTemplateUpdate(d *schema.ResourceData, meta interface{}) error {
+	flow, err := getFlowClient(meta)
+	if err != nil {
+		return err
+	}
+
+	tplID, err := strconv.Atoi(d.Id())
+	if err != nil {
+		return fmt.Errorf("Service Template Id (%s) is not an integer", d.Id())
+	}
+
+	// The whole template is replaced
+	err = flow.UpdateServiceTemplate(tplID, generateFlowServiceTemplate(d))
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] Successfully updated Service Template %s\n", d.Get("name"))
+
+	return resourceOpennebulaServiceTemplateRead(d, meta)
+}
+
+func resourceOpennebulaServiceTemplateDelete(d *schema.ResourceData, meta interface{}) error {
+	flow, err := getFlowClient(meta)
+	if err != nil {
+		return err
+	}
+
+	tplID, err := strconv.Atoi(d.Id())
+	if err != nil {
+		return fmt.Errorf("Service Template Id (%s) is not an integer", d.Id())
+	}
+
+	err = flow.DeleteServiceTemplate(tplID)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Successfully deleted Service Template ID %s\n", d.Id())
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/terraform"
+	"testing"
+)
+
+func TestAccService(t *testing.T) {
+	standin := newFlowStandIn()
+	defer standin.Close()
+
+	resource.UnitTest(t, resource.TestCase{
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckServiceDestroy(standin),
+		Steps: []resource.TestStep{
+			{
+				Config: testAccFlowProviderConfig(standin) + testAccServiceConfigBasic,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_service_template.app", "name", "terraapp"),
+					resource.TestCheckResourceAttr("opennebula_service_template.app", "role.#", "2"),
+					resource.TestCheckResourceAttr("opennebula_service_template.app", "role.1.parents.0", "db"),
+					resource.TestCheckResourceAttr("opennebula_service_template.app", "role.1.elasticity_policy.0.expression", "CPU > 80"),
+					resource.TestCheckResourceAttr("opennebula_service.app", "name", "terraapp-1"),
+					resource.TestCheckResourceAttr("opennebula_service.app", "state", "RUNNING"),
+					resource.TestCheckResourceAttr("opennebula_service.app", "roles.#", "2"),
+					resource.TestCheckResourceAttr("opennebula_service.app", "roles.1.vm_ids.#", "2"),
+				),
+			},
+			{
+				Config: testAccFlowProviderConfig(standin) + testAccServiceConfigScale,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_service.app", "state", "RUNNING"),
+					resource.TestCheckResourceAttr("opennebula_service.app", "roles.1.cardinality", "3"),
+					resource.TestCheckResourceAttr("opennebula_service.app", "roles.1.vm_ids.#", "3"),
+				),
+			},
+			{
+				Config:                  testAccFlowProviderConfig(standin) + testAccServiceConfigScale,
+				ResourceName:            "opennebula_service.app",
+				ImportState:             true,
+				ImportStateVerify:       true,
+				ImportStateVerifyIgnore: []string{"cardinalities"},
+			},
+			{
+				// The role removed from cardinalities gets back the
+				// cardinality of the template
+				Config: testAccFlowProviderConfig(standin) + testAccServiceConfigBasic,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_service.app", "state", "RUNNING"),
+					resource.TestCheckResourceAttr("opennebula_service.app", "roles.1.cardinality", "2"),
+					resource.TestCheckResourceAttr("opennebula_service.app", "roles.1.vm_ids.#", "2"),
+				),
+			},
+		},
+	})
+}
+
+func testAccCheckServiceDestroy(standin *flowStandIn) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		standin.mutex.Lock()
+		defer standin.mutex.Unlock()
+
+		if len(standin.services) > 0 {
+			return fmt.Errorf("Expected services to have been undeployed, %d left", len(standin.services))
+		}
+		if len(standin.templates) > 0 {
+			return fmt.Errorf("Expected service templates to have been destroyed, %d left", len(standin.templates))
+		}
+
+		return nil
+	}
+}
+
+var testAccServiceTemplateConfig = `
+resource "opennebula_service_template" "app" {
+  name = "terraapp"
+  deployment = "straight"
+
+  role {
+    name = "db"
+    vm_template_id = 0
+  }
+
+  role {
+    name = "frontend"
+    vm_template_id = 0
+    cardinality = 2
+    parents = ["db"]
+    min_vms = 1
+    max_vms = 5
+
+    elasticity_policy {
+      type = "CHANGE"
+      adjust = 1
+      expression = "CPU > 80"
+      period_number = 3
+      period = 60
+    }
+  }
+}
+`
+
+var testAccServiceConfigBasic = testAccServiceTemplateConfig + `
+resource "opennebula_service" "app" {
+  name = "terraapp-1"
+  template_id = "${opennebula_service_template.app.id}"
+}
+`
+
+var testAccServiceConfigScale = testAccServiceTemplateConfig + `
+resource "opennebula_service" "app" {
+  name = "terraapp-1"
+  template_id = "${opennebula_service_template.app.id}"
+  cardinalities = {
+    frontend = 3
+  }
+}
+`
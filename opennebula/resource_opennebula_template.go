@@ -1,19 +1,25 @@
 package opennebula
 
 import (
-	"bytes"
-	"encoding/xml"
 	"fmt"
 	"github.com/hashicorp/terraform/helper/schema"
-	"github.com/kylelemons/godebug/pretty"
 	"log"
+	"sort"
 	"strconv"
 	"strings"
 
+	"github.com/OpenNebula/addon-terraform/opennebula/onetemplate"
 	"github.com/OpenNebula/one/src/oca/go/src/goca"
-	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/template"
 )
 
+// templateArgs are the arguments building the template, they can't be used
+// along with the raw template argument
+var templateArgs = []string{"cpu", "vcpu", "memory", "context", "disk", "nic", "graphics", "os", "attributes"}
+
+// templateVectors are the vectors built by the arguments, the other vectors
+// (FEATURES, INPUT, SCHED_ACTION, USER_INPUTS...) are kept on update
+var templateVectors = []string{"CONTEXT", "DISK", "NIC", "GRAPHICS", "OS"}
+
 func resourceOpennebulaTemplate() *schema.Resource {
 	return &schema.Resource{
 		Create: resourceOpennebulaTemplateCreate,
@@ -31,10 +37,168 @@ func resourceOpennebulaTemplate() *schema.Resource {
 				Required:    true,
 				Description: "Name of the template",
 			},
+			"zone_id": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				ForceNew:    true,
+				Default:     -1,
+				Description: "ID of the Zone of the Template. If not set, it uses the zone of the provider",
+			},
 			"template": {
-				Type:        schema.TypeString,
-				Required:    true,
-				Description: "Description of the template, in OpenNebula's XML or String format",
+				Type:             schema.TypeString,
+				Optional:         true,
+				ConflictsWith:    templateArgs,
+				DiffSuppressFunc: rawTemplateDiffSuppress,
+				Description:      "Description of the template, in OpenNebula's XML or String format",
+			},
+			"cpu": {
+				Type:             schema.TypeFloat,
+				Optional:         true,
+				ConflictsWith:    []string{"template"},
+				DiffSuppressFunc: templateArgDiffSuppress,
+				Description:      "Amount of CPU quota assigned to the virtual machine",
+			},
+			"vcpu": {
+				Type:             schema.TypeInt,
+				Optional:         true,
+				ConflictsWith:    []string{"template"},
+				DiffSuppressFunc: templateArgDiffSuppress,
+				Description:      "Number of virtual CPUs assigned to the virtual machine",
+			},
+			"memory": {
+				Type:             schema.TypeInt,
+				Optional:         true,
+				ConflictsWith:    []string{"template"},
+				DiffSuppressFunc: templateArgDiffSuppress,
+				Description:      "Amount of memory (RAM) in MB assigned to the virtual machine",
+			},
+			"context": {
+				Type:             schema.TypeMap,
+				Optional:         true,
+				ConflictsWith:    []string{"template"},
+				DiffSuppressFunc: templateArgDiffSuppress,
+				Description:      "Context variables",
+			},
+			"disk": {
+				Type:             schema.TypeList,
+				Optional:         true,
+				ConflictsWith:    []string{"template"},
+				DiffSuppressFunc: templateArgDiffSuppress,
+				Description:      "Definition of disks assigned to the Virtual Machine, in boot order",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"image_id": {
+							Type:     schema.TypeInt,
+							Required: true,
+						},
+						"size": {
+							Type:     schema.TypeInt,
+							Optional: true,
+						},
+						"target": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"driver": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+					},
+				},
+			},
+			"nic": {
+				Type:             schema.TypeList,
+				Optional:         true,
+				ConflictsWith:    []string{"template"},
+				DiffSuppressFunc: templateArgDiffSuppress,
+				Description:      "Definition of network adapter(s) assigned to the Virtual Machine",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"ip": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"mac": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"model": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"network_id": {
+							Type:     schema.TypeInt,
+							Required: true,
+						},
+						"physical_device": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"security_groups": {
+							Type:     schema.TypeList,
+							Optional: true,
+							Elem: &schema.Schema{
+								Type: schema.TypeInt,
+							},
+						},
+					},
+				},
+			},
+			"graphics": {
+				Type:             schema.TypeList,
+				Optional:         true,
+				MaxItems:         1,
+				ConflictsWith:    []string{"template"},
+				DiffSuppressFunc: templateArgDiffSuppress,
+				Description:      "Definition of graphics adapter assigned to the Virtual Machine",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"listen": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"port": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"type": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"keymap": {
+							Type:     schema.TypeString,
+							Optional: true,
+							Default:  "en-us",
+						},
+					},
+				},
+			},
+			"os": {
+				Type:             schema.TypeList,
+				Optional:         true,
+				MaxItems:         1,
+				ConflictsWith:    []string{"template"},
+				DiffSuppressFunc: templateArgDiffSuppress,
+				Description:      "Definition of OS boot and type for the Virtual Machine",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"arch": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"boot": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+					},
+				},
+			},
+			"attributes": {
+				Type:             schema.TypeMap,
+				Optional:         true,
+				ConflictsWith:    []string{"template"},
+				DiffSuppressFunc: templateArgDiffSuppress,
+				Description:      "Other single attributes of the template, i.e. DESCRIPTION or SCHED_REQUIREMENTS",
 			},
 			"permissions": {
 				Type:        schema.TypeString,
@@ -97,7 +261,7 @@ func resourceOpennebulaTemplate() *schema.Resource {
 }
 
 func getTemplateController(d *schema.ResourceData, meta interface{}, args ...int) (*goca.TemplateController, error) {
-	controller := meta.(*goca.Controller)
+	controller := zoneController(d, meta)
 	var tc *goca.TemplateController
 
 	// Try to find the template by ID, if specified
@@ -123,7 +287,7 @@ func getTemplateController(d *schema.ResourceData, meta interface{}, args ...int
 }
 
 func changeTemplateGroup(d *schema.ResourceData, meta interface{}) error {
-	controller := meta.(*goca.Controller)
+	controller := zoneController(d, meta)
 	var gid int
 
 	tc, err := getTemplateController(d, meta)
@@ -149,14 +313,16 @@ func changeTemplateGroup(d *schema.ResourceData, meta interface{}) error {
 }
 
 func resourceOpennebulaTemplateCreate(d *schema.ResourceData, meta interface{}) error {
-	controller := meta.(*goca.Controller)
+	controller := zoneController(d, meta)
 
-	template, xmlerr := generateTemplateXML(d)
-	if xmlerr != nil {
-		return xmlerr
+	tpl := &onetemplate.Template{}
+	tpl.AddPair("NAME", d.Get("name").(string))
+	rawtpl := d.Get("template").(string)
+	if rawtpl == "" {
+		tpl.Append(generateTemplate(d))
 	}
 
-	templateID, err := controller.Templates().Create(template)
+	templateID, err := controller.Templates().Create(tpl.String())
 	if err != nil {
 		log.Printf("[ERROR] Template creation failed, error: %s", err)
 		return err
@@ -164,11 +330,16 @@ func resourceOpennebulaTemplateCreate(d *schema.ResourceData, meta interface{})
 
 	tc := controller.Template(templateID)
 
-	// add template information into Template
-	err = tc.Update(d.Get("template").(string), 1)
-
 	d.SetId(fmt.Sprintf("%v", templateID))
 
+	// add template information into Template
+	if rawtpl != "" {
+		err = tc.Update(rawtpl, 1)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Change Permissions only if Permissions are set
 	if perms, ok := d.GetOk("permissions"); ok {
 		err = tc.Chmod(permissionUnix(perms.(string)))
@@ -201,6 +372,7 @@ func resourceOpennebulaTemplateRead(d *schema.ResourceData, meta interface{}) er
 	}
 
 	d.SetId(fmt.Sprintf("%v", template.ID))
+	readZoneID(d)
 	d.Set("name", template.Name)
 	d.Set("uid", template.UID)
 	d.Set("gid", template.GID)
@@ -209,12 +381,18 @@ func resourceOpennebulaTemplateRead(d *schema.ResourceData, meta interface{}) er
 	d.Set("reg_time", template.RegTime)
 	d.Set("permissions", permissionsUnixString(template.Permissions))
 
-	// Get Human readable template information
-	tpl := pretty.Sprint(template.Template)
+	tpl, err := getObjectTemplate(zoneController(d, meta), "one.template.info", template.ID, false)
+	if err != nil {
+		return err
+	}
 
-	d.Set("template", tpl)
+	// The template is read in the form it has been given
+	if _, ok := d.GetOk("template"); ok {
+		d.Set("template", tpl.String())
+		return nil
+	}
 
-	return nil
+	return flattenTemplate(d, tpl)
 }
 
 func resourceOpennebulaTemplateExists(d *schema.ResourceData, meta interface{}) (bool, error) {
@@ -261,6 +439,23 @@ func resourceOpennebulaTemplateUpdate(d *schema.ResourceData, meta interface{})
 
 	}
 
+	if d.Get("template") == "" && templateArgsChanged(d) {
+		current, err := getObjectTemplate(zoneController(d, meta), "one.template.info", template.ID, false)
+		if err != nil {
+			return err
+		}
+
+		// Replace the attributes built by the arguments, keep the other vectors
+		tpl := generateTemplate(d)
+		tpl.Append(unmanagedVectors(current))
+		err = tc.Update(tpl.String(), 0)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("[INFO] Successfully updated template template %s\n", template.Name)
+	}
+
 	if d.HasChange("permissions") {
 		if perms, ok := d.GetOk("permissions"); ok {
 			err = tc.Chmod(permissionUnix(perms.(string)))
@@ -279,7 +474,7 @@ func resourceOpennebulaTemplateUpdate(d *schema.ResourceData, meta interface{})
 		log.Printf("[INFO] Successfully updated group for Template %s\n", template.Name)
 	}
 
-	return nil
+	return resourceOpennebulaTemplateRead(d, meta)
 }
 
 func resourceOpennebulaTemplateDelete(d *schema.ResourceData, meta interface{}) error {
@@ -298,22 +493,247 @@ func resourceOpennebulaTemplateDelete(d *schema.ResourceData, meta interface{})
 	return nil
 }
 
-func generateTemplateXML(d *schema.ResourceData) (string, error) {
-	name := d.Get("name").(string)
+// rawTemplateDiffSuppress ignores the differences between two raw templates
+// holding the same attributes. An imported template is read as arguments, the
+// raw template is then compared with the template they build.
+func rawTemplateDiffSuppress(k, old, new string, d *schema.ResourceData) bool {
+	if templateDiffSuppress(k, old, new, d) {
+		return true
+	}
+	if old != "" || new == "" {
+		return false
+	}
 
-	template := &template.Template{
-		Name: name,
+	newtpl, err := onetemplate.Decode(new)
+	if err != nil {
+		return false
 	}
 
-	w := &bytes.Buffer{}
+	return generateTemplate(d).Equal(newtpl)
+}
+
+// templateArgDiffSuppress ignores the arguments read from an imported template
+// when the raw template is used instead
+func templateArgDiffSuppress(k, old, new string, d *schema.ResourceData) bool {
+	return d.Get("template").(string) != ""
+}
+
+// unmanagedVectors returns the vectors of the template not built by the
+// arguments
+func unmanagedVectors(tpl *onetemplate.Template) *onetemplate.Template {
+	vectors := &onetemplate.Template{}
+	for _, e := range tpl.Elements {
+		if vector, ok := e.(*onetemplate.Vector); ok && inArray(strings.ToUpper(vector.Key), templateVectors) < 0 {
+			vectors.Elements = append(vectors.Elements, vector)
+		}
+	}
+	return vectors
+}
+
+func templateArgsChanged(d *schema.ResourceData) bool {
+	for _, arg := range templateArgs {
+		if d.HasChange(arg) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedKeys returns the keys of a map argument in a stable order
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// generateTemplate builds the template from the cpu, vcpu, memory, context,
+// disk, nic, graphics, os and attributes arguments
+func generateTemplate(d *schema.ResourceData) *onetemplate.Template {
+	tpl := &onetemplate.Template{}
+
+	if cpu, ok := d.GetOk("cpu"); ok {
+		tpl.AddPair("CPU", cpu.(float64))
+	}
+	if vcpu, ok := d.GetOk("vcpu"); ok {
+		tpl.AddPair("VCPU", vcpu.(int))
+	}
+	if memory, ok := d.GetOk("memory"); ok {
+		tpl.AddPair("MEMORY", memory.(int))
+	}
+
+	attributes := d.Get("attributes").(map[string]interface{})
+	for _, key := range sortedKeys(attributes) {
+		tpl.AddPair(key, attributes[key].(string))
+	}
+
+	context := d.Get("context").(map[string]interface{})
+	if len(context) > 0 {
+		vector := tpl.AddVector("CONTEXT")
+		for _, key := range sortedKeys(context) {
+			vector.AddPair(key, context[key].(string))
+		}
+	}
+
+	for _, disk := range d.Get("disk").([]interface{}) {
+		diskconfig := disk.(map[string]interface{})
+		vector := tpl.AddVector("DISK")
+		vector.AddPair("IMAGE_ID", diskconfig["image_id"].(int))
+		if size := diskconfig["size"].(int); size > 0 {
+			vector.AddPair("SIZE", size)
+		}
+		if target := diskconfig["target"].(string); target != "" {
+			vector.AddPair("TARGET", target)
+		}
+		if driver := diskconfig["driver"].(string); driver != "" {
+			vector.AddPair("DRIVER", driver)
+		}
+	}
+
+	for _, nic := range d.Get("nic").([]interface{}) {
+		nicconfig := nic.(map[string]interface{})
+		vector := tpl.AddVector("NIC")
+		vector.AddPair("NETWORK_ID", nicconfig["network_id"].(int))
+		for _, attr := range [][2]string{{"ip", "IP"}, {"mac", "MAC"}, {"model", "MODEL"}, {"physical_device", "PHYDEV"}} {
+			if value := nicconfig[attr[0]].(string); value != "" {
+				vector.AddPair(attr[1], value)
+			}
+		}
+		if secgroups := nicconfig["security_groups"].([]interface{}); len(secgroups) > 0 {
+			vector.AddPair("SECURITY_GROUPS", ArrayToString(secgroups, ","))
+		}
+	}
+
+	for _, graphics := range d.Get("graphics").([]interface{}) {
+		graphicsconfig := graphics.(map[string]interface{})
+		vector := tpl.AddVector("GRAPHICS")
+		vector.AddPair("LISTEN", graphicsconfig["listen"].(string))
+		if port := graphicsconfig["port"].(string); port != "" {
+			vector.AddPair("PORT", port)
+		}
+		vector.AddPair("TYPE", graphicsconfig["type"].(string))
+		if keymap := graphicsconfig["keymap"].(string); keymap != "" {
+			vector.AddPair("KEYMAP", keymap)
+		}
+	}
+
+	for _, os := range d.Get("os").([]interface{}) {
+		osconfig := os.(map[string]interface{})
+		vector := tpl.AddVector("OS")
+		vector.AddPair("ARCH", osconfig["arch"].(string))
+		vector.AddPair("BOOT", osconfig["boot"].(string))
+	}
+
+	return tpl
+}
+
+// configKey returns the key of the map argument matching the attribute name,
+// OpenNebula upper cases the names of the attributes
+func configKey(config map[string]interface{}, name string) string {
+	for key := range config {
+		if strings.EqualFold(key, name) {
+			return key
+		}
+	}
+	return name
+}
+
+// flattenTemplate sets the arguments building the template from its content.
+// Vectors not managed by the arguments are ignored, they are kept on update.
+func flattenTemplate(d *schema.ResourceData, tpl *onetemplate.Template) error {
+	cpu, _ := tpl.Get("CPU")
+	cpuvalue, _ := strconv.ParseFloat(cpu, 64)
+	d.Set("cpu", cpuvalue)
+	vcpu, _ := tpl.Get("VCPU")
+	vcpuvalue, _ := strconv.Atoi(vcpu)
+	d.Set("vcpu", vcpuvalue)
+	memory, _ := tpl.Get("MEMORY")
+	memoryvalue, _ := strconv.Atoi(memory)
+	d.Set("memory", memoryvalue)
+
+	configattributes := d.Get("attributes").(map[string]interface{})
+	attributes := make(map[string]interface{})
+	for _, e := range tpl.Elements {
+		pair, ok := e.(*onetemplate.Pair)
+		if !ok || inArray(strings.ToUpper(pair.Key), []string{"CPU", "VCPU", "MEMORY"}) >= 0 {
+			continue
+		}
+		attributes[configKey(configattributes, pair.Key)] = pair.Value
+	}
+	if err := d.Set("attributes", attributes); err != nil {
+		return err
+	}
+
+	configcontext := d.Get("context").(map[string]interface{})
+	context := make(map[string]interface{})
+	for _, vector := range tpl.GetVectors("CONTEXT") {
+		for _, pair := range vector.Pairs {
+			context[configKey(configcontext, pair.Key)] = pair.Value
+		}
+	}
+	if err := d.Set("context", context); err != nil {
+		return err
+	}
+
+	disks := make([]map[string]interface{}, 0)
+	for _, vector := range tpl.GetVectors("DISK") {
+		imageid, _ := vector.Get("IMAGE_ID")
+		size, _ := vector.Get("SIZE")
+		target, _ := vector.Get("TARGET")
+		driver, _ := vector.Get("DRIVER")
+
+		disk := map[string]interface{}{
+			"target": target,
+			"driver": driver,
+		}
+		disk["image_id"], _ = strconv.Atoi(imageid)
+		disk["size"], _ = strconv.Atoi(size)
+		disks = append(disks, disk)
+	}
+	if err := d.Set("disk", disks); err != nil {
+		return err
+	}
+
+	nics := make([]map[string]interface{}, 0)
+	for _, vector := range tpl.GetVectors("NIC") {
+		networkid, _ := vector.Get("NETWORK_ID")
+		secgrouplist, _ := vector.Get("SECURITY_GROUPS")
+
+		nic := map[string]interface{}{}
+		nic["network_id"], _ = strconv.Atoi(networkid)
+		nic["ip"], _ = vector.Get("IP")
+		nic["mac"], _ = vector.Get("MAC")
+		nic["model"], _ = vector.Get("MODEL")
+		nic["physical_device"], _ = vector.Get("PHYDEV")
+		nic["security_groups"] = parseIntList(secgrouplist)
+		nics = append(nics, nic)
+	}
+	if err := d.Set("nic", nics); err != nil {
+		return err
+	}
+
+	graphics := make([]map[string]interface{}, 0)
+	for _, vector := range tpl.GetVectors("GRAPHICS") {
+		graphic := map[string]interface{}{}
+		graphic["listen"], _ = vector.Get("LISTEN")
+		graphic["port"], _ = vector.Get("PORT")
+		graphic["type"], _ = vector.Get("TYPE")
+		graphic["keymap"], _ = vector.Get("KEYMAP")
+		graphics = append(graphics, graphic)
+	}
+	if err := d.Set("graphics", graphics); err != nil {
+		return err
+	}
 
-	//Encode the Template schema to XML
-	enc := xml.NewEncoder(w)
-	//enc.Indent("", "  ")
-	if err := enc.Encode(template); err != nil {
-		return "", err
+	oss := make([]map[string]interface{}, 0)
+	for _, vector := range tpl.GetVectors("OS") {
+		os := map[string]interface{}{}
+		os["arch"], _ = vector.Get("ARCH")
+		os["boot"], _ = vector.Get("BOOT")
+		oss = append(oss, os)
 	}
 
-	log.Printf("Template XML: %s", w.String())
-	return w.String(), nil
+	return d.Set("os", oss)
 }
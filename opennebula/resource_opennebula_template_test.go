@@ -0,0 +1,264 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/config"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/terraform"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+)
+
+func TestAccTemplate(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckTemplateDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccTemplateConfigBasic,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_template.template", "name", "terratemplate"),
+					resource.TestCheckResourceAttr("opennebula_template.template", "cpu", "0.5"),
+					resource.TestCheckResourceAttr("opennebula_template.template", "memory", "128"),
+					resource.TestCheckResourceAttr("opennebula_template.template", "context.%", "2"),
+					resource.TestCheckResourceAttr("opennebula_template.template", "context.NETWORK", "YES"),
+					resource.TestCheckResourceAttr("opennebula_template.template", "graphics.0.type", "VNC"),
+					resource.TestCheckResourceAttr("opennebula_template.template", "graphics.0.keymap", "en-us"),
+					resource.TestCheckResourceAttr("opennebula_template.template", "os.0.arch", "x86_64"),
+					resource.TestCheckResourceAttr("opennebula_template.template", "attributes.DESCRIPTION", "a \"quoted\" description"),
+					resource.TestCheckResourceAttr("opennebula_template.raw", "name", "terratemplate-raw"),
+				),
+			},
+			{
+				// FEATURES is not built by the arguments and must be kept
+				PreConfig: testAccTemplateAddFeatures("terratemplate"),
+				Config:    testAccTemplateConfigUpdate,
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckTemplateFeatures("opennebula_template.template"),
+					resource.TestCheckResourceAttr("opennebula_template.template", "name", "terratemplate-renamed"),
+					resource.TestCheckResourceAttr("opennebula_template.template", "memory", "256"),
+					resource.TestCheckResourceAttr("opennebula_template.template", "context.%", "1"),
+					resource.TestCheckResourceAttr("opennebula_template.template", "attributes.%", "1"),
+					resource.TestCheckResourceAttr("opennebula_template.template", "attributes.SCHED_REQUIREMENTS", "ID=\"0\""),
+				),
+			},
+		},
+	})
+}
+
+// TestTemplateImportDiff checks an imported template, read as arguments,
+// has no diff with a configuration using either the arguments or the raw
+// template
+func TestTemplateImportDiff(t *testing.T) {
+	imported := &terraform.InstanceState{
+		ID: "100",
+		Attributes: map[string]string{
+			"id":                   "100",
+			"name":                 "terratemplate-raw",
+			"zone_id":              "-1",
+			"cpu":                  "0.1",
+			"memory":               "64",
+			"context.%":            "2",
+			"context.NETWORK":      "YES",
+			"context.SET_HOSTNAME": "$NAME",
+		},
+	}
+
+	cases := []struct {
+		name    string
+		config  map[string]interface{}
+		changed string
+	}{
+		{
+			name: "raw template",
+			config: map[string]interface{}{
+				"name":     "terratemplate-raw",
+				"template": "<TEMPLATE><MEMORY>64</MEMORY><CONTEXT><SET_HOSTNAME>$NAME</SET_HOSTNAME><NETWORK>YES</NETWORK></CONTEXT><CPU>0.1</CPU></TEMPLATE>",
+			},
+		},
+		{
+			name: "updated raw template",
+			config: map[string]interface{}{
+				"name":     "terratemplate-raw",
+				"template": "CPU = 0.1\nMEMORY = 128\nCONTEXT = [ NETWORK = YES, SET_HOSTNAME = \"$NAME\" ]",
+			},
+			changed: "template",
+		},
+		{
+			name: "arguments",
+			config: map[string]interface{}{
+				"name":    "terratemplate-raw",
+				"cpu":     0.1,
+				"memory":  64,
+				"context": map[string]interface{}{"NETWORK": "YES", "SET_HOSTNAME": "$NAME"},
+			},
+		},
+		{
+			name: "updated arguments",
+			config: map[string]interface{}{
+				"name":    "terratemplate-raw",
+				"cpu":     0.1,
+				"memory":  128,
+				"context": map[string]interface{}{"NETWORK": "YES", "SET_HOSTNAME": "$NAME"},
+			},
+			changed: "memory",
+		},
+	}
+
+	for _, tc := range cases {
+		raw, err := config.NewRawConfig(tc.config)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+
+		diff, err := resourceOpennebulaTemplate().Diff(imported, terraform.NewResourceConfig(raw), nil)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+
+		changed := make([]string, 0)
+		if diff != nil {
+			for key := range diff.Attributes {
+				changed = append(changed, key)
+			}
+		}
+		if tc.changed == "" && len(changed) > 0 {
+			t.Errorf("%s: expected no diff, got %s", tc.name, strings.Join(changed, ", "))
+		}
+		if tc.changed != "" && (len(changed) != 1 || changed[0] != tc.changed) {
+			t.Errorf("%s: expected a diff on %s, got %s", tc.name, tc.changed, strings.Join(changed, ", "))
+		}
+	}
+}
+
+// testAccTemplateAddFeatures adds a FEATURES vector to the template
+func testAccTemplateAddFeatures(name string) func() {
+	return func() {
+		controller := testAccProvider.Meta().(*goca.Controller)
+
+		tplID, err := controller.Templates().ByName(name)
+		if err == nil {
+			err = controller.Template(tplID).Update("FEATURES = [ ACPI = \"yes\" ]", 1)
+		}
+		if err != nil {
+			panic(fmt.Sprintf("Unable to add FEATURES to template %s: %s", name, err))
+		}
+	}
+}
+
+// testAccCheckTemplateFeatures checks the FEATURES vector of the template
+func testAccCheckTemplateFeatures(name string) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		rs, ok := s.RootModule().Resources[name]
+		if !ok {
+			return fmt.Errorf("Not found: %s", name)
+		}
+		tplID, _ := strconv.Atoi(rs.Primary.ID)
+
+		controller := testAccProvider.Meta().(*goca.Controller)
+		tpl, err := getObjectTemplate(controller, "one.template.info", tplID, false)
+		if err != nil {
+			return err
+		}
+
+		features := tpl.GetVectors("FEATURES")
+		if len(features) != 1 {
+			return fmt.Errorf("Expected template %s to keep its FEATURES, got %s", rs.Primary.ID, tpl.String())
+		}
+		if acpi, _ := features[0].Get("ACPI"); acpi != "yes" {
+			return fmt.Errorf("Unexpected FEATURES of template %s: %s", rs.Primary.ID, tpl.String())
+		}
+
+		return nil
+	}
+}
+
+func testAccCheckTemplateDestroy(s *terraform.State) error {
+	controller := testAccProvider.Meta().(*goca.Controller)
+
+	for _, rs := range s.RootModule().Resources {
+		if rs.Type != "opennebula_template" {
+			continue
+		}
+		tplID, _ := strconv.ParseUint(rs.Primary.ID, 10, 64)
+		tc := controller.Template(int(tplID))
+		// Get Template Info
+		tpl, _ := tc.Info()
+		if tpl != nil {
+			return fmt.Errorf("Expected template %s to have been destroyed", rs.Primary.ID)
+		}
+	}
+
+	return nil
+}
+
+var testAccTemplateConfigBasic = `
+resource "opennebula_template" "template" {
+  name = "terratemplate"
+  cpu = 0.5
+  memory = 128
+
+  context = {
+    NETWORK = "YES"
+    SET_HOSTNAME = "$NAME"
+  }
+
+  graphics {
+    listen = "0.0.0.0"
+    type = "VNC"
+  }
+
+  os {
+    arch = "x86_64"
+    boot = "disk0"
+  }
+
+  attributes = {
+    DESCRIPTION = "a \"quoted\" description"
+  }
+}
+
+resource "opennebula_template" "raw" {
+  name = "terratemplate-raw"
+  template = <<EOF
+    <TEMPLATE>
+      <memory>64</memory>
+      <context>
+        <set_hostname>$NAME</set_hostname>
+        <network>YES</network>
+      </context>
+      <cpu>0.1</cpu>
+    </TEMPLATE>
+    EOF
+}
+`
+
+var testAccTemplateConfigUpdate = `
+resource "opennebula_template" "template" {
+  name = "terratemplate-renamed"
+  cpu = 0.5
+  memory = 256
+
+  context = {
+    NETWORK = "YES"
+  }
+
+  graphics {
+    listen = "0.0.0.0"
+    type = "VNC"
+  }
+
+  os {
+    arch = "x86_64"
+    boot = "disk0"
+  }
+
+  attributes = {
+    SCHED_REQUIREMENTS = "ID=\"0\""
+  }
+}
+`
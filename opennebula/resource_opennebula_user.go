@@ -0,0 +1,273 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/schema"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+)
+
+var authdrivers = []string{"core", "public", "ssh", "x509", "ldap", "server_cipher", "server_x509"}
+
+func resourceOpennebulaUser() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceOpennebulaUserCreate,
+		Read:   resourceOpennebulaUserRead,
+		Exists: resourceOpennebulaUserExists,
+		Update: resourceOpennebulaUserUpdate,
+		Delete: resourceOpennebulaUserDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "Name of the User",
+			},
+			"password": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				Description: "Password of the User. For the ssh and x509 drivers, the public key or the certificate DN",
+			},
+			"auth_driver": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "core",
+				Description: "Authentication driver of the User: core, public, ssh, x509, ldap, server_cipher, server_x509. Default is 'core'",
+				ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+					value := v.(string)
+
+					if inArray(value, authdrivers) < 0 {
+						errors = append(errors, fmt.Errorf("Auth driver %q must be one of: %s", k, strings.Join(authdrivers, ",")))
+					}
+
+					return
+				},
+			},
+			"primary_group": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Computed:    true,
+				Description: "ID of the primary Group of the User, If empty, it uses the default group",
+			},
+			"groups": {
+				Type:        schema.TypeSet,
+				Optional:    true,
+				Description: "List of secondary Group IDs of the User",
+				Elem: &schema.Schema{
+					Type: schema.TypeInt,
+				},
+				Set: schema.HashInt,
+			},
+			"template": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				DiffSuppressFunc: templateDiffSuppress,
+				Description:      "User template content, in OpenNebula XML or String format",
+			},
+		},
+	}
+}
+
+func getUserController(d *schema.ResourceData, meta interface{}) (*goca.UserController, error) {
+	controller := meta.(*goca.Controller)
+	var uc *goca.UserController
+
+	// Try to find the User by ID, if specified
+	if d.Id() != "" {
+		uid, err := strconv.ParseUint(d.Id(), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("User Id (%s) is not an integer", d.Id())
+		}
+		uc = controller.User(int(uid))
+	}
+
+	// Otherwise, try to find the User by name as the de facto compound primary key
+	if d.Id() == "" {
+		uid, err := controller.Users().ByName(d.Get("name").(string))
+		if err != nil {
+			d.SetId("")
+			return nil, fmt.Errorf("Could not find User with name %s", d.Get("name").(string))
+		}
+		uc = controller.User(uid)
+	}
+
+	return uc, nil
+}
+
+func resourceOpennebulaUserCreate(d *schema.ResourceData, meta interface{}) error {
+	controller := meta.(*goca.Controller)
+
+	userID, err := controller.Users().Create(d.Get("name").(string),
+		d.Get("password").(string),
+		d.Get("auth_driver").(string))
+	if err != nil {
+		return err
+	}
+	d.SetId(fmt.Sprintf("%v", userID))
+
+	uc := controller.User(userID)
+
+	// add template description
+	if d.Get("template") != "" {
+		// Erase previous template
+		err = uc.Update(d.Get("template").(string), 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	// set primary group if provided
+	if gid, ok := d.GetOk("primary_group"); ok {
+		err = uc.Chgrp(gid.(int))
+		if err != nil {
+			return err
+		}
+	}
+
+	// add secondary groups if list provided
+	if groupids, ok := d.GetOk("groups"); ok {
+		grouplist := groupids.(*schema.Set).List()
+		for i := 0; i < len(grouplist); i++ {
+			err = uc.AddGroup(grouplist[i].(int))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return resourceOpennebulaUserRead(d, meta)
+}
+
+func resourceOpennebulaUserRead(d *schema.ResourceData, meta interface{}) error {
+	uc, err := getUserController(d, meta)
+	if err != nil {
+		return err
+	}
+
+	user, err := uc.Info()
+	if err != nil {
+		return err
+	}
+
+	d.SetId(strconv.FormatUint(uint64(user.ID), 10))
+	d.Set("name", user.Name)
+	d.Set("auth_driver", user.AuthDriver)
+	d.Set("primary_group", user.GID)
+
+	// The primary group is part of the groups list of the User
+	groups := make([]int, 0)
+	for _, gid := range user.GroupsID {
+		if gid != user.GID {
+			groups = append(groups, gid)
+		}
+	}
+	err = d.Set("groups", groups)
+	if err != nil {
+		log.Printf("[DEBUG] Error setting groups on user: %s", err)
+	}
+
+	tpl, err := getObjectTemplate(meta.(*goca.Controller), "one.user.info", user.ID)
+	if err != nil {
+		return err
+	}
+	// oned generates the token password of the user, it is not part of the
+	// configuration
+	tpl.Del("TOKEN_PASSWORD")
+	d.Set("template", tpl.String())
+
+	return nil
+}
+
+func resourceOpennebulaUserExists(d *schema.ResourceData, meta interface{}) (bool, error) {
+	err := resourceOpennebulaUserRead(d, meta)
+	if err != nil || d.Id() == "" {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func resourceOpennebulaUserUpdate(d *schema.ResourceData, meta interface{}) error {
+	uc, err := getUserController(d, meta)
+	if err != nil {
+		return err
+	}
+
+	if d.HasChange("auth_driver") {
+		err = uc.Chauth(d.Get("auth_driver").(string), d.Get("password").(string))
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated auth driver for User %s\n", d.Get("name"))
+	} else if d.HasChange("password") {
+		err = uc.Passwd(d.Get("password").(string))
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated password for User %s\n", d.Get("name"))
+	}
+
+	if d.HasChange("template") {
+		// Erase previous template
+		err = uc.Update(d.Get("template").(string), 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	if d.HasChange("primary_group") {
+		err = uc.Chgrp(d.Get("primary_group").(int))
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated primary group for User %s\n", d.Get("name"))
+	}
+
+	if d.HasChange("groups") {
+		ogroups, ngroups := d.GetChange("groups")
+
+		addgroup, delgroup := getAddDelIntList(ngroups.(*schema.Set).List(), ogroups.(*schema.Set).List())
+
+		// Add new groups first, a user can't be removed from its last group
+		for _, g := range addgroup {
+			err = uc.AddGroup(g)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Delete old groups
+		for _, g := range delgroup {
+			err = uc.DelGroup(g)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return resourceOpennebulaUserRead(d, meta)
+}
+
+func resourceOpennebulaUserDelete(d *schema.ResourceData, meta interface{}) error {
+	uc, err := getUserController(d, meta)
+	if err != nil {
+		return err
+	}
+
+	err = uc.Delete()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Successfully deleted User ID %s\n", d.Id())
+
+	return nil
+}
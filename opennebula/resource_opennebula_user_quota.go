@@ -0,0 +1,308 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/schema"
+	"log"
+	"strconv"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+)
+
+func resourceOpennebulaUserQuota() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceOpennebulaUserQuotaCreate,
+		Read:   resourceOpennebulaUserQuotaRead,
+		Update: resourceOpennebulaUserQuotaUpdate,
+		Delete: resourceOpennebulaUserQuotaDelete,
+		Importer: &schema.ResourceImporter{
+			State: resourceOpennebulaUserQuotaImport,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"user_id": {
+				Type:        schema.TypeInt,
+				Required:    true,
+				ForceNew:    true,
+				Description: "ID of the User",
+			},
+			"datastore": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Datastore quotas",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"datastore_id": {
+							Type:        schema.TypeInt,
+							Required:    true,
+							Description: "Datastore ID",
+						},
+						"images": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Description: "Maximum number of Images allowed (default: unlimited)",
+							Default:     -2,
+						},
+						"size": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Description: "Maximum size in MB allowed on the datastore (default: unlimited)",
+							Default:     -2,
+						},
+						"images_used": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Number of Images used",
+						},
+						"size_used": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Size in MB used on the datastore",
+						},
+					},
+				},
+			},
+			"network": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Network quotas",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"network_id": {
+							Type:        schema.TypeInt,
+							Required:    true,
+							Description: "Network ID",
+						},
+						"leases": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Description: "Maximum number of Leases allowed for this network (default: unlimited)",
+							Default:     -2,
+						},
+						"leases_used": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Number of Leases used in this network",
+						},
+					},
+				},
+			},
+			"image": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Image quotas",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"image_id": {
+							Type:        schema.TypeInt,
+							Required:    true,
+							Description: "Image ID",
+						},
+						"running_vms": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Description: "Maximum number of Running VMs allowed for this image (default: unlimited)",
+							Default:     -2,
+						},
+						"running_vms_used": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Number of Running VMs using this image",
+						},
+					},
+				},
+			},
+			"vm": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "VM quotas",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"cpu": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Description: "Maximum number of CPU allowed (default: unlimited)",
+							Default:     -2,
+						},
+						"memory": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Description: "Maximum Memory (MB) allowed (default: unlimited)",
+							Default:     -2,
+						},
+						"running_cpu": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Description: "Maximum number of 'running' CPUs allowed (default: unlimited)",
+							Default:     -2,
+						},
+						"running_memory": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Description: "'Running' Memory (MB) allowed (default: unlimited)",
+							Default:     -2,
+						},
+						"running_vms": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Description: "Maximum number of Running VMs allowed (default: unlimited)",
+							Default:     -2,
+						},
+						"system_disk_size": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Description: "Maximum System Disk size (MB) allowed (default: unlimited)",
+							Default:     -2,
+						},
+						"vms": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Description: "Maximum number of VMs allowed (default: unlimited)",
+							Default:     -2,
+						},
+						"cpu_used": {
+							Type:        schema.TypeFloat,
+							Computed:    true,
+							Description: "Number of CPU used",
+						},
+						"memory_used": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Memory (MB) used",
+						},
+						"running_cpu_used": {
+							Type:        schema.TypeFloat,
+							Computed:    true,
+							Description: "Number of 'running' CPUs used",
+						},
+						"running_memory_used": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "'Running' Memory (MB) used",
+						},
+						"running_vms_used": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Number of Running VMs",
+						},
+						"system_disk_size_used": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "System Disk size (MB) used",
+						},
+						"vms_used": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Number of VMs",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func getUserQuotaController(d *schema.ResourceData, meta interface{}) *goca.UserController {
+	controller := meta.(*goca.Controller)
+
+	return controller.User(d.Get("user_id").(int))
+}
+
+func resourceOpennebulaUserQuotaCreate(d *schema.ResourceData, meta interface{}) error {
+	uc := getUserQuotaController(d, meta)
+
+	err := uc.Quota(generateUserQuotas(d))
+	if err != nil {
+		return err
+	}
+
+	d.SetId(strconv.Itoa(d.Get("user_id").(int)))
+
+	return resourceOpennebulaUserQuotaRead(d, meta)
+}
+
+func resourceOpennebulaUserQuotaRead(d *schema.ResourceData, meta interface{}) error {
+	uc := getUserQuotaController(d, meta)
+
+	user, err := uc.Info()
+	if err != nil {
+		return err
+	}
+
+	d.SetId(strconv.Itoa(user.ID))
+	d.Set("user_id", user.ID)
+
+	quotas := flattenQuotas(user.QuotasList, true)
+	for _, key := range []string{"datastore", "network", "image", "vm"} {
+		if err := d.Set(key, quotas[key]); err != nil {
+			log.Printf("[WARN] Error setting %s quotas for User %d, error: %s", key, user.ID, err)
+		}
+	}
+
+	return nil
+}
+
+func resourceOpennebulaUserQuotaUpdate(d *schema.ResourceData, meta interface{}) error {
+	uc := getUserQuotaController(d, meta)
+
+	if d.HasChange("datastore") || d.HasChange("network") || d.HasChange("image") || d.HasChange("vm") {
+		// Quotas removed from the configuration get back to the default limits
+		err := uc.Quota(generateDefaultUserQuotas(d, true) + "\n" + generateUserQuotas(d))
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated quotas for User %d\n", d.Get("user_id").(int))
+	}
+
+	return resourceOpennebulaUserQuotaRead(d, meta)
+}
+
+func resourceOpennebulaUserQuotaDelete(d *schema.ResourceData, meta interface{}) error {
+	uc := getUserQuotaController(d, meta)
+
+	err := uc.Quota(generateDefaultUserQuotas(d, false))
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Successfully reset quotas for User %d\n", d.Get("user_id").(int))
+
+	return nil
+}
+
+func resourceOpennebulaUserQuotaImport(d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	uid, err := strconv.Atoi(d.Id())
+	if err != nil {
+		return nil, fmt.Errorf("User Id (%s) is not an integer", d.Id())
+	}
+	d.Set("user_id", uid)
+
+	return []*schema.ResourceData{d}, nil
+}
+
+func generateUserQuotas(d *schema.ResourceData) string {
+	return generateQuotas(map[string]interface{}{
+		"datastore": d.Get("datastore"),
+		"network":   d.Get("network"),
+		"image":     d.Get("image"),
+		"vm":        d.Get("vm"),
+	})
+}
+
+// generateDefaultUserQuotas builds a quota template setting back the default
+// limits on the quotas of the state. With old set, the quotas are taken from
+// the previous state.
+func generateDefaultUserQuotas(d *schema.ResourceData, old bool) string {
+	quotasMap := map[string]interface{}{}
+
+	for _, key := range []string{"datastore", "network", "image", "vm"} {
+		quotas := d.Get(key)
+		if old {
+			quotas, _ = d.GetChange(key)
+		}
+		quotasMap[key] = quotas
+	}
+
+	return generateQuotas(defaultQuotas(quotasMap))
+}
@@ -0,0 +1,102 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/terraform"
+	"strconv"
+	"testing"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+)
+
+func TestAccUserQuota(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckUserDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccUserQuotaConfigBasic,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_user_quota.quota", "datastore.#", "1"),
+					resource.TestCheckResourceAttr("opennebula_user_quota.quota", "datastore.0.datastore_id", "1"),
+					resource.TestCheckResourceAttr("opennebula_user_quota.quota", "datastore.0.images", "3"),
+					resource.TestCheckResourceAttr("opennebula_user_quota.quota", "datastore.0.images_used", "0"),
+					resource.TestCheckResourceAttr("opennebula_user_quota.quota", "vm.0.cpu", "4"),
+					resource.TestCheckResourceAttr("opennebula_user_quota.quota", "vm.0.memory", "8192"),
+					testAccCheckUserQuotaVMs(4),
+				),
+			},
+			{
+				Config: testAccUserQuotaConfigUpdate,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_user_quota.quota", "datastore.#", "0"),
+					resource.TestCheckResourceAttr("opennebula_user_quota.quota", "vm.0.cpu", "2"),
+					resource.TestCheckResourceAttr("opennebula_user_quota.quota", "vm.0.memory", "4096"),
+					testAccCheckUserQuotaVMs(2),
+				),
+			},
+		},
+	})
+}
+
+func testAccCheckUserQuotaVMs(expected int) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		controller := testAccProvider.Meta().(*goca.Controller)
+
+		rs, ok := s.RootModule().Resources["opennebula_user_quota.quota"]
+		if !ok {
+			return fmt.Errorf("opennebula_user_quota.quota not found in state")
+		}
+
+		userID, _ := strconv.ParseUint(rs.Primary.ID, 10, 64)
+		user, err := controller.User(int(userID)).Info()
+		if err != nil {
+			return err
+		}
+		if len(user.QuotasList.VMQuotas) == 0 {
+			return fmt.Errorf("Expected user %s to have VM quotas", rs.Primary.ID)
+		}
+		if int(user.QuotasList.VMQuotas[0].CPU) != expected {
+			return fmt.Errorf("Expected user %s CPU quota to be %d, got %v", rs.Primary.ID, expected, user.QuotasList.VMQuotas[0].CPU)
+		}
+
+		return nil
+	}
+}
+
+var testAccUserQuotaConfigBasic = `
+resource "opennebula_user" "user" {
+  name = "iamquotauser"
+  password = "p@ssw0rd"
+}
+
+resource "opennebula_user_quota" "quota" {
+  user_id = "${opennebula_user.user.id}"
+  datastore {
+    datastore_id = 1
+    images = 3
+    size = 10000
+  }
+  vm {
+    cpu = 4
+    memory = 8192
+  }
+}
+`
+
+var testAccUserQuotaConfigUpdate = `
+resource "opennebula_user" "user" {
+  name = "iamquotauser"
+  password = "p@ssw0rd"
+}
+
+resource "opennebula_user_quota" "quota" {
+  user_id = "${opennebula_user.user.id}"
+  vm {
+    cpu = 2
+    memory = 4096
+  }
+}
+`
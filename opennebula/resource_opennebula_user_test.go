@@ -0,0 +1,96 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/terraform"
+	"strconv"
+	"testing"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+)
+
+func TestAccUser(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckUserDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccUserConfigBasic,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_user.user", "name", "iamuser"),
+					resource.TestCheckResourceAttr("opennebula_user.user", "auth_driver", "core"),
+					resource.TestCheckResourceAttr("opennebula_user.user", "primary_group", "1"),
+					resource.TestCheckResourceAttr("opennebula_user.user", "groups.#", "0"),
+				),
+			},
+			{
+				Config: testAccUserConfigUpdate,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_user.user", "name", "iamuser"),
+					resource.TestCheckResourceAttr("opennebula_user.user", "auth_driver", "core"),
+					resource.TestCheckResourceAttr("opennebula_user.user", "primary_group", "1"),
+					resource.TestCheckResourceAttr("opennebula_user.user", "groups.#", "1"),
+					testAccCheckSetAttrID("opennebula_user.user", "groups", "opennebula_group.group"),
+					resource.TestCheckResourceAttr("opennebula_user.user", "template", "EMAIL = \"iamuser@example.org\""),
+				),
+			},
+		},
+	})
+}
+
+func testAccCheckUserDestroy(s *terraform.State) error {
+	controller := testAccProvider.Meta().(*goca.Controller)
+
+	for _, rs := range s.RootModule().Resources {
+		if rs.Type != "opennebula_user" {
+			continue
+		}
+		userID, _ := strconv.ParseUint(rs.Primary.ID, 10, 64)
+		uc := controller.User(int(userID))
+		// Get User Info
+		user, _ := uc.Info()
+		if user != nil {
+			return fmt.Errorf("Expected user %s to have been destroyed", rs.Primary.ID)
+		}
+	}
+
+	return nil
+}
+
+var testAccUserConfigBasic = `
+resource "opennebula_user" "user" {
+  name = "iamuser"
+  password = "p@ssw0rd"
+  auth_driver = "core"
+  primary_group = 1
+  template = <<EOF
+    EMAIL = "iamuser@example.com"
+    EOF
+}
+`
+
+var testAccUserConfigUpdate = `
+resource "opennebula_group" "group" {
+  name = "iamusergroup"
+  template = <<EOF
+    SUNSTONE = [
+      DEFAULT_VIEW = "cloud",
+      VIEWS = "cloud"
+    ]
+    EOF
+  delete_on_destruction = true
+}
+
+resource "opennebula_user" "user" {
+  name = "iamuser"
+  password = "n3wp@ssw0rd"
+  auth_driver = "core"
+  primary_group = 1
+  groups = ["${opennebula_group.group.id}"]
+  template = <<EOF
+    EMAIL = "iamuser@example.org"
+    EOF
+}
+`
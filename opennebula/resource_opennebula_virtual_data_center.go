@@ -224,9 +224,9 @@ func getAddDelIntList(ngrouplist, ogrouplist []interface{}) ([]int, []int) {
 				found = true
 				break
 			}
-			if !found {
-				addgroup = append(addgroup, ngroup.(int))
-			}
+		}
+		if !found {
+			addgroup = append(addgroup, ngroup.(int))
 		}
 	}
 	// Get old groups to delete
@@ -238,9 +238,9 @@ func getAddDelIntList(ngrouplist, ogrouplist []interface{}) ([]int, []int) {
 				found = true
 				break
 			}
-			if !found {
-				delgroup = append(delgroup, ogroup.(int))
-			}
+		}
+		if !found {
+			delgroup = append(delgroup, ogroup.(int))
 		}
 	}
 
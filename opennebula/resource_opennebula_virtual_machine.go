@@ -10,10 +10,12 @@ import (
 	"github.com/hashicorp/terraform/helper/schema"
 	"io"
 	"log"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/OpenNebula/addon-terraform/opennebula/onetemplate"
 	"github.com/OpenNebula/one/src/oca/go/src/goca"
 	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/vm"
 )
@@ -30,6 +32,7 @@ type vmTemplate struct {
 	OS                 *vm.OS                 `xml:"OS"`
 	Snapshots          []vm.Snapshot          `xml:"SNAPSHOT"`
 	SecurityGroupRules []vm.SecurityGroupRule `xml:"SECURITY_GROUP_RULE"`
+	VMGroup            *vmVMGroup             `xml:"VMGROUP,omitempty"`
 }
 
 type vmNIC struct {
@@ -52,6 +55,11 @@ type vmDisk struct {
 	Driver   string `xml:"DRIVER,omitempty"`
 }
 
+type vmVMGroup struct {
+	ID   int    `xml:"VMGROUP_ID"`
+	Role string `xml:"ROLE"`
+}
+
 type vmGraphics struct {
 	Keymap string `xml:"KEYMAP,omitempty"`
 	Listen string `xml:"LISTEN,omitempty"`
@@ -111,6 +119,12 @@ func (m *stringMap) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 	return nil
 }
 
+var defaultVMTimeout = 10 * time.Minute
+
+var vmpowerstates = []string{"running", "poweroff", "suspended", "stopped", "undeployed"}
+
+var vmAttributeName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
 func resourceOpennebulaVirtualMachine() *schema.Resource {
 	return &schema.Resource{
 		Create:        resourceOpennebulaVirtualMachineCreate,
@@ -122,6 +136,11 @@ func resourceOpennebulaVirtualMachine() *schema.Resource {
 		Importer: &schema.ResourceImporter{
 			State: schema.ImportStatePassthrough,
 		},
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(defaultVMTimeout),
+			Update: schema.DefaultTimeout(defaultVMTimeout),
+			Delete: schema.DefaultTimeout(defaultVMTimeout),
+		},
 
 		Schema: map[string]*schema.Schema{
 			"name": {
@@ -129,6 +148,13 @@ func resourceOpennebulaVirtualMachine() *schema.Resource {
 				Optional:    true,
 				Description: "Name of the VM. If empty, defaults to 'templatename-<vmid>'",
 			},
+			"zone_id": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				ForceNew:    true,
+				Default:     -1,
+				Description: "ID of the Zone of the Virtual Machine. If not set, it uses the zone of the provider",
+			},
 			"instance": {
 				Type:        schema.TypeString,
 				Computed:    true,
@@ -202,20 +228,38 @@ func resourceOpennebulaVirtualMachine() *schema.Resource {
 				Computed:    true,
 				Description: "Current LCM state of the VM",
 			},
+			"power_state": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "running",
+				Description: "Power state of the VM: running, poweroff, suspended, stopped, undeployed. Default is 'running'",
+				ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+					value := v.(string)
+
+					if inArray(value, vmpowerstates) < 0 {
+						errors = append(errors, fmt.Errorf("Power state %q must be one of: %s", k, strings.Join(vmpowerstates, ",")))
+					}
+
+					return
+				},
+			},
 			"cpu": {
-				Type:          schema.TypeFloat,
-				Optional:      true,
-				Description:   "Amount of CPU quota assigned to the virtual machine",
+				Type:        schema.TypeFloat,
+				Optional:    true,
+				Computed:    true,
+				Description: "Amount of CPU quota assigned to the virtual machine",
 			},
 			"vcpu": {
 				Type:        schema.TypeInt,
 				Optional:    true,
+				Computed:    true,
 				Description: "Number of virtual CPUs assigned to the virtual machine",
 			},
 			"memory": {
-				Type:          schema.TypeInt,
-				Optional:      true,
-				Description:   "Amount of memory (RAM) in MB assigned to the virtual machine",
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Computed:    true,
+				Description: "Amount of memory (RAM) in MB assigned to the virtual machine",
 			},
 			"context": {
 				Type:        schema.TypeMap,
@@ -238,17 +282,25 @@ func resourceOpennebulaVirtualMachine() *schema.Resource {
 						"size": {
 							Type:     schema.TypeInt,
 							Optional: true,
+							Computed: true,
 						},
 						"target": {
 							Type:     schema.TypeString,
 							Optional: true,
+							Computed: true,
 						},
 						"driver": {
 							Type:     schema.TypeString,
 							Optional: true,
+							Computed: true,
+						},
+						"disk_id": {
+							Type:     schema.TypeInt,
+							Computed: true,
 						},
 					},
 				},
+				Set: resourceVMDiskHash,
 			},
 			"graphics": {
 				Type:     schema.TypeSet,
@@ -291,6 +343,7 @@ func resourceOpennebulaVirtualMachine() *schema.Resource {
 						"ip": {
 							Type:     schema.TypeString,
 							Optional: true,
+							Computed: true,
 						},
 						"mac": {
 							Type:     schema.TypeString,
@@ -311,10 +364,12 @@ func resourceOpennebulaVirtualMachine() *schema.Resource {
 						"physical_device": {
 							Type:     schema.TypeString,
 							Optional: true,
+							Computed: true,
 						},
 						"security_groups": {
-							Type:     schema.TypeList,
-							Optional: true,
+							Type:        schema.TypeList,
+							Optional:    true,
+							Description: "Security groups of the NIC, the ones inherited from the virtual network are not listed unless they are also set here",
 							Elem: &schema.Schema{
 								Type: schema.TypeInt,
 							},
@@ -358,12 +413,85 @@ func resourceOpennebulaVirtualMachine() *schema.Resource {
 				ConflictsWith: []string{"gid"},
 				Description:   "Name of the Group that onws the VM, If empty, it uses caller group",
 			},
+			"vmgroup": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				ForceNew:    true,
+				MaxItems:    1,
+				Description: "Virtual Machine Group and role of the Virtual Machine",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"id": {
+							Type:        schema.TypeInt,
+							Required:    true,
+							Description: "ID of the Virtual Machine Group",
+						},
+						"role": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "Role of the Virtual Machine in the group",
+						},
+					},
+				},
+			},
+			"wait_for": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "Wait for the guest to be ready before considering the VM as created",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"attribute": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Name of the user template attribute reported by the guest, i.e. READY",
+							ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+								value := v.(string)
+
+								if !vmAttributeName.MatchString(value) {
+									errors = append(errors, fmt.Errorf("%q must be an attribute name made of letters, digits and underscores", k))
+								}
+
+								return
+							},
+						},
+						"value": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Default:     "YES",
+							Description: "Expected value of the user template attribute (default: YES)",
+						},
+						"nic_id": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Default:     -1,
+							Description: "ID of the NIC on which an IP has to appear",
+							ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+								value := v.(int)
+
+								if value < -1 {
+									errors = append(errors, fmt.Errorf("%q must be a NIC ID, or -1 to not wait for an IP", k))
+								}
+
+								return
+							},
+						},
+						"timeout": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							Default:      "10m",
+							Description:  "Maximum time to wait for the guest, i.e. 30s, 10m (default: 10m)",
+							ValidateFunc: validateDuration,
+						},
+					},
+				},
+			},
 		},
 	}
 }
 
 func getVirtualMachineController(d *schema.ResourceData, meta interface{}, args ...int) (*goca.VMController, error) {
-	controller := meta.(*goca.Controller)
+	controller := zoneController(d, meta)
 	var vmc *goca.VMController
 
 	// Try to find the VM by ID, if specified
@@ -389,7 +517,7 @@ func getVirtualMachineController(d *schema.ResourceData, meta interface{}, args
 }
 
 func changeVmGroup(d *schema.ResourceData, meta interface{}) error {
-	controller := meta.(*goca.Controller)
+	controller := zoneController(d, meta)
 	var gid int
 
 	vmc, err := getVirtualMachineController(d, meta)
@@ -415,7 +543,7 @@ func changeVmGroup(d *schema.ResourceData, meta interface{}) error {
 }
 
 func resourceOpennebulaVirtualMachineCreate(d *schema.ResourceData, meta interface{}) error {
-	controller := meta.(*goca.Controller)
+	controller := zoneController(d, meta)
 
 	//Call one.template.instantiate only if template_id is defined
 	//otherwise use one.vm.allocate
@@ -459,7 +587,7 @@ func resourceOpennebulaVirtualMachineCreate(d *schema.ResourceData, meta interfa
 	d.SetId(fmt.Sprintf("%v", vmID))
 	vmc := controller.VM(vmID)
 
-	_, err = waitForVmState(d, meta, "running")
+	_, err = waitForVmState(d, meta, "running", d.Timeout(schema.TimeoutCreate))
 	if err != nil {
 		return fmt.Errorf(
 			"Error waiting for virtual machine (%s) to be in state RUNNING: %s", d.Id(), err)
@@ -489,6 +617,19 @@ func resourceOpennebulaVirtualMachineCreate(d *schema.ResourceData, meta interfa
 		}
 	}
 
+	if powerstate := d.Get("power_state").(string); powerstate != "running" {
+		err = changeVmPowerState(d, meta, powerstate, d.Timeout(schema.TimeoutCreate))
+		if err != nil {
+			return err
+		}
+	} else if waitfor, ok := d.GetOk("wait_for"); ok {
+		_, err = waitForVmGuest(d, meta, waitfor.([]interface{})[0].(map[string]interface{}))
+		if err != nil {
+			return fmt.Errorf(
+				"Error waiting for virtual machine (%s) guest to be ready: %s", d.Id(), err)
+		}
+	}
+
 	return resourceOpennebulaVirtualMachineRead(d, meta)
 }
 
@@ -504,6 +645,7 @@ func resourceOpennebulaVirtualMachineRead(d *schema.ResourceData, meta interface
 	}
 
 	d.SetId(fmt.Sprintf("%v", vm.ID))
+	readZoneID(d)
 	d.Set("instance", vm.Name)
 	d.Set("name", vm.Name)
 	d.Set("uid", vm.UID)
@@ -512,13 +654,24 @@ func resourceOpennebulaVirtualMachineRead(d *schema.ResourceData, meta interface
 	d.Set("gname", vm.GName)
 	d.Set("state", vm.StateRaw)
 	d.Set("lcmstate", vm.LCMStateRaw)
+	if powerstate := vmPowerState(vm); inArray(powerstate, vmpowerstates) >= 0 {
+		d.Set("power_state", powerstate)
+	}
 	//TODO fix this:
 	//d.Set("ip", vm.VmTemplate.Context.IP)
 	d.Set("permissions", permissionsUnixString(vm.Permissions))
+	d.Set("cpu", vm.Template.CPU)
+	d.Set("vcpu", vm.Template.VCPU)
+	d.Set("memory", vm.Template.Memory)
 
 	//Pull in NIC config from OpenNebula into schema
 	if vm.Template.NICs != nil {
-		d.Set("nic", generateNicMapFromStructs(vm.Template.NICs))
+		nics := generateNicMapFromStructs(vm.Template.NICs)
+		err = dropInheritedSecurityGroups(zoneController(d, meta), nics, d.Get("nic").(*schema.Set))
+		if err != nil {
+			return err
+		}
+		d.Set("nic", nics)
 		d.Set("ip", &vm.Template.NICs[0].IP)
 	}
 
@@ -559,7 +712,20 @@ func generateDiskMapFromStructs(slice []vm.Disk) []map[string]interface{} {
 	diskmap := make([]map[string]interface{}, 0)
 
 	for i := 0; i < len(slice); i++ {
-		diskmap = append(diskmap, structs.Map(slice[i]))
+		disk := map[string]interface{}{
+			"disk_id": slice[i].ID,
+			"size":    slice[i].Size,
+		}
+		imageid, err := slice[i].Dynamic.GetContentByName("IMAGE_ID")
+		if err != nil {
+			// Volatile disks are not managed by the provider
+			continue
+		}
+		disk["image_id"], _ = strconv.Atoi(imageid)
+		disk["target"], _ = slice[i].Dynamic.GetContentByName("TARGET")
+		disk["driver"], _ = slice[i].Dynamic.GetContentByName("DRIVER")
+
+		diskmap = append(diskmap, disk)
 	}
 
 	return diskmap
@@ -570,12 +736,90 @@ func generateNicMapFromStructs(slice []vm.Nic) []map[string]interface{} {
 	nicmap := make([]map[string]interface{}, 0)
 
 	for i := 0; i < len(slice); i++ {
-		nicmap = append(nicmap, structs.Map(slice[i]))
+		nic := map[string]interface{}{
+			"nic_id":          slice[i].ID,
+			"ip":              slice[i].IP,
+			"mac":             slice[i].MAC,
+			"network":         slice[i].Network,
+			"physical_device": slice[i].PhyDev,
+		}
+		networkid, _ := slice[i].Dynamic.GetContentByName("NETWORK_ID")
+		nic["network_id"], _ = strconv.Atoi(networkid)
+		nic["model"], _ = slice[i].Dynamic.GetContentByName("MODEL")
+
+		secgroups := make([]int, 0)
+		secgrouplist, _ := slice[i].Dynamic.GetContentByName("SECURITY_GROUPS")
+		for _, sg := range strings.Split(secgrouplist, ",") {
+			if sgid, err := strconv.Atoi(sg); err == nil {
+				secgroups = append(secgroups, sgid)
+			}
+		}
+		nic["security_groups"] = secgroups
+
+		nicmap = append(nicmap, nic)
 	}
 
 	return nicmap
 }
 
+// dropInheritedSecurityGroups removes from the NICs the security groups that
+// OpenNebula copied from their virtual network, unless they are also part of
+// the NIC configuration
+func dropInheritedSecurityGroups(controller *goca.Controller, nics []map[string]interface{}, nicconfigs *schema.Set) error {
+	vnetsecgroups := make(map[int][]int)
+
+	for _, nic := range nics {
+		networkid := nic["network_id"].(int)
+		if _, ok := vnetsecgroups[networkid]; !ok {
+			vnettpl, err := getObjectTemplate(controller, "one.vn.info", networkid, false)
+			if err != nil {
+				return fmt.Errorf("Unable to get virtual network %d of the NIC %d: %s", networkid, nic["nic_id"], err)
+			}
+			secgroups, _ := vnettpl.Get("SECURITY_GROUPS")
+			vnetsecgroups[networkid] = parseIntList(secgroups)
+		}
+
+		configured := []interface{}{}
+		for _, nicconfig := range nicconfigs.List() {
+			if resourceVMNicHash(nicconfig) == resourceVMNicHash(nic) {
+				configured = nicconfig.(map[string]interface{})["security_groups"].([]interface{})
+				break
+			}
+		}
+
+		nic["security_groups"] = vmNicSecurityGroups(nic["security_groups"].([]int), vnetsecgroups[networkid], configured)
+	}
+
+	return nil
+}
+
+// vmNicSecurityGroups returns the security groups of a NIC without the ones
+// inherited from the virtual network which are not configured
+func vmNicSecurityGroups(nicsecgroups, vnetsecgroups []int, configured []interface{}) []int {
+	secgroups := make([]int, 0)
+
+	for _, sg := range nicsecgroups {
+		inherited := false
+		for _, vnetsg := range vnetsecgroups {
+			if sg == vnetsg {
+				inherited = true
+				break
+			}
+		}
+		for _, configuredsg := range configured {
+			if sg == configuredsg.(int) {
+				inherited = false
+				break
+			}
+		}
+		if !inherited {
+			secgroups = append(secgroups, sg)
+		}
+	}
+
+	return secgroups
+}
+
 func resourceOpennebulaVirtualMachineExists(d *schema.ResourceData, meta interface{}) (bool, error) {
 	err := resourceOpennebulaVirtualMachineRead(d, meta)
 	// a terminated VM is in state 6 (DONE)
@@ -631,6 +875,44 @@ func resourceOpennebulaVirtualMachineUpdate(d *schema.ResourceData, meta interfa
 		log.Printf("[INFO] Successfully updated group for VM %s\n", vm.Name)
 	}
 
+	if d.HasChange("cpu") || d.HasChange("vcpu") || d.HasChange("memory") {
+		err = resizeVm(d, meta)
+		if err != nil {
+			return err
+		}
+		d.SetPartial("cpu")
+		d.SetPartial("vcpu")
+		d.SetPartial("memory")
+		log.Printf("[INFO] Successfully resized VM %s\n", vm.Name)
+	}
+
+	if d.HasChange("nic") {
+		err = updateVmNics(d, meta)
+		if err != nil {
+			return err
+		}
+		d.SetPartial("nic")
+		log.Printf("[INFO] Successfully updated NICs for VM %s\n", vm.Name)
+	}
+
+	if d.HasChange("disk") {
+		err = updateVmDisks(d, meta)
+		if err != nil {
+			return err
+		}
+		d.SetPartial("disk")
+		log.Printf("[INFO] Successfully updated disks for VM %s\n", vm.Name)
+	}
+
+	if d.HasChange("power_state") {
+		err = changeVmPowerState(d, meta, d.Get("power_state").(string), d.Timeout(schema.TimeoutUpdate))
+		if err != nil {
+			return err
+		}
+		d.SetPartial("power_state")
+		log.Printf("[INFO] Successfully updated power state for VM %s\n", vm.Name)
+	}
+
 	// We succeeded, disable partial mode. This causes Terraform to save
 	// save all fields again.
 	d.Partial(false)
@@ -638,6 +920,400 @@ func resourceOpennebulaVirtualMachineUpdate(d *schema.ResourceData, meta interfa
 	return nil
 }
 
+// changeVmPowerState triggers the action bringing the VM into the requested
+// power state and waits for the VM to reach it
+func changeVmPowerState(d *schema.ResourceData, meta interface{}, powerstate string, timeout time.Duration) error {
+	vmc, err := getVirtualMachineController(d, meta)
+	if err != nil {
+		return err
+	}
+
+	// No action is possible while the VM is in a transient state
+	current, err := waitForVmStableState(d, meta, timeout)
+	if err != nil {
+		return fmt.Errorf(
+			"Error waiting for virtual machine (%s) to be in a stable state: %s", d.Id(), err)
+	}
+	if current == powerstate {
+		return nil
+	}
+
+	log.Printf("[INFO] Changing power state of VM %s from %s to %s", d.Id(), current, powerstate)
+
+	switch powerstate {
+	case "running":
+		err = vmc.Resume()
+	case "poweroff":
+		err = vmc.Poweroff()
+	case "suspended":
+		err = vmc.Suspend()
+	case "stopped":
+		err = vmc.Stop()
+	case "undeployed":
+		err = vmc.Undeploy()
+	default:
+		return fmt.Errorf("Unexpected power state %s", powerstate)
+	}
+	if err != nil {
+		return fmt.Errorf("Error changing power state of virtual machine (%s) to %s: %s", d.Id(), powerstate, err)
+	}
+
+	_, err = waitForVmState(d, meta, powerstate, timeout)
+	if err != nil {
+		return fmt.Errorf(
+			"Error waiting for virtual machine (%s) to be in state %s: %s", d.Id(), strings.ToUpper(powerstate), err)
+	}
+
+	return nil
+}
+
+// vmPowerState returns the power_state matching the current VM state, or an
+// empty string if the VM is in a transient state
+func vmPowerState(vmInfo *vm.VM) string {
+	vmState, vmLcmState, err := vmInfo.State()
+	if err != nil {
+		return ""
+	}
+
+	switch {
+	case vmState == 3 && vmLcmState == 3:
+		return "running"
+	case vmState == 4:
+		return "stopped"
+	case vmState == 5:
+		return "suspended"
+	case vmState == 6:
+		return "done"
+	case vmState == 8:
+		return "poweroff"
+	case vmState == 9:
+		return "undeployed"
+	}
+
+	return ""
+}
+
+// resizeVm applies the cpu, vcpu and memory values to an existing VM.
+// A hot resize is tried first, if OpenNebula refuses it because of the VM state,
+// the VM is powered off, resized and resumed.
+func resizeVm(d *schema.ResourceData, meta interface{}) error {
+	vmc, err := getVirtualMachineController(d, meta)
+	if err != nil {
+		return err
+	}
+
+	resizetpl := generateVmResizeTemplate(d)
+	log.Printf("[DEBUG] VM resize template: %s", resizetpl)
+
+	err = vmc.Resize(resizetpl, true)
+	if err == nil {
+		return nil
+	}
+	// OpenNebula refuses actions not allowed in the current VM state with an
+	// ACTION error, any other error is returned as is
+	if e, ok := err.(*goca.ResponseError); !ok || e.Code != goca.OneActionError {
+		return fmt.Errorf("Error resizing virtual machine (%s): %s", d.Id(), err)
+	}
+
+	log.Printf("[INFO] Hot resize refused for VM %s, powering it off: %s", d.Id(), err)
+
+	err = vmc.Poweroff()
+	if err != nil {
+		return err
+	}
+	_, err = waitForVmState(d, meta, "poweroff", d.Timeout(schema.TimeoutUpdate))
+	if err != nil {
+		return fmt.Errorf(
+			"Error waiting for virtual machine (%s) to be in state POWEROFF: %s", d.Id(), err)
+	}
+
+	err = vmc.Resize(resizetpl, true)
+	if err != nil {
+		return fmt.Errorf("Error resizing virtual machine (%s): %s", d.Id(), err)
+	}
+
+	err = vmc.Resume()
+	if err != nil {
+		return err
+	}
+	_, err = waitForVmState(d, meta, "running", d.Timeout(schema.TimeoutUpdate))
+	if err != nil {
+		return fmt.Errorf(
+			"Error waiting for virtual machine (%s) to be in state RUNNING: %s", d.Id(), err)
+	}
+
+	return nil
+}
+
+func generateVmResizeTemplate(d *schema.ResourceData) string {
+	resizetpl := &onetemplate.Template{}
+
+	if cpu, ok := d.GetOk("cpu"); ok {
+		resizetpl.AddPair("CPU", cpu.(float64))
+	}
+	if vcpu, ok := d.GetOk("vcpu"); ok {
+		resizetpl.AddPair("VCPU", vcpu.(int))
+	}
+	if memory, ok := d.GetOk("memory"); ok {
+		resizetpl.AddPair("MEMORY", memory.(int))
+	}
+
+	return resizetpl.String()
+}
+
+// updateVmDisks detaches the disks removed from the configuration, then
+// attaches the new ones. The VM has to be back in its previous state between
+// each operation.
+func updateVmDisks(d *schema.ResourceData, meta interface{}) error {
+	vmc, err := getVirtualMachineController(d, meta)
+	if err != nil {
+		return err
+	}
+
+	// The VM has to be back in this state after each operation
+	powerstate, err := waitForVmStableState(d, meta, d.Timeout(schema.TimeoutUpdate))
+	if err != nil {
+		return fmt.Errorf(
+			"Error waiting for virtual machine (%s) to be in a stable state: %s", d.Id(), err)
+	}
+
+	vm, err := vmc.Info()
+	if err != nil {
+		return err
+	}
+
+	odisks, ndisks := d.GetChange("disk")
+	detachdisks := odisks.(*schema.Set).Difference(ndisks.(*schema.Set)).List()
+	attachdisks := ndisks.(*schema.Set).Difference(odisks.(*schema.Set)).List()
+
+	if len(detachdisks) > 0 {
+		for _, disk := range detachdisks {
+			diskconfig := disk.(map[string]interface{})
+			imageid := diskconfig["image_id"].(int)
+
+			diskid, err := getVmDiskID(vm.Template.Disks, imageid)
+			if err != nil {
+				return fmt.Errorf("Error detaching disk from virtual machine (%s): %s", d.Id(), err)
+			}
+
+			log.Printf("[DEBUG] Detaching disk %d (image %d) from VM %s", diskid, imageid, d.Id())
+			err = vmc.DiskDetach(diskid)
+			if err != nil {
+				return fmt.Errorf("Error detaching disk %d from virtual machine (%s): %s", diskid, d.Id(), err)
+			}
+
+			_, err = waitForVmState(d, meta, powerstate, d.Timeout(schema.TimeoutUpdate))
+			if err != nil {
+				return fmt.Errorf(
+					"Error waiting for virtual machine (%s) to be in state %s: %s", d.Id(), strings.ToUpper(powerstate), err)
+			}
+		}
+	}
+
+	for _, disk := range attachdisks {
+		disktpl, err := generateVmDiskTemplate(disk.(map[string]interface{}))
+		if err != nil {
+			return err
+		}
+
+		log.Printf("[DEBUG] Attaching disk to VM %s: %s", d.Id(), disktpl)
+		err = vmc.DiskAttach(disktpl)
+		if err != nil {
+			return fmt.Errorf("Error attaching disk to virtual machine (%s): %s", d.Id(), err)
+		}
+
+		_, err = waitForVmState(d, meta, powerstate, d.Timeout(schema.TimeoutUpdate))
+		if err != nil {
+			return fmt.Errorf(
+				"Error waiting for virtual machine (%s) to be in state %s: %s", d.Id(), strings.ToUpper(powerstate), err)
+		}
+	}
+
+	return nil
+}
+
+// updateVmNics detaches the NICs removed from the configuration, then attaches
+// the new ones. A NIC whose security groups changed is detached and attached
+// again, others NICs are left untouched so their nic_id is kept.
+func updateVmNics(d *schema.ResourceData, meta interface{}) error {
+	vmc, err := getVirtualMachineController(d, meta)
+	if err != nil {
+		return err
+	}
+
+	onics, nnics := d.GetChange("nic")
+	detachnics, attachnics := getVmNicChanges(onics.(*schema.Set), nnics.(*schema.Set))
+
+	// The VM has to be back in this state after each operation
+	powerstate, err := waitForVmStableState(d, meta, d.Timeout(schema.TimeoutUpdate))
+	if err != nil {
+		return fmt.Errorf(
+			"Error waiting for virtual machine (%s) to be in a stable state: %s", d.Id(), err)
+	}
+
+	vm, err := vmc.Info()
+	if err != nil {
+		return err
+	}
+
+	for _, nic := range detachnics {
+		nicconfig := nic.(map[string]interface{})
+
+		nicid, err := getVmNicID(vm.Template.NICs, nicconfig)
+		if err != nil {
+			return fmt.Errorf("Error detaching NIC from virtual machine (%s): %s", d.Id(), err)
+		}
+
+		log.Printf("[DEBUG] Detaching NIC %d from VM %s", nicid, d.Id())
+		err = vmc.DetachNIC(nicid)
+		if err != nil {
+			return fmt.Errorf("Error detaching NIC %d from virtual machine (%s): %s", nicid, d.Id(), err)
+		}
+
+		_, err = waitForVmState(d, meta, powerstate, d.Timeout(schema.TimeoutUpdate))
+		if err != nil {
+			return fmt.Errorf(
+				"Error waiting for virtual machine (%s) to be in state %s: %s", d.Id(), strings.ToUpper(powerstate), err)
+		}
+	}
+
+	for _, nic := range attachnics {
+		nictpl, err := generateVmNicTemplate(nic.(map[string]interface{}))
+		if err != nil {
+			return err
+		}
+
+		log.Printf("[DEBUG] Attaching NIC to VM %s: %s", d.Id(), nictpl)
+		err = vmc.AttachNIC(nictpl)
+		if err != nil {
+			return fmt.Errorf("Error attaching NIC to virtual machine (%s): %s", d.Id(), err)
+		}
+
+		_, err = waitForVmState(d, meta, powerstate, d.Timeout(schema.TimeoutUpdate))
+		if err != nil {
+			return fmt.Errorf(
+				"Error waiting for virtual machine (%s) to be in state %s: %s", d.Id(), strings.ToUpper(powerstate), err)
+		}
+	}
+
+	return nil
+}
+
+// getVmNicChanges returns the NICs to detach and the NICs to attach. The NICs
+// present in both sets with different security groups, compared as sets, are
+// detached and attached again.
+func getVmNicChanges(onics, nnics *schema.Set) ([]interface{}, []interface{}) {
+	detachnics := onics.Difference(nnics).List()
+	attachnics := nnics.Difference(onics).List()
+
+	for _, nnic := range nnics.Intersection(onics).List() {
+		nnicconfig := nnic.(map[string]interface{})
+		for _, onic := range onics.List() {
+			onicconfig := onic.(map[string]interface{})
+			if resourceVMNicHash(onic) != resourceVMNicHash(nnic) {
+				continue
+			}
+			addsecgroups, delsecgroups := getAddDelIntList(nnicconfig["security_groups"].([]interface{}), onicconfig["security_groups"].([]interface{}))
+			if len(addsecgroups) > 0 || len(delsecgroups) > 0 {
+				detachnics = append(detachnics, onic)
+				attachnics = append(attachnics, nnic)
+			}
+		}
+	}
+
+	return detachnics, attachnics
+}
+
+// getVmNicID returns the NIC_ID of the VM NIC matching the NIC configuration.
+// The nic_id stored in the state is used first, then the network ID.
+func getVmNicID(nics []vm.Nic, nicconfig map[string]interface{}) (int, error) {
+	networkid := strconv.Itoa(nicconfig["network_id"].(int))
+
+	if nicid, ok := nicconfig["nic_id"].(int); ok {
+		for _, nic := range nics {
+			nicnetworkid, _ := nic.Dynamic.GetContentByName("NETWORK_ID")
+			if nic.ID == nicid && nicnetworkid == networkid {
+				return nic.ID, nil
+			}
+		}
+	}
+
+	for _, nic := range nics {
+		nicnetworkid, _ := nic.Dynamic.GetContentByName("NETWORK_ID")
+		if nicnetworkid == networkid {
+			return nic.ID, nil
+		}
+	}
+
+	return -1, fmt.Errorf("No NIC found for network %s", networkid)
+}
+
+// generateVmNicTemplate returns the NIC vector used to attach a NIC
+func generateVmNicTemplate(nicconfig map[string]interface{}) (string, error) {
+	nictpl := &struct {
+		XMLName xml.Name `xml:"TEMPLATE"`
+		NIC     vmNIC    `xml:"NIC"`
+	}{
+		NIC: vmNIC{
+			IP:              nicconfig["ip"].(string),
+			Model:           nicconfig["model"].(string),
+			PhyDev:          nicconfig["physical_device"].(string),
+			Network_ID:      nicconfig["network_id"].(int),
+			Security_Groups: ArrayToString(nicconfig["security_groups"].([]interface{}), ","),
+		},
+	}
+
+	w := &bytes.Buffer{}
+
+	//Encode the NIC vector to XML
+	enc := xml.NewEncoder(w)
+	if err := enc.Encode(nictpl); err != nil {
+		return "", err
+	}
+
+	return w.String(), nil
+}
+
+// getVmDiskID returns the DISK_ID of the VM disk built from the given image
+func getVmDiskID(disks []vm.Disk, imageid int) (int, error) {
+	for _, disk := range disks {
+		diskimageid, err := disk.Dynamic.GetContentByName("IMAGE_ID")
+		if err != nil {
+			continue
+		}
+		if diskimageid == strconv.Itoa(imageid) {
+			return disk.ID, nil
+		}
+	}
+
+	return -1, fmt.Errorf("No disk found for image %d", imageid)
+}
+
+// generateVmDiskTemplate returns the DISK vector used to attach a disk
+func generateVmDiskTemplate(diskconfig map[string]interface{}) (string, error) {
+	disktpl := &struct {
+		XMLName xml.Name `xml:"TEMPLATE"`
+		Disk    vmDisk   `xml:"DISK"`
+	}{
+		Disk: vmDisk{
+			Image_ID: diskconfig["image_id"].(int),
+			Size:     diskconfig["size"].(int),
+			Target:   diskconfig["target"].(string),
+			Driver:   diskconfig["driver"].(string),
+		},
+	}
+
+	w := &bytes.Buffer{}
+
+	//Encode the DISK vector to XML
+	enc := xml.NewEncoder(w)
+	if err := enc.Encode(disktpl); err != nil {
+		return "", err
+	}
+
+	return w.String(), nil
+}
+
 func resourceOpennebulaVirtualMachineDelete(d *schema.ResourceData, meta interface{}) error {
 	err := resourceOpennebulaVirtualMachineRead(d, meta)
 	if err != nil || d.Id() == "" {
@@ -654,7 +1330,7 @@ func resourceOpennebulaVirtualMachineDelete(d *schema.ResourceData, meta interfa
 		return err
 	}
 
-	_, err = waitForVmState(d, meta, "done")
+	_, err = waitForVmState(d, meta, "done", d.Timeout(schema.TimeoutDelete))
 	if err != nil {
 		return fmt.Errorf(
 			"Error waiting for virtual machine (%s) to be in state DONE: %s", d.Id(), err)
@@ -664,54 +1340,165 @@ func resourceOpennebulaVirtualMachineDelete(d *schema.ResourceData, meta interfa
 	return nil
 }
 
-func waitForVmState(d *schema.ResourceData, meta interface{}, state string) (interface{}, error) {
+func waitForVmState(d *schema.ResourceData, meta interface{}, state string, timeout time.Duration) (interface{}, error) {
+	log.Printf("Waiting for VM (%s) to be in state %s", d.Id(), state)
+
+	// The VM may go through other stable states before reaching the target one
+	pending := []string{"anythingelse"}
+	for _, powerstate := range vmpowerstates {
+		if powerstate != state {
+			pending = append(pending, powerstate)
+		}
+	}
+
+	stateConf := &resource.StateChangeConf{
+		Pending: pending, Target: []string{state},
+		Refresh:    vmStateRefreshFunc(d, meta),
+		Timeout:    timeout,
+		Delay:      10 * time.Second,
+		MinTimeout: 3 * time.Second,
+	}
+
+	return stateConf.WaitForState()
+}
+
+// waitForVmStableState waits for the VM to leave its transient states and
+// returns the power state it reached
+func waitForVmStableState(d *schema.ResourceData, meta interface{}, timeout time.Duration) (string, error) {
+	log.Printf("Waiting for VM (%s) to be in a stable state", d.Id())
+
+	stateConf := &resource.StateChangeConf{
+		Pending:    []string{"anythingelse"},
+		Target:     vmpowerstates,
+		Refresh:    vmStateRefreshFunc(d, meta),
+		Timeout:    timeout,
+		MinTimeout: 3 * time.Second,
+	}
+
+	vminfo, err := stateConf.WaitForState()
+	if err != nil {
+		return "", err
+	}
+
+	return vmPowerState(vminfo.(*vm.VM)), nil
+}
+
+// vmStateRefreshFunc returns the power state of the VM, or "anythingelse"
+// while the VM is in a transient state
+func vmStateRefreshFunc(d *schema.ResourceData, meta interface{}) resource.StateRefreshFunc {
+	return func() (interface{}, string, error) {
+		log.Println("Refreshing VM state...")
+		//Get VM controller
+		vmc, err := getVirtualMachineController(d, meta)
+		if err != nil {
+			return nil, "", fmt.Errorf("Could not find VM by ID %s", d.Id())
+		}
+		vm, err := vmc.Info()
+		if err != nil {
+			if strings.Contains(err.Error(), "Error getting") {
+				return vm, "notfound", nil
+			}
+			return vm, "", err
+		}
+		vmState, vmLcmState, err := vm.State()
+		if err != nil {
+			if strings.Contains(err.Error(), "Error getting") {
+				return vm, "notfound", nil
+			}
+			return vm, "", err
+		}
+		log.Printf("VM %v is currently in state %v and in LCM state %v", vm.ID, vmState, vmLcmState)
+		if vmState == 3 && vmLcmState == 36 {
+			return vm, "boot_failure", fmt.Errorf("VM ID %s entered fail state, error message: %s", d.Id(), vm.UserTemplate.Error)
+		} else if powerstate := vmPowerState(vm); powerstate != "" {
+			return vm, powerstate, nil
+		} else {
+			return vm, "anythingelse", nil
+		}
+	}
+}
+
+// waitForVmGuest waits for the guest to report the expected user template
+// attribute and/or for an IP to be set on the given NIC
+func waitForVmGuest(d *schema.ResourceData, meta interface{}, waitfor map[string]interface{}) (interface{}, error) {
 	var vm *vm.VM
-	var err error
-	//Get VM controller
+
 	vmc, err := getVirtualMachineController(d, meta)
 	if err != nil {
 		return vm, err
 	}
 
-	log.Printf("Waiting for VM (%s) to be in state Done", d.Id())
+	attribute := waitfor["attribute"].(string)
+	value := waitfor["value"].(string)
+	nicid := waitfor["nic_id"].(int)
+
+	timeout, err := time.ParseDuration(waitfor["timeout"].(string))
+	if err != nil {
+		return vm, err
+	}
+
+	// A wrong nic_id would make the wait last until the timeout
+	if nicid >= 0 {
+		vm, err = vmc.Info()
+		if err != nil {
+			return vm, err
+		}
+		found := false
+		for _, nic := range vm.Template.NICs {
+			if nic.ID == nicid {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return vm, fmt.Errorf("VM ID %s has no NIC %d", d.Id(), nicid)
+		}
+	}
+
+	log.Printf("Waiting for VM (%s) guest to be ready", d.Id())
 
 	stateConf := &resource.StateChangeConf{
-		Pending: []string{"anythingelse"}, Target: []string{state},
+		Pending: []string{"waiting"},
+		Target:  []string{"ready"},
 		Refresh: func() (interface{}, string, error) {
-			log.Println("Refreshing VM state...")
-			if d.Id() != "" {
-				//Get VM controller
-				vmc, err = getVirtualMachineController(d, meta)
-				if err != nil {
-					return vm, "", fmt.Errorf("Could not find VM by ID %s", d.Id())
-				}
-			}
+			log.Println("Refreshing VM guest state...")
 			vm, err = vmc.Info()
 			if err != nil {
-				if strings.Contains(err.Error(), "Error getting") {
-					return vm, "notfound", nil
-				}
 				return vm, "", err
 			}
 			vmState, vmLcmState, err := vm.State()
 			if err != nil {
-				if strings.Contains(err.Error(), "Error getting") {
-					return vm, "notfound", nil
-				}
 				return vm, "", err
 			}
-			log.Printf("VM %v is currently in state %v and in LCM state %v", vm.ID, vmState, vmLcmState)
-			if vmState == 3 && vmLcmState == 3 {
-				return vm, "running", nil
-			} else if vmState == 6 {
-				return vm, "done", nil
-			} else if vmState == 3 && vmLcmState == 36 {
-				return vm, "boot_failure", fmt.Errorf("VM ID %s entered fail state, error message: %s", d.Id(), vm.UserTemplate.Error)
-			} else {
-				return vm, "anythingelse", nil
+			if vmState != 3 || vmLcmState != 3 {
+				return vm, "", fmt.Errorf("VM ID %s left RUNNING state, state %v, LCM state %v", d.Id(), vmState, vmLcmState)
+			}
+
+			if attribute != "" {
+				content, err := vm.UserTemplate.Dynamic.GetContentByName(attribute)
+				if err != nil || content != value {
+					log.Printf("VM %v attribute %s is not yet %s", vm.ID, attribute, value)
+					return vm, "waiting", nil
+				}
+			}
+
+			if nicid >= 0 {
+				found := false
+				for _, nic := range vm.Template.NICs {
+					if nic.ID == nicid && nic.IP != "" {
+						found = true
+						break
+					}
+				}
+				if !found {
+					log.Printf("VM %v NIC %d has no IP yet", vm.ID, nicid)
+					return vm, "waiting", nil
+				}
 			}
+
+			return vm, "ready", nil
 		},
-		Timeout:    10 * time.Minute,
+		Timeout:    timeout,
 		Delay:      10 * time.Second,
 		MinTimeout: 3 * time.Second,
 	}
@@ -855,6 +1642,15 @@ func generateVmXML(d *schema.ResourceData) (string, error) {
 		}
 	}
 
+	//Generate VMGROUP definition
+	if g, ok := d.GetOk("vmgroup"); ok {
+		vmgroupconfig := g.([]interface{})[0].(map[string]interface{})
+		vmtpl.VMGroup = &vmVMGroup{
+			ID:   vmgroupconfig["id"].(int),
+			Role: vmgroupconfig["role"].(string),
+		}
+	}
+
 	w := &bytes.Buffer{}
 
 	//Encode the VM template schema to XML
@@ -877,6 +1673,13 @@ func resourceVMNicHash(v interface{}) int {
 	return hashcode.String(buf.String())
 }
 
+func resourceVMDiskHash(v interface{}) int {
+	var buf bytes.Buffer
+	m := v.(map[string]interface{})
+	buf.WriteString(fmt.Sprintf("%d-", m["image_id"].(int)))
+	return hashcode.String(buf.String())
+}
+
 func resourceVMCustomizeDiff(diff *schema.ResourceDiff, v interface{}) error {
 	// If the VM is in error state, force the VM to be recreated
 	if diff.Get("lcmstate") == 36 {
@@ -887,5 +1690,28 @@ func resourceVMCustomizeDiff(diff *schema.ResourceDiff, v interface{}) error {
 		}
 	}
 
+	return validateVmWaitFor(diff)
+}
+
+// validateVmWaitFor rejects at plan time the wait_for blocks which couldn't be
+// waited for during the creation
+func validateVmWaitFor(diff *schema.ResourceDiff) error {
+	waitfor, ok := diff.GetOk("wait_for")
+	if !ok {
+		return nil
+	}
+
+	if diff.NewValueKnown("power_state") && diff.Get("power_state").(string) != "running" {
+		return fmt.Errorf("wait_for needs the power_state of the VM to be running")
+	}
+
+	waitforconfig, ok := waitfor.([]interface{})[0].(map[string]interface{})
+	if !ok || !diff.NewValueKnown("wait_for.0.attribute") || !diff.NewValueKnown("wait_for.0.nic_id") {
+		return nil
+	}
+	if waitforconfig["attribute"].(string) == "" && waitforconfig["nic_id"].(int) < 0 {
+		return fmt.Errorf("wait_for needs an attribute or a nic_id to wait for")
+	}
+
 	return nil
 }
@@ -0,0 +1,357 @@
+package opennebula
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"github.com/hashicorp/terraform/helper/schema"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/OpenNebula/addon-terraform/opennebula/onetemplate"
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+)
+
+type vmGroupTemplate struct {
+	XMLName     xml.Name              `xml:"TEMPLATE"`
+	Name        string                `xml:"NAME,omitempty"`
+	Roles       []vmGroupRoleTemplate `xml:"ROLE"`
+	Affined     []string              `xml:"AFFINED"`
+	AntiAffined []string              `xml:"ANTI_AFFINED"`
+}
+
+type vmGroupRoleTemplate struct {
+	Name            string `xml:"NAME"`
+	Policy          string `xml:"POLICY,omitempty"`
+	HostAffined     string `xml:"HOST_AFFINED,omitempty"`
+	HostAntiAffined string `xml:"HOST_ANTI_AFFINED,omitempty"`
+}
+
+var vmgrouppolicies = []string{"NONE", "AFFINED", "ANTI_AFFINED"}
+var vmgroupruletypes = []string{"AFFINED", "ANTI_AFFINED"}
+
+func resourceOpennebulaVirtualMachineGroup() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceOpennebulaVirtualMachineGroupCreate,
+		Read:   resourceOpennebulaVirtualMachineGroupRead,
+		Exists: resourceOpennebulaVirtualMachineGroupExists,
+		Update: resourceOpennebulaVirtualMachineGroupUpdate,
+		Delete: resourceOpennebulaVirtualMachineGroupDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Name of the Virtual Machine Group",
+			},
+			"zone_id": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				ForceNew:    true,
+				Default:     -1,
+				Description: "ID of the Zone of the Virtual Machine Group. If not set, it uses the zone of the provider",
+			},
+			"role": {
+				Type:        schema.TypeList,
+				Required:    true,
+				ForceNew:    true,
+				MinItems:    1,
+				Description: "Roles of the Virtual Machine Group",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"policy": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Default:     "NONE",
+							Description: "Placement of the VMs of the role between them: NONE, AFFINED (same host), ANTI_AFFINED (different hosts)",
+							ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+								value := v.(string)
+
+								if inArray(value, vmgrouppolicies) < 0 {
+									errors = append(errors, fmt.Errorf("Policy %q must be one of: %s", k, strings.Join(vmgrouppolicies, ",")))
+								}
+
+								return
+							},
+						},
+						"host_affined": {
+							Type:        schema.TypeList,
+							Optional:    true,
+							Description: "IDs of the hosts where the VMs of the role must run",
+							Elem: &schema.Schema{
+								Type: schema.TypeInt,
+							},
+						},
+						"host_anti_affined": {
+							Type:        schema.TypeList,
+							Optional:    true,
+							Description: "IDs of the hosts where the VMs of the role must not run",
+							Elem: &schema.Schema{
+								Type: schema.TypeInt,
+							},
+						},
+					},
+				},
+			},
+			"role_rule": {
+				Type:        schema.TypeSet,
+				Optional:    true,
+				Description: "Placement rules between roles",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"type": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "Placement of the VMs of the roles: AFFINED (same hosts), ANTI_AFFINED (different hosts)",
+							ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+								value := v.(string)
+
+								if inArray(value, vmgroupruletypes) < 0 {
+									errors = append(errors, fmt.Errorf("Type %q must be one of: %s", k, strings.Join(vmgroupruletypes, ",")))
+								}
+
+								return
+							},
+						},
+						"roles": {
+							Type:        schema.TypeList,
+							Required:    true,
+							MinItems:    2,
+							Description: "Names of the roles of the rule",
+							Elem: &schema.Schema{
+								Type: schema.TypeString,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func getVirtualMachineGroupController(d *schema.ResourceData, meta interface{}) (*goca.VMGroupController, error) {
+	controller := zoneController(d, meta)
+	var vmgc *goca.VMGroupController
+
+	// Try to find the VM Group by ID, if specified
+	if d.Id() != "" {
+		vmgid, err := strconv.ParseUint(d.Id(), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("VM Group Id (%s) is not an integer", d.Id())
+		}
+		vmgc = controller.VMGroup(int(vmgid))
+	}
+
+	// Otherwise, try to find the VM Group by name as the de facto compound primary key
+	if d.Id() == "" {
+		vmgid, err := controller.VMGroups().ByName(d.Get("name").(string))
+		if err != nil {
+			d.SetId("")
+			return nil, fmt.Errorf("Could not find VM Group with name %s", d.Get("name").(string))
+		}
+		vmgc = controller.VMGroup(vmgid)
+	}
+
+	return vmgc, nil
+}
+
+// generateVMGroupTemplate returns the template with the roles and the placement rules between them
+func generateVMGroupTemplate(d *schema.ResourceData) *vmGroupTemplate {
+	vmgtpl := &vmGroupTemplate{}
+
+	for _, r := range d.Get("role").([]interface{}) {
+		roleconfig := r.(map[string]interface{})
+		vmgtpl.Roles = append(vmgtpl.Roles, vmGroupRoleTemplate{
+			Name:            roleconfig["name"].(string),
+			Policy:          roleconfig["policy"].(string),
+			HostAffined:     ArrayToString(roleconfig["host_affined"].([]interface{}), ","),
+			HostAntiAffined: ArrayToString(roleconfig["host_anti_affined"].([]interface{}), ","),
+		})
+	}
+
+	for _, r := range d.Get("role_rule").(*schema.Set).List() {
+		ruleconfig := r.(map[string]interface{})
+		roles := make([]string, 0)
+		for _, role := range ruleconfig["roles"].([]interface{}) {
+			roles = append(roles, role.(string))
+		}
+
+		if ruleconfig["type"].(string) == "AFFINED" {
+			vmgtpl.Affined = append(vmgtpl.Affined, strings.Join(roles, ","))
+		} else {
+			vmgtpl.AntiAffined = append(vmgtpl.AntiAffined, strings.Join(roles, ","))
+		}
+	}
+
+	return vmgtpl
+}
+
+func encodeVMGroupTemplate(vmgtpl *vmGroupTemplate) (string, error) {
+	w := &bytes.Buffer{}
+
+	//Encode the VM Group template to XML
+	enc := xml.NewEncoder(w)
+	if err := enc.Encode(vmgtpl); err != nil {
+		return "", err
+	}
+
+	return w.String(), nil
+}
+
+func resourceOpennebulaVirtualMachineGroupCreate(d *schema.ResourceData, meta interface{}) error {
+	controller := zoneController(d, meta)
+
+	vmgtpl := generateVMGroupTemplate(d)
+	vmgtpl.Name = d.Get("name").(string)
+
+	vmgxml, err := encodeVMGroupTemplate(vmgtpl)
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] VM Group definition: %s", vmgxml)
+
+	vmgID, err := controller.VMGroups().Create(vmgxml)
+	if err != nil {
+		return err
+	}
+	d.SetId(fmt.Sprintf("%v", vmgID))
+
+	return resourceOpennebulaVirtualMachineGroupRead(d, meta)
+}
+
+// parseIntList returns the IDs of a comma separated list
+func parseIntList(list string) []int {
+	ids := make([]int, 0)
+	for _, v := range strings.Split(list, ",") {
+		if id, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func resourceOpennebulaVirtualMachineGroupRead(d *schema.ResourceData, meta interface{}) error {
+	vmgc, err := getVirtualMachineGroupController(d, meta)
+	if err != nil {
+		return err
+	}
+
+	vmg, err := vmgc.Info()
+	if err != nil {
+		return err
+	}
+
+	d.SetId(fmt.Sprintf("%v", vmg.ID))
+	readZoneID(d)
+	d.Set("name", vmg.Name)
+
+	roles := make([]map[string]interface{}, 0, len(vmg.Roles))
+	for _, role := range vmg.Roles {
+		policy := role.Policy
+		if policy == "" {
+			policy = "NONE"
+		}
+		roles = append(roles, map[string]interface{}{
+			"name":              role.Name,
+			"policy":            policy,
+			"host_affined":      parseIntList(role.HostAffined),
+			"host_anti_affined": parseIntList(role.HostAntiAffined),
+		})
+	}
+	err = d.Set("role", roles)
+	if err != nil {
+		log.Printf("[DEBUG] Error setting roles on VM group: %s", err)
+	}
+
+	// The rules between roles are kept in the template
+	tpl, err := getObjectTemplate(zoneController(d, meta), "one.vmgroup.info", vmg.ID)
+	if err != nil {
+		return err
+	}
+
+	rules := make([]map[string]interface{}, 0)
+	for _, e := range tpl.Elements {
+		pair, ok := e.(*onetemplate.Pair)
+		if !ok || inArray(pair.Key, vmgroupruletypes) < 0 {
+			continue
+		}
+		names := make([]string, 0)
+		for _, name := range strings.Split(pair.Value, ",") {
+			names = append(names, strings.TrimSpace(name))
+		}
+		rules = append(rules, map[string]interface{}{
+			"type":  pair.Key,
+			"roles": names,
+		})
+	}
+	err = d.Set("role_rule", rules)
+	if err != nil {
+		log.Printf("[DEBUG] Error setting role rules on VM group: %s", err)
+	}
+
+	return nil
+}
+
+func resourceOpennebulaVirtualMachineGroupExists(d *schema.ResourceData, meta interface{}) (bool, error) {
+	err := resourceOpennebulaVirtualMachineGroupRead(d, meta)
+	if err != nil || d.Id() == "" {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func resourceOpennebulaVirtualMachineGroupUpdate(d *schema.ResourceData, meta interface{}) error {
+	vmgc, err := getVirtualMachineGroupController(d, meta)
+	if err != nil {
+		return err
+	}
+
+	if d.HasChange("name") {
+		err = vmgc.Rename(d.Get("name").(string))
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated name for VM Group %s\n", d.Get("name"))
+	}
+
+	if d.HasChange("role_rule") {
+		vmgxml, err := encodeVMGroupTemplate(generateVMGroupTemplate(d))
+		if err != nil {
+			return err
+		}
+
+		// Roles are kept as is, previous rules are erased
+		err = vmgc.Update(vmgxml, 0)
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated role rules for VM Group %s\n", d.Get("name"))
+	}
+
+	return resourceOpennebulaVirtualMachineGroupRead(d, meta)
+}
+
+func resourceOpennebulaVirtualMachineGroupDelete(d *schema.ResourceData, meta interface{}) error {
+	vmgc, err := getVirtualMachineGroupController(d, meta)
+	if err != nil {
+		return err
+	}
+
+	err = vmgc.Delete()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Successfully deleted VM Group ID %s\n", d.Id())
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/terraform"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+)
+
+func TestAccVirtualMachineGroup(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVirtualMachineGroupDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccVirtualMachineGroupConfigBasic,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_virtual_machine_group.vmgroup", "name", "terravmgroup"),
+					resource.TestCheckResourceAttr("opennebula_virtual_machine_group.vmgroup", "role.#", "2"),
+					resource.TestCheckResourceAttr("opennebula_virtual_machine_group.vmgroup", "role.0.name", "frontend"),
+					resource.TestCheckResourceAttr("opennebula_virtual_machine_group.vmgroup", "role.0.policy", "ANTI_AFFINED"),
+					resource.TestCheckResourceAttr("opennebula_virtual_machine_group.vmgroup", "role.1.name", "backend"),
+					resource.TestCheckResourceAttr("opennebula_virtual_machine_group.vmgroup", "role.1.policy", "AFFINED"),
+					resource.TestCheckResourceAttr("opennebula_virtual_machine.vm", "vmgroup.0.role", "frontend"),
+				),
+			},
+			{
+				Config: testAccVirtualMachineGroupConfigUpdate,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_virtual_machine_group.vmgroup", "name", "terravmgroup-renamed"),
+					resource.TestCheckResourceAttr("opennebula_virtual_machine_group.vmgroup", "role.#", "2"),
+					resource.TestCheckResourceAttr("opennebula_virtual_machine_group.vmgroup", "role_rule.#", "1"),
+					testAccCheckVirtualMachineGroupRule("opennebula_virtual_machine_group.vmgroup", "ANTI_AFFINED", "frontend", "backend"),
+				),
+			},
+			{
+				ResourceName:      "opennebula_virtual_machine_group.vmgroup",
+				ImportState:       true,
+				ImportStateVerify: true,
+			},
+		},
+	})
+}
+
+func testAccCheckVirtualMachineGroupDestroy(s *terraform.State) error {
+	controller := testAccProvider.Meta().(*goca.Controller)
+
+	for _, rs := range s.RootModule().Resources {
+		if rs.Type != "opennebula_virtual_machine_group" {
+			continue
+		}
+		vmgID, _ := strconv.ParseUint(rs.Primary.ID, 10, 64)
+		vmgc := controller.VMGroup(int(vmgID))
+		// Get VM Group Info
+		vmg, _ := vmgc.Info()
+		if vmg != nil {
+			return fmt.Errorf("Expected VM group %s to have been destroyed", rs.Primary.ID)
+		}
+	}
+
+	return nil
+}
+
+// testAccCheckVirtualMachineGroupRule checks the VM group has a role rule of
+// the type between the roles
+func testAccCheckVirtualMachineGroupRule(name, ruleType string, roles ...string) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		rs, ok := s.RootModule().Resources[name]
+		if !ok {
+			return fmt.Errorf("Not found: %s", name)
+		}
+
+		for key, value := range rs.Primary.Attributes {
+			if !strings.HasPrefix(key, "role_rule.") || !strings.HasSuffix(key, ".type") || value != ruleType {
+				continue
+			}
+			prefix := strings.TrimSuffix(key, "type")
+			if rs.Primary.Attributes[prefix+"roles.#"] != strconv.Itoa(len(roles)) {
+				continue
+			}
+			found := true
+			for i, role := range roles {
+				if rs.Primary.Attributes[prefix+"roles."+strconv.Itoa(i)] != role {
+					found = false
+				}
+			}
+			if found {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("%s: no %s rule between %s", name, ruleType, strings.Join(roles, ","))
+	}
+}
+
+var testAccVirtualMachineGroupConfigBasic = `
+resource "opennebula_virtual_machine_group" "vmgroup" {
+  name = "terravmgroup"
+
+  role {
+    name = "frontend"
+    policy = "ANTI_AFFINED"
+  }
+
+  role {
+    name = "backend"
+    policy = "AFFINED"
+  }
+}
+
+resource "opennebula_virtual_machine" "vm" {
+  name = "test-virtual_machine-group"
+  group = "oneadmin"
+  permissions = "642"
+  memory = 128
+  cpu = 0.1
+
+  vmgroup {
+    id = "${opennebula_virtual_machine_group.vmgroup.id}"
+    role = "frontend"
+  }
+}
+`
+
+var testAccVirtualMachineGroupConfigUpdate = `
+resource "opennebula_virtual_machine_group" "vmgroup" {
+  name = "terravmgroup-renamed"
+
+  role {
+    name = "frontend"
+    policy = "ANTI_AFFINED"
+  }
+
+  role {
+    name = "backend"
+    policy = "AFFINED"
+  }
+
+  role_rule {
+    type = "ANTI_AFFINED"
+    roles = ["frontend", "backend"]
+  }
+}
+`
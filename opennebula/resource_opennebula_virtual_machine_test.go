@@ -0,0 +1,500 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/config"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/vm"
+)
+
+func TestAccVirtualMachine(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVirtualMachineDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccVirtualMachineConfigBasic,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_virtual_machine.vm", "name", "terravm"),
+					resource.TestCheckResourceAttr("opennebula_virtual_machine.vm", "cpu", "0.1"),
+					resource.TestCheckResourceAttr("opennebula_virtual_machine.vm", "memory", "64"),
+					resource.TestCheckResourceAttr("opennebula_virtual_machine.vm", "power_state", "running"),
+					resource.TestCheckResourceAttr("opennebula_virtual_machine.vm", "disk.#", "1"),
+					resource.TestCheckResourceAttr("opennebula_virtual_machine.vm", "nic.#", "1"),
+					resource.TestCheckResourceAttr("opennebula_virtual_machine.vm", "ip", "172.16.104.110"),
+					testAccCheckVirtualMachineDisks("opennebula_image.first"),
+					testAccCheckVirtualMachineSecurityGroups("opennebula_security_group.first"),
+				),
+			},
+			{
+				// The stand-in refuses to resize a running VM, the resize
+				// goes through a power off
+				Config: testAccVirtualMachineConfigUpdate,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_virtual_machine.vm", "cpu", "0.2"),
+					resource.TestCheckResourceAttr("opennebula_virtual_machine.vm", "memory", "128"),
+					resource.TestCheckResourceAttr("opennebula_virtual_machine.vm", "power_state", "running"),
+					resource.TestCheckResourceAttr("opennebula_virtual_machine.vm", "disk.#", "1"),
+					resource.TestCheckResourceAttr("opennebula_virtual_machine.vm", "nic.#", "2"),
+					testAccCheckVirtualMachineDisks("opennebula_image.second"),
+					testAccCheckVirtualMachineSecurityGroups("opennebula_security_group.second"),
+				),
+			},
+			{
+				Config: testAccVirtualMachineConfigPoweroff,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_virtual_machine.vm", "power_state", "poweroff"),
+					resource.TestCheckResourceAttr("opennebula_virtual_machine.vm", "state", "8"),
+					resource.TestCheckResourceAttr("opennebula_virtual_machine.vm", "disk.#", "2"),
+					resource.TestCheckResourceAttr("opennebula_virtual_machine.vm", "nic.#", "1"),
+					testAccCheckVirtualMachineDisks("opennebula_image.first", "opennebula_image.second"),
+					testAccCheckVirtualMachineSecurityGroups(),
+				),
+			},
+		},
+	})
+}
+
+func testAccCheckVirtualMachineDestroy(s *terraform.State) error {
+	controller := testAccProvider.Meta().(*goca.Controller)
+
+	for _, rs := range s.RootModule().Resources {
+		if rs.Type != "opennebula_virtual_machine" {
+			continue
+		}
+		vmID, _ := strconv.ParseUint(rs.Primary.ID, 10, 64)
+		vmc := controller.VM(int(vmID))
+		// Get VM Info, a terminated VM stays in the DONE state
+		vm, _ := vmc.Info()
+		if vm != nil && vmPowerState(vm) != "done" {
+			return fmt.Errorf("Expected virtual machine %s to have been destroyed", rs.Primary.ID)
+		}
+	}
+
+	return nil
+}
+
+// testAccResourceIDs returns the sorted IDs of the resources
+func testAccResourceIDs(s *terraform.State, names []string) ([]string, error) {
+	ids := []string{}
+	for _, name := range names {
+		rs, ok := s.RootModule().Resources[name]
+		if !ok {
+			return nil, fmt.Errorf("Resource %s not found", name)
+		}
+		ids = append(ids, rs.Primary.ID)
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+// testAccVirtualMachineAttributes returns the sorted values of the VM
+// attributes matching the prefix and the suffix, without the counts
+func testAccVirtualMachineAttributes(s *terraform.State, prefix, suffix string) []string {
+	values := []string{}
+	for k, v := range s.RootModule().Resources["opennebula_virtual_machine.vm"].Primary.Attributes {
+		if strings.HasPrefix(k, prefix) && strings.Contains(k, suffix) && !strings.HasSuffix(k, ".#") {
+			values = append(values, v)
+		}
+	}
+	sort.Strings(values)
+
+	return values
+}
+
+// testAccCheckVirtualMachineDisks checks the disks of the VM use the images
+func testAccCheckVirtualMachineDisks(images ...string) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		expected, err := testAccResourceIDs(s, images)
+		if err != nil {
+			return err
+		}
+
+		if ids := testAccVirtualMachineAttributes(s, "disk.", ".image_id"); !reflect.DeepEqual(ids, expected) {
+			return fmt.Errorf("Expected the disks to use the images %v, got %v", expected, ids)
+		}
+
+		return nil
+	}
+}
+
+// testAccCheckVirtualMachineSecurityGroups checks the NICs of the VM list the
+// security groups, and not the ones inherited from their virtual network
+func testAccCheckVirtualMachineSecurityGroups(secgroups ...string) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		expected, err := testAccResourceIDs(s, secgroups)
+		if err != nil {
+			return err
+		}
+
+		if ids := testAccVirtualMachineAttributes(s, "nic.", ".security_groups."); !reflect.DeepEqual(ids, expected) {
+			return fmt.Errorf("Expected the NICs to have the security groups %v, got %v", expected, ids)
+		}
+
+		return nil
+	}
+}
+
+var testAccVirtualMachineResources = `
+resource "opennebula_security_group" "first" {
+  name = "terravm-first"
+  rule {
+    protocol = "ALL"
+    rule_type = "OUTBOUND"
+  }
+}
+
+resource "opennebula_security_group" "second" {
+  name = "terravm-second"
+  rule {
+    protocol = "TCP"
+    rule_type = "INBOUND"
+    range = "22"
+  }
+}
+
+resource "opennebula_virtual_network" "vnet" {
+  name = "terravm-vnet"
+  physical_device = "dummy0"
+  type            = "vxlan"
+  vlan_id         = "8000050"
+  mtu             = 1500
+  ar {
+    ar_type = "IP4"
+    size    = 16
+    ip4     = "172.16.104.110"
+  }
+  permissions = "642"
+  security_groups = [0]
+  clusters = [0]
+}
+
+resource "opennebula_image" "first" {
+  name = "terravm-first"
+  datastore_id = 1
+  type = "DATABLOCK"
+  size = "64"
+  dev_prefix = "vd"
+}
+
+resource "opennebula_image" "second" {
+  name = "terravm-second"
+  datastore_id = 1
+  type = "DATABLOCK"
+  size = "64"
+  dev_prefix = "vd"
+}
+`
+
+var testAccVirtualMachineConfigBasic = testAccVirtualMachineResources + `
+resource "opennebula_virtual_machine" "vm" {
+  name = "terravm"
+  cpu = 0.1
+  memory = 64
+
+  disk {
+    image_id = "${opennebula_image.first.id}"
+  }
+
+  nic {
+    network_id = "${opennebula_virtual_network.vnet.id}"
+    security_groups = ["${opennebula_security_group.first.id}"]
+  }
+
+  wait_for {
+    nic_id = 0
+    timeout = "1m"
+  }
+
+  timeouts {
+    create = "2m"
+    update = "2m"
+    delete = "2m"
+  }
+}
+`
+
+var testAccVirtualMachineConfigUpdate = testAccVirtualMachineResources + `
+resource "opennebula_virtual_machine" "vm" {
+  name = "terravm"
+  cpu = 0.2
+  memory = 128
+
+  disk {
+    image_id = "${opennebula_image.second.id}"
+  }
+
+  nic {
+    network_id = "${opennebula_virtual_network.vnet.id}"
+    security_groups = ["${opennebula_security_group.second.id}"]
+  }
+
+  nic {
+    network_id = "${opennebula_virtual_network.vnet.id}"
+    model = "virtio"
+  }
+
+  wait_for {
+    nic_id = 0
+    timeout = "1m"
+  }
+
+  timeouts {
+    create = "2m"
+    update = "2m"
+    delete = "2m"
+  }
+}
+`
+
+var testAccVirtualMachineConfigPoweroff = testAccVirtualMachineResources + `
+resource "opennebula_virtual_machine" "vm" {
+  name = "terravm"
+  cpu = 0.2
+  memory = 128
+  power_state = "poweroff"
+
+  disk {
+    image_id = "${opennebula_image.first.id}"
+  }
+
+  disk {
+    image_id = "${opennebula_image.second.id}"
+  }
+
+  nic {
+    network_id = "${opennebula_virtual_network.vnet.id}"
+    model = "virtio"
+  }
+
+  wait_for {
+    nic_id = 0
+    timeout = "1m"
+  }
+
+  timeouts {
+    create = "2m"
+    update = "2m"
+    delete = "2m"
+  }
+}
+`
+
+func testVMNic(networkid int, secgroups ...interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"model":           "virtio",
+		"network_id":      networkid,
+		"security_groups": secgroups,
+	}
+}
+
+func TestGetVmNicChanges(t *testing.T) {
+	cases := []struct {
+		name           string
+		onics          []interface{}
+		nnics          []interface{}
+		expectedDetach []int
+		expectedAttach []int
+	}{
+		{
+			name:  "unchanged",
+			onics: []interface{}{testVMNic(1, 100), testVMNic(2)},
+			nnics: []interface{}{testVMNic(1, 100), testVMNic(2)},
+		},
+		{
+			name:  "security groups in another order",
+			onics: []interface{}{testVMNic(1, 100, 101)},
+			nnics: []interface{}{testVMNic(1, 101, 100)},
+		},
+		{
+			name:           "NIC added and removed",
+			onics:          []interface{}{testVMNic(1), testVMNic(2)},
+			nnics:          []interface{}{testVMNic(1), testVMNic(3)},
+			expectedDetach: []int{2},
+			expectedAttach: []int{3},
+		},
+		{
+			name:           "security group added",
+			onics:          []interface{}{testVMNic(1, 100)},
+			nnics:          []interface{}{testVMNic(1, 100, 101)},
+			expectedDetach: []int{1},
+			expectedAttach: []int{1},
+		},
+		{
+			name:           "security groups cleared",
+			onics:          []interface{}{testVMNic(1, 100), testVMNic(2)},
+			nnics:          []interface{}{testVMNic(1), testVMNic(2)},
+			expectedDetach: []int{1},
+			expectedAttach: []int{1},
+		},
+	}
+
+	networkIDs := func(nics []interface{}) []int {
+		ids := []int{}
+		for _, nic := range nics {
+			ids = append(ids, nic.(map[string]interface{})["network_id"].(int))
+		}
+		return ids
+	}
+
+	for _, tc := range cases {
+		onics := schema.NewSet(resourceVMNicHash, tc.onics)
+		nnics := schema.NewSet(resourceVMNicHash, tc.nnics)
+
+		detach, attach := getVmNicChanges(onics, nnics)
+		if tc.expectedDetach == nil {
+			tc.expectedDetach = []int{}
+		}
+		if tc.expectedAttach == nil {
+			tc.expectedAttach = []int{}
+		}
+		if ids := networkIDs(detach); !reflect.DeepEqual(ids, tc.expectedDetach) {
+			t.Errorf("%s: expected to detach %v, got %v", tc.name, tc.expectedDetach, ids)
+		}
+		if ids := networkIDs(attach); !reflect.DeepEqual(ids, tc.expectedAttach) {
+			t.Errorf("%s: expected to attach %v, got %v", tc.name, tc.expectedAttach, ids)
+		}
+	}
+}
+
+func TestVmNicSecurityGroups(t *testing.T) {
+	cases := []struct {
+		name       string
+		nic        []int
+		vnet       []int
+		configured []interface{}
+		expected   []int
+	}{
+		{
+			name:     "default security group of the network",
+			nic:      []int{0, 100},
+			vnet:     []int{0},
+			expected: []int{100},
+		},
+		{
+			name:     "only inherited security groups",
+			nic:      []int{0},
+			vnet:     []int{0},
+			expected: []int{},
+		},
+		{
+			name:       "inherited security group also configured",
+			nic:        []int{0, 100},
+			vnet:       []int{0},
+			configured: []interface{}{0, 100},
+			expected:   []int{0, 100},
+		},
+		{
+			name:     "network without security groups",
+			nic:      []int{100, 101},
+			vnet:     []int{},
+			expected: []int{100, 101},
+		},
+	}
+
+	for _, tc := range cases {
+		secgroups := vmNicSecurityGroups(tc.nic, tc.vnet, tc.configured)
+		if !reflect.DeepEqual(secgroups, tc.expected) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, secgroups)
+		}
+	}
+}
+
+func TestVmPowerState(t *testing.T) {
+	cases := []struct {
+		name     string
+		state    int
+		lcmState int
+		expected string
+	}{
+		{name: "running", state: 3, lcmState: 3, expected: "running"},
+		{name: "booting", state: 3, lcmState: 2, expected: ""},
+		{name: "disk hotplug", state: 3, lcmState: 17, expected: ""},
+		{name: "pending", state: 1, lcmState: 0, expected: ""},
+		{name: "stopped", state: 4, lcmState: 0, expected: "stopped"},
+		{name: "suspended", state: 5, lcmState: 0, expected: "suspended"},
+		{name: "done", state: 6, lcmState: 0, expected: "done"},
+		{name: "poweroff", state: 8, lcmState: 0, expected: "poweroff"},
+		{name: "undeployed", state: 9, lcmState: 0, expected: "undeployed"},
+	}
+
+	for _, tc := range cases {
+		vminfo := &vm.VM{StateRaw: tc.state, LCMStateRaw: tc.lcmState}
+		if powerstate := vmPowerState(vminfo); powerstate != tc.expected {
+			t.Errorf("%s: expected power state %q, got %q", tc.name, tc.expected, powerstate)
+		}
+	}
+}
+
+func TestVmWaitForDiff(t *testing.T) {
+	cases := []struct {
+		name    string
+		config  map[string]interface{}
+		invalid bool
+	}{
+		{
+			name: "attribute",
+			config: map[string]interface{}{
+				"name":     "terravm",
+				"wait_for": []interface{}{map[string]interface{}{"attribute": "READY"}},
+			},
+		},
+		{
+			name: "nic_id",
+			config: map[string]interface{}{
+				"name":     "terravm",
+				"wait_for": []interface{}{map[string]interface{}{"nic_id": 0}},
+			},
+		},
+		{
+			name: "nothing to wait for",
+			config: map[string]interface{}{
+				"name":     "terravm",
+				"wait_for": []interface{}{map[string]interface{}{"value": "YES"}},
+			},
+			invalid: true,
+		},
+		{
+			name: "powered off",
+			config: map[string]interface{}{
+				"name":        "terravm",
+				"power_state": "poweroff",
+				"wait_for":    []interface{}{map[string]interface{}{"attribute": "READY"}},
+			},
+			invalid: true,
+		},
+		{
+			name: "powered off without wait_for",
+			config: map[string]interface{}{
+				"name":        "terravm",
+				"power_state": "poweroff",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		raw, err := config.NewRawConfig(tc.config)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+
+		_, err = resourceOpennebulaVirtualMachine().Diff(nil, terraform.NewResourceConfig(raw), nil)
+		if tc.invalid && err == nil {
+			t.Errorf("%s: expected the plan to be rejected", tc.name)
+		}
+		if !tc.invalid && err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.name, err)
+		}
+	}
+}
@@ -5,16 +5,22 @@ import (
 	"encoding/xml"
 	"fmt"
 	"github.com/fatih/structs"
+	"github.com/hashicorp/terraform/helper/resource"
 	"github.com/hashicorp/terraform/helper/schema"
 	"log"
 	"net"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/OpenNebula/addon-terraform/opennebula/onetemplate"
 	"github.com/OpenNebula/one/src/oca/go/src/goca"
 	vn "github.com/OpenNebula/one/src/oca/go/src/goca/schemas/virtualnetwork"
 )
 
+// Address Range types
+var artypes = []string{"IP4", "IP6", "IP6_STATIC", "IP4_6", "IP4_6_STATIC", "ETHER"}
+
 type vnetTemplate struct {
 	Description     string `xml:"DESCRIPTION,omitempty"`
 	Security_Groups string `xml:"SECURITY_GROUPS,omitempty"`
@@ -25,6 +31,8 @@ type vnetTemplate struct {
 	GuestMtu        int    `xml:"GUEST_MTU,omitempty"`
 }
 
+var defaultVNetTimeout = 10 * time.Minute
+
 func resourceOpennebulaVirtualNetwork() *schema.Resource {
 	return &schema.Resource{
 		Create: resourceOpennebulaVirtualNetworkCreate,
@@ -35,6 +43,11 @@ func resourceOpennebulaVirtualNetwork() *schema.Resource {
 		Importer: &schema.ResourceImporter{
 			State: schema.ImportStatePassthrough,
 		},
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(defaultVNetTimeout),
+			Update: schema.DefaultTimeout(defaultVNetTimeout),
+			Delete: schema.DefaultTimeout(defaultVNetTimeout),
+		},
 
 		Schema: map[string]*schema.Schema{
 			"name": {
@@ -42,6 +55,13 @@ func resourceOpennebulaVirtualNetwork() *schema.Resource {
 				Required:    true,
 				Description: "Name of the vnet",
 			},
+			"zone_id": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				ForceNew:    true,
+				Default:     -1,
+				Description: "ID of the Zone of the Virtual Network. If not set, it uses the zone of the provider",
+			},
 			"description": {
 				Type:        schema.TypeString,
 				Optional:    true,
@@ -191,11 +211,10 @@ func resourceOpennebulaVirtualNetwork() *schema.Resource {
 							Default:     "IP4",
 							Description: "Type of the Address Range: IP4, IP6. Default is 'IP4'",
 							ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
-								validtypes := []string{"IP4", "IP6", "IP6_STATIC", "IP4_6", "IP4_6_STATIC", "ETHER"}
 								value := v.(string)
 
-								if inArray(value, validtypes) < 0 {
-									errors = append(errors, fmt.Errorf("Address Range type %q must be one of: %s", k, strings.Join(validtypes, ",")))
+								if inArray(value, artypes) < 0 {
+									errors = append(errors, fmt.Errorf("Address Range type %q must be one of: %s", k, strings.Join(artypes, ",")))
 								}
 
 								return
@@ -283,7 +302,7 @@ func resourceOpennebulaVirtualNetwork() *schema.Resource {
 }
 
 func getVirtualNetworkController(d *schema.ResourceData, meta interface{}, args ...int) (*goca.VirtualNetworkController, error) {
-	controller := meta.(*goca.Controller)
+	controller := zoneController(d, meta)
 	var vnc *goca.VirtualNetworkController
 
 	// Try to find the VNet by ID, if specified
@@ -309,7 +328,7 @@ func getVirtualNetworkController(d *schema.ResourceData, meta interface{}, args
 }
 
 func changeVNetGroup(d *schema.ResourceData, meta interface{}) error {
-	controller := meta.(*goca.Controller)
+	controller := zoneController(d, meta)
 	var gid int
 
 	vnc, err := getVirtualNetworkController(d, meta)
@@ -340,7 +359,7 @@ func validVlanType(intype string) int {
 }
 
 func resourceOpennebulaVirtualNetworkCreate(d *schema.ResourceData, meta interface{}) error {
-	controller := meta.(*goca.Controller)
+	controller := zoneController(d, meta)
 	var vnc *goca.VirtualNetworkController
 
 	//VNET reservation
@@ -356,12 +375,14 @@ func resourceOpennebulaVirtualNetworkCreate(d *schema.ResourceData, meta interfa
 		}
 
 		//The API only takes ATTRIBUTE=VALUE for VNET reservations...
-		reservation_string := "SIZE=%d\nNAME=\"%s\""
+		reservation := &onetemplate.Template{}
+		reservation.AddPair("SIZE", reservation_size)
+		reservation.AddPair("NAME", reservation_name)
 
 		// Get VNet Controller to reserve from
 		vnc = controller.VirtualNetwork(reservation_vnet)
 
-		err := vnc.Reserve(fmt.Sprintf(reservation_string, reservation_size, reservation_name))
+		err := vnc.Reserve(reservation.String())
 		if err != nil {
 			return err
 		}
@@ -379,7 +400,7 @@ func resourceOpennebulaVirtualNetworkCreate(d *schema.ResourceData, meta interfa
 		if securitygroups, ok := d.GetOk("security_groups"); ok {
 			secgrouplist := ArrayToString(securitygroups.([]interface{}), ",")
 
-			err = vnc.Update(fmt.Sprintf("SECURITY_GROUPS=\"%s\"", secgrouplist), 1)
+			err = vnc.Update(generateSecurityGroupsTemplate(secgrouplist), 1)
 			if err != nil {
 				return err
 			}
@@ -404,7 +425,7 @@ func resourceOpennebulaVirtualNetworkCreate(d *schema.ResourceData, meta interfa
 		if securitygroups, ok := d.GetOk("security_groups"); ok {
 			secgrouplist := ArrayToString(securitygroups.([]interface{}), ",")
 
-			err = vnc.Update(fmt.Sprintf("SECURITY_GROUPS=\"%s\"", secgrouplist), 1)
+			err = vnc.Update(generateSecurityGroupsTemplate(secgrouplist), 1)
 			if err != nil {
 				return err
 			}
@@ -431,8 +452,7 @@ func resourceOpennebulaVirtualNetworkCreate(d *schema.ResourceData, meta interfa
 			ip = ip.To4()
 
 			for i := 0; i < d.Get("hold_size").(int); i++ {
-				var address_reservation_string = `LEASES=[IP=%s]`
-				r_err := vnc.Hold(fmt.Sprintf(address_reservation_string, ip))
+				r_err := vnc.Hold(generateLeaseTemplate(ip))
 				if r_err != nil {
 					return r_err
 				}
@@ -473,6 +493,11 @@ func resourceOpennebulaVirtualNetworkCreate(d *schema.ResourceData, meta interfa
 
 	}
 
+	_, err := waitForVNetState(d, meta, "ready", d.Timeout(schema.TimeoutCreate))
+	if err != nil {
+		return fmt.Errorf("Error waiting for Virtual Network (%s) to be in state READY: %s", d.Id(), err)
+	}
+
 	return resourceOpennebulaVirtualNetworkRead(d, meta)
 }
 
@@ -486,154 +511,57 @@ func generateAR(armap map[string]interface{}, id int) string {
 	argprefix := armap["global_prefix"].(string)
 	arulaprefix := armap["ula_prefix"].(string)
 	arprefixlength := armap["prefix_length"].(string)
-	arid := strconv.Itoa(id)
-
-	if artype == "IP4" {
-		if armac != "" {
-			var arstring = `AR = [
-                AR_ID = %s,
-		        TYPE = IP4,
-		        IP = %s,
-		        MAC = %s,
-		        SIZE = %d ]`
-			return fmt.Sprintf(arstring, arid, arip4, armac, arsize)
-		}
-		var arstring = `AR = [
-            AR_ID = %s,
-            TYPE = IP4,
-            IP = %s,
-            SIZE = %d ]`
-		return fmt.Sprintf(arstring, arid, arip4, arsize)
-	}
-	if artype == "IP6" {
-		if armac != "" {
-			if argprefix != "" {
-				if arulaprefix != "" {
-					var arstring = `AR = [
-                        AR_ID = %s,
-		                TYPE = IP6,
-		                MAC = %s,
-		                GLOBAL_PREFIX = %s,
-		                ULA_PREFIX = %s,
-		                SIZE = %d ]`
-					return fmt.Sprintf(arstring, arid, armac, argprefix, arulaprefix, arsize)
-				}
-				var arstring = `AR = [
-                    AR_ID = %s,
-		            TYPE = IP6,
-		            MAC = %s,
-		            GLOBAL_PREFIX = %s,
-		            SIZE = %d ]`
-				return fmt.Sprintf(arstring, arid, armac, argprefix, arsize)
-			}
-			var arstring = `AR = [
-                AR_ID = %s,
-		        TYPE = IP6,
-		        MAC = %s,
-		        SIZE = %d ]`
-			return fmt.Sprintf(arstring, arid, armac, arsize)
-		}
-		var arstring = `AR = [
-            AR_ID = %s,
-		    TYPE = IP6,
-		    SIZE = %d ]`
-		return fmt.Sprintf(arstring, arid, arsize)
-	}
-	if artype == "IP6_STATIC" {
-		if armac != "" {
-			var arstring = `AR = [
-                AR_ID = %s,
-		        TYPE = IP6_STATIC,
-		        MAC = %s,
-		        IP6 = %s,
-		        PREFIX_LENGTH = %s,
-		        SIZE = %d ]`
-			return fmt.Sprintf(arstring, arid, armac, arip6, arprefixlength, arsize)
-		}
-		var arstring = `AR = [
-            AR_ID = %s,
-		    TYPE = IP6_STATIC,
-		    IP6 = %s,
-		    PREFIX_LENGTH = %s,
-		    SIZE = %d ]`
-		return fmt.Sprintf(arstring, arid, arip6, arprefixlength, arsize)
-	}
-	if artype == "IP4_6" {
-		if armac != "" {
-			if argprefix != "" {
-				if arulaprefix != "" {
-					var arstring = `AR = [
-                        AR_ID = %s,
-		                TYPE = IP4_6,
-		                IP = %s,
-		                MAC = %s,
-		                GLOBAL_PREFIX = %s,
-		                ULA_PREFIX = %s,
-		                SIZE = %d ]`
-					return fmt.Sprintf(arstring, arid, arip4, armac, argprefix, arulaprefix, arsize)
-				}
-				var arstring = `AR = [
-                    AR_ID = %s,
-		            TYPE = IP4_6,
-		            IP = %s,
-		            MAC = %s,
-		            GLOBAL_PREFIX = %s,
-		            SIZE = %d ]`
-				return fmt.Sprintf(arstring, arid, arip4, armac, argprefix, arsize)
-			}
-			var arstring = `AR = [
-                AR_ID = %s,
-		        TYPE = IP4_6,
-		        IP = %s,
-		        MAC = %s,
-		        SIZE = %d ]`
-			return fmt.Sprintf(arstring, arid, arip4, armac, arsize)
-		}
-		var arstring = `AR = [
-            AR_ID = %s,
-		    TYPE = IP4_6,
-		    IP = %s,
-		    SIZE = %d ]`
-		return fmt.Sprintf(arstring, arid, arip4, arsize)
-	}
-	if artype == "IP4_6_STATIC" {
-		if armac != "" {
-			var arstring = `AR = [
-                AR_ID = %s,
-		        TYPE = IP4_6_STATIC,
-		        IP = %s,
-		        MAC = %s,
-		        IP6 = %s,
-		        PREFIX_LENGTH = %s,
-		        SIZE = %d ]`
-			return fmt.Sprintf(arstring, arid, arip4, armac, arip6, arprefixlength, arsize)
+
+	if inArray(artype, artypes) < 0 {
+		return ""
+	}
+
+	artpl := &onetemplate.Template{}
+	ar := artpl.AddVector("AR")
+	ar.AddPair("AR_ID", id)
+	ar.AddPair("TYPE", artype)
+
+	// IPv4 first address
+	if artype == "IP4" || artype == "IP4_6" || artype == "IP4_6_STATIC" {
+		ar.AddPair("IP", arip4)
+	}
+	if armac != "" {
+		ar.AddPair("MAC", armac)
+	}
+	// IPv6 addresses built from the MAC and the prefixes
+	if artype == "IP6" || artype == "IP4_6" {
+		if argprefix != "" {
+			ar.AddPair("GLOBAL_PREFIX", argprefix)
 		}
-		var arstring = `AR = [
-            AR_ID = %s,
-		    TYPE = IP4_6_STATIC,
-		    IP = %s,
-		    IP6 = %s,
-		    PREFIX_LENGTH = %s,
-		    SIZE = %d ]`
-		return fmt.Sprintf(arstring, arid, arip4, arip6, arprefixlength, arsize)
-	}
-	if artype == "ETHER" {
-		if armac != "" {
-			var arstring = `AR = [
-                AR_ID = %s,
-		        TYPE = ETHER,
-		        MAC = %s,
-		        SIZE = %d ]`
-			return fmt.Sprintf(arstring, arid, armac, arsize)
+		if arulaprefix != "" {
+			ar.AddPair("ULA_PREFIX", arulaprefix)
 		}
-		var arstring = `AR = [
-            AR_ID = %s,
-		    TYPE = ETHER,
-		    SIZE = %d ]`
-		return fmt.Sprintf(arstring, arid, arsize)
 	}
+	// Static IPv6 addresses
+	if artype == "IP6_STATIC" || artype == "IP4_6_STATIC" {
+		ar.AddPair("IP6", arip6)
+		ar.AddPair("PREFIX_LENGTH", arprefixlength)
+	}
+	ar.AddPair("SIZE", arsize)
+
+	return artpl.String()
+}
+
+// generateSecurityGroupsTemplate returns the template setting the Security
+// Groups of the Virtual Network
+func generateSecurityGroupsTemplate(secgrouplist string) string {
+	sgtpl := &onetemplate.Template{}
+	sgtpl.AddPair("SECURITY_GROUPS", secgrouplist)
 
-	return ""
+	return sgtpl.String()
+}
+
+// generateLeaseTemplate returns the template holding or releasing an IP
+func generateLeaseTemplate(ip net.IP) string {
+	leasetpl := &onetemplate.Template{}
+	leasetpl.AddVector("LEASES").AddPair("IP", ip.String())
+
+	return leasetpl.String()
 }
 
 func generateVnTemplate(d *schema.ResourceData) (string, error) {
@@ -715,7 +643,7 @@ func generateVnXML(d *schema.ResourceData) (string, error) {
 }
 
 func setVnetClusters(d *schema.ResourceData, meta interface{}, id int) error {
-	controller := meta.(*goca.Controller)
+	controller := zoneController(d, meta)
 	clusterPool, err := controller.Clusters().Info()
 	if err != nil {
 		return err
@@ -747,6 +675,7 @@ func resourceOpennebulaVirtualNetworkRead(d *schema.ResourceData, meta interface
 	}
 
 	d.SetId(strconv.Itoa(vn.ID))
+	readZoneID(d)
 	d.Set("name", vn.Name)
 	d.Set("uid", vn.UID)
 	d.Set("gid", vn.GID)
@@ -828,7 +757,10 @@ func resourceOpennebulaVirtualNetworkUpdate(d *schema.ResourceData, meta interfa
 	}
 
 	if d.HasChange("description") {
-		err := vnc.Update(fmt.Sprintf("DESCRIPTION =\"%s\"", d.Get("description").(string)), 1)
+		desctpl := &onetemplate.Template{}
+		desctpl.AddPair("DESCRIPTION", d.Get("description").(string))
+
+		err := vnc.Update(desctpl.String(), 1)
 		if err != nil {
 			return err
 		}
@@ -838,7 +770,7 @@ func resourceOpennebulaVirtualNetworkUpdate(d *schema.ResourceData, meta interfa
 		securitygroups := d.Get("security_groups")
 		secgrouplist := ArrayToString(securitygroups.([]interface{}), ",")
 
-		err := vnc.Update(fmt.Sprintf("SECURITY_GROUPS=\"%s\"", secgrouplist), 1)
+		err := vnc.Update(generateSecurityGroupsTemplate(secgrouplist), 1)
 		if err != nil {
 			return err
 		}
@@ -909,6 +841,10 @@ func resourceOpennebulaVirtualNetworkUpdate(d *schema.ResourceData, meta interfa
 			}
 		}
 
+		_, err = waitForVNetState(d, meta, "ready", d.Timeout(schema.TimeoutUpdate))
+		if err != nil {
+			return fmt.Errorf("Error waiting for Virtual Network (%s) to be in state READY: %s", d.Id(), err)
+		}
 	}
 
 	return nil
@@ -926,8 +862,7 @@ func resourceOpennebulaVirtualNetworkDelete(d *schema.ResourceData, meta interfa
 		ip = ip.To4()
 
 		for i := 0; i < d.Get("reservation_size").(int); i++ {
-			var address_reservation_string = `LEASES=[IP=%s]`
-			r_err := vnc.Release(fmt.Sprintf(address_reservation_string, ip))
+			r_err := vnc.Release(generateLeaseTemplate(ip))
 
 			if r_err != nil {
 				return r_err
@@ -943,6 +878,49 @@ func resourceOpennebulaVirtualNetworkDelete(d *schema.ResourceData, meta interfa
 		return err
 	}
 
+	_, err = waitForVNetState(d, meta, "notfound", d.Timeout(schema.TimeoutDelete))
+	if err != nil {
+		return fmt.Errorf("Error waiting for Virtual Network (%s) to be in state NOTFOUND: %s", d.Id(), err)
+	}
+
 	log.Printf("[INFO] Successfully deleted Vnet\n")
 	return nil
 }
+
+// waitForVNetState polls the Virtual Network until it is available ("ready")
+// or it has been removed ("notfound")
+func waitForVNetState(d *schema.ResourceData, meta interface{}, state string, timeout time.Duration) (interface{}, error) {
+	var vnc *goca.VirtualNetworkController
+	var vnet *vn.VirtualNetwork
+	var err error
+
+	vnc, err = getVirtualNetworkController(d, meta)
+	if err != nil {
+		return vnet, err
+	}
+
+	stateConf := &resource.StateChangeConf{
+		Pending: []string{"anythingelse"},
+		Target:  []string{state},
+		Refresh: func() (interface{}, string, error) {
+			log.Println("Refreshing Virtual Network state...")
+			vnet, err = vnc.Info()
+			if err != nil {
+				if strings.Contains(err.Error(), "Error getting") {
+					return vnet, "notfound", nil
+				}
+				return vnet, "", err
+			}
+			log.Printf("Virtual Network %v is available", vnet.ID)
+			if state == "notfound" {
+				return vnet, "anythingelse", nil
+			}
+			return vnet, "ready", nil
+		},
+		Timeout:    timeout,
+		Delay:      1 * time.Second,
+		MinTimeout: 3 * time.Second,
+	}
+
+	return stateConf.WaitForState()
+}
@@ -0,0 +1,525 @@
+package opennebula
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/hashicorp/terraform/helper/hashcode"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/helper/schema"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/OpenNebula/addon-terraform/opennebula/onetemplate"
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+)
+
+var defaultVRouterTimeout = 10 * time.Minute
+
+func resourceOpennebulaVirtualRouter() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceOpennebulaVirtualRouterCreate,
+		Read:   resourceOpennebulaVirtualRouterRead,
+		Exists: resourceOpennebulaVirtualRouterExists,
+		Update: resourceOpennebulaVirtualRouterUpdate,
+		Delete: resourceOpennebulaVirtualRouterDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(defaultVRouterTimeout),
+			Update: schema.DefaultTimeout(defaultVRouterTimeout),
+			Delete: schema.DefaultTimeout(defaultVRouterTimeout),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Name of the Virtual Router",
+			},
+			"zone_id": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				ForceNew:    true,
+				Default:     -1,
+				Description: "ID of the Zone of the Virtual Router. If not set, it uses the zone of the provider",
+			},
+			"description": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Description of the Virtual Router",
+			},
+			"template_id": {
+				Type:        schema.TypeInt,
+				Required:    true,
+				ForceNew:    true,
+				Description: "ID of the VM template used to instantiate the Virtual Router VMs",
+			},
+			"instances": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				ForceNew:    true,
+				Default:     1,
+				Description: "Number of VMs of the Virtual Router. Default is 1",
+				ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+					if v.(int) < 1 {
+						errors = append(errors, fmt.Errorf("%q must be at least 1", k))
+					}
+
+					return
+				},
+			},
+			"vm_name": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "Name of the Virtual Router VMs, %i is replaced by the VM index. Default is 'vr-<name>-%i'",
+			},
+			"keepalived_id": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Description: "Keepalived virtual router ID, used with several instances",
+			},
+			"keepalived_password": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				Description: "Keepalived password, used with several instances",
+			},
+			"nic": {
+				Type:        schema.TypeSet,
+				Optional:    true,
+				Description: "Definition of the Virtual Router NICs",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"nic_id": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"network_id": {
+							Type:     schema.TypeInt,
+							Required: true,
+						},
+						"ip": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "IP requested for the NIC, the floating IP if floating_ip is set",
+						},
+						"model": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"floating_ip": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     false,
+							Description: "Request a floating IP shared by the Virtual Router VMs",
+						},
+						"floating_only": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     false,
+							Description: "Only the floating IP is leased, the VMs NICs don't get an IP of their own",
+						},
+						"security_groups": {
+							Type:     schema.TypeList,
+							Optional: true,
+							Elem: &schema.Schema{
+								Type: schema.TypeInt,
+							},
+						},
+					},
+				},
+				Set: resourceVRouterNicHash,
+			},
+			"vm_ids": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "IDs of the Virtual Router VMs",
+				Elem: &schema.Schema{
+					Type: schema.TypeInt,
+				},
+			},
+		},
+	}
+}
+
+func getVirtualRouterController(d *schema.ResourceData, meta interface{}) (*goca.VirtualRouterController, error) {
+	controller := zoneController(d, meta)
+	var vrc *goca.VirtualRouterController
+
+	// Try to find the Virtual Router by ID, if specified
+	if d.Id() != "" {
+		vrid, err := strconv.ParseUint(d.Id(), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Virtual Router Id (%s) is not an integer", d.Id())
+		}
+		vrc = controller.VirtualRouter(int(vrid))
+	}
+
+	// Otherwise, try to find the Virtual Router by name as the de facto compound primary key
+	if d.Id() == "" {
+		vrid, err := controller.VirtualRouters().ByName(d.Get("name").(string))
+		if err != nil {
+			d.SetId("")
+			return nil, fmt.Errorf("Could not find Virtual Router with name %s", d.Get("name").(string))
+		}
+		vrc = controller.VirtualRouter(vrid)
+	}
+
+	return vrc, nil
+}
+
+func resourceOpennebulaVirtualRouterCreate(d *schema.ResourceData, meta interface{}) error {
+	controller := zoneController(d, meta)
+
+	vrtpl := &onetemplate.Template{}
+	vrtpl.AddPair("NAME", d.Get("name").(string))
+	vrtpl.Append(generateVRouterTemplate(d, false))
+	for _, nic := range d.Get("nic").(*schema.Set).List() {
+		addVRouterNIC(vrtpl, nic.(map[string]interface{}))
+	}
+	log.Printf("[INFO] Virtual Router definition: %s", vrtpl)
+
+	vrID, err := controller.VirtualRouters().Create(vrtpl.String())
+	if err != nil {
+		return err
+	}
+	d.SetId(fmt.Sprintf("%v", vrID))
+
+	_, err = controller.VirtualRouter(vrID).Instantiate(d.Get("instances").(int),
+		d.Get("template_id").(int),
+		d.Get("vm_name").(string),
+		false,
+		"")
+	if err != nil {
+		return fmt.Errorf("Error instantiating virtual router (%s) VMs: %s", d.Id(), err)
+	}
+
+	vr, err := controller.VirtualRouter(vrID).Info()
+	if err != nil {
+		return err
+	}
+
+	err = waitForVRouterVMs(d, meta, vr.VMsID, "running", d.Timeout(schema.TimeoutCreate))
+	if err != nil {
+		return fmt.Errorf("Error waiting for virtual router (%s) VMs to be in state RUNNING: %s", d.Id(), err)
+	}
+
+	return resourceOpennebulaVirtualRouterRead(d, meta)
+}
+
+func resourceOpennebulaVirtualRouterRead(d *schema.ResourceData, meta interface{}) error {
+	vrc, err := getVirtualRouterController(d, meta)
+	if err != nil {
+		return err
+	}
+
+	vr, err := vrc.Info()
+	if err != nil {
+		return err
+	}
+
+	d.SetId(fmt.Sprintf("%v", vr.ID))
+	readZoneID(d)
+	d.Set("name", vr.Name)
+	d.Set("vm_ids", vr.VMsID)
+
+	tpl, err := getObjectTemplate(zoneController(d, meta), "one.vrouter.info", vr.ID)
+	if err != nil {
+		return err
+	}
+	description, _ := tpl.Get("DESCRIPTION")
+	d.Set("description", description)
+	keepalivedid, _ := tpl.Get("KEEPALIVED_ID")
+	// An empty KEEPALIVED_ID is an unset one
+	kid, _ := strconv.Atoi(keepalivedid)
+	d.Set("keepalived_id", kid)
+	keepalivedpassword, _ := tpl.Get("KEEPALIVED_PASSWORD")
+	d.Set("keepalived_password", keepalivedpassword)
+
+	configured := d.Get("nic").(*schema.Set)
+	nics := make([]interface{}, 0, len(vr.Template.NIC))
+	for _, nic := range vr.Template.NIC {
+		networkid, _ := nic.Dynamic.GetContentByName("NETWORK_ID")
+		ip, _ := nic.Dynamic.GetContentByName("IP")
+		model, _ := nic.Dynamic.GetContentByName("MODEL")
+		floatingip, _ := nic.Dynamic.GetContentByName("FLOATING_IP")
+		floatingonly, _ := nic.Dynamic.GetContentByName("FLOATING_ONLY")
+		secgroups, _ := nic.Dynamic.GetContentByName("SECURITY_GROUPS")
+
+		nid, _ := strconv.Atoi(networkid)
+		sgs := make([]interface{}, 0)
+		for _, sg := range strings.Split(secgroups, ",") {
+			if sgid, err := strconv.Atoi(sg); err == nil {
+				sgs = append(sgs, sgid)
+			}
+		}
+
+		nics = append(nics, managedVRouterNIC(configured, map[string]interface{}{
+			"nic_id":          nic.NICID,
+			"network_id":      nid,
+			"ip":              ip,
+			"model":           model,
+			"floating_ip":     strings.ToUpper(floatingip) == "YES",
+			"floating_only":   strings.ToUpper(floatingonly) == "YES",
+			"security_groups": sgs,
+		}))
+	}
+	err = d.Set("nic", nics)
+	if err != nil {
+		log.Printf("[DEBUG] Error setting NICs on virtual router: %s", err)
+	}
+
+	return nil
+}
+
+func resourceOpennebulaVirtualRouterExists(d *schema.ResourceData, meta interface{}) (bool, error) {
+	err := resourceOpennebulaVirtualRouterRead(d, meta)
+	if err != nil || d.Id() == "" {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func resourceOpennebulaVirtualRouterUpdate(d *schema.ResourceData, meta interface{}) error {
+	vrc, err := getVirtualRouterController(d, meta)
+	if err != nil {
+		return err
+	}
+
+	if d.HasChange("name") {
+		err = vrc.Rename(d.Get("name").(string))
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated name for Virtual Router %s\n", d.Get("name"))
+	}
+
+	if d.HasChange("description") || d.HasChange("keepalived_id") || d.HasChange("keepalived_password") {
+		// Merge the template to keep the NICs, the unset attributes are
+		// emptied
+		err = vrc.Update(generateVRouterTemplate(d, true).String(), 1)
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated template for Virtual Router %s\n", d.Get("name"))
+	}
+
+	if d.HasChange("nic") {
+		err = updateVRouterNics(d, meta)
+		if err != nil {
+			return err
+		}
+	}
+
+	return resourceOpennebulaVirtualRouterRead(d, meta)
+}
+
+// updateVRouterNics detaches the removed NICs and attaches the new ones.
+// OpenNebula propagates the change to all the Virtual Router VMs.
+func updateVRouterNics(d *schema.ResourceData, meta interface{}) error {
+	vrc, err := getVirtualRouterController(d, meta)
+	if err != nil {
+		return err
+	}
+
+	onicsset, nnicsset := d.GetChange("nic")
+	onics := onicsset.(*schema.Set)
+	nnics := nnicsset.(*schema.Set)
+
+	for _, nic := range onics.Difference(nnics).List() {
+		nicid := nic.(map[string]interface{})["nic_id"].(int)
+
+		log.Printf("[DEBUG] Detaching NIC %d from Virtual Router %s", nicid, d.Id())
+		err = vrc.DetachNic(nicid)
+		if err != nil {
+			return fmt.Errorf("Error detaching NIC %d from virtual router (%s): %s", nicid, d.Id(), err)
+		}
+	}
+
+	for _, nic := range nnics.Difference(onics).List() {
+		nictpl := &onetemplate.Template{}
+		addVRouterNIC(nictpl, nic.(map[string]interface{}))
+
+		log.Printf("[DEBUG] Attaching NIC to Virtual Router %s: %s", d.Id(), nictpl)
+		err = vrc.AttachNic(nictpl.String())
+		if err != nil {
+			return fmt.Errorf("Error attaching NIC to virtual router (%s): %s", d.Id(), err)
+		}
+	}
+
+	vr, err := vrc.Info()
+	if err != nil {
+		return err
+	}
+
+	err = waitForVRouterVMs(d, meta, vr.VMsID, "running", d.Timeout(schema.TimeoutUpdate))
+	if err != nil {
+		return fmt.Errorf("Error waiting for virtual router (%s) VMs to be in state RUNNING: %s", d.Id(), err)
+	}
+
+	return nil
+}
+
+func resourceOpennebulaVirtualRouterDelete(d *schema.ResourceData, meta interface{}) error {
+	vrc, err := getVirtualRouterController(d, meta)
+	if err != nil {
+		return err
+	}
+
+	vr, err := vrc.Info()
+	if err != nil {
+		return err
+	}
+
+	// Deleting the Virtual Router terminates its VMs
+	err = vrc.Delete()
+	if err != nil {
+		return err
+	}
+
+	err = waitForVRouterVMs(d, meta, vr.VMsID, "done", d.Timeout(schema.TimeoutDelete))
+	if err != nil {
+		return fmt.Errorf("Error waiting for virtual router (%s) VMs to be in state DONE: %s", d.Id(), err)
+	}
+
+	log.Printf("[INFO] Successfully deleted Virtual Router ID %s\n", d.Id())
+
+	return nil
+}
+
+// waitForVRouterVMs waits for all the given VMs to be in the given power state
+func waitForVRouterVMs(d *schema.ResourceData, meta interface{}, vmids []int, state string, timeout time.Duration) error {
+	controller := zoneController(d, meta)
+
+	// The VMs may go through other stable states before reaching the target one
+	pending := []string{"anythingelse"}
+	for _, powerstate := range vmpowerstates {
+		if powerstate != state {
+			pending = append(pending, powerstate)
+		}
+	}
+
+	for _, vmid := range vmids {
+		vmc := controller.VM(vmid)
+
+		stateConf := &resource.StateChangeConf{
+			Pending: pending,
+			Target:  []string{state},
+			Refresh: func() (interface{}, string, error) {
+				log.Println("Refreshing Virtual Router VM state...")
+				vm, err := vmc.Info()
+				if err != nil {
+					if strings.Contains(err.Error(), "Error getting") {
+						return vm, "done", nil
+					}
+					return vm, "", err
+				}
+				vmState, vmLcmState, err := vm.State()
+				if err != nil {
+					return vm, "", err
+				}
+				log.Printf("VM %v is currently in state %v and in LCM state %v", vm.ID, vmState, vmLcmState)
+				if vmState == 3 && vmLcmState == 36 {
+					return vm, "boot_failure", fmt.Errorf("VM ID %d entered fail state, error message: %s", vm.ID, vm.UserTemplate.Error)
+				} else if powerstate := vmPowerState(vm); powerstate != "" {
+					return vm, powerstate, nil
+				}
+				return vm, "anythingelse", nil
+			},
+			Timeout:    timeout,
+			Delay:      10 * time.Second,
+			MinTimeout: 3 * time.Second,
+		}
+
+		if _, err := stateConf.WaitForState(); err != nil {
+			return fmt.Errorf("VM %d: %s", vmid, err)
+		}
+	}
+
+	return nil
+}
+
+// generateVRouterTemplate returns the Virtual Router attributes without its
+// NICs. The unset attributes are only part of it if withEmpty is true.
+func generateVRouterTemplate(d *schema.ResourceData, withEmpty bool) *onetemplate.Template {
+	vrtpl := &onetemplate.Template{}
+
+	if description := d.Get("description").(string); description != "" || withEmpty {
+		vrtpl.AddPair("DESCRIPTION", description)
+	}
+	if keepalivedid, ok := d.GetOk("keepalived_id"); ok {
+		vrtpl.AddPair("KEEPALIVED_ID", keepalivedid.(int))
+	} else if withEmpty {
+		vrtpl.AddPair("KEEPALIVED_ID", "")
+	}
+	if password := d.Get("keepalived_password").(string); password != "" || withEmpty {
+		vrtpl.AddPair("KEEPALIVED_PASSWORD", password)
+	}
+
+	return vrtpl
+}
+
+// addVRouterNIC adds the NIC vector built from its configuration to the template
+func addVRouterNIC(tpl *onetemplate.Template, nicconfig map[string]interface{}) {
+	nic := tpl.AddVector("NIC")
+	nic.AddPair("NETWORK_ID", nicconfig["network_id"].(int))
+
+	if ip := nicconfig["ip"].(string); ip != "" {
+		nic.AddPair("IP", ip)
+	}
+	if model := nicconfig["model"].(string); model != "" {
+		nic.AddPair("MODEL", model)
+	}
+	if nicconfig["floating_ip"].(bool) {
+		nic.AddPair("FLOATING_IP", "YES")
+	}
+	if nicconfig["floating_only"].(bool) {
+		nic.AddPair("FLOATING_ONLY", "YES")
+	}
+	if secgroups := nicconfig["security_groups"].([]interface{}); len(secgroups) > 0 {
+		nic.AddPair("SECURITY_GROUPS", ArrayToString(secgroups, ","))
+	}
+}
+
+// managedVRouterNIC leaves the IP and the security groups of the NIC unset
+// when OpenNebula chose them, as they are part of the NIC hash
+func managedVRouterNIC(configured *schema.Set, nic map[string]interface{}) map[string]interface{} {
+	unset := map[string]interface{}{
+		"ip":              "",
+		"security_groups": []interface{}{},
+	}
+
+	for _, keys := range [][]string{{}, {"ip"}, {"security_groups"}, {"ip", "security_groups"}} {
+		managed := make(map[string]interface{}, len(nic))
+		for k, v := range nic {
+			managed[k] = v
+		}
+		for _, k := range keys {
+			managed[k] = unset[k]
+		}
+		if configured.Contains(managed) {
+			return managed
+		}
+	}
+
+	return nic
+}
+
+func resourceVRouterNicHash(v interface{}) int {
+	var buf bytes.Buffer
+	m := v.(map[string]interface{})
+	buf.WriteString(fmt.Sprintf("%d-", m["network_id"].(int)))
+	buf.WriteString(fmt.Sprintf("%s-", m["ip"].(string)))
+	buf.WriteString(fmt.Sprintf("%s-", m["model"].(string)))
+	buf.WriteString(fmt.Sprintf("%t-", m["floating_ip"].(bool)))
+	buf.WriteString(fmt.Sprintf("%t-", m["floating_only"].(bool)))
+	for _, sg := range m["security_groups"].([]interface{}) {
+		buf.WriteString(fmt.Sprintf("%d,", sg.(int)))
+	}
+	return hashcode.String(buf.String())
+}
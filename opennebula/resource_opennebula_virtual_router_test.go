@@ -0,0 +1,234 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+	"strconv"
+	"testing"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+)
+
+func TestAccVirtualRouter(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVirtualRouterDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccVirtualRouterConfigBasic,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_virtual_router.router", "name", "terrarouter"),
+					resource.TestCheckResourceAttr("opennebula_virtual_router.router", "instances", "2"),
+					resource.TestCheckResourceAttr("opennebula_virtual_router.router", "description", "Terraform router"),
+					resource.TestCheckResourceAttr("opennebula_virtual_router.router", "keepalived_id", "1"),
+					resource.TestCheckResourceAttr("opennebula_virtual_router.router", "keepalived_password", "s3cret"),
+					resource.TestCheckResourceAttr("opennebula_virtual_router.router", "vm_ids.#", "2"),
+					resource.TestCheckResourceAttr("opennebula_virtual_router.router", "nic.#", "1"),
+				),
+			},
+			{
+				Config: testAccVirtualRouterConfigUpdate,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_virtual_router.router", "name", "terrarouter"),
+					resource.TestCheckResourceAttr("opennebula_virtual_router.router", "description", ""),
+					resource.TestCheckResourceAttr("opennebula_virtual_router.router", "keepalived_id", "2"),
+					resource.TestCheckResourceAttr("opennebula_virtual_router.router", "keepalived_password", "n3ws3cret"),
+					resource.TestCheckResourceAttr("opennebula_virtual_router.router", "vm_ids.#", "2"),
+					resource.TestCheckResourceAttr("opennebula_virtual_router.router", "nic.#", "2"),
+				),
+			},
+			{
+				Config: testAccVirtualRouterConfigNicIP,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_virtual_router.router", "nic.#", "2"),
+					testAccCheckVirtualRouterNicIP("opennebula_virtual_router.router", "172.16.103.120"),
+				),
+			},
+		},
+	})
+}
+
+func TestManagedVRouterNIC(t *testing.T) {
+	nic := map[string]interface{}{
+		"nic_id":          1,
+		"network_id":      2,
+		"ip":              "172.16.103.110",
+		"model":           "",
+		"floating_ip":     false,
+		"floating_only":   false,
+		"security_groups": []interface{}{0},
+	}
+
+	cases := []struct {
+		configured map[string]interface{}
+		ip         string
+		secgroups  int
+	}{
+		{map[string]interface{}{"ip": "", "security_groups": []interface{}{}}, "", 0},
+		{map[string]interface{}{"ip": "172.16.103.110", "security_groups": []interface{}{}}, "172.16.103.110", 0},
+		{map[string]interface{}{"ip": "", "security_groups": []interface{}{0}}, "", 1},
+		// A NIC which isn't configured is reported as read
+		{map[string]interface{}{"ip": "172.16.103.120", "security_groups": []interface{}{}}, "172.16.103.110", 1},
+	}
+
+	for _, c := range cases {
+		config := make(map[string]interface{})
+		for k, v := range nic {
+			config[k] = v
+		}
+		for k, v := range c.configured {
+			config[k] = v
+		}
+		configured := schema.NewSet(resourceVRouterNicHash, []interface{}{config})
+
+		managed := managedVRouterNIC(configured, nic)
+		if managed["ip"] != c.ip || len(managed["security_groups"].([]interface{})) != c.secgroups {
+			t.Errorf("Expected NIC with IP %q and %d security groups for %v, got %v", c.ip, c.secgroups, c.configured, managed)
+		}
+	}
+}
+
+func testAccCheckVirtualRouterDestroy(s *terraform.State) error {
+	controller := testAccProvider.Meta().(*goca.Controller)
+
+	for _, rs := range s.RootModule().Resources {
+		if rs.Type != "opennebula_virtual_router" {
+			continue
+		}
+		vrID, _ := strconv.ParseUint(rs.Primary.ID, 10, 64)
+		vrc := controller.VirtualRouter(int(vrID))
+		// Get Virtual Router Info
+		vr, _ := vrc.Info()
+		if vr != nil {
+			return fmt.Errorf("Expected virtual router %s to have been destroyed", rs.Primary.ID)
+		}
+	}
+
+	return nil
+}
+
+func testAccCheckVirtualRouterNicIP(name, ip string) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		controller := testAccProvider.Meta().(*goca.Controller)
+
+		rs, ok := s.RootModule().Resources[name]
+		if !ok {
+			return fmt.Errorf("Not found: %s", name)
+		}
+		vrID, _ := strconv.ParseUint(rs.Primary.ID, 10, 64)
+		vr, err := controller.VirtualRouter(int(vrID)).Info()
+		if err != nil {
+			return err
+		}
+
+		for _, nic := range vr.Template.NIC {
+			if nicip, _ := nic.Dynamic.GetContentByName("IP"); nicip == ip {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("Expected virtual router %s to have a NIC with IP %s", rs.Primary.ID, ip)
+	}
+}
+
+var testAccVirtualRouterNetworks = `
+resource "opennebula_virtual_network" "public" {
+  name = "terrarouter-public"
+  physical_device = "dummy0"
+  type            = "vxlan"
+  vlan_id         = "8000048"
+  mtu             = 1500
+  ar {
+    ar_type = "IP4"
+    size    = 16
+    ip4     = "172.16.102.110"
+  }
+  permissions = "642"
+  security_groups = [0]
+  clusters = [0]
+}
+
+resource "opennebula_virtual_network" "private" {
+  name = "terrarouter-private"
+  physical_device = "dummy0"
+  type            = "vxlan"
+  vlan_id         = "8000049"
+  mtu             = 1500
+  ar {
+    ar_type = "IP4"
+    size    = 16
+    ip4     = "172.16.103.110"
+  }
+  permissions = "642"
+  security_groups = [0]
+  clusters = [0]
+}
+
+resource "opennebula_template" "router" {
+  name = "terrarouter-template"
+  template = <<EOF
+    CPU = "0.1"
+    MEMORY = "64"
+    VROUTER = "YES"
+    EOF
+  permissions = "642"
+}
+`
+
+var testAccVirtualRouterConfigBasic = testAccVirtualRouterNetworks + `
+resource "opennebula_virtual_router" "router" {
+  name = "terrarouter"
+  template_id = "${opennebula_template.router.id}"
+  instances = 2
+  description = "Terraform router"
+  keepalived_id = 1
+  keepalived_password = "s3cret"
+
+  nic {
+    network_id = "${opennebula_virtual_network.public.id}"
+    floating_ip = true
+  }
+}
+`
+
+var testAccVirtualRouterConfigUpdate = testAccVirtualRouterNetworks + `
+resource "opennebula_virtual_router" "router" {
+  name = "terrarouter"
+  template_id = "${opennebula_template.router.id}"
+  instances = 2
+  keepalived_id = 2
+  keepalived_password = "n3ws3cret"
+
+  nic {
+    network_id = "${opennebula_virtual_network.public.id}"
+    floating_ip = true
+  }
+
+  nic {
+    network_id = "${opennebula_virtual_network.private.id}"
+  }
+}
+`
+
+var testAccVirtualRouterConfigNicIP = testAccVirtualRouterNetworks + `
+resource "opennebula_virtual_router" "router" {
+  name = "terrarouter"
+  template_id = "${opennebula_template.router.id}"
+  instances = 2
+  keepalived_id = 2
+  keepalived_password = "n3ws3cret"
+
+  nic {
+    network_id = "${opennebula_virtual_network.public.id}"
+    floating_ip = true
+  }
+
+  nic {
+    network_id = "${opennebula_virtual_network.private.id}"
+    ip = "172.16.103.120"
+  }
+}
+`
@@ -0,0 +1,189 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/schema"
+	"log"
+	"strconv"
+
+	"github.com/OpenNebula/addon-terraform/opennebula/onetemplate"
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+)
+
+func resourceOpennebulaZone() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceOpennebulaZoneCreate,
+		Read:   resourceOpennebulaZoneRead,
+		Exists: resourceOpennebulaZoneExists,
+		Update: resourceOpennebulaZoneUpdate,
+		Delete: resourceOpennebulaZoneDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Name of the Zone",
+			},
+			"endpoint": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "XML-RPC endpoint of the Zone",
+			},
+			"template": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				DiffSuppressFunc: templateDiffSuppress,
+				Description:      "Zone template content, in OpenNebula XML or String format",
+			},
+		},
+	}
+}
+
+func getZoneController(d *schema.ResourceData, meta interface{}) (*goca.ZoneController, error) {
+	controller := meta.(*goca.Controller)
+	var zc *goca.ZoneController
+
+	// Try to find the Zone by ID, if specified
+	if d.Id() != "" {
+		zoneid, err := strconv.ParseUint(d.Id(), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Zone Id (%s) is not an integer", d.Id())
+		}
+		zc = controller.Zone(int(zoneid))
+	}
+
+	// Otherwise, try to find the Zone by name as the de facto compound primary key
+	if d.Id() == "" {
+		zoneid, err := controller.Zones().ByName(d.Get("name").(string))
+		if err != nil {
+			d.SetId("")
+			return nil, fmt.Errorf("Could not find Zone with name %s", d.Get("name").(string))
+		}
+		zc = controller.Zone(zoneid)
+	}
+
+	return zc, nil
+}
+
+// generateZoneTemplate returns the Zone endpoint followed by the content of
+// the template argument
+func generateZoneTemplate(d *schema.ResourceData) (*onetemplate.Template, error) {
+	zonetpl := &onetemplate.Template{}
+	zonetpl.AddPair("ENDPOINT", d.Get("endpoint").(string))
+	if tpl, ok := d.GetOk("template"); ok {
+		usertpl, err := onetemplate.Decode(tpl.(string))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid template of Zone: %s", err)
+		}
+		zonetpl.Append(usertpl)
+	}
+
+	return zonetpl, nil
+}
+
+func resourceOpennebulaZoneCreate(d *schema.ResourceData, meta interface{}) error {
+	controller := meta.(*goca.Controller)
+
+	zonetpl, err := generateZoneTemplate(d)
+	if err != nil {
+		return err
+	}
+	zonetpl.AddPair("NAME", d.Get("name").(string))
+
+	zoneID, err := controller.Zones().Create(zonetpl.String())
+	if err != nil {
+		return err
+	}
+	d.SetId(fmt.Sprintf("%v", zoneID))
+
+	return resourceOpennebulaZoneRead(d, meta)
+}
+
+func resourceOpennebulaZoneRead(d *schema.ResourceData, meta interface{}) error {
+	zc, err := getZoneController(d, meta)
+	if err != nil {
+		return err
+	}
+
+	zone, err := zc.Info()
+	if err != nil {
+		return err
+	}
+
+	d.SetId(fmt.Sprintf("%v", zone.ID))
+	d.Set("name", zone.Name)
+	d.Set("endpoint", zone.Template.Endpoint)
+
+	tpl, err := getObjectTemplate(meta.(*goca.Controller), "one.zone.info", zone.ID)
+	if err != nil {
+		return err
+	}
+	d.Set("template", managedTemplate(tpl, d.Get("template").(string)))
+
+	return nil
+}
+
+func resourceOpennebulaZoneExists(d *schema.ResourceData, meta interface{}) (bool, error) {
+	err := resourceOpennebulaZoneRead(d, meta)
+	if err != nil || d.Id() == "" {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func resourceOpennebulaZoneUpdate(d *schema.ResourceData, meta interface{}) error {
+	zc, err := getZoneController(d, meta)
+	if err != nil {
+		return err
+	}
+
+	if d.HasChange("name") {
+		err = zc.Rename(d.Get("name").(string))
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated name for Zone %s\n", d.Get("name"))
+	}
+
+	if d.HasChange("endpoint") || d.HasChange("template") {
+		zonetpl, err := generateZoneTemplate(d)
+		if err != nil {
+			return err
+		}
+		current, err := getObjectTemplate(meta.(*goca.Controller), "one.zone.info", zc.ID)
+		if err != nil {
+			return err
+		}
+
+		// Replace the template to remove the attributes dropped from the
+		// configuration, keeping the ones set by OpenNebula
+		otpl, _ := d.GetChange("template")
+		err = zc.Update(replaceManagedTemplate(current, otpl.(string), zonetpl).String(), 0)
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated template for Zone %s\n", d.Get("name"))
+	}
+
+	return resourceOpennebulaZoneRead(d, meta)
+}
+
+func resourceOpennebulaZoneDelete(d *schema.ResourceData, meta interface{}) error {
+	zc, err := getZoneController(d, meta)
+	if err != nil {
+		return err
+	}
+
+	err = zc.Delete()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Successfully deleted Zone ID %s\n", d.Id())
+
+	return nil
+}
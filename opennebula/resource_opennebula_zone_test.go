@@ -0,0 +1,88 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/terraform"
+	"strconv"
+	"testing"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+)
+
+func TestAccZone(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckZoneDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccZoneConfigBasic,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_zone.zone", "name", "terrazone"),
+					resource.TestCheckResourceAttr("opennebula_zone.zone", "endpoint", "http://terrazone:2633/RPC2"),
+					resource.TestCheckResourceAttr("opennebula_zone.zone", "template", "DESCRIPTION = \"Terraform zone\"\nLABEL = \"dc1\""),
+					resource.TestCheckResourceAttr("data.opennebula_zone.master", "endpoint", "http://localhost:2633/RPC2"),
+				),
+			},
+			{
+				Config: testAccZoneConfigUpdate,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_zone.zone", "name", "terrazone-renamed"),
+					resource.TestCheckResourceAttr("opennebula_zone.zone", "endpoint", "http://terrazone2:2633/RPC2"),
+					resource.TestCheckResourceAttr("opennebula_zone.zone", "template", "DESCRIPTION = \"Terraform zone, renamed\""),
+				),
+			},
+			{
+				ResourceName:      "opennebula_zone.zone",
+				ImportState:       true,
+				ImportStateVerify: true,
+				// The import can't tell the configured attributes apart from
+				// the ones set by OpenNebula
+				ImportStateVerifyIgnore: []string{"template"},
+			},
+		},
+	})
+}
+
+func testAccCheckZoneDestroy(s *terraform.State) error {
+	controller := testAccProvider.Meta().(*goca.Controller)
+
+	for _, rs := range s.RootModule().Resources {
+		if rs.Type != "opennebula_zone" {
+			continue
+		}
+		zoneID, _ := strconv.ParseUint(rs.Primary.ID, 10, 64)
+		zc := controller.Zone(int(zoneID))
+		// Get Zone Info
+		zone, _ := zc.Info()
+		if zone != nil {
+			return fmt.Errorf("Expected zone %s to have been destroyed", rs.Primary.ID)
+		}
+	}
+
+	return nil
+}
+
+var testAccZoneConfigBasic = `
+resource "opennebula_zone" "zone" {
+  name = "terrazone"
+  endpoint = "http://terrazone:2633/RPC2"
+  template = <<EOF
+    DESCRIPTION = "Terraform zone"
+    LABEL = "dc1"
+    EOF
+}
+
+data "opennebula_zone" "master" {
+  name = "OpenNebula"
+}
+`
+
+var testAccZoneConfigUpdate = `
+resource "opennebula_zone" "zone" {
+  name = "terrazone-renamed"
+  endpoint = "http://terrazone2:2633/RPC2"
+  template = "<TEMPLATE><DESCRIPTION>Terraform zone, renamed</DESCRIPTION></TEMPLATE>"
+}
+`
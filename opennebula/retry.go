@@ -0,0 +1,109 @@
+package opennebula
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+)
+
+// Classes of errors that may be retried
+var retryableerrors = []string{"network", "locked", "internal", "action"}
+
+// retryCaller wraps a goca RPCCaller and retries the failed calls, with an
+// exponential backoff, as long as the error is part of a retryable class.
+type retryCaller struct {
+	caller      goca.RPCCaller
+	maxAttempts int
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	retryOn     []string
+}
+
+func newRetryCaller(caller goca.RPCCaller, maxAttempts int, minBackoff, maxBackoff time.Duration, retryOn []string) *retryCaller {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	if maxBackoff < minBackoff {
+		maxBackoff = minBackoff
+	}
+
+	return &retryCaller{
+		caller:      caller,
+		maxAttempts: maxAttempts,
+		minBackoff:  minBackoff,
+		maxBackoff:  maxBackoff,
+		retryOn:     retryOn,
+	}
+}
+
+// Call performs the XML-RPC call and retries it on retryable errors
+func (c *retryCaller) Call(method string, args ...interface{}) (*goca.Response, error) {
+	backoff := c.minBackoff
+
+	for attempt := 1; ; attempt++ {
+		response, err := c.caller.Call(method, args...)
+		if err == nil {
+			return response, nil
+		}
+
+		class := errorClass(method, err)
+		if attempt >= c.maxAttempts || inArray(class, c.retryOn) < 0 {
+			return response, err
+		}
+
+		log.Printf("[WARN] %s failed with a %s error (attempt %d/%d), retrying in %s: %s",
+			method, class, attempt, c.maxAttempts, backoff, err)
+		time.Sleep(backoff)
+
+		backoff *= 2
+		if backoff > c.maxBackoff {
+			backoff = c.maxBackoff
+		}
+	}
+}
+
+// readOnlyMethod returns true if the XML-RPC method only reads information,
+// so it may be sent again without side effects
+func readOnlyMethod(method string) bool {
+	if strings.HasPrefix(method, "one.system.") {
+		return true
+	}
+	for _, suffix := range []string{".info", ".infoextended", ".monitoring"} {
+		if strings.HasSuffix(method, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// errorClass returns the retry class of an error returned by goca for the
+// method. Errors which must never be retried, like authentication or
+// authorization failures, get an empty class.
+func errorClass(method string, err error) string {
+	switch e := err.(type) {
+	case *goca.ClientError:
+		// oned may have processed the call before the connection failed, only
+		// the calls without side effects are safe to send again, otherwise an
+		// allocate or a clone would create a duplicate
+		switch e.Code {
+		case goca.ClientReqHTTP, goca.ClientRespHTTP:
+			if readOnlyMethod(method) {
+				return "network"
+			}
+		}
+	case *goca.ResponseError:
+		switch e.Code {
+		case goca.OneLockedError:
+			return "locked"
+		case goca.OneInternalError:
+			return "internal"
+		case goca.OneActionError:
+			return "action"
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,112 @@
+package opennebula
+
+import (
+	"testing"
+	"time"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+)
+
+type failingCaller struct {
+	errors []error
+	calls  int
+}
+
+func (c *failingCaller) Call(method string, args ...interface{}) (*goca.Response, error) {
+	c.calls++
+	if c.calls <= len(c.errors) {
+		return nil, c.errors[c.calls-1]
+	}
+	return &goca.Response{}, nil
+}
+
+func TestRetryCaller(t *testing.T) {
+	cases := []struct {
+		name          string
+		method        string
+		errors        []error
+		expectedCalls int
+		expectedError bool
+	}{
+		{
+			name:          "success",
+			errors:        []error{},
+			expectedCalls: 1,
+		},
+		{
+			name:          "locked then success",
+			errors:        []error{&goca.ResponseError{Code: goca.OneLockedError}},
+			expectedCalls: 2,
+		},
+		{
+			name: "locked until max attempts",
+			errors: []error{
+				&goca.ResponseError{Code: goca.OneLockedError},
+				&goca.ResponseError{Code: goca.OneLockedError},
+				&goca.ResponseError{Code: goca.OneLockedError},
+			},
+			expectedCalls: 3,
+			expectedError: true,
+		},
+		{
+			name:          "authorization is not retried",
+			errors:        []error{&goca.ResponseError{Code: goca.OneAuthorizationError}},
+			expectedCalls: 1,
+			expectedError: true,
+		},
+		{
+			name:          "internal is not retried by default",
+			errors:        []error{&goca.ResponseError{Code: goca.OneInternalError}},
+			expectedCalls: 1,
+			expectedError: true,
+		},
+		{
+			name:          "network error on info",
+			method:        "one.vm.info",
+			errors:        []error{&goca.ClientError{Code: goca.ClientRespHTTP}},
+			expectedCalls: 2,
+		},
+		{
+			name:          "network error on pool info",
+			method:        "one.vmpool.info",
+			errors:        []error{&goca.ClientError{Code: goca.ClientReqHTTP}},
+			expectedCalls: 2,
+		},
+		{
+			name:          "network error on allocate is not retried",
+			method:        "one.vm.allocate",
+			errors:        []error{&goca.ClientError{Code: goca.ClientRespHTTP}},
+			expectedCalls: 1,
+			expectedError: true,
+		},
+		{
+			name:          "network error on instantiate is not retried",
+			method:        "one.template.instantiate",
+			errors:        []error{&goca.ClientError{Code: goca.ClientReqHTTP}},
+			expectedCalls: 1,
+			expectedError: true,
+		},
+		{
+			name:          "locked allocate is retried",
+			method:        "one.vm.allocate",
+			errors:        []error{&goca.ResponseError{Code: goca.OneLockedError}},
+			expectedCalls: 2,
+		},
+	}
+
+	for _, tc := range cases {
+		if tc.method == "" {
+			tc.method = "one.vm.info"
+		}
+		caller := &failingCaller{errors: tc.errors}
+		rc := newRetryCaller(caller, 3, time.Millisecond, time.Millisecond, []string{"network", "locked"})
+
+		_, err := rc.Call(tc.method, 0)
+		if (err != nil) != tc.expectedError {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		}
+		if caller.calls != tc.expectedCalls {
+			t.Errorf("%s: expected %d calls, got %d", tc.name, tc.expectedCalls, caller.calls)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/schema"
+	"sync"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+)
+
+// zoneCaller sends the XML-RPC calls to the zone of the provider and builds,
+// on demand, the callers of the other zones of the federation.
+type zoneCaller struct {
+	goca.RPCCaller
+
+	newCaller func(endpoint string) goca.RPCCaller
+	endpoint  func(caller goca.RPCCaller, zoneID int) (string, error)
+
+	mutex sync.Mutex
+	zones map[int]goca.RPCCaller
+}
+
+func newZoneCaller(caller goca.RPCCaller, newCaller func(endpoint string) goca.RPCCaller) *zoneCaller {
+	return &zoneCaller{
+		RPCCaller: caller,
+		newCaller: newCaller,
+		endpoint:  zoneEndpoint,
+		zones:     make(map[int]goca.RPCCaller),
+	}
+}
+
+// zoneEndpoint returns the XML-RPC endpoint of the zone
+func zoneEndpoint(caller goca.RPCCaller, zoneID int) (string, error) {
+	zone, err := goca.NewController(caller).Zone(zoneID).Info()
+	if err != nil {
+		return "", fmt.Errorf("Unable to get Zone %d: %s", zoneID, err)
+	}
+
+	if zone.Template.Endpoint == "" {
+		return "", fmt.Errorf("Zone %d has no endpoint", zoneID)
+	}
+
+	return zone.Template.Endpoint, nil
+}
+
+// Zone returns the caller of the zone. A negative ID is the zone of the provider.
+func (c *zoneCaller) Zone(zoneID int) (goca.RPCCaller, error) {
+	if zoneID < 0 {
+		return c.RPCCaller, nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if caller, ok := c.zones[zoneID]; ok {
+		return caller, nil
+	}
+
+	endpoint, err := c.endpoint(c.RPCCaller, zoneID)
+	if err != nil {
+		return nil, err
+	}
+
+	caller := c.newCaller(endpoint)
+	c.zones[zoneID] = caller
+
+	return caller, nil
+}
+
+// failedCaller fails all the calls with the error met while building the
+// caller of a zone
+type failedCaller struct {
+	err error
+}
+
+func (c failedCaller) Call(method string, args ...interface{}) (*goca.Response, error) {
+	return nil, c.err
+}
+
+// zoneController returns the controller of the zone set by the zone_id
+// argument of the resource, or the controller of the provider if not set
+func zoneController(d *schema.ResourceData, meta interface{}) *goca.Controller {
+	controller := meta.(*goca.Controller)
+
+	zoneID, ok := d.Get("zone_id").(int)
+	if !ok || zoneID < 0 {
+		return controller
+	}
+
+	pc, ok := controller.Client.(*providerCaller)
+	if !ok {
+		return controller
+	}
+
+	caller, err := pc.Zone(zoneID)
+	if err != nil {
+		return goca.NewController(failedCaller{err: err})
+	}
+
+	return goca.NewController(caller)
+}
+
+// readZoneID sets zone_id in the state of the resources read without it, the
+// ones imported or created before the argument existed, which are in the zone
+// of the provider
+func readZoneID(d *schema.ResourceData) {
+	if _, ok := d.GetOkExists("zone_id"); !ok {
+		d.Set("zone_id", -1)
+	}
+}
@@ -0,0 +1,100 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/terraform"
+	"testing"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+)
+
+type endpointCaller struct {
+	endpoint string
+}
+
+func (c *endpointCaller) Call(method string, args ...interface{}) (*goca.Response, error) {
+	return nil, nil
+}
+
+func TestZoneCaller(t *testing.T) {
+	lookups := 0
+	master := &endpointCaller{endpoint: "http://master:2633/RPC2"}
+
+	zc := newZoneCaller(master, func(endpoint string) goca.RPCCaller {
+		return &endpointCaller{endpoint: endpoint}
+	})
+	zc.endpoint = func(caller goca.RPCCaller, zoneID int) (string, error) {
+		lookups++
+		if zoneID == 100 {
+			return "", fmt.Errorf("Zone %d not found", zoneID)
+		}
+		return fmt.Sprintf("http://zone%d:2633/RPC2", zoneID), nil
+	}
+
+	caller, err := zc.Zone(-1)
+	if err != nil || caller != master {
+		t.Fatalf("Expected the provider caller for a negative zone ID, got %v, %v", caller, err)
+	}
+
+	caller, err = zc.Zone(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if endpoint := caller.(*endpointCaller).endpoint; endpoint != "http://zone1:2633/RPC2" {
+		t.Errorf("Unexpected endpoint for zone 1: %s", endpoint)
+	}
+
+	cached, _ := zc.Zone(1)
+	if cached != caller || lookups != 1 {
+		t.Errorf("Expected the caller of zone 1 to be cached, %d lookups", lookups)
+	}
+
+	if _, err = zc.Zone(100); err == nil {
+		t.Error("Expected an error for an unknown zone")
+	}
+
+	_, err = failedCaller{err: fmt.Errorf("failed")}.Call("one.vm.info", 0)
+	if err == nil || err.Error() != "failed" {
+		t.Errorf("Expected the failed caller to return its error, got %v", err)
+	}
+}
+
+func TestReadZoneID(t *testing.T) {
+	r := resourceOpennebulaCluster()
+
+	cases := []struct {
+		name     string
+		state    map[string]string
+		expected string
+	}{
+		{
+			name:     "imported",
+			state:    map[string]string{"name": "cluster"},
+			expected: "-1",
+		},
+		{
+			name:     "provider zone",
+			state:    map[string]string{"name": "cluster", "zone_id": "-1"},
+			expected: "-1",
+		},
+		{
+			name:     "zone 0",
+			state:    map[string]string{"name": "cluster", "zone_id": "0"},
+			expected: "0",
+		},
+		{
+			name:     "zone 100",
+			state:    map[string]string{"name": "cluster", "zone_id": "100"},
+			expected: "100",
+		},
+	}
+
+	for _, tc := range cases {
+		d := r.Data(&terraform.InstanceState{ID: "100", Attributes: tc.state})
+		readZoneID(d)
+
+		if zoneID := d.State().Attributes["zone_id"]; zoneID != tc.expected {
+			t.Errorf("%s: expected zone_id %s, got %s", tc.name, tc.expected, zoneID)
+		}
+	}
+}